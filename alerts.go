@@ -0,0 +1,385 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultAlertWindow = "24h"
+
+// AlertRule fires an alert while a metric is on the wrong side of a
+// threshold.
+type AlertRule struct {
+	// Metric is active_homeservers, the number of homeservers which
+	// reported within the window, or a numeric stats column.
+	Metric     string  `json:"metric"`
+	Comparison string  `json:"comparison"` // <, <=, > or >=: the alert fires while "metric comparison threshold" holds
+	Threshold  float64 `json:"threshold"`
+	Window     string  `json:"window"` // How far back reports count, e.g. "6h" or "7d"; 24 hours by default
+
+	// Scope is network (the default), to compare the sum of every
+	// homeserver's last value, or homeserver, to compare each homeserver's
+	// last value and alert for each separately.
+	Scope string `json:"scope"`
+
+	window time.Duration
+}
+
+func (r *AlertRule) compile() error {
+	switch r.Comparison {
+	case "<", "<=", ">", ">=":
+	default:
+		return fmt.Errorf("unknown comparison %q", r.Comparison)
+	}
+	switch r.Scope {
+	case "":
+		r.Scope = "network"
+	case "network", "homeserver":
+	default:
+		return fmt.Errorf("unknown scope %q", r.Scope)
+	}
+	if r.Metric == "active_homeservers" {
+		if r.Scope != "network" {
+			return errors.New("active_homeservers only has a network scope")
+		}
+	} else if len(histogramTables(reportColumns(), r.Metric)) == 0 {
+		return fmt.Errorf("%s is not a numeric column", r.Metric)
+	}
+	if r.Window == "" {
+		r.Window = defaultAlertWindow
+	}
+	var err error
+	if r.window, err = parseWindow(r.Window); err != nil || r.window <= 0 {
+		return fmt.Errorf("bad window %q", r.Window)
+	}
+	return nil
+}
+
+func validateAlerts(rules map[string]*AlertRule) error {
+	for name, r := range rules {
+		if len(name) > 64 {
+			return fmt.Errorf("%s: name is longer than 64 characters", name)
+		}
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// breached reports whether v is on the alerting side of the threshold.
+func (r *AlertRule) breached(v float64) bool {
+	switch r.Comparison {
+	case "<":
+		return v < r.Threshold
+	case "<=":
+		return v <= r.Threshold
+	case ">":
+		return v > r.Threshold
+	default:
+		return v >= r.Threshold
+	}
+}
+
+// evaluate returns the current value of the rule's metric, keyed by
+// homeserver, or by "" for the network scope.
+func (r *AlertRule) evaluate(ctx context.Context, db *sql.DB, s *Storage, now int64) (map[string]float64, error) {
+	d := dialectFor(db)
+	since := now - int64(r.window.Seconds())
+	if r.Metric == "active_homeservers" {
+		var n int64
+		err := db.QueryRowContext(ctx,
+			"SELECT COUNT(*) FROM homeservers WHERE last_seen >= "+d.placeholder(1)+" AND homeserver NOT IN (SELECT homeserver FROM tombstones)",
+			since,
+		).Scan(&n)
+		return map[string]float64{"": float64(n)}, err
+	}
+	latest := map[string]float64{}
+	for _, t := range histogramTables(s.tables(), r.Metric) {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT homeserver, %[1]s FROM %[2]s
+			WHERE local_timestamp >= %[3]s AND %[1]s IS NOT NULL ORDER BY local_timestamp`, r.Metric, t, d.placeholder(1)), since)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var hs string
+			var v float64
+			if err := rows.Scan(&hs, &v); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			latest[hs] = v
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if r.Scope == "homeserver" {
+		return latest, nil
+	}
+	var sum float64
+	for _, v := range latest {
+		sum += v
+	}
+	return map[string]float64{"": sum}, nil
+}
+
+// Alert is a period during which an alert rule fired.
+type Alert struct {
+	ID         int64    `json:"id"`
+	Rule       string   `json:"rule"`
+	Homeserver *string  `json:"homeserver"` // Null for the network scope
+	Observed   *float64 `json:"observed"`   // The value which fired, or last breached; null if it stopped being reported
+	FiredAt    int64    `json:"fired_at"`
+	ResolvedAt *int64   `json:"resolved_at"` // Null while firing
+}
+
+func createTableAlerts(db *sql.DB) error {
+	err := createTable(db, &tableDef{Name: "alerts", Columns: []columnDef{
+		{"rule", "VARCHAR(64) NOT NULL"},
+		{"homeserver", "VARCHAR(256)"},
+		{"observed", floatColumnType(driverFor(db))},
+		{"fired_at", "BIGINT NOT NULL"},
+		{"resolved_at", "BIGINT"},
+	}})
+	if err != nil {
+		return err
+	}
+	return createIndex(db, "alerts_rule_resolved_at", "alerts", "rule, resolved_at")
+}
+
+func queryAlerts(db *sql.DB, where string, args []interface{}, limit int) ([]*Alert, error) {
+	qry := "SELECT id, rule, homeserver, observed, fired_at, resolved_at FROM alerts"
+	if where != "" {
+		qry += " WHERE " + where
+	}
+	qry += " ORDER BY id DESC"
+	if limit > 0 {
+		qry += fmt.Sprintf(" LIMIT %d", limit)
+	}
+	rows, err := db.Query(qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	alerts := []*Alert{}
+	for rows.Next() {
+		var (
+			a          Alert
+			homeserver sql.NullString
+			observed   sql.NullFloat64
+			resolved   sql.NullInt64
+		)
+		if err := rows.Scan(&a.ID, &a.Rule, &homeserver, &observed, &a.FiredAt, &resolved); err != nil {
+			return nil, err
+		}
+		if homeserver.Valid {
+			a.Homeserver = &homeserver.String
+		}
+		if observed.Valid {
+			a.Observed = &observed.Float64
+		}
+		if resolved.Valid {
+			a.ResolvedAt = &resolved.Int64
+		}
+		alerts = append(alerts, &a)
+	}
+	return alerts, rows.Err()
+}
+
+// evaluateAlerts is the evaluate_alerts job. It fires an alert for each
+// rule, and homeserver for the homeserver scope, whose metric newly breaches
+// its threshold and resolves those which no longer do, notifying both
+// unless they are silenced.
+func evaluateAlerts(db *sql.DB, s *Storage, rules map[string]*AlertRule, notifiers []Notifier) func(ctx context.Context) error {
+	names := make([]string, 0, len(rules))
+	for name := range rules {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return func(ctx context.Context) error {
+		now := time.Now().UTC().Unix()
+		var firstErr error
+		for _, name := range names {
+			if err := evaluateAlert(ctx, db, s, name, rules[name], notifiers, now); err != nil {
+				log.Printf("Alert %s: %v", name, err)
+				if firstErr == nil {
+					firstErr = fmt.Errorf("%s: %v", name, err)
+				}
+			}
+		}
+		return firstErr
+	}
+}
+
+func evaluateAlert(ctx context.Context, db *sql.DB, s *Storage, name string, rule *AlertRule, notifiers []Notifier, now int64) error {
+	d := dialectFor(db)
+	values, err := rule.evaluate(ctx, db, s, now)
+	if err != nil {
+		return err
+	}
+	open, err := queryAlerts(db, "rule = "+d.placeholder(1)+" AND resolved_at IS NULL", []interface{}{name}, 0)
+	if err != nil {
+		return err
+	}
+	firing := map[string]*Alert{}
+	for _, a := range open {
+		hs := ""
+		if a.Homeserver != nil {
+			hs = *a.Homeserver
+		}
+		firing[hs] = a
+	}
+
+	var notifyErr error
+	notify := func(homeserver, subject, body string) {
+		silenced, err := isSilenced(db, homeserver, now)
+		if err != nil {
+			log.Printf("Alert %s: error checking silences: %v", name, err)
+		} else if silenced {
+			metrics.Inc("panopticon_alert_notifications_silenced_total", "rule", name)
+			return
+		}
+		if err := notifyAll(ctx, notifiers, subject, body); err != nil && notifyErr == nil {
+			notifyErr = err
+		}
+	}
+	keys := make([]string, 0, len(values))
+	for hs := range values {
+		keys = append(keys, hs)
+	}
+	sort.Strings(keys)
+	for _, hs := range keys {
+		v := values[hs]
+		a := firing[hs]
+		switch {
+		case rule.breached(v) && a == nil:
+			var homeserver interface{}
+			if hs != "" {
+				homeserver = hs
+			}
+			if _, err := insertRow(db, "alerts", []string{"rule", "homeserver", "observed", "fired_at"},
+				[]interface{}{name, homeserver, v, now}); err != nil {
+				return err
+			}
+			metrics.Inc("panopticon_alert_events_total", "rule", name, "event", "fired")
+			notify(hs, alertSubject("Firing", name, hs), alertBody(rule, v))
+		case rule.breached(v):
+			_, err := db.ExecContext(ctx, fmt.Sprintf("UPDATE alerts SET observed = %s WHERE id = %s", d.placeholder(1), d.placeholder(2)), v, a.ID)
+			if err != nil {
+				return err
+			}
+		case a != nil:
+			if err := resolveAlert(ctx, db, a, now); err != nil {
+				return err
+			}
+			metrics.Inc("panopticon_alert_events_total", "rule", name, "event", "resolved")
+			notify(hs, alertSubject("Resolved", name, hs), alertBody(rule, v))
+		}
+	}
+	// Homeservers which stopped reporting the metric can't breach it.
+	for hs, a := range firing {
+		if _, ok := values[hs]; ok {
+			continue
+		}
+		if err := resolveAlert(ctx, db, a, now); err != nil {
+			return err
+		}
+		metrics.Inc("panopticon_alert_events_total", "rule", name, "event", "resolved")
+		notify(hs, alertSubject("Resolved", name, hs), fmt.Sprintf("%s is no longer reported.", rule.Metric))
+	}
+
+	var n int
+	err = db.QueryRowContext(ctx, "SELECT COUNT(*) FROM alerts WHERE rule = "+d.placeholder(1)+" AND resolved_at IS NULL", name).Scan(&n)
+	if err != nil {
+		return err
+	}
+	metrics.Set("panopticon_alerts_firing", float64(n), "rule", name)
+	return notifyErr
+}
+
+func resolveAlert(ctx context.Context, db *sql.DB, a *Alert, now int64) error {
+	d := dialectFor(db)
+	_, err := db.ExecContext(ctx, fmt.Sprintf("UPDATE alerts SET resolved_at = %s WHERE id = %s", d.placeholder(1), d.placeholder(2)), now, a.ID)
+	return err
+}
+
+func alertSubject(event, name, homeserver string) string {
+	if homeserver == "" {
+		return fmt.Sprintf("[%s] %s", event, name)
+	}
+	return fmt.Sprintf("[%s] %s on %s", event, name, homeserver)
+}
+
+func alertBody(rule *AlertRule, v float64) string {
+	return fmt.Sprintf("%s is %s (alerting while %s %s %s, over %s).",
+		rule.Metric, strconv.FormatFloat(v, 'f', -1, 64),
+		rule.Metric, rule.Comparison, strconv.FormatFloat(rule.Threshold, 'f', -1, 64), rule.Window)
+}
+
+// AlertsHandler serves /admin/alerts, the alerts which fired, newest first.
+// It accepts the query parameters rule, state (firing or resolved) and
+// limit.
+type AlertsHandler struct {
+	DB *sql.DB
+}
+
+func (h *AlertsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	if req.Method != http.MethodGet {
+		methodNotAllowed(w, req, http.MethodGet)
+		return
+	}
+	q := req.URL.Query()
+	limit, err := intParam(q.Get("limit"), defaultRowLimit)
+	if err != nil || limit <= 0 || limit > *maxRowLimit {
+		logAndReplyError(w, fmt.Errorf("bad limit %q", q.Get("limit")), 400, "Bad query")
+		return
+	}
+	var where []string
+	var args []interface{}
+	if rule := q.Get("rule"); rule != "" {
+		args = append(args, rule)
+		where = append(where, "rule = "+d.placeholder(len(args)))
+	}
+	switch q.Get("state") {
+	case "":
+	case "firing":
+		where = append(where, "resolved_at IS NULL")
+	case "resolved":
+		where = append(where, "resolved_at IS NOT NULL")
+	default:
+		logAndReplyError(w, fmt.Errorf("bad state %q", q.Get("state")), 400, "Bad query")
+		return
+	}
+	alerts, err := queryAlerts(h.DB, strings.Join(where, " AND "), args, limit)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error loading alerts")
+		return
+	}
+	writeJSON(w, alerts)
+}
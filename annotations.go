@@ -0,0 +1,291 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Annotation explains what happened at a time, or over a range of time,
+// such as an outage or a spam wave, so that charts can be read later.
+type Annotation struct {
+	ID        int64    `json:"id"`
+	Title     string   `json:"title"`
+	Text      string   `json:"text,omitempty"`
+	Tags      []string `json:"tags"`
+	StartsAt  int64    `json:"starts_at"`
+	EndsAt    *int64   `json:"ends_at"` // Null for a moment rather than a range
+	CreatedAt int64    `json:"created_at"`
+}
+
+func createTableAnnotations(db *sql.DB) error {
+	err := createTable(db, &tableDef{Name: "annotations", Columns: []columnDef{
+		{"title", "VARCHAR(256) NOT NULL"},
+		{"text", "TEXT"},
+		{"tags", "VARCHAR(1024)"}, // Comma separated
+		{"starts_at", "BIGINT NOT NULL"},
+		{"ends_at", "BIGINT"},
+		{"created_at", "BIGINT NOT NULL"},
+	}})
+	if err != nil {
+		return err
+	}
+	return createIndex(db, "annotations_starts_at", "annotations", "starts_at")
+}
+
+func (a *Annotation) validate() error {
+	if a.Title == "" || len(a.Title) > 256 {
+		return errors.New("title must be 1 to 256 bytes")
+	}
+	if a.StartsAt <= 0 {
+		return errors.New("missing starts_at")
+	}
+	if a.EndsAt != nil && *a.EndsAt < a.StartsAt {
+		return errors.New("ends_at must not be before starts_at")
+	}
+	for _, t := range a.Tags {
+		if t == "" || strings.ContainsAny(t, ", ") {
+			return fmt.Errorf("bad tag %q", t)
+		}
+	}
+	if len(strings.Join(a.Tags, ",")) > 1024 {
+		return errors.New("too many tags")
+	}
+	return nil
+}
+
+// queryAnnotations returns the annotations overlapping since to until,
+// oldest first, with every one of tags.
+func queryAnnotations(db *sql.DB, since, until int64, tags []string) ([]*Annotation, error) {
+	d := dialectFor(db)
+	rows, err := db.Query(fmt.Sprintf(`SELECT id, title, text, tags, starts_at, ends_at, created_at FROM annotations
+		WHERE starts_at < %s AND COALESCE(ends_at, starts_at) >= %s ORDER BY starts_at, id`,
+		d.placeholder(1), d.placeholder(2)), until, since)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	annotations := []*Annotation{}
+	for rows.Next() {
+		var (
+			a             Annotation
+			text, tagList sql.NullString
+			ends          sql.NullInt64
+		)
+		if err := rows.Scan(&a.ID, &a.Title, &text, &tagList, &a.StartsAt, &ends, &a.CreatedAt); err != nil {
+			return nil, err
+		}
+		a.Text = text.String
+		a.Tags = []string{}
+		if tagList.String != "" {
+			a.Tags = strings.Split(tagList.String, ",")
+		}
+		if ends.Valid {
+			a.EndsAt = &ends.Int64
+		}
+		if a.hasTags(tags) {
+			annotations = append(annotations, &a)
+		}
+	}
+	return annotations, rows.Err()
+}
+
+func (a *Annotation) hasTags(tags []string) bool {
+	for _, want := range tags {
+		found := false
+		for _, t := range a.Tags {
+			found = found || t == want
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// annotationRange parses the since and until query parameters (seconds),
+// which default to everything.
+func annotationRange(q map[string][]string) (int64, int64, error) {
+	since, until := int64(0), int64(1)<<62
+	for _, p := range []struct {
+		param string
+		value *int64
+	}{{"since", &since}, {"until", &until}} {
+		if v := strings.Join(q[p.param], ""); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				return 0, 0, err
+			}
+			*p.value = ts
+		}
+	}
+	return since, until, nil
+}
+
+// AnnotationsHandler serves /api/v1/annotations, the annotations
+// overlapping a range of time. It accepts the query parameters since and
+// until (seconds) and tag (repeated, all of which must match).
+type AnnotationsHandler struct {
+	DB *sql.DB
+}
+
+func (h *AnnotationsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	since, until, err := annotationRange(q)
+	if err != nil {
+		logAndReplyError(w, err, 400, "Bad query")
+		return
+	}
+	annotations, err := queryAnnotations(h.DB, since, until, q["tag"])
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error loading annotations")
+		return
+	}
+	writeJSON(w, annotations)
+}
+
+// AdminAnnotationsHandler serves /admin/annotations: GET lists annotations
+// as /api/v1/annotations does, POST adds one and DELETE ?id=... removes
+// one.
+type AdminAnnotationsHandler struct {
+	DB *sql.DB
+}
+
+func (h *AdminAnnotationsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	switch req.Method {
+	case http.MethodGet:
+		(&AnnotationsHandler{h.DB}).ServeHTTP(w, req)
+	case http.MethodPost:
+		var a Annotation
+		if err := json.NewDecoder(req.Body).Decode(&a); err != nil {
+			logAndReplyError(w, err, 400, "Error decoding annotation")
+			return
+		}
+		if a.Tags == nil {
+			a.Tags = []string{}
+		}
+		if err := a.validate(); err != nil {
+			logAndReplyError(w, err, 400, "Error decoding annotation")
+			return
+		}
+		var ends interface{}
+		if a.EndsAt != nil {
+			ends = *a.EndsAt
+		}
+		a.CreatedAt = time.Now().UTC().Unix()
+		var err error
+		a.ID, err = insertRow(h.DB, "annotations", []string{"title", "text", "tags", "starts_at", "ends_at", "created_at"},
+			[]interface{}{a.Title, a.Text, strings.Join(a.Tags, ","), a.StartsAt, ends, a.CreatedAt})
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error saving annotation")
+			return
+		}
+		writeJSON(w, a)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(req.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			logAndReplyError(w, err, 400, "Bad annotation ID")
+			return
+		}
+		if _, err := h.DB.Exec("DELETE FROM annotations WHERE id = "+d.placeholder(1), id); err != nil {
+			logAndReplyError(w, err, 500, "Error removing annotation")
+			return
+		}
+		writeJSON(w, struct{}{})
+	default:
+		methodNotAllowed(w, req, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
+
+// grafanaAnnotation is an annotation in the form Grafana's JSON data
+// sources expect.
+type grafanaAnnotation struct {
+	Annotation json.RawMessage `json:"annotation"`
+	Time       int64           `json:"time"` // Milliseconds
+	TimeEnd    int64           `json:"timeEnd,omitempty"`
+	IsRegion   bool            `json:"isRegion"`
+	Title      string          `json:"title"`
+	Text       string          `json:"text"`
+	Tags       []string        `json:"tags"`
+}
+
+// GrafanaHandler serves /api/v1/grafana/, for use as a Grafana JSON data
+// source. GET / answers Grafana's connection test, and POST /annotations
+// returns the annotations in the dashboard's time range, optionally only
+// those with every tag in the annotation's query, separated by spaces.
+type GrafanaHandler struct {
+	DB *sql.DB
+}
+
+func (h *GrafanaHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch strings.TrimPrefix(req.URL.Path, "/api/v1/grafana") {
+	case "", "/":
+		writeJSON(w, struct{}{})
+	case "/annotations":
+		if req.Method != http.MethodPost {
+			methodNotAllowed(w, req, http.MethodPost)
+			return
+		}
+		h.serveAnnotations(w, req)
+	default:
+		notFound(w, req)
+	}
+}
+
+func (h *GrafanaHandler) serveAnnotations(w http.ResponseWriter, req *http.Request) {
+	var body struct {
+		Range struct {
+			From time.Time `json:"from"`
+			To   time.Time `json:"to"`
+		} `json:"range"`
+		Annotation json.RawMessage `json:"annotation"`
+	}
+	if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+		logAndReplyError(w, err, 400, "Error decoding annotation query")
+		return
+	}
+	var query struct {
+		Query string `json:"query"`
+	}
+	json.Unmarshal(body.Annotation, &query)
+	annotations, err := queryAnnotations(h.DB, body.Range.From.Unix(), body.Range.To.Unix()+1, strings.Fields(query.Query))
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error loading annotations")
+		return
+	}
+	result := []grafanaAnnotation{}
+	for _, a := range annotations {
+		g := grafanaAnnotation{
+			Annotation: body.Annotation,
+			Time:       a.StartsAt * 1000,
+			Title:      a.Title,
+			Text:       a.Text,
+			Tags:       a.Tags,
+		}
+		if a.EndsAt != nil && *a.EndsAt > a.StartsAt {
+			g.TimeEnd, g.IsRegion = *a.EndsAt*1000, true
+		}
+		result = append(result, g)
+	}
+	writeJSON(w, result)
+}
@@ -0,0 +1,301 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+var readToken = flag.String("read-token", "", "bearer token required by the /api/v1 read endpoints; they are disabled if neither this nor -admin-token is set, and no roles are configured")
+
+const defaultRowLimit = 100
+
+// cidrPageSize is the fewest reports read by each query of a
+// /api/v1/reports request filtered by cidr.
+const cidrPageSize = 1000
+
+// requireReader wraps a read API handler so that it is only reachable with
+// the read token, the admin token, or the token of one of roles, in which
+// case the role is attached to the request.
+func requireReader(roles map[string]*Role, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if *readToken == "" && *adminToken == "" && len(roles) == 0 {
+			notFound(w, req)
+			return
+		}
+		token := []byte(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		if (*readToken == "" || subtle.ConstantTimeCompare(token, []byte(*readToken)) != 1) &&
+			(*adminToken == "" || subtle.ConstantTimeCompare(token, []byte(*adminToken)) != 1) {
+			role := matchRole(roles, token)
+			if role == nil {
+				writeError(w, http.StatusUnauthorized, errcodeUnauthorized, "unauthorized")
+				return
+			}
+			req = withRole(req, role)
+		}
+		h(w, req)
+	}
+}
+
+// ReportsHandler serves /api/v1/reports, listing raw reports. It accepts
+// the query parameters table (stats or dendrite_stats), homeserver, since
+// and until (local timestamps, seconds), cidr, tag, exclude_tag, product,
+// version and limit.
+// With metadata=1, each report includes the metadata recorded for its
+// homeserver.
+type ReportsHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *ReportsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	if aggregatesOnly(w, req) {
+		return
+	}
+	q := req.URL.Query()
+	table := q.Get("table")
+	if table == "" {
+		table = "stats"
+	} else if table != "stats" && table != "dendrite_stats" {
+		logAndReplyError(w, fmt.Errorf("unknown table %q", table), 400, "Bad query")
+		return
+	}
+	limit, err := intParam(q.Get("limit"), defaultRowLimit)
+	if err != nil || limit <= 0 || limit > *maxRowLimit {
+		logAndReplyError(w, fmt.Errorf("bad limit %q", q.Get("limit")), 400, "Bad query")
+		return
+	}
+	var cidr *net.IPNet
+	if c := q.Get("cidr"); c != "" {
+		if _, cidr, err = net.ParseCIDR(c); err != nil {
+			logAndReplyError(w, err, 400, "Bad query")
+			return
+		}
+	}
+
+	role := roleOf(req)
+	if cidr != nil && (hiddenColumnError(w, role, "remote_ip", "cidr") || hiddenColumnError(w, role, "remote_addr", "cidr")) {
+		return
+	}
+	if (q.Get("homeserver") != "" || len(q["tag"]) > 0 || len(q["exclude_tag"]) > 0 || q.Get("metadata") == "1") &&
+		hiddenColumnError(w, role, "homeserver", "homeserver, tag and metadata") {
+		return
+	}
+	product, ok := productFilterOf(w, req)
+	if !ok {
+		return
+	}
+	if (q.Get("since") != "" || q.Get("until") != "") && hiddenColumnError(w, role, "local_timestamp", "since and until") {
+		return
+	}
+
+	var where []string
+	var args []interface{}
+	if hs := q.Get("homeserver"); hs != "" {
+		args = append(args, h.Storage.storedHomeserver(hs))
+		where = append(where, "homeserver = "+d.placeholder(len(args)))
+	}
+	var since int64
+	for _, p := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		if v := q.Get(p.param); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				logAndReplyError(w, err, 400, "Bad query")
+				return
+			}
+			if p.param == "since" {
+				since = ts
+			}
+			args = append(args, ts)
+			where = append(where, fmt.Sprintf("local_timestamp %s %s", p.op, d.placeholder(len(args))))
+		}
+	}
+	markPruned(w, since)
+	where = append(where, tagConditions(q, d, "homeserver", &args)...)
+	where = append(where, product.conditions(d, &args)...)
+	columns := "*"
+	for _, t := range h.Storage.tables() {
+		if t.Kind == table {
+			if cols := role.visibleColumns(t); len(cols) > 0 {
+				columns = strings.Join(cols, ", ")
+			} else if cols != nil {
+				logAndReplyError(w, fmt.Errorf("no columns of %s are visible", table), http.StatusForbidden, "Forbidden query")
+				return
+			}
+		}
+	}
+	// CIDR matching happens after the query, so can't be limited in SQL.
+	// Instead reports are read a page at a time, each continuing from the
+	// last id of the one before, until limit of them match.
+	pageSize := limit
+	hideID := false
+	if cidr != nil {
+		if pageSize < cidrPageSize {
+			pageSize = cidrPageSize
+		}
+		if columns != "*" && !role.visible("id") {
+			columns += ", id"
+			hideID = true
+		}
+	}
+
+	var decryptErr error
+	keep := func(row map[string]interface{}) bool {
+		if err := h.Storage.Encryption.decryptRow(row, role); err != nil {
+			decryptErr = err
+		}
+		if cidr == nil {
+			return true
+		}
+		ip, _ := row["remote_ip"].(string)
+		if ip == "" {
+			addr, _ := row["remote_addr"].(string)
+			ip, _ = canonicalIP(addr)
+		}
+		parsed := net.ParseIP(ip)
+		return parsed != nil && cidr.Contains(parsed)
+	}
+	reports := []map[string]interface{}{}
+	var lastID interface{}
+	for {
+		pageWhere, pageArgs := where, args
+		if lastID != nil {
+			pageArgs = append(append([]interface{}{}, args...), lastID)
+			pageWhere = append(append([]string{}, where...), "id < "+d.placeholder(len(pageArgs)))
+		}
+		qry := "SELECT " + columns + " FROM " + h.Storage.tableName(table)
+		if len(pageWhere) > 0 {
+			qry += " WHERE " + strings.Join(pageWhere, " AND ")
+		}
+		qry += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", pageSize)
+
+		rows, qerr := h.DB.QueryContext(req.Context(), qry, pageArgs...)
+		if qerr != nil {
+			replyQueryError(w, req, qerr, "Error querying reports")
+			return
+		}
+		read := 0
+		var page []map[string]interface{}
+		page, err = scanRows(rows, limit-len(reports), func(row map[string]interface{}) bool {
+			read++
+			lastID = row["id"]
+			if hideID {
+				delete(row, "id")
+			}
+			return keep(row)
+		})
+		rows.Close()
+		reports = append(reports, page...)
+		if err != nil || cidr == nil || read < pageSize || len(reports) >= limit {
+			break
+		}
+	}
+	if err = partialRows(w, req, err); err != nil {
+		replyQueryError(w, req, err, "Error querying reports")
+		return
+	}
+	if decryptErr != nil {
+		logAndReplyError(w, decryptErr, 500, "Error querying reports")
+		return
+	}
+	if q.Get("metadata") == "1" {
+		if err := attachMetadata(h.DB, reports); err != nil {
+			logAndReplyError(w, err, 500, "Error querying reports")
+			return
+		}
+	}
+	writeJSON(w, reports)
+}
+
+// scanRows reads up to limit rows accepted by keep as column name to value
+// maps, converting values to JSON friendly types based on the column type.
+func scanRows(rows *sql.Rows, limit int, keep func(map[string]interface{}) bool) ([]map[string]interface{}, error) {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	result := []map[string]interface{}{}
+	for len(result) < limit && rows.Next() {
+		row, err := scanRow(rows, types)
+		if err != nil {
+			return result, err
+		}
+		if keep == nil || keep(row) {
+			result = append(result, row)
+		}
+	}
+	return result, rows.Err()
+}
+
+// scanRow scans the current row into a map from column names to values.
+func scanRow(rows *sql.Rows, types []*sql.ColumnType) (map[string]interface{}, error) {
+	raw := make([]interface{}, len(types))
+	ptrs := make([]interface{}, len(types))
+	for i := range raw {
+		ptrs[i] = &raw[i]
+	}
+	if err := rows.Scan(ptrs...); err != nil {
+		return nil, err
+	}
+	row := make(map[string]interface{}, len(types))
+	for i, t := range types {
+		row[t.Name()] = jsonValue(raw[i], t.DatabaseTypeName())
+	}
+	return row, nil
+}
+
+// jsonValue converts a value scanned from the database into a string or
+// number. Some drivers return numbers as bytes, so the column type decides.
+func jsonValue(v interface{}, dbType string) interface{} {
+	b, ok := v.([]byte)
+	if !ok {
+		return v
+	}
+	s := string(b)
+	dbType = strings.ToUpper(dbType)
+	switch {
+	case strings.Contains(dbType, "INT"):
+		if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			return n
+		}
+	case strings.Contains(dbType, "DOUBLE"), strings.Contains(dbType, "FLOAT"),
+		strings.Contains(dbType, "REAL"), strings.Contains(dbType, "NUMERIC"), strings.Contains(dbType, "DECIMAL"):
+		if f, err := strconv.ParseFloat(s, 64); err == nil {
+			return f
+		}
+	}
+	return s
+}
+
+// intParam parses an optional integer query parameter.
+func intParam(v string, def int) (int, error) {
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, errors.New("not an integer")
+	}
+	return n, nil
+}
@@ -0,0 +1,347 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// runQueryArchive implements "panopticon query-archive", which runs a query
+// over exported Parquet or NDJSON archives of reports, so that historical
+// analyses don't need old data restored into a live instance. NDJSON
+// archives are loaded into an in-memory sqlite database. Parquet archives
+// are queried where they lie by DuckDB, so need a build with -tags duckdb.
+func runQueryArchive(args []string) int {
+	fs := flag.NewFlagSet("query-archive", flag.ExitOnError)
+	query := fs.String("query", "", "the SQL query to run, over the archives as the table given by -table")
+	table := fs.String("table", "reports", "the name the archives are queried by")
+	format := fs.String("format", "csv", "csv or json")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: panopticon query-archive -query <SQL> [flags] <archive>...")
+		fmt.Fprintln(fs.Output(), "Archives are .parquet, or .ndjson or .jsonl files, optionally gzipped, or globs of them.")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *query == "" || fs.NArg() == 0 || (*format != "csv" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+	files, err := archiveFiles(fs.Args())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	var db *sql.DB
+	if hasParquet(files) {
+		db, err = openArchiveDuckDB(*table, files)
+	} else {
+		db, err = openArchiveSQLite(*table, files)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error reading archives: %v\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	rows, err := db.Query(*query)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running query: %v\n", err)
+		return 1
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running query: %v\n", err)
+		return 1
+	}
+	result, err := scanRows(rows, int(^uint(0)>>1), nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error running query: %v\n", err)
+		return 1
+	}
+	if *format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(result)
+		return 0
+	}
+	cw := csv.NewWriter(os.Stdout)
+	cw.Write(columns)
+	for _, row := range result {
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			if v := row[c]; v != nil {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return 0
+}
+
+// archiveFile is an archive and the format it is in.
+type archiveFile struct {
+	path    string
+	parquet bool
+}
+
+// archiveFiles expands the globs among paths and works out the format of
+// each file from its extension.
+func archiveFiles(paths []string) ([]archiveFile, error) {
+	var files []archiveFile
+	for _, p := range paths {
+		matches, err := filepath.Glob(p)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no archives match %s", p)
+		}
+		sort.Strings(matches)
+		for _, m := range matches {
+			switch ext := strings.TrimSuffix(m, ".gz"); {
+			case strings.HasSuffix(m, ".parquet"):
+				files = append(files, archiveFile{m, true})
+			case strings.HasSuffix(ext, ".ndjson"), strings.HasSuffix(ext, ".jsonl"):
+				files = append(files, archiveFile{m, false})
+			default:
+				return nil, fmt.Errorf("%s: not a .parquet, .ndjson or .jsonl archive", m)
+			}
+		}
+	}
+	return files, nil
+}
+
+func hasParquet(files []archiveFile) bool {
+	for _, f := range files {
+		if f.parquet {
+			return true
+		}
+	}
+	return false
+}
+
+// openArchiveDuckDB opens an in-memory DuckDB database in which the
+// archives are a view, read from the files by each query. Columns are
+// matched up by name, so archives exported before a column was added
+// have it as null. NDJSON archives among them need DuckDB's json
+// extension.
+func openArchiveDuckDB(table string, files []archiveFile) (*sql.DB, error) {
+	found := false
+	for _, d := range sql.Drivers() {
+		found = found || d == "duckdb"
+	}
+	if !found {
+		return nil, errors.New("Parquet archives can only be read by builds with -tags duckdb")
+	}
+	var parquet, ndjson []string
+	for _, f := range files {
+		quoted := "'" + strings.ReplaceAll(f.path, "'", "''") + "'"
+		if f.parquet {
+			parquet = append(parquet, quoted)
+		} else {
+			ndjson = append(ndjson, quoted)
+		}
+	}
+	var selects []string
+	if len(parquet) > 0 {
+		selects = append(selects, fmt.Sprintf("SELECT * FROM read_parquet([%s], union_by_name = true)", strings.Join(parquet, ", ")))
+	}
+	if len(ndjson) > 0 {
+		selects = append(selects, fmt.Sprintf("SELECT * FROM read_json_auto([%s], format = 'newline_delimited', union_by_name = true)", strings.Join(ndjson, ", ")))
+	}
+	db, err := openDB("duckdb", "")
+	if err != nil {
+		return nil, err
+	}
+	extensions := []string{"parquet"}
+	if len(ndjson) > 0 {
+		extensions = append(extensions, "json")
+	}
+	for _, e := range extensions {
+		if err := loadDuckDBExtension(db, e); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	d := dialect("duckdb")
+	_, err = db.Exec(fmt.Sprintf("CREATE VIEW %s AS %s", d.quote(table), strings.Join(selects, " UNION ALL BY NAME ")))
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+// loadDuckDBExtension loads one of DuckDB's extensions, which some builds
+// of it have to download the first time.
+func loadDuckDBExtension(db *sql.DB, name string) error {
+	if _, err := db.Exec("LOAD " + name); err == nil {
+		return nil
+	}
+	if _, err := db.Exec("INSTALL " + name); err != nil {
+		return fmt.Errorf("installing DuckDB's %s extension: %v", name, err)
+	}
+	_, err := db.Exec("LOAD " + name)
+	return err
+}
+
+// openArchiveSQLite loads NDJSON archives into a table of an in-memory
+// sqlite database, adding columns as they are first seen.
+func openArchiveSQLite(table string, files []archiveFile) (*sql.DB, error) {
+	db, err := openDB("sqlite", ":memory:")
+	if err != nil {
+		return nil, err
+	}
+	// Each connection would have its own in-memory database.
+	db.SetMaxOpenConns(1)
+	// A table needs a column to start with, which is dropped once the
+	// archives have added theirs.
+	l := &archiveLoader{db: db, table: table, d: dialect("sqlite"), columns: map[string]bool{}}
+	if _, err := db.Exec(fmt.Sprintf("CREATE TABLE %s (_placeholder INTEGER)", l.d.quote(table))); err != nil {
+		db.Close()
+		return nil, err
+	}
+	for _, f := range files {
+		if err := l.load(f.path); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("%s: %v", f.path, err)
+		}
+	}
+	if len(l.columns) == 0 {
+		return db, nil
+	}
+	if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP COLUMN _placeholder", l.d.quote(table))); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return db, nil
+}
+
+type archiveLoader struct {
+	db      *sql.DB
+	table   string
+	d       dialect
+	columns map[string]bool
+}
+
+// load inserts the reports of one NDJSON file.
+func (l *archiveLoader) load(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	var r io.Reader = f
+	if strings.HasSuffix(path, ".gz") {
+		zr, err := gzip.NewReader(f)
+		if err != nil {
+			return err
+		}
+		defer zr.Close()
+		r = zr
+	}
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(nil, 16<<20)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		if err := l.insert(tx, scanner.Bytes()); err != nil {
+			return fmt.Errorf("line %d: %v", line, err)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (l *archiveLoader) insert(tx *sql.Tx, line []byte) error {
+	dec := json.NewDecoder(bytes.NewReader(line))
+	dec.UseNumber()
+	var report map[string]interface{}
+	if err := dec.Decode(&report); err != nil {
+		return err
+	}
+	if report == nil {
+		return errors.New("not an object")
+	}
+	var cols []string
+	for c := range report {
+		cols = append(cols, c)
+	}
+	sort.Strings(cols)
+	// Field names are quoted whole, since a report's fields may contain
+	// dots or quotes.
+	quoted := make([]string, len(cols))
+	vals := make([]interface{}, len(cols))
+	for i, c := range cols {
+		if c == "_placeholder" {
+			return fmt.Errorf("unsupported field name %q", c)
+		}
+		quoted[i] = l.d.quoteIdent(c)
+		if !l.columns[c] {
+			if _, err := tx.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s", l.d.quote(l.table), quoted[i])); err != nil {
+				return err
+			}
+			l.columns[c] = true
+		}
+		vals[i] = archiveValue(report[c])
+	}
+	if len(cols) == 0 {
+		return nil
+	}
+	_, err := tx.Exec(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		l.d.quote(l.table), strings.Join(quoted, ", "), strings.Join(l.d.placeholders(len(cols)), ", ")), vals...)
+	return err
+}
+
+// archiveValue converts a JSON value to what sqlite stores it as: numbers
+// as integers where they are whole, and objects and arrays as JSON.
+func archiveValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if n, err := v.Int64(); err == nil {
+			return n
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}, []interface{}:
+		b, _ := json.Marshal(v)
+		return string(b)
+	}
+	return v
+}
@@ -0,0 +1,108 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	backfillToken  = flag.String("backfill-token", "", "bearer token allowing reports to be submitted to /api/v1/backfill; the admin token is also accepted")
+	maxBackfillAge = flag.Duration("max-backfill-age", 30*24*time.Hour, "how far in the past backfilled reports may be")
+)
+
+type backfillKey struct{}
+
+// backfillTimestamp returns the local timestamp a backfilled report should be
+// stored with.
+func backfillTimestamp(ctx context.Context) (int64, bool) {
+	ts, ok := ctx.Value(backfillKey{}).(int64)
+	return ts, ok
+}
+
+// requireBackfiller wraps the backfill handler so that it is only reachable
+// with the backfill token or the admin token.
+func requireBackfiller(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if *backfillToken == "" && *adminToken == "" {
+			notFound(w, req)
+			return
+		}
+		token := []byte(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+		if (*backfillToken == "" || subtle.ConstantTimeCompare(token, []byte(*backfillToken)) != 1) &&
+			(*adminToken == "" || subtle.ConstantTimeCompare(token, []byte(*adminToken)) != 1) {
+			writeError(w, http.StatusUnauthorized, errcodeUnauthorized, "unauthorized")
+			return
+		}
+		h(w, req)
+	}
+}
+
+// Backfill serves /api/v1/backfill, which accepts a report queued while a
+// reporter was offline. The report carries the time it was collected as
+// local_timestamp, and is stored as though it had arrived then, marked as
+// backfilled. Backfilled reports are never considered stale or downsampled.
+func (r *Recorder) Backfill(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, req, http.MethodPost)
+		return
+	}
+	body, ok := readPushBody(w, req)
+	req.Body.Close()
+	if !ok {
+		return
+	}
+	if err := checkPushBody(body); err != nil {
+		logAndReplyError(w, err, 400, "Rejected report")
+		return
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		replyError(w, err, 400, jsonErrcode(err), "Error decoding JSON")
+		return
+	}
+	var ts int64
+	if _, ok := raw["local_timestamp"]; !ok {
+		logAndReplyError(w, fmt.Errorf("missing local_timestamp"), 400, "Error decoding JSON")
+		return
+	}
+	if err := json.Unmarshal(raw["local_timestamp"], &ts); err != nil {
+		logAndReplyError(w, fmt.Errorf("invalid local_timestamp: %v", err), 400, "Error decoding JSON")
+		return
+	}
+	now := time.Now().UTC()
+	if ts > now.Unix() || ts < now.Add(-*maxBackfillAge).Unix() {
+		logAndReplyError(w, fmt.Errorf("local_timestamp %d is not within the last %s", ts, *maxBackfillAge), 400, "Rejected report")
+		return
+	}
+	delete(raw, "local_timestamp")
+	body, err := json.Marshal(raw)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error encoding JSON")
+		return
+	}
+	req = req.WithContext(context.WithValue(req.Context(), backfillKey{}, ts))
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	r.Handle(w, req)
+}
@@ -0,0 +1,393 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBigQueryEndpoint  = "https://bigquery.googleapis.com"
+	defaultBigQueryBatchSize = 500
+	bigQueryScope            = "https://www.googleapis.com/auth/bigquery.insertdata"
+)
+
+// BigQueryConfig configures the bigquery_export job, which streams new
+// stats rows into BigQuery tables.
+type BigQueryConfig struct {
+	CredentialsFile string `json:"credentials_file"` // Service account key, as downloaded from the console
+	Project         string `json:"project"`
+	Dataset         string `json:"dataset"`
+
+	// Tables maps stats and dendrite_stats to BigQuery tables. Tables which
+	// aren't listed keep their names.
+	Tables map[string]string `json:"tables"`
+	// Columns renames columns in BigQuery, or leaves them out if mapped to
+	// "-". Columns which aren't listed keep their names.
+	Columns map[string]string `json:"columns"`
+
+	BatchSize int    `json:"batch_size"` // Rows per insertAll request, 500 by default
+	Endpoint  string `json:"endpoint"`   // For testing against something other than BigQuery
+}
+
+// serviceAccount is the part of a service account key file which is needed
+// to get access tokens.
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type bigQueryExporter struct {
+	config  *BigQueryConfig
+	account serviceAccount
+	key     *rsa.PrivateKey
+	client  *http.Client
+
+	mu      sync.Mutex
+	token   string
+	expires time.Time
+}
+
+func newBigQueryExporter(c *BigQueryConfig, s *Storage) (*bigQueryExporter, error) {
+	if c.CredentialsFile == "" || c.Project == "" || c.Dataset == "" {
+		return nil, errors.New("bigquery needs credentials_file, project and dataset")
+	}
+	if s.generatedIDs() {
+		// Exports resume from the highest integer id exported.
+		return nil, errors.New("bigquery can't export rows with -id-type " + s.IDType)
+	}
+	b, err := os.ReadFile(c.CredentialsFile)
+	if err != nil {
+		return nil, err
+	}
+	e := &bigQueryExporter{config: c, client: &http.Client{Timeout: time.Minute}}
+	if err := json.Unmarshal(b, &e.account); err != nil {
+		return nil, fmt.Errorf("%s: %v", c.CredentialsFile, err)
+	}
+	if e.account.ClientEmail == "" || e.account.TokenURI == "" {
+		return nil, fmt.Errorf("%s: not a service account key", c.CredentialsFile)
+	}
+	if e.key, err = parseRSAKey(e.account.PrivateKey); err != nil {
+		return nil, fmt.Errorf("%s: %v", c.CredentialsFile, err)
+	}
+	for table := range c.Tables {
+		if table != "stats" && table != "dendrite_stats" {
+			return nil, fmt.Errorf("bigquery: unknown table %s", table)
+		}
+	}
+	return e, nil
+}
+
+func parseRSAKey(s string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("no PEM private key")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// accessToken exchanges a signed JWT for an access token, reusing it until
+// shortly before it expires.
+func (e *bigQueryExporter) accessToken(ctx context.Context) (string, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.token != "" && time.Now().Before(e.expires) {
+		return e.token, nil
+	}
+	now := time.Now()
+	enc := base64.RawURLEncoding
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":   e.account.ClientEmail,
+		"scope": bigQueryScope,
+		"aud":   e.account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	unsigned := enc.EncodeToString(header) + "." + enc.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, e.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {unsigned + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.account.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	var resp struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := e.do(req, &resp); err != nil {
+		return "", fmt.Errorf("getting access token: %v", err)
+	}
+	e.token = resp.AccessToken
+	e.expires = now.Add(time.Duration(resp.ExpiresIn)*time.Second - time.Minute)
+	return e.token, nil
+}
+
+// do sends a request and decodes its JSON response into v.
+func (e *bigQueryExporter) do(req *http.Request, v interface{}) error {
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// insertAll streams rows into a BigQuery table. The insert IDs let BigQuery
+// drop rows it has already seen when a batch is retried.
+func (e *bigQueryExporter) insertAll(ctx context.Context, table string, ids []string, rows []map[string]interface{}) error {
+	token, err := e.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	type insertRow struct {
+		InsertID string                 `json:"insertId"`
+		JSON     map[string]interface{} `json:"json"`
+	}
+	body := struct {
+		Kind string      `json:"kind"`
+		Rows []insertRow `json:"rows"`
+	}{Kind: "bigquery#tableDataInsertAllRequest"}
+	for i, row := range rows {
+		body.Rows = append(body.Rows, insertRow{ids[i], row})
+	}
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	endpoint := e.config.Endpoint
+	if endpoint == "" {
+		endpoint = defaultBigQueryEndpoint
+	}
+	u := fmt.Sprintf("%s/bigquery/v2/projects/%s/datasets/%s/tables/%s/insertAll",
+		strings.TrimSuffix(endpoint, "/"), url.PathEscape(e.config.Project), url.PathEscape(e.config.Dataset), url.PathEscape(table))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	var resp struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	if err := e.do(req, &resp); err != nil {
+		return err
+	}
+	if len(resp.InsertErrors) > 0 {
+		first := resp.InsertErrors[0]
+		msg := "unknown error"
+		if len(first.Errors) > 0 {
+			msg = first.Errors[0].Reason + ": " + first.Errors[0].Message
+		}
+		return fmt.Errorf("%d rows rejected, the first (%s) with %s", len(resp.InsertErrors), ids[first.Index], msg)
+	}
+	return nil
+}
+
+// mapRow renames or drops the columns of a row according to the config.
+func (e *bigQueryExporter) mapRow(row map[string]interface{}) map[string]interface{} {
+	out := make(map[string]interface{}, len(row))
+	for col, v := range row {
+		if v == nil {
+			continue
+		}
+		name := col
+		if mapped, ok := e.config.Columns[col]; ok {
+			name = mapped
+		}
+		if name == "-" || name == "" {
+			continue
+		}
+		out[name] = v
+	}
+	return out
+}
+
+func createTableExportWatermarks(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS export_watermarks(
+		exporter VARCHAR(64) NOT NULL,
+		source_table VARCHAR(64) NOT NULL,
+		last_id BIGINT NOT NULL,
+		updated_at BIGINT NOT NULL,
+		PRIMARY KEY (exporter, source_table)
+		)`)
+	return err
+}
+
+// loadWatermark returns the ID of the last row of a table the exporter has
+// sent, or 0 if it hasn't sent any.
+func loadWatermark(db *sql.DB, exporter, table string) (int64, error) {
+	d := dialectFor(db)
+	var id int64
+	err := db.QueryRow(
+		fmt.Sprintf("SELECT last_id FROM export_watermarks WHERE exporter = %s AND source_table = %s", d.placeholder(1), d.placeholder(2)),
+		exporter, table,
+	).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	return id, err
+}
+
+func saveWatermark(db *sql.DB, exporter, table string, id int64) error {
+	d := dialectFor(db)
+	now := time.Now().UTC().Unix()
+	res, err := db.Exec(
+		fmt.Sprintf("UPDATE export_watermarks SET last_id = %s, updated_at = %s WHERE exporter = %s AND source_table = %s",
+			d.placeholder(1), d.placeholder(2), d.placeholder(3), d.placeholder(4)),
+		id, now, exporter, table,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return nil
+	}
+	_, err = db.Exec(
+		dialectFor(db).insert("export_watermarks", "exporter", "source_table", "last_id", "updated_at"),
+		exporter, table, id, now,
+	)
+	return err
+}
+
+// bigQueryExport is the bigquery_export job. It sends the rows added to
+// each stats table since the last run, in batches, advancing the table's
+// watermark after each batch BigQuery accepts.
+func bigQueryExport(db *sql.DB, s *Storage, e *bigQueryExporter) func(ctx context.Context) error {
+	batchSize := e.config.BatchSize
+	if batchSize <= 0 {
+		batchSize = defaultBigQueryBatchSize
+	}
+	return func(ctx context.Context) error {
+		for _, table := range []string{"stats", "dendrite_stats"} {
+			dest := table
+			if t, ok := e.config.Tables[table]; ok {
+				dest = t
+			}
+			if dest == "-" {
+				continue
+			}
+			n, err := e.exportTable(ctx, db, s.tableName(table), dest, batchSize)
+			if n > 0 {
+				log.Printf("Exported %d rows from %s to BigQuery", n, table)
+				metrics.Add("panopticon_export_rows_total", float64(n), "exporter", "bigquery", "table", table)
+				addRowsAffected(ctx, int64(n))
+			}
+			if err != nil {
+				return fmt.Errorf("exporting %s: %v", table, err)
+			}
+		}
+		return nil
+	}
+}
+
+func (e *bigQueryExporter) exportTable(ctx context.Context, db *sql.DB, table, dest string, batchSize int) (int, error) {
+	d := dialectFor(db)
+	watermark, err := loadWatermark(db, "bigquery", table)
+	if err != nil {
+		return 0, err
+	}
+	exported := 0
+	for {
+		rows, err := db.QueryContext(ctx,
+			fmt.Sprintf("SELECT * FROM %s WHERE id > %s ORDER BY id LIMIT %d", table, d.placeholder(1), batchSize),
+			watermark,
+		)
+		if err != nil {
+			return exported, err
+		}
+		batch, err := scanRows(rows, batchSize, nil)
+		rows.Close()
+		if err != nil {
+			return exported, err
+		}
+		if len(batch) == 0 {
+			return exported, nil
+		}
+		ids := make([]string, len(batch))
+		mapped := make([]map[string]interface{}, len(batch))
+		last := watermark
+		for i, row := range batch {
+			id, ok := row["id"].(int64)
+			if !ok {
+				return exported, fmt.Errorf("unexpected id %v", row["id"])
+			}
+			ids[i] = fmt.Sprintf("%s-%d", table, id)
+			mapped[i] = e.mapRow(row)
+			last = id
+		}
+		if err := e.insertAll(ctx, dest, ids, mapped); err != nil {
+			return exported, err
+		}
+		if err := saveWatermark(db, "bigquery", table, last); err != nil {
+			return exported, err
+		}
+		exported += len(batch)
+		watermark = last
+		if len(batch) < batchSize {
+			return exported, nil
+		}
+	}
+}
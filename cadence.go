@@ -0,0 +1,160 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultCadenceWindow = 30 * 24 * time.Hour
+	maxCadenceWindow     = 90 * 24 * time.Hour
+)
+
+// Cadence describes how regularly a homeserver reports, so that one which
+// reports weekly can be told apart from one which has gone down.
+type Cadence struct {
+	Homeserver     string `json:"homeserver"`
+	Reports        int    `json:"reports"`
+	LastSeen       int64  `json:"last_seen"`
+	MedianInterval *int64 `json:"median_interval_seconds"` // Null with fewer than two reports
+	Jitter         *int64 `json:"jitter_seconds"`          // Median absolute deviation of the intervals
+	MissedReports  int64  `json:"missed_reports"`          // Expected reports which didn't arrive, including since the last one
+	Overdue        bool   `json:"overdue"`
+}
+
+// computeCadence works out the cadence of a homeserver from its report
+// timestamps, in ascending order.
+func computeCadence(homeserver string, timestamps []int64, now int64) *Cadence {
+	c := &Cadence{Homeserver: homeserver, Reports: len(timestamps)}
+	if len(timestamps) == 0 {
+		return c
+	}
+	c.LastSeen = timestamps[len(timestamps)-1]
+	if len(timestamps) < 2 {
+		return c
+	}
+	var intervals []int64
+	for i := 1; i < len(timestamps); i++ {
+		intervals = append(intervals, timestamps[i]-timestamps[i-1])
+	}
+	median := medianInt64(intervals)
+	var deviations []int64
+	for _, d := range intervals {
+		deviations = append(deviations, int64(math.Abs(float64(d-median))))
+	}
+	jitter := medianInt64(deviations)
+	c.MedianInterval, c.Jitter = &median, &jitter
+	if median <= 0 {
+		return c
+	}
+
+	// A gap of n intervals means n-1 reports went missing.
+	for _, d := range intervals {
+		if n := int64(math.Round(float64(d) / float64(median))); n > 1 {
+			c.MissedReports += n - 1
+		}
+	}
+	// Allow some slack so a report which is a little late isn't overdue.
+	slack := 2 * jitter
+	if slack < median/10 {
+		slack = median / 10
+	}
+	if elapsed := now - c.LastSeen; elapsed > median+slack {
+		c.Overdue = true
+		c.MissedReports += elapsed / median
+	}
+	return c
+}
+
+func medianInt64(values []int64) int64 {
+	sorted := append([]int64(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+// CadenceHandler serves /api/v1/cadence, the reporting cadence of each
+// homeserver over a window of history. It accepts the query parameters
+// homeserver and window (e.g. "7d" or "720h", up to 90 days).
+type CadenceHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *CadenceHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	if aggregatesOnly(w, req) {
+		return
+	}
+	q := req.URL.Query()
+	window := defaultCadenceWindow
+	if v := q.Get("window"); v != "" {
+		var err error
+		if window, err = parseWindow(v); err != nil || window <= 0 || window > maxCadenceWindow {
+			logAndReplyError(w, fmt.Errorf("bad window %q", v), 400, "Bad query")
+			return
+		}
+	}
+	now := time.Now().UTC()
+	var args []interface{}
+	var selects []string
+	for _, table := range []string{"stats", "dendrite_stats"} {
+		args = append(args, now.Add(-window).Unix())
+		where := "local_timestamp >= " + d.placeholder(len(args))
+		if hs := q.Get("homeserver"); hs != "" {
+			args = append(args, h.Storage.storedHomeserver(hs))
+			where += " AND homeserver = " + d.placeholder(len(args))
+		}
+		selects = append(selects, fmt.Sprintf("SELECT homeserver, local_timestamp FROM %s WHERE %s", h.Storage.tableName(table), where))
+	}
+	rows, err := h.DB.QueryContext(req.Context(), strings.Join(selects, " UNION ALL ")+" ORDER BY local_timestamp", args...)
+	if err != nil {
+		replyQueryError(w, req, err, "Error querying reports")
+		return
+	}
+	defer rows.Close()
+	timestamps := map[string][]int64{}
+	for rows.Next() {
+		var hs sql.NullString
+		var ts int64
+		if err := rows.Scan(&hs, &ts); err != nil {
+			logAndReplyError(w, err, 500, "Error querying reports")
+			return
+		}
+		if hs.Valid {
+			timestamps[hs.String] = append(timestamps[hs.String], ts)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		replyQueryError(w, req, err, "Error querying reports")
+		return
+	}
+	result := []*Cadence{}
+	for hs, ts := range timestamps {
+		result = append(result, computeCadence(hs, ts, now.Unix()))
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Homeserver < result[j].Homeserver })
+	writeJSON(w, result)
+}
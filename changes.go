@@ -0,0 +1,188 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Change is a reported attribute of a homeserver taking a new value.
+type Change struct {
+	Table    string      `json:"table"`
+	Field    string      `json:"field"`
+	From     interface{} `json:"from"`
+	To       interface{} `json:"to"`
+	Since    int64       `json:"since"`     // The first report with the new value
+	LastSeen int64       `json:"last_seen"` // The last report with the old value
+}
+
+// notAttributes are the text columns which describe a report rather than
+// the homeserver, so aren't tracked by default.
+var notAttributes = map[string]bool{
+	"homeserver":       true,
+	"remote_addr":      true,
+	"forwarded_for":    true,
+	"remote_ip":        true,
+	"adjusted_fields":  true,
+	"compacted_ranges": true,
+}
+
+// attributeColumns returns the text columns of t which describe the
+// homeserver, such as its version and database engine.
+func attributeColumns(t *tableDef) []string {
+	var cols []string
+	for _, c := range t.Columns {
+		if !notAttributes[c.Name] && (c.Type == "TEXT" || strings.HasPrefix(c.Type, "VARCHAR")) {
+			cols = append(cols, c.Name)
+		}
+	}
+	return cols
+}
+
+func isStatsColumn(name string) bool {
+	for _, t := range reportColumns() {
+		for _, c := range t.Columns {
+			if c.Name == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// fieldChanges returns the changes of fields between consecutive reports,
+// given in ascending order. Reports without a field are skipped for it, so
+// that fields a homeserver only sometimes reports don't appear to flap.
+func fieldChanges(table string, fields []string, reports []map[string]interface{}) []*Change {
+	var changes []*Change
+	for _, f := range fields {
+		var last interface{}
+		var lastSeen int64
+		for _, r := range reports {
+			v := r[f]
+			if v == nil {
+				continue
+			}
+			ts, _ := r["local_timestamp"].(int64)
+			if last != nil && v != last {
+				changes = append(changes, &Change{Table: table, Field: f, From: last, To: v, Since: ts, LastSeen: lastSeen})
+			}
+			last, lastSeen = v, ts
+		}
+	}
+	return changes
+}
+
+// ChangesHandler serves /api/v1/changes, when reported attributes of a
+// homeserver changed, such as its version or database engine. It accepts
+// the query parameters homeserver (required), field (repeatable, by default
+// every text attribute), since and until.
+type ChangesHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *ChangesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	if aggregatesOnly(w, req) {
+		return
+	}
+	q := req.URL.Query()
+	hs := q.Get("homeserver")
+	if hs == "" {
+		logAndReplyError(w, errors.New("missing homeserver"), 400, "Bad query")
+		return
+	}
+	role := roleOf(req)
+	if hiddenColumnError(w, role, "homeserver", "homeserver") || hiddenColumnError(w, role, "local_timestamp", "changes") {
+		return
+	}
+	requested := map[string]bool{}
+	for _, f := range q["field"] {
+		if !isStatsColumn(f) {
+			logAndReplyError(w, fmt.Errorf("unknown field %q", f), 400, "Bad query")
+			return
+		}
+		if hiddenColumnError(w, role, f, "field "+f) {
+			return
+		}
+		requested[f] = true
+	}
+
+	args := []interface{}{h.Storage.storedHomeserver(hs)}
+	where := []string{"homeserver = " + d.placeholder(1)}
+	var since int64
+	for _, p := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		if v := q.Get(p.param); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				logAndReplyError(w, err, 400, "Bad query")
+				return
+			}
+			if p.param == "since" {
+				since = ts
+			}
+			args = append(args, ts)
+			where = append(where, fmt.Sprintf("local_timestamp %s %s", p.op, d.placeholder(len(args))))
+		}
+	}
+	markPruned(w, since)
+
+	result := []*Change{}
+	for _, t := range h.Storage.tables() {
+		var fields []string
+		if len(requested) > 0 {
+			for _, c := range t.Columns {
+				if requested[c.Name] {
+					fields = append(fields, c.Name)
+				}
+			}
+		} else {
+			for _, f := range attributeColumns(t) {
+				if role.visible(f) {
+					fields = append(fields, f)
+				}
+			}
+		}
+		if len(fields) == 0 {
+			continue
+		}
+		rows, err := h.DB.QueryContext(req.Context(), fmt.Sprintf("SELECT local_timestamp, %s FROM %s WHERE %s ORDER BY local_timestamp, id",
+			strings.Join(fields, ", "), t.Name, strings.Join(where, " AND ")), args...)
+		if err != nil {
+			replyQueryError(w, req, err, "Error querying reports")
+			return
+		}
+		var reports []map[string]interface{}
+		err = eachRow(rows, func(row map[string]interface{}) error {
+			reports = append(reports, row)
+			return h.Storage.Encryption.decryptRow(row, role)
+		})
+		rows.Close()
+		if err != nil {
+			replyQueryError(w, req, err, "Error querying reports")
+			return
+		}
+		result = append(result, fieldChanges(t.Kind, fields, reports)...)
+	}
+	sort.SliceStable(result, func(i, j int) bool { return result[i].Since < result[j].Since })
+	writeJSON(w, result)
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// runCheck implements "panopticon check", an end-to-end canary for external
+// monitoring. It pushes a synthetic report to an instance, then polls the
+// read API until the report can be read back, exiting non-zero if either
+// fails.
+func runCheck(args []string) int {
+	fs := flag.NewFlagSet("check", flag.ExitOnError)
+	base := fs.String("url", "", "the base URL of the instance to check, such as https://stats.example.com")
+	readToken := fs.String("read-token", "", "a token for the read API of the instance")
+	homeserver := fs.String("homeserver", "panopticon-check.internal", "the homeserver to report as")
+	wait := fs.Duration("wait", 10*time.Second, "how long to wait for the report to be readable, such as from a replica")
+	timeout := fs.Duration("timeout", 10*time.Second, "the timeout of each request")
+	fs.Parse(args)
+	if *base == "" || *readToken == "" || fs.NArg() > 0 {
+		fmt.Fprintln(os.Stderr, "Usage: panopticon check -url <base URL> -read-token <token> [flags]")
+		return 2
+	}
+	client := &http.Client{Timeout: *timeout}
+	start := time.Now()
+	id, err := pushCheckReport(client, strings.TrimSuffix(*base, "/"), *homeserver)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "CHECK FAILED: pushing: %v\n", err)
+		return 1
+	}
+	for {
+		err = readCheckReport(client, strings.TrimSuffix(*base, "/"), *readToken, *homeserver, id)
+		if err == nil {
+			break
+		}
+		if time.Since(start) > *wait {
+			fmt.Fprintf(os.Stderr, "CHECK FAILED: reading back report %s: %v\n", id, err)
+			return 1
+		}
+		time.Sleep(time.Second)
+	}
+	fmt.Printf("OK: report %s stored and read back in %s\n", id, time.Since(start).Round(time.Millisecond))
+	return 0
+}
+
+// pushCheckReport pushes a report marked as coming from the checker,
+// returning the id it was stored with.
+func pushCheckReport(client *http.Client, base, homeserver string) (string, error) {
+	body, err := json.Marshal(map[string]interface{}{
+		"homeserver": homeserver,
+		"timestamp":  time.Now().Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest(http.MethodPost, base+"/push?verbose=1", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "panopticon-check")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.New(resp.Status)
+	}
+	var result struct {
+		ID          json.Number `json:"id"`
+		Downsampled bool        `json:"downsampled"`
+		SampledOut  bool        `json:"sampled_out"`
+	}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&result); err != nil {
+		return "", fmt.Errorf("decoding reply: %v", err)
+	}
+	switch {
+	case result.Downsampled:
+		return "", errors.New("report was downsampled, so not stored")
+	case result.SampledOut:
+		return "", errors.New("report was sampled out, so not stored")
+	case result.ID == "" || result.ID == "0":
+		return "", errors.New("reply has no id")
+	}
+	return result.ID.String(), nil
+}
+
+// readCheckReport looks for the report with the given id among the latest
+// reports of homeserver.
+func readCheckReport(client *http.Client, base, token, homeserver, id string) error {
+	q := url.Values{"homeserver": {homeserver}, "limit": {"10"}}
+	req, err := http.NewRequest(http.MethodGet, base+"/api/v1/reports?"+q.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(resp.Status)
+	}
+	var reports []map[string]interface{}
+	dec := json.NewDecoder(resp.Body)
+	dec.UseNumber()
+	if err := dec.Decode(&reports); err != nil {
+		return fmt.Errorf("decoding reports: %v", err)
+	}
+	for _, r := range reports {
+		if fmt.Sprint(r["id"]) == id {
+			return nil
+		}
+	}
+	return errors.New("not found")
+}
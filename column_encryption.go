@@ -0,0 +1,208 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// encryptedPrefix marks an encrypted value, so that values stored before
+// encryption was turned on are still read as they are.
+const encryptedPrefix = "enc1:"
+
+// ColumnEncryption encrypts sensitive text columns of reports with
+// AES-256-GCM before they are stored, so that a database which isn't fully
+// trusted never sees them. The key is given by exactly one of Key, KeyFile
+// and KeyCommand.
+type ColumnEncryption struct {
+	Key        string   `json:"key"`         // Base64
+	KeyFile    string   `json:"key_file"`    // Holding the key in base64
+	KeyCommand []string `json:"key_command"` // Printing the key in base64, such as a KMS client unwrapping it
+	Columns    []string `json:"columns"`     // Defaults to remote_addr, forwarded_for and user_agent
+	Roles      []string `json:"roles"`       // Roles which see decrypted values besides the read and admin tokens
+
+	aead    cipher.AEAD
+	columns map[string]bool
+	roles   map[*Role]bool
+}
+
+// compile checks the settings and loads the key.
+func (e *ColumnEncryption) compile(roles map[string]*Role) error {
+	key, err := e.loadKey()
+	if err != nil {
+		return err
+	}
+	if len(key) != 32 {
+		return fmt.Errorf("key is %d bytes, not 32", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	if e.aead, err = cipher.NewGCM(block); err != nil {
+		return err
+	}
+
+	if e.Columns == nil {
+		e.Columns = []string{"remote_addr", "forwarded_for", "user_agent"}
+	}
+	types := map[string]string{}
+	for _, t := range reportColumns() {
+		for _, c := range t.Columns {
+			types[c.Name] = c.Type
+		}
+	}
+	e.columns = map[string]bool{}
+	for _, c := range e.Columns {
+		// Ciphertext doesn't fit in the shorter VARCHAR columns.
+		if types[c] != "TEXT" {
+			return fmt.Errorf("column %s: not a TEXT column of reports", c)
+		}
+		e.columns[c] = true
+	}
+	e.roles = map[*Role]bool{}
+	for _, name := range e.Roles {
+		r, ok := roles[name]
+		if !ok {
+			return fmt.Errorf("unknown role %s", name)
+		}
+		e.roles[r] = true
+	}
+	return nil
+}
+
+func (e *ColumnEncryption) loadKey() ([]byte, error) {
+	var encoded []byte
+	switch {
+	case e.Key != "" && e.KeyFile == "" && e.KeyCommand == nil:
+		encoded = []byte(e.Key)
+	case e.Key == "" && e.KeyFile != "" && e.KeyCommand == nil:
+		b, err := os.ReadFile(e.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		encoded = b
+	case e.Key == "" && e.KeyFile == "" && len(e.KeyCommand) > 0:
+		var stderr bytes.Buffer
+		cmd := exec.Command(e.KeyCommand[0], e.KeyCommand[1:]...)
+		cmd.Stderr = &stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("key_command: %v: %s", err, strings.TrimSpace(stderr.String()))
+		}
+		encoded = out
+	default:
+		return nil, errors.New("needs exactly one of key, key_file and key_command")
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(encoded)))
+	if err != nil {
+		return nil, fmt.Errorf("decoding key: %v", err)
+	}
+	return key, nil
+}
+
+// encrypts reports whether values of a column are encrypted.
+func (e *ColumnEncryption) encrypts(column string) bool {
+	return e != nil && e.columns[column]
+}
+
+// encrypt encrypts a value of a column, binding it to the column so that
+// it can't be moved to another. Values of other columns, and NULLs, are
+// returned unchanged.
+func (e *ColumnEncryption) encrypt(column string, v interface{}) interface{} {
+	s, ok := v.(string)
+	if !ok || !e.encrypts(column) {
+		return v
+	}
+	nonce := make([]byte, e.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(err)
+	}
+	sealed := e.aead.Seal(nonce, nonce, []byte(s), []byte(column))
+	return encryptedPrefix + base64.StdEncoding.EncodeToString(sealed)
+}
+
+// encryptRow encrypts the values of a row to be inserted. remote_ip is the
+// canonical form of remote_addr, and too short a column to hold ciphertext,
+// so it isn't stored when remote_addr is encrypted.
+func (e *ColumnEncryption) encryptRow(cols []string, vals []interface{}) []interface{} {
+	if e == nil {
+		return vals
+	}
+	encrypted := make([]interface{}, len(vals))
+	for i, c := range cols {
+		encrypted[i] = e.encrypt(c, vals[i])
+		if c == "remote_ip" && e.encrypts("remote_addr") {
+			encrypted[i] = nil
+		}
+	}
+	return encrypted
+}
+
+// decrypt returns the plaintext of a value of a column, or the value
+// unchanged if it isn't encrypted. Only the configured columns are ever
+// encrypted; a value of any other column is plaintext, whatever a push put
+// in it.
+func (e *ColumnEncryption) decrypt(column string, v string) (string, error) {
+	if !e.encrypts(column) || !strings.HasPrefix(v, encryptedPrefix) {
+		return v, nil
+	}
+	sealed, err := base64.StdEncoding.DecodeString(v[len(encryptedPrefix):])
+	if err != nil || len(sealed) < e.aead.NonceSize() {
+		return "", fmt.Errorf("malformed encrypted value in %s", column)
+	}
+	n := e.aead.NonceSize()
+	plain, err := e.aead.Open(nil, sealed[:n], sealed[n:], []byte(column))
+	if err != nil {
+		return "", fmt.Errorf("decrypting %s: %v", column, err)
+	}
+	return string(plain), nil
+}
+
+// mayDecrypt reports whether a read API role sees decrypted values. The
+// read and admin tokens do.
+func (e *ColumnEncryption) mayDecrypt(role *Role) bool {
+	return role == nil || (e != nil && e.roles[role])
+}
+
+// decryptRow decrypts the encrypted values of a row read for a role, or
+// replaces them with NULL if the role may not see them.
+func (e *ColumnEncryption) decryptRow(row map[string]interface{}, role *Role) error {
+	for column, v := range row {
+		s, ok := v.(string)
+		if !ok || !e.encrypts(column) || !strings.HasPrefix(s, encryptedPrefix) {
+			continue
+		}
+		if !e.mayDecrypt(role) {
+			row[column] = nil
+			continue
+		}
+		plain, err := e.decrypt(column, s)
+		if err != nil {
+			return err
+		}
+		row[column] = plain
+	}
+	return nil
+}
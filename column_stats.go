@@ -0,0 +1,142 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ColumnStats describes what a column of a report table holds, so that
+// analysts can see which data is available before writing queries.
+type ColumnStats struct {
+	Table       string      `json:"table"`
+	Column      string      `json:"column"`
+	Type        string      `json:"type"`
+	Reports     int64       `json:"reports"`
+	NullRate    *float64    `json:"null_rate"`   // Null if there are no reports
+	Min         interface{} `json:"min"`         // Null for roles with privacy settings
+	Max         interface{} `json:"max"`         // Null for roles with privacy settings
+	Homeservers *int64      `json:"homeservers"` // Reporting a value; null if suppressed for privacy
+	FirstSeen   *string     `json:"first_seen"`  // Date of the first report with a value
+	LastSeen    *string     `json:"last_seen"`   // Date of the last report with a value
+}
+
+// ColumnStatsHandler serves /api/v1/columns, which gives statistics for
+// each column of the report tables which the role may see. It accepts
+// the query parameter table (stats or dendrite_stats) to describe only one.
+type ColumnStatsHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *ColumnStatsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	table := req.URL.Query().Get("table")
+	if table != "" && table != "stats" && table != "dendrite_stats" {
+		logAndReplyError(w, fmt.Errorf("unknown table %q", table), 400, "Bad query")
+		return
+	}
+	role := roleOf(req)
+	result := []*ColumnStats{}
+	for _, t := range h.Storage.tables() {
+		if table != "" && t.Kind != table {
+			continue
+		}
+		var columns []columnDef
+		for _, c := range t.Columns {
+			if role.visible(c.Name) {
+				columns = append(columns, c)
+			}
+		}
+		if len(columns) == 0 {
+			continue
+		}
+		stats, err := h.columnStats(req, t, columns)
+		if err != nil {
+			replyQueryError(w, req, err, "Error querying column statistics")
+			return
+		}
+		result = append(result, stats...)
+	}
+	writeJSON(w, result)
+}
+
+// columnStats computes the statistics of some columns of a table, with a
+// single scan of it.
+func (h *ColumnStatsHandler) columnStats(req *http.Request, t *tableDef, columns []columnDef) ([]*ColumnStats, error) {
+	selects := []string{"COUNT(*)"}
+	for _, c := range columns {
+		selects = append(selects,
+			fmt.Sprintf("COUNT(%s)", c.Name),
+			fmt.Sprintf("MIN(%s)", c.Name),
+			fmt.Sprintf("MAX(%s)", c.Name),
+			fmt.Sprintf("COUNT(DISTINCT CASE WHEN %s IS NOT NULL THEN homeserver END)", c.Name),
+			fmt.Sprintf("MIN(CASE WHEN %s IS NOT NULL THEN local_timestamp END)", c.Name),
+			fmt.Sprintf("MAX(CASE WHEN %s IS NOT NULL THEN local_timestamp END)", c.Name),
+		)
+	}
+	var reports int64
+	type columnValues struct {
+		count, homeservers  int64
+		min, max            interface{}
+		firstSeen, lastSeen sql.NullInt64
+	}
+	values := make([]columnValues, len(columns))
+	dest := []interface{}{&reports}
+	for i := range values {
+		v := &values[i]
+		dest = append(dest, &v.count, &v.min, &v.max, &v.homeservers, &v.firstSeen, &v.lastSeen)
+	}
+	qry := fmt.Sprintf("SELECT %s FROM %s", strings.Join(selects, ", "), t.Name)
+	if err := h.DB.QueryRowContext(req.Context(), qry).Scan(dest...); err != nil {
+		return nil, err
+	}
+
+	p := privacyOf(req)
+	result := make([]*ColumnStats, len(columns))
+	for i, c := range columns {
+		v := values[i]
+		s := &ColumnStats{
+			Table:       t.Kind,
+			Column:      c.Name,
+			Type:        c.Type,
+			Reports:     reports,
+			Homeservers: p.count(v.homeservers, "homeservers with "+t.Kind+"."+c.Name, "all"),
+			FirstSeen:   seenDate(v.firstSeen),
+			LastSeen:    seenDate(v.lastSeen),
+		}
+		if reports > 0 {
+			rate := float64(reports-v.count) / float64(reports)
+			s.NullRate = &rate
+		}
+		if p == nil {
+			s.Min = jsonValue(v.min, c.Type)
+			s.Max = jsonValue(v.max, c.Type)
+		}
+		result[i] = s
+	}
+	return result, nil
+}
+
+func seenDate(ts sql.NullInt64) *string {
+	if !ts.Valid {
+		return nil
+	}
+	date := time.Unix(ts.Int64, 0).UTC().Format("2006-01-02")
+	return &date
+}
@@ -0,0 +1,259 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+var compactAfter = flag.Duration("compact-after", 0, "collapse each homeserver's near-identical reports of a day into one row once the day is older than this; 0 never compacts")
+
+// compactionIgnored are columns which may differ between reports that are
+// otherwise alike, and are taken from the last of them.
+var compactionIgnored = map[string]bool{
+	"id":                true,
+	"local_timestamp":   true,
+	"remote_timestamp":  true,
+	"remote_addr":       true,
+	"forwarded_for":     true,
+	"remote_ip":         true,
+	"remote_ip_family":  true,
+	"adjusted_fields":   true,
+	"compacted_reports": true,
+	"compacted_ranges":  true,
+}
+
+// compactionCounters returns the numeric columns of t whose values may
+// vary within a compacted row, and whose ranges are kept. The others must
+// be equal for reports to be compacted together.
+func compactionCounters(t *tableDef) map[string]bool {
+	counters := map[string]bool{}
+	for _, c := range t.Columns {
+		if compactionIgnored[c.Name] || c.Name == "sample_rate" {
+			continue
+		}
+		if c.Type == "BIGINT" || strings.HasPrefix(c.Type, "DOUBLE") {
+			counters[c.Name] = true
+		}
+	}
+	return counters
+}
+
+// compactStats is the compact_stats job. For each day older than
+// -compact-after, it collapses each homeserver's reports which differ only
+// in their counters into the last of them, which records how many reports
+// it stands for and the range of each counter which varied.
+func compactStats(db *sql.DB, s *Storage) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		cutoff := time.Now().UTC().Unix() - int64(compactAfter.Seconds())
+		cutoff -= cutoff % oneDay
+		for _, t := range s.tables() {
+			n, err := compactTable(ctx, db, s, t, cutoff)
+			if err != nil {
+				return fmt.Errorf("%s: %v", t.Name, err)
+			}
+			if n > 0 {
+				addRowsAffected(ctx, n)
+				log.Printf("Compacted %d reports from %s", n, t.Name)
+				if err := analyzeAfterDelete(ctx, db, t.Name, n); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// compactTable compacts the days of t, a report table of s, before cutoff,
+// returning the number of rows deleted.
+func compactTable(ctx context.Context, db *sql.DB, s *Storage, t *tableDef, cutoff int64) (int64, error) {
+	d := dialectFor(db)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT homeserver, local_timestamp - local_timestamp %% %[1]d AS day
+		FROM %[2]s WHERE local_timestamp < %[3]s
+		GROUP BY homeserver, local_timestamp - local_timestamp %% %[1]d HAVING COUNT(*) > 1`,
+		oneDay, t.Name, d.placeholder(1)), cutoff)
+	if err != nil {
+		return 0, err
+	}
+	type homeserverDay struct {
+		homeserver string
+		day        int64
+	}
+	var days []homeserverDay
+	for rows.Next() {
+		var d homeserverDay
+		if err := rows.Scan(&d.homeserver, &d.day); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		days = append(days, d)
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+	counters := compactionCounters(t)
+	var deleted int64
+	for _, d := range days {
+		if err := ctx.Err(); err != nil {
+			return deleted, err
+		}
+		n, err := compactDay(ctx, db, s.Encryption, t.Name, counters, d.homeserver, d.day)
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+	}
+	return deleted, nil
+}
+
+// compactGroup is a set of reports from one day which differ only in
+// their counters.
+type compactGroup struct {
+	rows    []map[string]interface{}
+	reports int64
+	ranges  map[string][2]float64
+}
+
+func compactDay(ctx context.Context, db *sql.DB, e *ColumnEncryption, table string, counters map[string]bool, homeserver string, day int64) (int64, error) {
+	d := dialectFor(db)
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT * FROM %s
+		WHERE homeserver = %s AND local_timestamp >= %s AND local_timestamp < %s
+		ORDER BY local_timestamp, id`, table, d.placeholder(1), d.placeholder(2), d.placeholder(3)),
+		homeserver, day, day+oneDay)
+	if err != nil {
+		return 0, err
+	}
+	groups := map[string]*compactGroup{}
+	var order []string
+	err = eachRow(rows, func(row map[string]interface{}) error {
+		key := map[string]interface{}{}
+		for col, v := range row {
+			if compactionIgnored[col] || counters[col] {
+				continue
+			}
+			// Encrypted values differ even when their plaintexts are
+			// equal, so it's the plaintexts which are compared.
+			if s, ok := v.(string); ok && e.encrypts(col) {
+				plain, err := e.decrypt(col, s)
+				if err != nil {
+					return err
+				}
+				v = plain
+			}
+			key[col] = v
+		}
+		b, _ := json.Marshal(key)
+		g, ok := groups[string(b)]
+		if !ok {
+			g = &compactGroup{ranges: map[string][2]float64{}}
+			groups[string(b)] = g
+			order = append(order, string(b))
+		}
+		return g.add(row, counters)
+	})
+	rows.Close()
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+	var deleted int64
+	for _, k := range order {
+		g := groups[k]
+		if len(g.rows) < 2 {
+			continue
+		}
+		kept := g.rows[len(g.rows)-1]
+		for _, row := range g.rows[:len(g.rows)-1] {
+			if _, err := tx.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE id = %s", table, d.placeholder(1)), row["id"]); err != nil {
+				return 0, err
+			}
+			deleted++
+		}
+		ranges := map[string][]float64{}
+		for col, r := range g.ranges {
+			if r[0] != r[1] {
+				ranges[col] = []float64{r[0], r[1]}
+			}
+		}
+		var rangesJSON interface{}
+		if len(ranges) > 0 {
+			b, _ := json.Marshal(ranges)
+			rangesJSON = string(b)
+		}
+		_, err := tx.ExecContext(ctx,
+			fmt.Sprintf("UPDATE %s SET compacted_reports = %s, compacted_ranges = %s WHERE id = %s", table, d.placeholder(1), d.placeholder(2), d.placeholder(3)),
+			g.reports, rangesJSON, kept["id"])
+		if err != nil {
+			return 0, err
+		}
+	}
+	return deleted, tx.Commit()
+}
+
+// add adds a report to the group, widening the counter ranges by its
+// values, or by its own ranges if it was already compacted.
+func (g *compactGroup) add(row map[string]interface{}, counters map[string]bool) error {
+	g.rows = append(g.rows, row)
+	reports, _ := row["compacted_reports"].(int64)
+	if reports < 1 {
+		reports = 1
+	}
+	g.reports += reports
+	var ranges map[string][2]float64
+	if s, ok := row["compacted_ranges"].(string); ok && s != "" {
+		if err := json.Unmarshal([]byte(s), &ranges); err != nil {
+			return fmt.Errorf("row %v: compacted_ranges: %v", row["id"], err)
+		}
+	}
+	for col := range counters {
+		var lo, hi float64
+		if r, ok := ranges[col]; ok {
+			lo, hi = r[0], r[1]
+		} else {
+			switch v := row[col].(type) {
+			case int64:
+				lo, hi = float64(v), float64(v)
+			case float64:
+				lo, hi = v, v
+			default:
+				continue
+			}
+		}
+		if r, ok := g.ranges[col]; ok {
+			if r[0] < lo {
+				lo = r[0]
+			}
+			if r[1] > hi {
+				hi = r[1]
+			}
+		}
+		g.ranges[col] = [2]float64{lo, hi}
+	}
+	return nil
+}
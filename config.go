@@ -0,0 +1,217 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// Config holds the settings which are too structured to be expressed as
+// command line flags. It is read from the JSON file given by -config.
+type Config struct {
+	Jobs map[string]JobConfig `json:"jobs"`
+
+	// DownsampleIntervals overrides --downsample-interval per homeserver.
+	DownsampleIntervals map[string]Duration `json:"downsample_intervals"`
+
+	// MinReportIntervals overrides --min-report-interval per homeserver.
+	MinReportIntervals map[string]Duration `json:"min_report_intervals"`
+
+	// PushEndpoints serves the push handler on extra paths, or on /push
+	// itself, with a customised success response.
+	PushEndpoints map[string]*PushEndpoint `json:"push_endpoints"`
+
+	// WriteTargets are further databases which stored reports are copied to.
+	WriteTargets []WriteTargetConfig `json:"write_targets"`
+
+	// Notifiers are where the weekly digest and alerts are sent.
+	Notifiers NotifiersConfig `json:"notifiers"`
+
+	// Alerts are rules evaluated by the evaluate_alerts job, keyed by name.
+	Alerts map[string]*AlertRule `json:"alerts"`
+
+	// Fields overrides the type and allowed range of numeric report fields.
+	Fields map[string]FieldRule `json:"fields"`
+
+	// StringMetrics are report fields with categorical string values, keyed
+	// by field name.
+	StringMetrics map[string]*StringMetric `json:"string_metrics"`
+
+	// UserAgentFilters drop or flag pushes by user agent, in order.
+	UserAgentFilters []*UserAgentFilter `json:"user_agent_filters"`
+
+	// FieldPaths maps report fields to dotted paths into nested payloads.
+	FieldPaths map[string]string `json:"field_paths"`
+
+	// Transforms rename and scale report fields, in order, after
+	// FieldPaths.
+	Transforms []*Transform `json:"transforms"`
+
+	// PayloadSchemas are the versions of the payload reporters may declare,
+	// keyed by version.
+	PayloadSchemas map[string]*PayloadSchema `json:"payload_schemas"`
+
+	// DerivedMetrics are computed from other metrics in /api/v1/series.
+	DerivedMetrics map[string]*Expr `json:"derived_metrics"`
+
+	// Histograms are the upper bounds of the buckets of the daily histograms
+	// kept for each metric.
+	Histograms map[string][]float64 `json:"histograms"`
+
+	// Queries are the named queries admins may run through /admin/queries.
+	Queries map[string]*NamedQuery `json:"queries"`
+
+	// BigQuery configures streaming new stats rows to BigQuery.
+	BigQuery *BigQueryConfig `json:"bigquery"`
+
+	// Quotas cap the pushes of each tenant per day, keyed by tenant name.
+	Quotas map[string]*Quota `json:"quotas"`
+
+	// Sampling stores only one in N reports, keyed by homeserver or by the
+	// name of a tenant in Quotas.
+	Sampling map[string]int64 `json:"sampling"`
+
+	// Roles grant read API access which only sees some columns, keyed by
+	// role name.
+	Roles map[string]*Role `json:"roles"`
+
+	// ResponseHeaders are set on the responses to matching paths, in
+	// order, after the security headers.
+	ResponseHeaders []*ResponseHeaders `json:"response_headers"`
+
+	// EndpointGroups configures the middleware of the push, read, admin and
+	// public endpoints, keyed by group.
+	EndpointGroups map[string]*EndpointGroup `json:"endpoint_groups"`
+
+	// Features switches subsystems on or off, keyed by feature name.
+	Features map[string]bool `json:"features"`
+
+	// ColumnEncryption encrypts sensitive columns of reports before they
+	// are stored.
+	ColumnEncryption *ColumnEncryption `json:"column_encryption"`
+}
+
+// Duration is a time.Duration which is written as a string such as "90m"
+// in the config file.
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// JobConfig overrides the defaults of a single scheduled job.
+type JobConfig struct {
+	Enabled  *bool  `json:"enabled"`  // Defaults to true
+	Schedule string `json:"schedule"` // Cron expression, "@hourly", "@daily" or "@every 1h30m"
+}
+
+func loadConfig(path string) (*Config, error) {
+	c := &Config{}
+	if path == "" {
+		return c, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	dec := json.NewDecoder(f)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(c); err != nil {
+		return nil, fmt.Errorf("parsing %s: %v", path, err)
+	}
+	for path, e := range c.PushEndpoints {
+		if err := e.compile(path); err != nil {
+			return nil, fmt.Errorf("push endpoint %s: %v", path, err)
+		}
+	}
+	for name, f := range c.Fields {
+		if !numericFields()[name] {
+			return nil, fmt.Errorf("field %s: not a numeric report field", name)
+		}
+		if err := f.validate(); err != nil {
+			return nil, fmt.Errorf("field %s: %v", name, err)
+		}
+	}
+	for name, q := range c.Quotas {
+		if err := q.validate(); err != nil {
+			return nil, fmt.Errorf("quota %s: %v", name, err)
+		}
+	}
+	for name, r := range c.Roles {
+		if err := r.compile(name, c.StringMetrics, c.Fields); err != nil {
+			return nil, fmt.Errorf("role %s: %v", name, err)
+		}
+	}
+	for name, q := range c.Queries {
+		if err := q.compile(); err != nil {
+			return nil, fmt.Errorf("query %s: %v", name, err)
+		}
+	}
+	if err := validateUserAgentFilters(c.UserAgentFilters); err != nil {
+		return nil, fmt.Errorf("user_agent_filters: %v", err)
+	}
+	if err := validateStringMetrics(c.StringMetrics); err != nil {
+		return nil, fmt.Errorf("string_metrics: %v", err)
+	}
+	if err := validateFieldPaths(c.FieldPaths, c.StringMetrics); err != nil {
+		return nil, fmt.Errorf("field_paths: %v", err)
+	}
+	if err := validateTransforms(c.Transforms); err != nil {
+		return nil, fmt.Errorf("transforms: %v", err)
+	}
+	if err := validatePayloadSchemas(c.PayloadSchemas); err != nil {
+		return nil, fmt.Errorf("payload_schemas: %v", err)
+	}
+	if err := validateDerivedMetrics(c.DerivedMetrics); err != nil {
+		return nil, fmt.Errorf("derived_metrics: %v", err)
+	}
+	if err := validateHistograms(c.Histograms); err != nil {
+		return nil, fmt.Errorf("histograms: %v", err)
+	}
+	if err := validateSampling(c.Sampling); err != nil {
+		return nil, fmt.Errorf("sampling: %v", err)
+	}
+	if err := validateAlerts(c.Alerts); err != nil {
+		return nil, fmt.Errorf("alerts: %v", err)
+	}
+	if err := validateResponseHeaders(c.ResponseHeaders); err != nil {
+		return nil, fmt.Errorf("response_headers: %v", err)
+	}
+	if err := validateEndpointGroups(c.EndpointGroups); err != nil {
+		return nil, fmt.Errorf("endpoint_groups: %v", err)
+	}
+	if err := validateFeatures(c.Features); err != nil {
+		return nil, fmt.Errorf("features: %v", err)
+	}
+	if c.ColumnEncryption != nil {
+		if err := c.ColumnEncryption.compile(c.Roles); err != nil {
+			return nil, fmt.Errorf("column_encryption: %v", err)
+		}
+	}
+	return c, nil
+}
@@ -0,0 +1,93 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+	"time"
+)
+
+var (
+	dbConnectTimeout = flag.Duration("db-connect-timeout", 30*time.Second, "how long to keep retrying the database at startup before giving up, such as while a MySQL container starts")
+	dbHealthInterval = flag.Duration("db-health-interval", 30*time.Second, "how often to check the database connection, exporting the result as panopticon_db_up; 0 disables the check")
+)
+
+// Bounds of the delay between connection attempts at startup.
+const (
+	minConnectRetry = 250 * time.Millisecond
+	maxConnectRetry = 5 * time.Second
+)
+
+// waitForDB pings the database until it answers, backing off between
+// attempts, and gives up with the last error after timeout.
+func waitForDB(db *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := minConnectRetry
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), maxConnectRetry)
+		err := db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+		if time.Now().Add(delay).After(deadline) {
+			return err
+		}
+		log.Printf("Waiting for database: %v", err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxConnectRetry {
+			delay = maxConnectRetry
+		}
+	}
+}
+
+// watchDB pings the database every interval, logging when it goes away and
+// comes back. database/sql replaces broken connections as they're used, so
+// this is only for visibility; requests fail while the database is down and
+// succeed again once it is back without a restart.
+func watchDB(ctx context.Context, db *sql.DB, interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	up := true
+	metrics.Set("panopticon_db_up", 1)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		pingCtx, cancel := context.WithTimeout(ctx, interval)
+		err := db.PingContext(pingCtx)
+		cancel()
+		switch {
+		case err != nil && up:
+			log.Printf("Lost the database connection: %v", err)
+		case err == nil && !up:
+			log.Printf("Database connection restored")
+		}
+		up = err == nil
+		if up {
+			metrics.Set("panopticon_db_up", 1)
+		} else {
+			metrics.Set("panopticon_db_up", 0)
+		}
+	}
+}
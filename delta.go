@@ -0,0 +1,162 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+var (
+	errBadDeltaOf       = errors.New("delta_of must be the id of a report")
+	errUnknownDeltaBase = errors.New("no report with the id in delta_of from this homeserver")
+)
+
+// reportFields are the fields of a report which a delta carries over from
+// the one it is a delta of. Each is stored in the column of the same name,
+// or for string metrics in string_metrics.
+func reportFields(isDendrite bool, stringMetrics map[string]*StringMetric) map[string]bool {
+	t := reflect.TypeOf(ReportStatsSynapse{})
+	if isDendrite {
+		t = reflect.TypeOf(ReportStatsDendrite{})
+	}
+	fields := map[string]bool{}
+	for _, name := range append(jsonFieldNames(reflect.TypeOf(CommonStats{})), jsonFieldNames(t)...) {
+		// Untagged fields, and those tagged "-", are set by panopticon. When
+		// the report was sent can't be carried over from an earlier one.
+		if name != "-" && strings.ToLower(name) == name && name != "timestamp" {
+			fields[name] = true
+		}
+	}
+	for name := range stringMetrics {
+		fields[name] = true
+	}
+	return fields
+}
+
+// applyDelta turns a delta report, which only has the fields which changed
+// since the report named by its delta_of field, into a full one by filling
+// in the others from that report. Fields which the delta sets to null stay
+// null. Other reports are returned unchanged.
+func (r *Recorder) applyDelta(body []byte, isDendrite bool) ([]byte, bool, error) {
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		// Leave it to the report decoder to complain.
+		return body, false, nil
+	}
+	ref, ok := raw["delta_of"]
+	if !ok {
+		return body, false, nil
+	}
+	delete(raw, "delta_of")
+	var homeserver string
+	json.Unmarshal(raw["homeserver"], &homeserver)
+	base, err := r.deltaBase(ref, r.Storage.storedHomeserver(homeserver), isDendrite)
+	if err != nil {
+		return nil, true, err
+	}
+	for field := range reportFields(isDendrite, r.Storage.StringMetrics) {
+		v := base[field]
+		if _, set := raw[field]; set || v == nil {
+			continue
+		}
+		if raw[field], err = json.Marshal(v); err != nil {
+			return nil, true, err
+		}
+	}
+	body, err = json.Marshal(raw)
+	return body, true, err
+}
+
+// deltaBase returns the columns of the report a delta is of, and its string
+// metrics, or errUnknownDeltaBase if the homeserver sent no such report.
+func (r *Recorder) deltaBase(ref json.RawMessage, homeserver string, isDendrite bool) (map[string]interface{}, error) {
+	var s string
+	if err := json.Unmarshal(ref, &s); err != nil {
+		var n json.Number
+		if err := json.Unmarshal(ref, &n); err != nil {
+			return nil, errBadDeltaOf
+		}
+		s = n.String()
+	}
+	var id interface{} = s
+	if !r.Storage.generatedIDs() {
+		n, err := strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			return nil, errBadDeltaOf
+		}
+		id = n
+	}
+	table := r.Storage.tableName("stats")
+	if isDendrite {
+		table = r.Storage.tableName("dendrite_stats")
+	}
+	d := dialectFor(r.DB)
+	rows, err := r.DB.Query(fmt.Sprintf("SELECT * FROM %s WHERE id = %s AND homeserver = %s", table, d.placeholder(1), d.placeholder(2)), id, homeserver)
+	if err != nil {
+		return nil, err
+	}
+	found, err := scanRows(rows, 1, nil)
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+	if len(found) == 0 {
+		return nil, errUnknownDeltaBase
+	}
+	base := found[0]
+	if err := r.Storage.Encryption.decryptRow(base, nil); err != nil {
+		return nil, err
+	}
+	rows, err = r.DB.Query(fmt.Sprintf("SELECT metric, value FROM string_metrics WHERE homeserver = %s AND local_timestamp = %s", d.placeholder(1), d.placeholder(2)),
+		homeserver, base["local_timestamp"])
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var metric string
+		var value sql.NullString
+		if err := rows.Scan(&metric, &value); err != nil {
+			return nil, err
+		}
+		if _, ok := base[metric]; !ok && value.Valid {
+			base[metric] = value.String
+		}
+	}
+	return base, rows.Err()
+}
+
+// replyDelta replies to a push in delta mode with the id of the report it
+// stored, which its next delta can name, or nothing if it wasn't stored, so
+// that the next report is sent in full.
+func replyDelta(w http.ResponseWriter, result *PushResult) {
+	var reply struct {
+		ID interface{} `json:"id,omitempty"`
+	}
+	if !result.SampledOut && !result.Downsampled {
+		reply.ID = result.ID
+	}
+	writeJSON(w, reply)
+}
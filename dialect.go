@@ -0,0 +1,132 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// dialect generates statements in the SQL flavour of a database driver, so
+// that placeholder styles and identifier quoting are decided in one place.
+type dialect string
+
+// dialectFor returns the dialect of the driver db was opened with. Use it
+// rather than the -db-driver dialect for anything which may be handed a
+// write target.
+func dialectFor(db *sql.DB) dialect {
+	return dialect(driverFor(db))
+}
+
+// placeholder returns the bind parameter for the i-th (1-based) argument.
+func (d dialect) placeholder(i int) string {
+	if d == "mysql" {
+		return "?"
+	}
+	return fmt.Sprintf("$%d", i)
+}
+
+// placeholders returns the bind parameters for n arguments, starting at the
+// first.
+func (d dialect) placeholders(n int) []string {
+	ps := make([]string, n)
+	for i := range ps {
+		ps[i] = d.placeholder(i + 1)
+	}
+	return ps
+}
+
+// quote quotes a table or column name, quoting each part of a name
+// qualified by a schema separately. Names containing a quote character are
+// never valid here, so they are rejected rather than escaped.
+func (d dialect) quote(name string) string {
+	q := `"`
+	if d == "mysql" {
+		// Double quotes are string literals unless ANSI_QUOTES is set.
+		q = "`"
+	}
+	if strings.ContainsAny(name, "\"`") {
+		panic(fmt.Sprintf("invalid SQL identifier %q", name))
+	}
+	return q + strings.ReplaceAll(name, ".", q+"."+q) + q
+}
+
+// quoteIdent quotes name as a single identifier, dots and all, doubling any
+// quote characters in it. It is for names which come from data, such as
+// the fields of archived reports, rather than from configuration.
+func (d dialect) quoteIdent(name string) string {
+	q := `"`
+	if d == "mysql" {
+		q = "`"
+	}
+	return q + strings.ReplaceAll(name, q, q+q) + q
+}
+
+// insert returns a statement inserting one row into table, with a bind
+// parameter for each column in order.
+func (d dialect) insert(table string, cols ...string) string {
+	quoted := make([]string, len(cols))
+	for i, c := range cols {
+		quoted[i] = d.quote(c)
+	}
+	return fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)",
+		d.quote(table), strings.Join(quoted, ", "), strings.Join(d.placeholders(len(cols)), ", "))
+}
+
+// upsert returns a statement inserting one row into table like insert,
+// which instead updates the existing row if one has the same keys, so that
+// concurrent writers can't both insert it. set gives the SQL assigned to
+// each column updated, in which the existing row's columns are qualified by
+// the table name and d.excluded(column) is the value which would have been
+// inserted. MySQL applies the assignments in order, each seeing the ones
+// before, so they must not refer to each other's columns.
+func (d dialect) upsert(table string, keys, cols []string, set map[string]string) string {
+	updated := make([]string, 0, len(set))
+	for c := range set {
+		updated = append(updated, c)
+	}
+	sort.Strings(updated)
+	assignments := make([]string, len(updated))
+	for i, c := range updated {
+		assignments[i] = d.quote(c) + " = " + set[c]
+	}
+	if d == "mysql" {
+		// MySQL resolves the conflict on whichever unique key it hits.
+		return d.insert(table, cols...) + " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	}
+	quoted := make([]string, len(keys))
+	for i, k := range keys {
+		quoted[i] = d.quote(k)
+	}
+	return fmt.Sprintf("%s ON CONFLICT (%s) DO UPDATE SET %s",
+		d.insert(table, cols...), strings.Join(quoted, ", "), strings.Join(assignments, ", "))
+}
+
+// excluded refers to the value a column would have been inserted with, in
+// the assignments of an upsert.
+func (d dialect) excluded(column string) string {
+	if d == "mysql" {
+		return "VALUES(" + d.quote(column) + ")"
+	}
+	return "excluded." + d.quote(column)
+}
+
+// returnsIDs reports whether inserted IDs have to be fetched with RETURNING,
+// since neither lib/pq nor go-duckdb support LastInsertId.
+func (d dialect) returnsIDs() bool {
+	return d == "postgres" || d == "duckdb"
+}
@@ -0,0 +1,63 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "testing"
+
+func TestInsertStatements(t *testing.T) {
+	for d, want := range map[dialect]string{
+		"sqlite3":  `INSERT INTO "stats" ("homeserver", "total_users") VALUES ($1, $2)`,
+		"postgres": `INSERT INTO "panopticon"."stats" ("homeserver", "total_users") VALUES ($1, $2)`,
+		"mysql":    "INSERT INTO `panopticon`.`stats` (`homeserver`, `total_users`) VALUES (?, ?)",
+	} {
+		table := "panopticon.stats"
+		if d == "sqlite3" {
+			table = "stats"
+		}
+		if got := d.insert(table, "homeserver", "total_users"); got != want {
+			t.Errorf("%s: got %s, want %s", d, got, want)
+		}
+	}
+	if got := dialect("postgres").placeholders(3); len(got) != 3 || got[0] != "$1" || got[2] != "$3" {
+		t.Errorf("postgres placeholders: %q", got)
+	}
+	if got := dialect("mysql").placeholder(2); got != "?" {
+		t.Errorf("mysql placeholder: %q", got)
+	}
+}
+
+func TestQuoteIdent(t *testing.T) {
+	for d, want := range map[dialect]string{
+		"sqlite3": `"a.b""c` + "`" + `"`,
+		"mysql":   "`a.b\"c``" + "`",
+	} {
+		if got := d.quoteIdent("a.b\"c`"); got != want {
+			t.Errorf("%s: got %s, want %s", d, got, want)
+		}
+	}
+}
+
+func TestQuoteRejectsQuotes(t *testing.T) {
+	for _, name := range []string{`stats"; DROP TABLE stats; --`, "stats`"} {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Errorf("quoted %q", name)
+				}
+			}()
+			dialect("sqlite3").quote(name)
+		}()
+	}
+}
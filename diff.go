@@ -0,0 +1,377 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultDiffMetrics are the metrics a snapshot diff compares unless others
+// are asked for.
+var defaultDiffMetrics = []string{"total_users", "daily_active_users", "daily_messages"}
+
+// SnapshotDiff compares the network as it stood at the end of two days.
+// The network on a day is each homeserver's last report within the window
+// ending with that day.
+type SnapshotDiff struct {
+	From       string `json:"from"`
+	To         string `json:"to"`
+	WindowDays int64  `json:"window_days"`
+
+	Homeservers DiffValue `json:"homeservers"`
+	Gained      *int64    `json:"gained"` // Homeservers only in the second snapshot
+	Lost        *int64    `json:"lost"`   // Homeservers only in the first snapshot
+	// Null if the homeserver column isn't visible, or aggregates are
+	// protected for privacy.
+	GainedHomeservers []string `json:"gained_homeservers"`
+	LostHomeservers   []string `json:"lost_homeservers"`
+	// Sums over every homeserver, and over those in both snapshots.
+	Metrics         map[string]DiffValue `json:"metrics"`
+	RetainedMetrics map[string]DiffValue `json:"retained_metrics"`
+}
+
+// DiffValue is an aggregate at each end of a diff. Change is null if
+// either is, and Percent if From is 0.
+type DiffValue struct {
+	From    *float64 `json:"from"`
+	To      *float64 `json:"to"`
+	Change  *float64 `json:"change"`
+	Percent *float64 `json:"percent"`
+}
+
+func newDiffValue(from, to *float64) DiffValue {
+	v := DiffValue{From: from, To: to}
+	if from != nil && to != nil {
+		change := *to - *from
+		v.Change = &change
+		if *from != 0 {
+			percent := math.Round(change / *from * 1000) / 10
+			v.Percent = &percent
+		}
+	}
+	return v
+}
+
+// diffQuery is what a snapshot diff compares.
+type diffQuery struct {
+	from, to time.Time // Days
+	window   time.Duration
+	metrics  []string
+}
+
+// parseDiffQuery parses the from, to, window and metric parameters of a
+// diff.
+func parseDiffQuery(q url.Values) (*diffQuery, error) {
+	d := &diffQuery{window: 7 * 24 * time.Hour, metrics: q["metric"]}
+	var err error
+	if d.from, err = time.Parse("2006-01-02", q.Get("from")); err != nil {
+		return nil, fmt.Errorf("from: %v", err)
+	}
+	if d.to, err = time.Parse("2006-01-02", q.Get("to")); err != nil {
+		return nil, fmt.Errorf("to: %v", err)
+	}
+	if !d.from.Before(d.to) {
+		return nil, fmt.Errorf("from must be before to")
+	}
+	if w := q.Get("window"); w != "" {
+		if d.window, err = time.ParseDuration(w); err != nil {
+			return nil, fmt.Errorf("window: %v", err)
+		}
+		if d.window < 24*time.Hour || d.window%(24*time.Hour) != 0 {
+			return nil, fmt.Errorf("window must be a whole number of days")
+		}
+	}
+	if len(d.metrics) == 0 {
+		d.metrics = defaultDiffMetrics
+	}
+	known := seriesMetrics()
+	for _, m := range d.metrics {
+		if !known[m] {
+			return nil, fmt.Errorf("unknown metric %q", m)
+		}
+	}
+	return d, nil
+}
+
+// snapshotAt returns the last report of each homeserver within the window
+// ending with day, other than decommissioned ones.
+func snapshotAt(ctx context.Context, db *sql.DB, s *Storage, day time.Time, window time.Duration, metrics []string) (map[string][]sql.NullFloat64, error) {
+	d := dialectFor(db)
+	end := day.Unix() + oneDay
+	start := end - int64(window.Seconds())
+	snapshot := map[string][]sql.NullFloat64{}
+	for _, table := range []string{"stats", "dendrite_stats"} {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT homeserver, %s FROM %s
+			WHERE local_timestamp >= %s AND local_timestamp < %s AND homeserver NOT IN (SELECT homeserver FROM tombstones)
+			ORDER BY local_timestamp`,
+			strings.Join(metrics, ", "), s.tableName(table), d.placeholder(1), d.placeholder(2)), start, end)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var hs string
+			values := make([]sql.NullFloat64, len(metrics))
+			dest := []interface{}{&hs}
+			for i := range values {
+				dest = append(dest, &values[i])
+			}
+			if err := rows.Scan(dest...); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			snapshot[hs] = values
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return snapshot, nil
+}
+
+// buildSnapshotDiff compares the snapshots of two days, protecting
+// aggregates with p.
+func buildSnapshotDiff(ctx context.Context, db *sql.DB, s *Storage, d *diffQuery, p *Privacy) (*SnapshotDiff, error) {
+	from, err := snapshotAt(ctx, db, s, d.from, d.window, d.metrics)
+	if err != nil {
+		return nil, err
+	}
+	to, err := snapshotAt(ctx, db, s, d.to, d.window, d.metrics)
+	if err != nil {
+		return nil, err
+	}
+	diff := &SnapshotDiff{
+		From:              d.from.Format("2006-01-02"),
+		To:                d.to.Format("2006-01-02"),
+		WindowDays:        int64(d.window / (24 * time.Hour)),
+		GainedHomeservers: []string{},
+		LostHomeservers:   []string{},
+		Metrics:           map[string]DiffValue{},
+		RetainedMetrics:   map[string]DiffValue{},
+	}
+	for hs := range to {
+		if _, ok := from[hs]; !ok {
+			diff.GainedHomeservers = append(diff.GainedHomeservers, hs)
+		}
+	}
+	for hs := range from {
+		if _, ok := to[hs]; !ok {
+			diff.LostHomeservers = append(diff.LostHomeservers, hs)
+		}
+	}
+	sort.Strings(diff.GainedHomeservers)
+	sort.Strings(diff.LostHomeservers)
+	// Each snapshot is of the window up to its day.
+	fromWindow := fmt.Sprintf("%s to %s", d.window, diff.From)
+	toWindow := fmt.Sprintf("%s to %s", d.window, diff.To)
+	diffWindow := fromWindow + " and " + toWindow
+	diff.Gained = p.count(int64(len(diff.GainedHomeservers)), "gained_homeservers", diffWindow)
+	diff.Lost = p.count(int64(len(diff.LostHomeservers)), "lost_homeservers", diffWindow)
+	diff.Homeservers = newDiffValue(protectedCount(p, int64(len(from)), fromWindow), protectedCount(p, int64(len(to)), toWindow))
+
+	for i, m := range d.metrics {
+		sum := func(snapshot map[string][]sql.NullFloat64, window string, retained bool) *float64 {
+			var v float64
+			var n int64
+			for hs, values := range snapshot {
+				_, inFrom := from[hs]
+				_, inTo := to[hs]
+				if values[i].Valid && (!retained || inFrom && inTo) {
+					v += values[i].Float64
+					n++
+				}
+			}
+			if protected, ok := p.sum(m, window, v, n); ok {
+				return &protected
+			}
+			return nil
+		}
+		diff.Metrics[m] = newDiffValue(sum(from, fromWindow, false), sum(to, toWindow, false))
+		diff.RetainedMetrics[m] = newDiffValue(sum(from, fromWindow+" retained", true), sum(to, toWindow+" retained", true))
+	}
+	return diff, nil
+}
+
+func protectedCount(p *Privacy, n int64, window string) *float64 {
+	c := p.count(n, "homeservers", window)
+	if c == nil {
+		return nil
+	}
+	f := float64(*c)
+	return &f
+}
+
+// Markdown renders the diff as the tables of a report.
+func (d *SnapshotDiff) Markdown() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "## Network from %s to %s\n\n", d.From, d.To)
+	fmt.Fprintf(&b, "Each homeserver's last report within %d days of the end of each day.\n\n", d.WindowDays)
+	b.WriteString("| | " + d.From + " | " + d.To + " | Change | % |\n|---|---:|---:|---:|---:|\n")
+	writeDiffRow(&b, "Homeservers", d.Homeservers)
+	names := make([]string, 0, len(d.Metrics))
+	for m := range d.Metrics {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	for _, m := range names {
+		writeDiffRow(&b, m, d.Metrics[m])
+	}
+	for _, m := range names {
+		writeDiffRow(&b, m+" (retained homeservers)", d.RetainedMetrics[m])
+	}
+	for _, l := range []struct {
+		title       string
+		n           *int64
+		homeservers []string
+	}{{"Gained", d.Gained, d.GainedHomeservers}, {"Lost", d.Lost, d.LostHomeservers}} {
+		if l.n == nil {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s: %d\n", l.title, *l.n)
+		if len(l.homeservers) > 0 {
+			b.WriteString("\n")
+		}
+		for _, hs := range l.homeservers {
+			fmt.Fprintf(&b, "- %s\n", hs)
+		}
+	}
+	return b.String()
+}
+
+func writeDiffRow(b *strings.Builder, name string, v DiffValue) {
+	format := func(f *float64, sign string) string {
+		if f == nil {
+			return "-"
+		}
+		if sign == "+" && *f >= 0 {
+			return "+" + strconv.FormatFloat(*f, 'f', -1, 64)
+		}
+		return strconv.FormatFloat(*f, 'f', -1, 64)
+	}
+	percent := "-"
+	if v.Percent != nil {
+		percent = fmt.Sprintf("%+.1f%%", *v.Percent)
+	}
+	fmt.Fprintf(b, "| %s | %s | %s | %s | %s |\n", name, format(v.From, ""), format(v.To, ""), format(v.Change, "+"), percent)
+}
+
+// DiffHandler serves /api/v1/diff, which compares the network between two
+// days. It accepts the query parameters from and to (YYYY-MM-DD), window
+// (such as 168h, defaulting to a week), metric (repeated) and
+// format=markdown.
+type DiffHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *DiffHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	d, err := parseDiffQuery(q)
+	if err != nil {
+		logAndReplyError(w, err, 400, "Bad query")
+		return
+	}
+	markPruned(w, d.from.Unix()+oneDay-int64(d.window.Seconds()))
+	for _, m := range d.metrics {
+		if hiddenColumnError(w, roleOf(req), m, "metric "+m) {
+			return
+		}
+	}
+	p := privacyOf(req)
+	diff, err := buildSnapshotDiff(req.Context(), h.DB, h.Storage, d, p)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error comparing snapshots")
+		return
+	}
+	if p != nil || !roleOf(req).visible("homeserver") {
+		diff.GainedHomeservers, diff.LostHomeservers = nil, nil
+	}
+	if q.Get("format") == "markdown" {
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		fmt.Fprint(w, diff.Markdown())
+		return
+	}
+	writeJSON(w, diff)
+}
+
+// runDiff implements "panopticon diff", which prints the diff of
+// /api/v1/diff straight from a database.
+func runDiff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	fs.StringVar(dbDriver, "db-driver", "sqlite3", "the driver of the database")
+	fs.StringVar(dbPath, "db", "stats.db", "the database to read")
+	fs.StringVar(statsTable, "stats-table", "stats", "the table Synapse reports are stored in")
+	fs.StringVar(dendriteStatsTable, "dendrite-stats-table", "dendrite_stats", "the table Dendrite reports are stored in")
+	from := fs.String("from", "", "the first day, as YYYY-MM-DD")
+	to := fs.String("to", "", "the second day, as YYYY-MM-DD")
+	window := fs.Duration("window", 7*24*time.Hour, "how far back from the end of each day to look for reports")
+	format := fs.String("format", "markdown", "markdown or json")
+	var metrics []string
+	fs.Func("metric", "a metric to compare, which may be repeated; total_users, daily_active_users and daily_messages if not given", func(m string) error {
+		metrics = append(metrics, m)
+		return nil
+	})
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: panopticon diff -from <day> -to <day> [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() > 0 || *dbDriver == "memory" || (*format != "markdown" && *format != "json") {
+		fs.Usage()
+		return 2
+	}
+	storage := storageFromFlags()
+	if err := storage.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	d, err := parseDiffQuery(url.Values{"from": {*from}, "to": {*to}, "window": {window.String()}, "metric": metrics})
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	db, err := openDB(storage.Driver, *dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", *dbPath, err)
+		return 1
+	}
+	defer db.Close()
+	diff, err := buildSnapshotDiff(context.Background(), db, storage, d, nil)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error comparing snapshots: %v\n", err)
+		return 1
+	}
+	if *format == "json" {
+		b, _ := json.MarshalIndent(diff, "", "  ")
+		fmt.Println(string(b))
+	} else {
+		fmt.Print(diff.Markdown())
+	}
+	return 0
+}
@@ -0,0 +1,159 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const week = 7 * 24 * time.Hour
+
+// Digest summarises how the network changed over the last week.
+type Digest struct {
+	Start, End time.Time
+
+	ActiveHomeservers         int64 // Reported this week
+	PreviousActiveHomeservers int64 // Reported the week before
+	NewHomeservers            []string
+	SilentHomeservers         []string // Reported the week before, but not this week
+
+	// From aggregate_stats, if it is being maintained.
+	TotalUsers, PreviousTotalUsers             *int64
+	DailyActiveUsers, PreviousDailyActiveUsers *int64
+}
+
+func buildDigest(ctx context.Context, db *sql.DB, end time.Time) (*Digest, error) {
+	dl := dialectFor(db)
+	d := &Digest{Start: end.Add(-week), End: end}
+	start, prev := d.Start.Unix(), d.Start.Add(-week).Unix()
+	notTombstoned := "homeserver NOT IN (SELECT homeserver FROM tombstones)"
+
+	err := db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM homeservers WHERE last_seen >= %s AND %s", dl.placeholder(1), notTombstoned),
+		start,
+	).Scan(&d.ActiveHomeservers)
+	if err != nil {
+		return nil, err
+	}
+	// The week before is approximated by servers which were first seen
+	// before it ended and last seen after it started.
+	err = db.QueryRowContext(ctx,
+		fmt.Sprintf("SELECT COUNT(*) FROM homeservers WHERE first_seen < %s AND last_seen >= %s AND %s",
+			dl.placeholder(1), dl.placeholder(2), notTombstoned),
+		start, prev,
+	).Scan(&d.PreviousActiveHomeservers)
+	if err != nil {
+		return nil, err
+	}
+	if d.NewHomeservers, err = queryStrings(ctx, db,
+		fmt.Sprintf("SELECT homeserver FROM homeservers WHERE first_seen >= %s AND %s ORDER BY homeserver", dl.placeholder(1), notTombstoned),
+		start,
+	); err != nil {
+		return nil, err
+	}
+	if d.SilentHomeservers, err = queryStrings(ctx, db,
+		fmt.Sprintf("SELECT homeserver FROM homeservers WHERE last_seen >= %s AND last_seen < %s AND %s ORDER BY homeserver",
+			dl.placeholder(1), dl.placeholder(2), notTombstoned),
+		prev, start,
+	); err != nil {
+		return nil, err
+	}
+
+	// aggregate_stats is maintained by scripts/aggregate.py, so may not
+	// exist; the digest just leaves the user counts out in that case.
+	for _, a := range []struct {
+		day                    int64
+		totalUsers, activeUser **int64
+	}{
+		{d.End.Add(-24 * time.Hour).Truncate(24 * time.Hour).Unix(), &d.TotalUsers, &d.DailyActiveUsers},
+		{d.Start.Add(-24 * time.Hour).Truncate(24 * time.Hour).Unix(), &d.PreviousTotalUsers, &d.PreviousDailyActiveUsers},
+	} {
+		var total, active sql.NullInt64
+		err := db.QueryRowContext(ctx,
+			"SELECT total_users, daily_active_users FROM aggregate_stats WHERE day = "+dl.placeholder(1), a.day,
+		).Scan(&total, &active)
+		if err == nil && total.Valid && active.Valid {
+			*a.totalUsers, *a.activeUser = &total.Int64, &active.Int64
+		}
+	}
+	return d, nil
+}
+
+func queryStrings(ctx context.Context, db *sql.DB, qry string, args ...interface{}) ([]string, error) {
+	rows, err := db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var result []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// Subject is a one line summary of the digest.
+func (d *Digest) Subject() string {
+	return fmt.Sprintf("panopticon weekly digest: %d active homeservers (%s)",
+		d.ActiveHomeservers, signed(d.ActiveHomeservers-d.PreviousActiveHomeservers))
+}
+
+// Text renders the digest as plain text.
+func (d *Digest) Text() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Week of %s to %s\n\n", d.Start.Format("2006-01-02"), d.End.Format("2006-01-02"))
+	fmt.Fprintf(&b, "Active homeservers: %d (%s on the previous week)\n",
+		d.ActiveHomeservers, signed(d.ActiveHomeservers-d.PreviousActiveHomeservers))
+	if d.TotalUsers != nil && d.PreviousTotalUsers != nil {
+		fmt.Fprintf(&b, "Total users: %d (%s)\n", *d.TotalUsers, signed(*d.TotalUsers-*d.PreviousTotalUsers))
+	}
+	if d.DailyActiveUsers != nil && d.PreviousDailyActiveUsers != nil {
+		fmt.Fprintf(&b, "Daily active users: %d (%s)\n", *d.DailyActiveUsers, signed(*d.DailyActiveUsers-*d.PreviousDailyActiveUsers))
+	}
+	writeList(&b, "New homeservers", d.NewHomeservers)
+	writeList(&b, "Homeservers which have gone silent", d.SilentHomeservers)
+	return b.String()
+}
+
+func writeList(b *strings.Builder, title string, items []string) {
+	fmt.Fprintf(b, "\n%s: %d\n", title, len(items))
+	for _, item := range items {
+		fmt.Fprintf(b, "  - %s\n", item)
+	}
+}
+
+func signed(n int64) string {
+	return fmt.Sprintf("%+d", n)
+}
+
+// weeklyDigest is the weekly_digest job, which sends the digest to every
+// configured notifier.
+func weeklyDigest(db *sql.DB, notifiers []Notifier) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		d, err := buildDigest(ctx, db, time.Now().UTC())
+		if err != nil {
+			return err
+		}
+		return notifyAll(ctx, notifiers, d.Subject(), d.Text())
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBuildDigest(t *testing.T) {
+	db, err := openDB("sqlite3", filepath.Join(t.TempDir(), "digest.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := createTables(db, storageFromFlags().withDriver("sqlite3")); err != nil {
+		t.Fatal(err)
+	}
+	end := time.Date(2026, 3, 9, 9, 0, 0, 0, time.UTC)
+	day := int64(24 * 60 * 60)
+	thisWeek, lastWeek, longAgo := end.Unix()-day, end.Unix()-8*day, end.Unix()-30*day
+	for _, hs := range []struct {
+		name                string
+		firstSeen, lastSeen int64
+	}{
+		{"steady.turtles", longAgo, thisWeek},
+		{"new.turtles", thisWeek, thisWeek},
+		{"silent.turtles", longAgo, lastWeek},
+		{"gone.turtles", longAgo, longAgo},
+		{"tombstoned.turtles", thisWeek, thisWeek},
+	} {
+		if _, err := db.Exec("INSERT INTO homeservers (homeserver, first_seen, last_seen, report_count) VALUES (?, ?, ?, 1)", hs.name, hs.firstSeen, hs.lastSeen); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := db.Exec("INSERT INTO tombstones (homeserver, tombstoned_at) VALUES ('tombstoned.turtles', 0)"); err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := buildDigest(context.Background(), db, end)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "panopticon weekly digest: 2 active homeservers (+0)"; d.Subject() != want {
+		t.Errorf("subject %q, want %q", d.Subject(), want)
+	}
+	want := `Week of 2026-03-02 to 2026-03-09
+
+Active homeservers: 2 (+0 on the previous week)
+
+New homeservers: 1
+  - new.turtles
+
+Homeservers which have gone silent: 1
+  - silent.turtles
+`
+	if d.Text() != want {
+		t.Errorf("text:\n%s\nwant:\n%s", d.Text(), want)
+	}
+}
+
+// fakeSMTPServer accepts one message, which it sends on the returned
+// channel.
+func fakeSMTPServer(t *testing.T) (host string, port int, messages <-chan string) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	ch := make(chan string, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		reply := func(s string) { conn.Write([]byte(s + "\r\n")) }
+		reply("220 localhost ESMTP")
+		var data strings.Builder
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			switch strings.ToUpper(strings.Fields(line + " ")[0]) {
+			case "EHLO", "HELO":
+				reply("250 localhost")
+			case "DATA":
+				reply("354 go ahead")
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					data.WriteString(line)
+				}
+				ch <- data.String()
+				reply("250 queued")
+			case "QUIT":
+				reply("221 bye")
+				return
+			default:
+				reply("250 ok")
+			}
+		}
+	}()
+	addr := ln.Addr().(*net.TCPAddr)
+	return addr.IP.String(), addr.Port, ch
+}
+
+func TestEmailNotifier(t *testing.T) {
+	host, port, messages := fakeSMTPServer(t)
+	e := &EmailConfig{Host: host, Port: port, From: "panopticon@turtles", To: []string{"a@turtles", "b@turtles"}}
+	if err := e.Notify(context.Background(), "Weekly digest", "Active homeservers: 2\nNew homeservers: 1\n"); err != nil {
+		t.Fatal(err)
+	}
+	msg := <-messages
+	for _, want := range []string{
+		"From: panopticon@turtles\r\n",
+		"To: a@turtles, b@turtles\r\n",
+		"Subject: Weekly digest\r\n",
+		"\r\n\r\nActive homeservers: 2\r\nNew homeservers: 1\r\n",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("message lacks %q:\n%s", want, msg)
+		}
+	}
+	if !strings.Contains(msg, "\r\nDate: ") {
+		t.Errorf("message has no date:\n%s", msg)
+	}
+}
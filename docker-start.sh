@@ -2,4 +2,4 @@
 #
 # Converts environment variables into flags for panopticon
 
-exec /root/panopticon --db-driver=$PANOPTICON_DB_DRIVER --db=$PANOPTICON_DB --port=$PANOPTICON_PORT
+exec /root/panopticon --db-driver=$PANOPTICON_DB_DRIVER --db=$PANOPTICON_DB --port=$PANOPTICON_PORT ${PANOPTICON_READ_DB:+--read-db="$PANOPTICON_READ_DB"} ${PANOPTICON_DB_CONNECT_TIMEOUT:+--db-connect-timeout="$PANOPTICON_DB_CONNECT_TIMEOUT"} ${PANOPTICON_HOMESERVER_HASH_SALT:+--homeserver-hash-salt="$PANOPTICON_HOMESERVER_HASH_SALT"} ${PANOPTICON_ID_TYPE:+--id-type="$PANOPTICON_ID_TYPE"}
@@ -0,0 +1,75 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"time"
+)
+
+var downsampleInterval = flag.Duration("downsample-interval", 0, "store at most one report per homeserver per interval, counting the rest (0 to disable)")
+
+// downsampleInterval returns the minimum time between stored reports for a
+// homeserver, taking per-homeserver overrides from the config into account.
+func (r *Recorder) downsampleInterval(homeserver string) time.Duration {
+	if d, ok := r.Config.DownsampleIntervals[homeserver]; ok {
+		return time.Duration(d)
+	}
+	return *downsampleInterval
+}
+
+// withinDownsampleInterval reports whether a row has already been stored for
+// the homeserver in a report table of s within the last interval.
+func withinDownsampleInterval(db *sql.DB, s *Storage, table string, c *CommonStats, interval time.Duration) (bool, error) {
+	if interval <= 0 || c.Backfilled != nil {
+		return false, nil
+	}
+	var last sql.NullInt64
+	err := db.QueryRow(
+		fmt.Sprintf("SELECT MAX(local_timestamp) FROM %s WHERE homeserver = %s", s.tableName(table), dialectFor(db).placeholder(1)),
+		c.Homeserver,
+	).Scan(&last)
+	if err != nil {
+		return false, err
+	}
+	return last.Valid && c.LocalTimestamp-last.Int64 < int64(interval.Seconds()), nil
+}
+
+func createTableDownsampledReports(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS downsampled_reports(
+		homeserver VARCHAR(256) NOT NULL,
+		bucket_start BIGINT NOT NULL,
+		report_count BIGINT NOT NULL,
+		last_timestamp BIGINT NOT NULL,
+		PRIMARY KEY (homeserver, bucket_start)
+		)`)
+	return err
+}
+
+// recordDownsampled counts a report which wasn't stored against the
+// interval-sized bucket it arrived in.
+func recordDownsampled(ex execer, d dialect, homeserver string, ts int64, interval time.Duration) error {
+	bucket := ts - ts%int64(interval.Seconds())
+	_, err := ex.Exec(
+		d.upsert("downsampled_reports", []string{"homeserver", "bucket_start"}, []string{"homeserver", "bucket_start", "report_count", "last_timestamp"}, map[string]string{
+			"report_count":   d.quote("downsampled_reports.report_count") + " + 1",
+			"last_timestamp": d.excluded("last_timestamp"),
+		}),
+		homeserver, bucket, 1, ts,
+	)
+	return err
+}
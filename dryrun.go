@@ -0,0 +1,46 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// dryRunPath takes pushes like /push, checking them against the same
+// config and replying with what would be stored, but storing nothing, so
+// that reporters can be tested against a production instance. Quotas,
+// sampling, raw reports and ingest stats are left alone too.
+const dryRunPath = "/push/dry-run"
+
+// dryRunResult describes what a push would have stored.
+func dryRunResult(sr StatsReport, isDendrite, downsample bool) *PushResult {
+	var (
+		cols []string
+		vals []interface{}
+	)
+	result := &PushResult{ID: 0, Table: "stats", Stored: []string{}, Downsampled: downsample, DryRun: true}
+	if isDendrite {
+		s := sr.ReportStatsDendrite
+		s.Common = sr.ReportStatsSynapse.CommonStats
+		result.Table = "dendrite_stats"
+		cols, vals = s.Columns()
+	} else {
+		cols, vals = sr.ReportStatsSynapse.Columns()
+	}
+	result.Report = map[string]interface{}{}
+	for i, c := range cols {
+		result.Report[c] = vals[i]
+	}
+	if !downsample {
+		result.Stored = cols
+	}
+	return result
+}
@@ -0,0 +1,62 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// engagementMetric is a ratio of two metrics which /api/v1/series computes
+// over only the homeservers which reported both, so that those leaving one
+// out don't skew it.
+type engagementMetric struct {
+	numerator, denominator string
+}
+
+var engagementMetrics = map[string]engagementMetric{
+	"active_user_ratio":         {"daily_active_users", "total_users"},
+	"monthly_active_user_ratio": {"monthly_active_users", "total_users"},
+	"dau_mau_ratio":             {"daily_active_users", "monthly_active_users"},
+	"messages_per_dau":          {"daily_messages", "daily_active_users"},
+}
+
+// eval computes the ratio over a day's last reports, given the index of each
+// metric in them. It returns false if no homeserver reported both metrics,
+// if the sums are suppressed for privacy or if it would divide by zero.
+func (m engagementMetric) eval(reports map[string][]sql.NullFloat64, index map[string]int, p *Privacy, day int64) (float64, bool) {
+	var num, den float64
+	var n int64
+	for _, values := range reports {
+		a, b := values[index[m.numerator]], values[index[m.denominator]]
+		if a.Valid && b.Valid {
+			num += a.Float64
+			den += b.Float64
+			n++
+		}
+	}
+	if n == 0 {
+		return 0, false
+	}
+	// The sums are over the homeservers which reported both metrics, not
+	// those of the day's series.
+	window := fmt.Sprintf("%d with %s and %s", day, m.numerator, m.denominator)
+	num, numOK := p.sum(m.numerator, window, num, n)
+	den, denOK := p.sum(m.denominator, window, den, n)
+	if !numOK || !denOK || den == 0 {
+		return 0, false
+	}
+	return num / den, true
+}
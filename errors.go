@@ -0,0 +1,137 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var writeQueueRetryAfter = flag.Duration("write-queue-retry-after", time.Minute, "Retry-After sent with pushes refused because the write queue is full")
+
+// Error codes returned to clients. Requests failing with RATE_LIMITED or
+// STORAGE_UNAVAILABLE may be retried unchanged, and those failing with
+// QUOTA_EXCEEDED once the quota resets, delta reports failing with
+// UNKNOWN_DELTA_BASE in full, and read requests failing with QUERY_TIMEOUT
+// when the database is less busy; the others will fail again.
+const (
+	errcodeInvalidJSON        = "INVALID_JSON"
+	errcodeValidationFailed   = "VALIDATION_FAILED"
+	errcodeRateLimited        = "RATE_LIMITED"
+	errcodeStorageUnavailable = "STORAGE_UNAVAILABLE"
+	errcodeUnauthorized       = "UNAUTHORIZED"
+	errcodeNotFound           = "NOT_FOUND"
+	errcodeMethodNotAllowed   = "METHOD_NOT_ALLOWED"
+	errcodeQuotaExceeded      = "QUOTA_EXCEEDED"
+	errcodeUnknownDeltaBase   = "UNKNOWN_DELTA_BASE"
+	errcodeQueryTimeout       = "QUERY_TIMEOUT"
+)
+
+// errorBody is the body of every error reply.
+type errorBody struct {
+	ErrorMessage string `json:"error_message"`
+	Errcode      string `json:"errcode"`
+	RetryAfter   int64  `json:"retry_after,omitempty"` // Seconds
+}
+
+// writeError replies with an error body.
+func writeError(w http.ResponseWriter, code int, errcode, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorBody{ErrorMessage: message, Errcode: errcode})
+}
+
+// notFound replies to requests for paths which don't exist, or endpoints
+// which are disabled.
+func notFound(w http.ResponseWriter, req *http.Request) {
+	writeError(w, http.StatusNotFound, errcodeNotFound, "no such endpoint")
+}
+
+// methodNotAllowed refuses a request, listing the methods the endpoint
+// accepts.
+func methodNotAllowed(w http.ResponseWriter, req *http.Request, allowed ...string) {
+	w.Header().Set("Allow", strings.Join(allowed, ", "))
+	writeError(w, http.StatusMethodNotAllowed, errcodeMethodNotAllowed, req.Method+" is not allowed")
+}
+
+// allowMethods wraps a handler so that it only sees the given methods.
+func allowMethods(h http.HandlerFunc, allowed ...string) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		for _, m := range allowed {
+			if req.Method == m {
+				h(w, req)
+				return
+			}
+		}
+		methodNotAllowed(w, req, allowed...)
+	}
+}
+
+// replyError logs an error and replies with it. The client is told what
+// went wrong with its request, but not the details of server errors.
+func replyError(w http.ResponseWriter, err error, code int, errcode, description string) {
+	log.Printf("%s: %v%s", description, err, traceResponseField(w.Header()))
+	message := description
+	if code < 500 {
+		message += ": " + err.Error()
+	}
+	writeError(w, code, errcode, message)
+}
+
+// errcodeForStatus is the error code of a reply with the given status, for
+// errors which don't have a more specific one. Server errors are almost
+// always failures to read or write the database.
+func errcodeForStatus(code int) string {
+	switch {
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return errcodeUnauthorized
+	case code == http.StatusTooManyRequests:
+		return errcodeRateLimited
+	case code >= 500:
+		return errcodeStorageUnavailable
+	}
+	return errcodeValidationFailed
+}
+
+// jsonErrcode tells a body which isn't JSON apart from JSON which doesn't
+// have the shape of a report.
+func jsonErrcode(err error) string {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		return errcodeValidationFailed
+	}
+	return errcodeInvalidJSON
+}
+
+// replyRetryLater refuses a request with a Retry-After header and an error
+// code telling the client its push can be retried unchanged.
+func replyRetryLater(w http.ResponseWriter, code int, errcode string, retryAfter time.Duration, description string, err error) {
+	log.Printf("%s: %v%s", description, err, traceResponseField(w.Header()))
+	seconds := int64(retryAfter.Seconds())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", strconv.FormatInt(seconds, 10))
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(errorBody{
+		ErrorMessage: "try again later",
+		Errcode:      errcode,
+		RetryAfter:   seconds,
+	})
+}
@@ -0,0 +1,218 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"unicode"
+)
+
+// Expr is an arithmetic expression over metric names, such as
+// "daily_messages / daily_active_users". It supports numbers, + - * /,
+// unary minus and parentheses, and is written as a string in the config
+// file.
+type Expr struct {
+	Source string
+	root   exprNode
+}
+
+type exprNode interface {
+	// eval returns false if a metric is missing or on division by zero.
+	eval(vars map[string]float64) (float64, bool)
+	vars(into []string) []string
+}
+
+func (e *Expr) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+	parsed, err := parseExpr(s)
+	if err != nil {
+		return err
+	}
+	*e = *parsed
+	return nil
+}
+
+func (e *Expr) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Source)
+}
+
+// Eval evaluates the expression with the given metric values.
+func (e *Expr) Eval(vars map[string]float64) (float64, bool) {
+	return e.root.eval(vars)
+}
+
+// Vars lists the metric names the expression refers to.
+func (e *Expr) Vars() []string {
+	return e.root.vars(nil)
+}
+
+func parseExpr(s string) (*Expr, error) {
+	p := &exprParser{src: []rune(s)}
+	root, err := p.parseSum()
+	if err != nil {
+		return nil, fmt.Errorf("%q: %v", s, err)
+	}
+	if p.skipSpace(); p.pos < len(p.src) {
+		return nil, fmt.Errorf("%q: unexpected %q at %d", s, string(p.src[p.pos]), p.pos)
+	}
+	return &Expr{Source: s, root: root}, nil
+}
+
+type exprParser struct {
+	src []rune
+	pos int
+}
+
+func (p *exprParser) skipSpace() {
+	for p.pos < len(p.src) && unicode.IsSpace(p.src[p.pos]) {
+		p.pos++
+	}
+}
+
+// peek returns the next non-space character, or 0 at the end.
+func (p *exprParser) peek() rune {
+	p.skipSpace()
+	if p.pos >= len(p.src) {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *exprParser) parseSum() (exprNode, error) {
+	left, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '+' || op == '-'; op = p.peek() {
+		p.pos++
+		right, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseProduct() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for op := p.peek(); op == '*' || op == '/'; op = p.peek() {
+		p.pos++
+		right, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = binaryExpr{op, left, right}
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	switch c := p.peek(); {
+	case c == '-':
+		p.pos++
+		e, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return binaryExpr{'-', numberExpr(0), e}, nil
+	case c == '(':
+		p.pos++
+		e, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, fmt.Errorf("missing ) at %d", p.pos)
+		}
+		p.pos++
+		return e, nil
+	case c >= '0' && c <= '9' || c == '.':
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		v, err := strconv.ParseFloat(string(p.src[start:p.pos]), 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad number at %d", start)
+		}
+		return numberExpr(v), nil
+	case c == '_' || unicode.IsLetter(c):
+		start := p.pos
+		for p.pos < len(p.src) && (p.src[p.pos] == '_' || unicode.IsLetter(p.src[p.pos]) || unicode.IsDigit(p.src[p.pos])) {
+			p.pos++
+		}
+		return varExpr(p.src[start:p.pos]), nil
+	case c == 0:
+		return nil, fmt.Errorf("unexpected end")
+	default:
+		return nil, fmt.Errorf("unexpected %q at %d", string(c), p.pos)
+	}
+}
+
+type numberExpr float64
+
+func (n numberExpr) eval(map[string]float64) (float64, bool) { return float64(n), true }
+func (n numberExpr) vars(into []string) []string             { return into }
+
+type varExpr string
+
+func (v varExpr) eval(vars map[string]float64) (float64, bool) {
+	x, ok := vars[string(v)]
+	return x, ok
+}
+
+func (v varExpr) vars(into []string) []string { return append(into, string(v)) }
+
+type binaryExpr struct {
+	op          rune
+	left, right exprNode
+}
+
+func (b binaryExpr) eval(vars map[string]float64) (float64, bool) {
+	l, ok := b.left.eval(vars)
+	if !ok {
+		return 0, false
+	}
+	r, ok := b.right.eval(vars)
+	if !ok {
+		return 0, false
+	}
+	switch b.op {
+	case '+':
+		return l + r, true
+	case '-':
+		return l - r, true
+	case '*':
+		return l * r, true
+	default:
+		if r == 0 {
+			return 0, false
+		}
+		return l / r, true
+	}
+}
+
+func (b binaryExpr) vars(into []string) []string {
+	return b.right.vars(b.left.vars(into))
+}
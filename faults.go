@@ -0,0 +1,80 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build chaos
+
+package main
+
+// Fault injection, for checking that reporters, write targets and the
+// write queue cope with a slow or failing database. It is only built in
+// with -tags chaos, so production binaries can't be made to fail.
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"math/rand"
+	"strings"
+	"time"
+)
+
+var (
+	chaosFailureRate = flag.Float64("chaos-failure-rate", 0, "fraction of writes to fail with an injected error")
+	chaosLatency     = flag.Duration("chaos-latency", 0, "latency to add to writes")
+	chaosLatencyRate = flag.Float64("chaos-latency-rate", 1, "fraction of writes to add -chaos-latency to")
+	chaosTargets     = flag.String("chaos-targets", "", "comma separated databases to inject faults into: primary or the names of write targets; all if empty")
+)
+
+var errInjectedFault = errors.New("injected fault")
+
+// validateFaultFlags checks the fault injection flags, and warns that
+// faults are being injected.
+func validateFaultFlags() error {
+	for _, rate := range []float64{*chaosFailureRate, *chaosLatencyRate} {
+		if rate < 0 || rate > 1 {
+			return errors.New("-chaos-failure-rate and -chaos-latency-rate must be between 0 and 1")
+		}
+	}
+	if *chaosFailureRate > 0 || *chaosLatency > 0 {
+		log.Printf("Injecting faults into writes: failure rate %g, latency %s at rate %g", *chaosFailureRate, *chaosLatency, *chaosLatencyRate)
+	}
+	return nil
+}
+
+// injectFault is called before writing a report to a database, primary or
+// a write target's name. It may sleep, and may return an error which the
+// write should fail with.
+func injectFault(target string) error {
+	if *chaosTargets != "" && !containsTarget(*chaosTargets, target) {
+		return nil
+	}
+	if *chaosLatency > 0 && rand.Float64() < *chaosLatencyRate {
+		metrics.Inc("panopticon_injected_faults_total", "target", target, "fault", "latency")
+		time.Sleep(*chaosLatency)
+	}
+	if rand.Float64() < *chaosFailureRate {
+		metrics.Inc("panopticon_injected_faults_total", "target", target, "fault", "failure")
+		return errInjectedFault
+	}
+	return nil
+}
+
+func containsTarget(list, target string) bool {
+	for _, t := range strings.Split(list, ",") {
+		if strings.TrimSpace(t) == target {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+)
+
+// feature is a subsystem which can be switched on or off in the config
+// file, so that it can be enabled gradually, or disabled again without
+// going back to an older binary. New subsystems should start out off.
+type feature struct {
+	Default     bool
+	Description string
+}
+
+var features = map[string]feature{
+	"delta_reports": {true, "rebuild pushes with delta_of from an earlier report"},
+	"verification":  {true, "serve /verify and mark reports from verified homeservers, with -verification-period"},
+	"shadow":        {true, "forward pushes to the legacy collector of -shadow-url"},
+	"group_tokens":  {true, "require the tokens of endpoint_groups"},
+	"histograms":    {true, "build the daily histograms configured in histograms"},
+}
+
+// enabledFeatures are the features switched on or off by the config file.
+var enabledFeatures = map[string]bool{}
+
+func validateFeatures(fs map[string]bool) error {
+	for name := range fs {
+		if _, ok := features[name]; !ok {
+			return fmt.Errorf("unknown feature %s; features are %s", name, strings.Join(featureNames(), ", "))
+		}
+	}
+	return nil
+}
+
+func featureNames() []string {
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// setFeatures switches features on or off, exporting their state as
+// panopticon_feature_enabled.
+func setFeatures(fs map[string]bool) {
+	enabledFeatures = fs
+	for _, name := range featureNames() {
+		v := 0.0
+		if featureEnabled(name) {
+			v = 1
+		}
+		metrics.Set("panopticon_feature_enabled", v, "feature", name)
+	}
+}
+
+// featureEnabled reports whether a feature is switched on, by the config
+// file or by default.
+func featureEnabled(name string) bool {
+	if on, ok := enabledFeatures[name]; ok {
+		return on
+	}
+	return features[name].Default
+}
+
+// Version describes the running binary and the features it has switched
+// on, so that operators can tell what a deployment is doing.
+type Version struct {
+	Version   string          `json:"version"`
+	Revision  string          `json:"revision,omitempty"` // The VCS revision built, if known
+	GoVersion string          `json:"go_version"`
+	Features  map[string]bool `json:"features"`
+}
+
+func currentVersion() *Version {
+	v := &Version{Version: "(devel)", GoVersion: runtime.Version(), Features: map[string]bool{}}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		v.Version = info.Main.Version
+		for _, s := range info.Settings {
+			if s.Key == "vcs.revision" {
+				v.Revision = s.Value
+			}
+		}
+	}
+	for name := range features {
+		v.Features[name] = featureEnabled(name)
+	}
+	return v
+}
+
+// serveVersion serves /version.
+func serveVersion(w http.ResponseWriter, req *http.Request) {
+	writeJSON(w, currentVersion())
+}
@@ -0,0 +1,227 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+)
+
+var feedToken = flag.String("feed-token", "", "a token which only allows reading /api/v1/feed.atom, passed as ?token=, for feed readers which can't send headers")
+
+const defaultFeedEntries = 50
+
+// feedEvent is a notable event published in the Atom feed.
+type feedEvent struct {
+	id, title, summary string
+	at                 int64
+}
+
+type atomFeed struct {
+	XMLName xml.Name    `xml:"http://www.w3.org/2005/Atom feed"`
+	ID      string      `xml:"id"`
+	Title   string      `xml:"title"`
+	Updated string      `xml:"updated"`
+	Links   []atomLink  `xml:"link"`
+	Author  atomAuthor  `xml:"author"`
+	Entries []atomEntry `xml:"entry"`
+}
+
+type atomLink struct {
+	Rel  string `xml:"rel,attr"`
+	Href string `xml:"href,attr"`
+}
+
+type atomAuthor struct {
+	Name string `xml:"name"`
+}
+
+type atomEntry struct {
+	ID      string `xml:"id"`
+	Title   string `xml:"title"`
+	Updated string `xml:"updated"`
+	Summary string `xml:"summary"`
+}
+
+// feedEvents returns the latest events of each kind: homeservers seen for
+// the first time, alerts firing and resolving and silences being added.
+// Each kind is limited to n, so the newest n of them all are among them.
+func feedEvents(db *sql.DB, n int) ([]feedEvent, error) {
+	var events []feedEvent
+	rows, err := db.Query(fmt.Sprintf(`SELECT homeserver, first_seen FROM homeservers
+		WHERE homeserver NOT IN (SELECT homeserver FROM tombstones) ORDER BY first_seen DESC LIMIT %d`, n))
+	if err != nil {
+		return nil, err
+	}
+	for rows.Next() {
+		var hs string
+		var at int64
+		if err := rows.Scan(&hs, &at); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		events = append(events, feedEvent{
+			id:      "new-homeserver/" + hs,
+			title:   "New homeserver: " + hs,
+			summary: hs + " reported for the first time.",
+			at:      at,
+		})
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	alerts, err := queryAlerts(db, "", nil, n)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range alerts {
+		hs := ""
+		if a.Homeserver != nil {
+			hs = *a.Homeserver
+		}
+		observed := "no longer reported"
+		if a.Observed != nil {
+			observed = strconv.FormatFloat(*a.Observed, 'f', -1, 64)
+		}
+		events = append(events, feedEvent{
+			id:      fmt.Sprintf("alert/%d/fired", a.ID),
+			title:   alertSubject("Firing", a.Rule, hs),
+			summary: fmt.Sprintf("Alert %s fired; observed %s.", a.Rule, observed),
+			at:      a.FiredAt,
+		})
+		if a.ResolvedAt != nil {
+			events = append(events, feedEvent{
+				id:      fmt.Sprintf("alert/%d/resolved", a.ID),
+				title:   alertSubject("Resolved", a.Rule, hs),
+				summary: fmt.Sprintf("Alert %s resolved after %s.", a.Rule, time.Duration(*a.ResolvedAt-a.FiredAt)*time.Second),
+				at:      *a.ResolvedAt,
+			})
+		}
+	}
+
+	rows, err = db.Query(fmt.Sprintf("SELECT id, homeserver, starts_at, ends_at, reason, created_at FROM silences ORDER BY id DESC LIMIT %d", n))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var s Silence
+		var homeserver, reason sql.NullString
+		if err := rows.Scan(&s.ID, &homeserver, &s.StartsAt, &s.EndsAt, &reason, &s.CreatedAt); err != nil {
+			return nil, err
+		}
+		scope := "every alert"
+		if homeserver.Valid {
+			scope = homeserver.String
+		}
+		summary := fmt.Sprintf("Alerts for %s are silenced from %s to %s.", scope, atomTime(s.StartsAt), atomTime(s.EndsAt))
+		if reason.String != "" {
+			summary += " Reason: " + reason.String
+		}
+		events = append(events, feedEvent{
+			id:      fmt.Sprintf("silence/%d", s.ID),
+			title:   "Silenced " + scope,
+			summary: summary,
+			at:      s.CreatedAt,
+		})
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(events, func(i, j int) bool { return events[i].at > events[j].at })
+	if len(events) > n {
+		events = events[:n]
+	}
+	return events, nil
+}
+
+func atomTime(ts int64) string {
+	return time.Unix(ts, 0).UTC().Format(time.RFC3339)
+}
+
+// requireFeedReader lets feed readers in with -feed-token, and anybody who
+// may use the read API.
+func requireFeedReader(roles map[string]*Role, h http.HandlerFunc) http.HandlerFunc {
+	reader := requireReader(roles, h)
+	return func(w http.ResponseWriter, req *http.Request) {
+		token := req.URL.Query().Get("token")
+		if *feedToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(*feedToken)) == 1 {
+			h(w, req)
+			return
+		}
+		reader(w, req)
+	}
+}
+
+// FeedHandler serves /api/v1/feed.atom, an Atom feed of notable events,
+// newest first. It accepts the query parameter limit.
+type FeedHandler struct {
+	DB *sql.DB
+}
+
+func (h *FeedHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if aggregatesOnly(w, req) || hiddenColumnError(w, roleOf(req), "homeserver", "the feed") {
+		return
+	}
+	limit, err := intParam(req.URL.Query().Get("limit"), defaultFeedEntries)
+	if err != nil || limit <= 0 || limit > *maxRowLimit {
+		logAndReplyError(w, fmt.Errorf("bad limit %q", req.URL.Query().Get("limit")), 400, "Bad query")
+		return
+	}
+	events, err := feedEvents(h.DB, limit)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error loading events")
+		return
+	}
+	scheme := "http"
+	if isHTTPS(req) {
+		scheme = "https"
+	}
+	self := fmt.Sprintf("%s://%s%s", scheme, req.Host, req.URL.Path)
+	feed := atomFeed{
+		ID:      self,
+		Title:   "panopticon events",
+		Updated: atomTime(time.Now().UTC().Unix()),
+		Links:   []atomLink{{Rel: "self", Href: self}},
+		Author:  atomAuthor{Name: "panopticon"},
+	}
+	if len(events) > 0 {
+		feed.Updated = atomTime(events[0].at)
+	}
+	for _, e := range events {
+		feed.Entries = append(feed.Entries, atomEntry{
+			ID:      self + "#" + e.id,
+			Title:   e.title,
+			Updated: atomTime(e.at),
+			Summary: e.summary,
+		})
+	}
+	w.Header().Set("Content-Type", "application/atom+xml; charset=utf-8")
+	fmt.Fprint(w, xml.Header)
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	enc.Encode(feed)
+}
@@ -0,0 +1,225 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// FieldRule overrides how a numeric report field is stored and validated.
+type FieldRule struct {
+	Type      string   `json:"type"`       // "int" (the default) or "float"
+	Min       *float64 `json:"min"`        // Defaults to the smallest int64 for int fields
+	Max       *float64 `json:"max"`        // Defaults to the largest int64 for int fields
+	OnInvalid string   `json:"on_invalid"` // "clamp" (the default), "drop" or "reject"
+}
+
+func (f FieldRule) validate() error {
+	switch f.Type {
+	case "", "int", "float":
+	default:
+		return fmt.Errorf("unknown type %q", f.Type)
+	}
+	switch f.OnInvalid {
+	case "", "clamp", "drop", "reject":
+	default:
+		return fmt.Errorf("unknown on_invalid %q", f.OnInvalid)
+	}
+	if f.Min != nil && f.Max != nil && *f.Min > *f.Max {
+		return fmt.Errorf("min %v is greater than max %v", *f.Min, *f.Max)
+	}
+	return nil
+}
+
+// numericFields returns the JSON names of the integer fields of a report,
+// which are the ones rules may apply to.
+func numericFields() map[string]bool {
+	fields := map[string]bool{}
+	for _, t := range []reflect.Type{
+		reflect.TypeOf(CommonStats{}),
+		reflect.TypeOf(ReportStatsSynapse{}),
+		reflect.TypeOf(ReportStatsDendrite{}),
+	} {
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Type != reflect.TypeOf((*int64)(nil)) {
+				continue
+			}
+			name := strings.Split(f.Tag.Get("json"), ",")[0]
+			if name != "" && name != "-" {
+				fields[name] = true
+			}
+		}
+	}
+	return fields
+}
+
+// floatColumnType is the column type used for floating point fields.
+func floatColumnType(driver string) string {
+	if driver == "postgres" {
+		return "DOUBLE PRECISION"
+	}
+	return "DOUBLE"
+}
+
+// withFieldTypes changes the columns of fields s configures as floats to a
+// floating point type. checkSchema changes existing columns to match.
+func withFieldTypes(t *tableDef, s *Storage) *tableDef {
+	for i, c := range t.Columns {
+		if s.Fields[c.Name].Type == "float" {
+			t.Columns[i].Type = floatColumnType(s.Driver)
+		}
+	}
+	return t
+}
+
+// sanitizeNumbers checks the integer fields of a JSON report before it is
+// decoded, so that a fractional or out of range value doesn't fail the whole
+// report. Fractional values of int fields are rounded, and values outside
+// the allowed range are clamped or dropped according to the field's rule.
+// It returns the report with those values replaced, the names of the fields
+// which were changed, and the exact values of fields configured as floats.
+func sanitizeNumbers(body []byte, rules map[string]FieldRule) ([]byte, []string, map[string]float64, error) {
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		// Leave it to the report decoder to complain.
+		return body, nil, nil, nil
+	}
+	var (
+		adjusted []string
+		floats   map[string]float64
+		changed  bool
+	)
+	numeric := numericFields()
+	for key, value := range raw {
+		if !numeric[key] {
+			continue
+		}
+		s := string(bytes.TrimSpace(value))
+		if s == "null" {
+			continue
+		}
+		v, err := strconv.ParseFloat(s, 64)
+		if err != nil && !isRangeError(err) {
+			continue
+		}
+		rule := rules[key]
+		_, intErr := strconv.ParseInt(s, 10, 64)
+		if intErr == nil && rule.Min == nil && rule.Max == nil {
+			// The common case: an integer which fits.
+			continue
+		}
+		out, ok, valid := applyFieldRule(rule, v)
+		if !valid {
+			return nil, nil, nil, fmt.Errorf("%s: %s is out of range", key, s)
+		}
+		if !ok {
+			raw[key] = json.RawMessage("null")
+			adjusted = append(adjusted, key)
+			changed = true
+			continue
+		}
+		if rule.Type == "float" {
+			if floats == nil {
+				floats = map[string]float64{}
+			}
+			floats[key] = out
+			if out != v {
+				adjusted = append(adjusted, key)
+			}
+		} else if out != v || isRangeError(intErr) {
+			adjusted = append(adjusted, key)
+		}
+		// The report is still decoded into int64 fields, so replace the
+		// value with one which fits.
+		if replacement := strconv.FormatInt(roundToInt64(out), 10); replacement != s {
+			raw[key] = json.RawMessage(replacement)
+			changed = true
+		}
+	}
+	sort.Strings(adjusted)
+	if !changed {
+		return body, adjusted, floats, nil
+	}
+	clean, err := json.Marshal(raw)
+	return clean, adjusted, floats, err
+}
+
+// applyFieldRule checks v against the rule's range. ok is false if the value
+// should be dropped and valid is false if the report should be rejected.
+func applyFieldRule(rule FieldRule, v float64) (out float64, ok bool, valid bool) {
+	min, max := float64(math.MinInt64), float64(math.MaxInt64)
+	if rule.Type == "float" {
+		min, max = -math.MaxFloat64, math.MaxFloat64
+	}
+	if rule.Min != nil {
+		min = *rule.Min
+	}
+	if rule.Max != nil {
+		max = *rule.Max
+	}
+	if rule.Type != "float" {
+		v = math.Round(v)
+	}
+	if v >= min && v <= max && !math.IsInf(v, 0) {
+		return v, true, true
+	}
+	switch rule.OnInvalid {
+	case "drop":
+		return 0, false, true
+	case "reject":
+		return 0, false, false
+	}
+	if v < min {
+		return min, true, true
+	}
+	return max, true, true
+}
+
+// roundToInt64 rounds v, saturating at the bounds of int64.
+func roundToInt64(v float64) int64 {
+	v = math.Round(v)
+	if v >= math.MaxInt64 {
+		return math.MaxInt64
+	}
+	if v <= math.MinInt64 {
+		return math.MinInt64
+	}
+	return int64(v)
+}
+
+func isRangeError(err error) bool {
+	e, ok := err.(*strconv.NumError)
+	return ok && e.Err == strconv.ErrRange
+}
+
+// applyFloats replaces the values of float fields with their exact values.
+func applyFloats(cols []string, vals []interface{}, floats map[string]float64) []interface{} {
+	for i, c := range cols {
+		if v, ok := floats[c]; ok {
+			vals[i] = v
+		}
+	}
+	return vals
+}
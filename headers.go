@@ -0,0 +1,143 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var (
+	securityHeaders = flag.Bool("security-headers", true, "send standard security headers with every response")
+	hstsMaxAge      = flag.Duration("hsts-max-age", 365*24*time.Hour, "max-age of the Strict-Transport-Security header sent with responses over HTTPS; 0 doesn't send it")
+)
+
+// defaultSecurityHeaders are sent with every response unless
+// -security-headers=false. The policy still lets the dashboard load its
+// own scripts and styles and fetch /metrics.
+var defaultSecurityHeaders = map[string]string{
+	"X-Content-Type-Options":  "nosniff",
+	"X-Frame-Options":         "DENY",
+	"Referrer-Policy":         "no-referrer",
+	"Content-Security-Policy": "default-src 'self'; frame-ancestors 'none'",
+}
+
+// privatePrefixes are the paths whose responses are only for the client
+// which authenticated, so mustn't be cached.
+var privatePrefixes = []string{"/api/", "/admin/"}
+
+// headerNamePattern matches the token characters allowed in header names.
+var headerNamePattern = regexp.MustCompile("^[A-Za-z0-9!#$%&'*+.^_`|~-]+$")
+
+// ResponseHeaders sets headers on the responses to some paths.
+type ResponseHeaders struct {
+	Paths   []string          `json:"paths"`   // Path prefixes; every path if empty
+	Headers map[string]string `json:"headers"` // An empty value removes the header
+}
+
+func (r *ResponseHeaders) matches(path string) bool {
+	if len(r.Paths) == 0 {
+		return true
+	}
+	for _, p := range r.Paths {
+		if strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+func validateResponseHeaders(rules []*ResponseHeaders) error {
+	for i, r := range rules {
+		if len(r.Headers) == 0 {
+			return fmt.Errorf("%d: no headers", i)
+		}
+		for _, p := range r.Paths {
+			if !strings.HasPrefix(p, "/") {
+				return fmt.Errorf("%d: path %q doesn't start with /", i, p)
+			}
+		}
+		for name, v := range r.Headers {
+			if !headerNamePattern.MatchString(name) {
+				return fmt.Errorf("%d: bad header name %q", i, name)
+			}
+			if strings.ContainsAny(v, "\r\n\x00") {
+				return fmt.Errorf("%d: %s: value contains a line break", i, name)
+			}
+		}
+	}
+	return nil
+}
+
+// isHTTPS reports whether a request reached panopticon, or the trusted
+// proxy in front of it, over TLS.
+func isHTTPS(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	if peer, _ := canonicalIP(req.RemoteAddr); !isTrustedProxy(peer) {
+		return false
+	}
+	if proto := req.Header.Get("X-Forwarded-Proto"); proto != "" {
+		return strings.EqualFold(strings.TrimSpace(strings.Split(proto, ",")[0]), "https")
+	}
+	for _, element := range strings.Split(req.Header.Get("Forwarded"), ",") {
+		for _, pair := range strings.Split(element, ";") {
+			kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+			if len(kv) == 2 && strings.EqualFold(kv[0], "proto") {
+				return strings.EqualFold(strings.Trim(kv[1], `"`), "https")
+			}
+		}
+	}
+	return false
+}
+
+// withResponseHeaders sets the security headers and the configured
+// headers on every response. Handlers may still override them.
+func withResponseHeaders(h http.Handler, rules []*ResponseHeaders) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		header := w.Header()
+		if *securityHeaders {
+			for name, v := range defaultSecurityHeaders {
+				header.Set(name, v)
+			}
+			for _, p := range privatePrefixes {
+				if strings.HasPrefix(req.URL.Path, p) {
+					header.Set("Cache-Control", "no-store")
+				}
+			}
+		}
+		if *hstsMaxAge > 0 && isHTTPS(req) {
+			header.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d", int64(hstsMaxAge.Seconds())))
+		}
+		for _, r := range rules {
+			if !r.matches(req.URL.Path) {
+				continue
+			}
+			for name, v := range r.Headers {
+				if v == "" {
+					header.Del(name)
+				} else {
+					header.Set(name, v)
+				}
+			}
+		}
+		h.ServeHTTP(w, req)
+	})
+}
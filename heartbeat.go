@@ -0,0 +1,134 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"net/http"
+	"runtime"
+	"time"
+)
+
+var heartbeatInterval = flag.Duration("heartbeat-interval", 0, "push a report about panopticon itself, as homeserver panopticon.internal, this often and check it was stored; 0 doesn't")
+
+// heartbeatHomeserver is the name panopticon reports about itself under.
+// The .internal TLD is reserved for private use, so no homeserver has it.
+const heartbeatHomeserver = "panopticon.internal"
+
+var processStarted = time.Now()
+
+// heartbeat is the heartbeat job, a canary of the write path. It pushes a
+// report through h, the handler serving /push, just as a homeserver would,
+// with token if pushes need one, then reads the row back from db. The first
+// time, panopticon.internal is tagged internal, so that it can be left out
+// of figures.
+func heartbeat(db *sql.DB, s *Storage, h http.Handler, token string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		if err := tagHeartbeatHomeserver(db, s); err != nil {
+			return err
+		}
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		now := time.Now()
+		report, err := json.Marshal(map[string]interface{}{
+			"homeserver":     heartbeatHomeserver,
+			"timestamp":      now.Unix(),
+			"uptime_seconds": int64(now.Sub(processStarted).Seconds()),
+			"memory_rss":     int64(mem.Sys / 1024), // Kilobytes, like Synapse's
+		})
+		if err != nil {
+			return err
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, "/push?verbose=1", bytes.NewReader(report))
+		if err != nil {
+			return err
+		}
+		req.RemoteAddr = "127.0.0.1:0"
+		req.Header.Set("User-Agent", "panopticon-heartbeat")
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		w := &bufferedResponseWriter{header: http.Header{}}
+		h.ServeHTTP(w, req)
+		if w.status != http.StatusOK {
+			metrics.Inc("panopticon_heartbeats_total", "result", "error")
+			return fmt.Errorf("push replied %d: %s", w.status, w.body.String())
+		}
+		var result PushResult
+		if err := json.Unmarshal(w.body.Bytes(), &result); err != nil {
+			metrics.Inc("panopticon_heartbeats_total", "result", "error")
+			return fmt.Errorf("decoding push reply: %v", err)
+		}
+		if err := checkHeartbeat(ctx, db, s, &result); err != nil {
+			metrics.Inc("panopticon_heartbeats_total", "result", "error")
+			return err
+		}
+		metrics.Inc("panopticon_heartbeats_total", "result", "ok")
+		metrics.Set("panopticon_heartbeat_last_success_seconds", float64(now.Unix()))
+		addRowsAffected(ctx, 1)
+		return nil
+	}
+}
+
+// checkHeartbeat reads back the row a heartbeat push says it stored.
+// Downsampled and sampled out heartbeats have none.
+func checkHeartbeat(ctx context.Context, db *sql.DB, s *Storage, result *PushResult) error {
+	d := dialectFor(db)
+	var id interface{}
+	switch v := result.ID.(type) {
+	case float64:
+		if v == 0 {
+			return nil
+		}
+		id = int64(v)
+	case string:
+		id = v
+	default:
+		return fmt.Errorf("push replied with id %v", result.ID)
+	}
+	var hs string
+	err := db.QueryRowContext(ctx, fmt.Sprintf("SELECT homeserver FROM %s WHERE id = %s", s.tableName(result.Table), d.placeholder(1)), id).Scan(&hs)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("%s %v wasn't stored", result.Table, id)
+	}
+	if err != nil {
+		return err
+	}
+	if hs != s.storedHomeserver(heartbeatHomeserver) {
+		return fmt.Errorf("%s %v is from %s", result.Table, id, hs)
+	}
+	return nil
+}
+
+// tagHeartbeatHomeserver tags panopticon.internal internal, unless admins
+// have already given it metadata.
+func tagHeartbeatHomeserver(db *sql.DB, s *Storage) error {
+	hs := s.storedHomeserver(heartbeatHomeserver)
+	existing, err := loadMetadata(db, hs)
+	if err != nil || existing[hs] != nil {
+		return err
+	}
+	return saveMetadata(db, &HomeserverMetadata{
+		Homeserver:  hs,
+		DisplayName: "panopticon heartbeat",
+		Tags:        []string{"internal"},
+		UpdatedAt:   time.Now().UTC().Unix(),
+	})
+}
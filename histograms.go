@@ -0,0 +1,273 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var statsRetention = flag.Duration("stats-retention", 0, "delete reports older than this, after summarising the configured histogram metrics; 0 keeps them forever")
+
+func createTableMetricHistograms(db *sql.DB) error {
+	_, err := db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS metric_histograms(
+		metric VARCHAR(64) NOT NULL,
+		day BIGINT NOT NULL,
+		bucket INT NOT NULL,
+		le %s,
+		homeservers BIGINT NOT NULL,
+		PRIMARY KEY (metric, day, bucket)
+		)`, floatColumnType(driverFor(db))))
+	return err
+}
+
+// histogramTables returns the stats tables which have a numeric column for
+// metric.
+func histogramTables(tables []*tableDef, metric string) []string {
+	var names []string
+	for _, t := range tables {
+		for _, c := range t.Columns {
+			if c.Name == metric && (strings.Contains(c.Type, "INT") || strings.Contains(c.Type, "DOUBLE")) {
+				names = append(names, t.Name)
+			}
+		}
+	}
+	return names
+}
+
+func validateHistograms(histograms map[string][]float64) error {
+	for metric, bounds := range histograms {
+		if len(histogramTables(reportColumns(), metric)) == 0 {
+			return fmt.Errorf("%s is not a numeric column", metric)
+		}
+		if len(bounds) == 0 {
+			return fmt.Errorf("%s: no buckets", metric)
+		}
+		for i := 1; i < len(bounds); i++ {
+			if bounds[i] <= bounds[i-1] {
+				return fmt.Errorf("%s: bucket bounds must be ascending", metric)
+			}
+		}
+	}
+	return nil
+}
+
+// buildHistograms summarises each complete day before until which doesn't
+// have a histogram yet. Each homeserver is counted once a day, in the bucket
+// of the last value it reported that day. A bucket holds values up to and
+// including its bound, and a last bucket holds anything larger.
+func buildHistograms(ctx context.Context, db *sql.DB, s *Storage, histograms map[string][]float64, until int64) error {
+	d := dialectFor(db)
+	until -= until % oneDay
+	for metric, bounds := range histograms {
+		tables := histogramTables(s.tables(), metric)
+		var last sql.NullInt64
+		err := db.QueryRowContext(ctx, "SELECT MAX(day) FROM metric_histograms WHERE metric = "+d.placeholder(1), metric).Scan(&last)
+		if err != nil {
+			return err
+		}
+		start := last.Int64 + oneDay
+		if !last.Valid {
+			var selects []string
+			for _, t := range tables {
+				selects = append(selects, fmt.Sprintf("SELECT MIN(local_timestamp) AS ts FROM %s WHERE %s IS NOT NULL", t, metric))
+			}
+			var first sql.NullInt64
+			err := db.QueryRowContext(ctx, "SELECT MIN(ts) FROM ("+strings.Join(selects, " UNION ALL ")+") AS s").Scan(&first)
+			if err != nil {
+				return err
+			}
+			if !first.Valid {
+				continue
+			}
+			start = first.Int64 - first.Int64%oneDay
+		}
+		for day := start; day < until; day += oneDay {
+			if err := buildHistogram(ctx, db, metric, bounds, tables, day); err != nil {
+				return fmt.Errorf("%s on %d: %v", metric, day, err)
+			}
+		}
+	}
+	return nil
+}
+
+func buildHistogram(ctx context.Context, db *sql.DB, metric string, bounds []float64, tables []string, day int64) error {
+	d := dialectFor(db)
+	latest := map[int64]map[string][]sql.NullFloat64{}
+	for _, t := range tables {
+		qry := fmt.Sprintf(`SELECT homeserver, local_timestamp, %[1]s FROM %[2]s
+			WHERE local_timestamp >= %[3]s AND local_timestamp < %[4]s AND %[1]s IS NOT NULL
+			AND homeserver NOT IN (SELECT homeserver FROM tombstones)
+			ORDER BY local_timestamp`, metric, t, d.placeholder(1), d.placeholder(2))
+		if err := collectLatest(ctx, db, qry, []interface{}{day, day + oneDay}, 1, latest); err != nil {
+			return err
+		}
+	}
+	counts := make([]int64, len(bounds)+1)
+	for _, values := range latest[day] {
+		i := 0
+		for i < len(bounds) && values[0].Float64 > bounds[i] {
+			i++
+		}
+		counts[i]++
+	}
+
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	_, err = tx.Exec(
+		fmt.Sprintf("DELETE FROM metric_histograms WHERE metric = %s AND day = %s", d.placeholder(1), d.placeholder(2)),
+		metric, day,
+	)
+	if err != nil {
+		return err
+	}
+	for i, n := range counts {
+		var le interface{}
+		if i < len(bounds) {
+			le = bounds[i]
+		}
+		_, err := tx.Exec(
+			dialectFor(db).insert("metric_histograms", "metric", "day", "bucket", "le", "homeservers"),
+			metric, day, i, le, n,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	addRowsAffected(ctx, int64(len(counts)))
+	return nil
+}
+
+// histogramsJob is the build_histograms job.
+func histogramsJob(db *sql.DB, s *Storage, histograms map[string][]float64) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return buildHistograms(ctx, db, s, histograms, time.Now().UTC().Unix())
+	}
+}
+
+// pruneStats is the prune_stats job. It deletes whole days of reports older
+// than -stats-retention, once their histograms have been built.
+func pruneStats(db *sql.DB, s *Storage, histograms map[string][]float64) func(ctx context.Context) error {
+	d := dialectFor(db)
+	return func(ctx context.Context) error {
+		now := time.Now().UTC().Unix()
+		if err := buildHistograms(ctx, db, s, histograms, now); err != nil {
+			return err
+		}
+		cutoff := now - int64(statsRetention.Seconds())
+		cutoff -= cutoff % oneDay
+		// String metrics are kept as long as the reports they came with.
+		tables := []string{"string_metrics"}
+		for _, t := range s.tables() {
+			tables = append(tables, t.Name)
+		}
+		for _, table := range tables {
+			res, err := db.ExecContext(ctx, fmt.Sprintf("DELETE FROM %s WHERE local_timestamp < %s", table, d.placeholder(1)), cutoff)
+			if err != nil {
+				return err
+			}
+			if n, err := res.RowsAffected(); err == nil && n > 0 {
+				addRowsAffected(ctx, n)
+				log.Printf("Pruned %d rows from %s", n, table)
+				if err := analyzeAfterDelete(ctx, db, table, n); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// HistogramBucket is one bucket of a histogram. Le is null for the last
+// bucket, which has no upper bound.
+type HistogramBucket struct {
+	Le          *float64 `json:"le"`
+	Homeservers *int64   `json:"homeservers"` // Null if suppressed for privacy
+}
+
+// HistogramsHandler serves /api/v1/histograms, the daily histograms of a
+// metric. It accepts the query parameters metric, since and until (seconds).
+type HistogramsHandler struct {
+	DB *sql.DB
+}
+
+func (h *HistogramsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	q := req.URL.Query()
+	if hiddenColumnError(w, roleOf(req), q.Get("metric"), "metric "+q.Get("metric")) {
+		return
+	}
+	args := []interface{}{q.Get("metric")}
+	where := []string{"metric = " + d.placeholder(1)}
+	for _, p := range []struct{ param, op string }{{"since", ">="}, {"until", "<"}} {
+		if v := q.Get(p.param); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				logAndReplyError(w, err, 400, "Bad query")
+				return
+			}
+			args = append(args, ts)
+			where = append(where, fmt.Sprintf("day %s %s", p.op, d.placeholder(len(args))))
+		}
+	}
+	rows, err := h.DB.QueryContext(req.Context(), "SELECT day, le, homeservers FROM metric_histograms WHERE "+strings.Join(where, " AND ")+" ORDER BY day, bucket", args...)
+	if err != nil {
+		replyQueryError(w, req, err, "Error querying histograms")
+		return
+	}
+	defer rows.Close()
+	type dayHistogram struct {
+		Day     int64             `json:"day"`
+		Buckets []HistogramBucket `json:"buckets"`
+	}
+	result := []*dayHistogram{}
+	for rows.Next() {
+		var day, n int64
+		var le sql.NullFloat64
+		var b HistogramBucket
+		if err := rows.Scan(&day, &le, &n); err != nil {
+			logAndReplyError(w, err, 500, "Error querying histograms")
+			return
+		}
+		bucket := q.Get("metric") + " le +Inf"
+		if le.Valid {
+			b.Le = &le.Float64
+			bucket = fmt.Sprintf("%s le %v", q.Get("metric"), le.Float64)
+		}
+		b.Homeservers = privacyOf(req).count(n, bucket, fmt.Sprint(day))
+		if len(result) == 0 || result[len(result)-1].Day != day {
+			result = append(result, &dayHistogram{Day: day})
+		}
+		result[len(result)-1].Buckets = append(result[len(result)-1].Buckets, b)
+	}
+	if err := rows.Err(); err != nil {
+		replyQueryError(w, req, err, "Error querying histograms")
+		return
+	}
+	writeJSON(w, result)
+}
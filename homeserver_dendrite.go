@@ -16,8 +16,6 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
-	"strings"
 )
 
 // Dendrite specific stats
@@ -36,70 +34,95 @@ type ReportStatsDendrite struct {
 	Version            string `json:"version,omitempty"`
 }
 
-func createTableDendrite(db *sql.DB) error {
-	autoincrement := "AUTOINCREMENT"
-	primaryKeyType := "INTEGER"
+// dendriteTable describes the dendrite_stats table, besides its id primary key.
+func dendriteTable(s *Storage) *tableDef {
+	return withFieldTypes(&tableDef{Name: s.tableName("dendrite_stats"), Kind: "dendrite_stats", GeneratedIDs: s.generatedIDs(), Indexes: reportIndexes, Columns: []columnDef{
+		{"homeserver", "VARCHAR(256)"},
+		{"local_timestamp", "BIGINT"},
+		{"remote_timestamp", "BIGINT"},
+		{"remote_addr", "TEXT"},
+		{"forwarded_for", "TEXT"},
+		{"uptime_seconds", "BIGINT"},
+		{"total_users", "BIGINT"},
+		{"total_nonbridged_users", "BIGINT"},
+		{"total_room_count", "BIGINT"},
+		{"daily_active_users", "BIGINT"},
+		{"daily_active_rooms", "BIGINT"},
+		{"daily_messages", "BIGINT"},
+		{"daily_sent_messages", "BIGINT"},
+		{"daily_active_e2ee_rooms", "BIGINT"},
+		{"daily_e2ee_messages", "BIGINT"},
+		{"daily_sent_e2ee_messages", "BIGINT"},
+		{"monthly_active_users", "BIGINT"},
+		{"r30_users_all", "BIGINT"},
+		{"r30_users_android", "BIGINT"},
+		{"r30_users_ios", "BIGINT"},
+		{"r30_users_electron", "BIGINT"},
+		{"r30_users_web", "BIGINT"},
+		{"r30v2_users_all", "BIGINT"},
+		{"r30v2_users_android", "BIGINT"},
+		{"r30v2_users_ios", "BIGINT"},
+		{"r30v2_users_electron", "BIGINT"},
+		{"r30v2_users_web", "BIGINT"},
+		{"cpu_average", "BIGINT"},
+		{"memory_rss", "BIGINT"},
+		{"user_agent", "TEXT"},
+		{"daily_user_type_native", "BIGINT"},
+		{"daily_user_type_bridged", "BIGINT"},
+		{"daily_user_type_guest", "BIGINT"},
+		{"database_engine", "TEXT"},
+		{"database_server_version", "TEXT"},
+		{"log_level", "TEXT"},
+		{"goos", "TEXT"},
+		{"goarch", "TEXT"},
+		{"goversion", "TEXT"},
+		{"federation_disabled", "INT"},
+		{"monolith", "INT"},
+		{"nats_embedded", "INT"},
+		{"nats_in_memory", "INT"},
+		{"num_cpu", "INT"},
+		{"num_go_routine", "INT"},
+		{"version", "TEXT"},
+		{"stale", "INT"},
+		{"remote_ip", "VARCHAR(45)"},
+		{"remote_ip_family", "INT"},
+		{"adjusted_fields", "TEXT"},
+		{"backfilled", "INT"},
+		{"sample_rate", "BIGINT"},
+		{"compacted_reports", "BIGINT"},
+		{"compacted_ranges", "TEXT"},
+		{"schema_version", "VARCHAR(32)"},
+		{"user_agent_filter", "VARCHAR(64)"},
+		{"verified", "INT"},
+	}}, s)
+}
 
-	if *dbDriver == "mysql" {
-		autoincrement = "AUTO_INCREMENT"
-	} else if *dbDriver == "postgres" {
-		autoincrement = ""
-		primaryKeyType = "SERIAL"
+// Save inserts the report into the dendrite_stats table of s with the given id, or
+// if that is "" one the database assigns, returning the row's ID and the columns it has values
+// for. Every other column is explicitly NULL.
+func (sr *ReportStatsDendrite) Save(db *sql.DB, s *Storage, id string) (interface{}, []string, error) {
+	ins, cols, err := sr.prepareSave(db, s, id)
+	if err != nil {
+		return nil, nil, err
 	}
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS dendrite_stats(
-		id ` + primaryKeyType + ` NOT NULL PRIMARY KEY ` + autoincrement + ` ,
-		homeserver VARCHAR(256),
-		local_timestamp BIGINT,
-		remote_timestamp BIGINT,
-		remote_addr TEXT,
-		forwarded_for TEXT,
-		uptime_seconds BIGINT,
-		total_users BIGINT,
-		total_nonbridged_users BIGINT,
-		total_room_count BIGINT,
-		daily_active_users BIGINT,
-		daily_active_rooms BIGINT,
-		daily_messages BIGINT,
-		daily_sent_messages BIGINT,
-		daily_active_e2ee_rooms BIGINT,
-		daily_e2ee_messages BIGINT,
-		daily_sent_e2ee_messages BIGINT,
-		monthly_active_users BIGINT,
-		r30_users_all BIGINT,
-		r30_users_android BIGINT,
-		r30_users_ios BIGINT,
-		r30_users_electron BIGINT,
-		r30_users_web BIGINT,
-		r30v2_users_all BIGINT,
-		r30v2_users_android BIGINT,
-		r30v2_users_ios BIGINT,
-		r30v2_users_electron BIGINT,
-		r30v2_users_web BIGINT,
-		cpu_average BIGINT,
-		memory_rss BIGINT,
-		user_agent TEXT,
-		daily_user_type_native BIGINT,
-		daily_user_type_bridged BIGINT,
-		daily_user_type_guest BIGINT,
-		database_engine TEXT,
-		database_server_version TEXT,
-		log_level TEXT,
-		goos TEXT,
-		goarch TEXT,
-		goversion TEXT,
-		federation_disabled INT,
-		monolith INT,
-		nats_embedded INT,
-		nats_in_memory INT,
-		num_cpu INT,
-		num_go_routine INT,
-		version TEXT
-		)`)
-
-	return err
+	rowID, err := ins.exec(nil)
+	return rowID, cols, err
 }
 
-func (sr *ReportStatsDendrite) Save(db *sql.DB) error {
+// prepareSave prepares the insert of the report into the dendrite_stats table of s,
+// returning it and the columns the report has values for.
+func (sr *ReportStatsDendrite) prepareSave(db *sql.DB, s *Storage, id string) (*reportInsert, []string, error) {
+	cols, vals := sr.Columns()
+	allCols, allVals, err := withNulls(db, dendriteTable(s), cols, vals)
+	if err != nil {
+		return nil, nil, err
+	}
+	ins, err := prepareReport(db, s, s.tableName("dendrite_stats"), id, allCols, allVals)
+	return ins, cols, err
+}
+
+// Columns returns the dendrite_stats columns the report has values for.
+func (sr *ReportStatsDendrite) Columns() ([]string, []interface{}) {
 	cols := []string{"homeserver", "local_timestamp", "remote_addr"}
 	vals := []interface{}{sr.Common.Homeserver, sr.Common.LocalTimestamp, sr.Common.RemoteAddr}
 
@@ -129,6 +152,8 @@ func (sr *ReportStatsDendrite) Save(db *sql.DB) error {
 	cols, vals = appendIfNonNil(cols, vals, "r30v2_users_electron", sr.Common.R30V2UsersElectron)
 	cols, vals = appendIfNonNil(cols, vals, "r30v2_users_web", sr.Common.R30V2UsersWeb)
 
+	cols, vals = appendIfNonEmpty(cols, vals, "remote_ip", sr.Common.RemoteIP)
+	cols, vals = appendIfNonNil(cols, vals, "remote_ip_family", sr.Common.RemoteIPFamily)
 	cols, vals = appendIfNonEmpty(cols, vals, "forwarded_for", sr.Common.XForwardedFor)
 	cols, vals = appendIfNonEmpty(cols, vals, "user_agent", sr.Common.UserAgent)
 
@@ -143,6 +168,13 @@ func (sr *ReportStatsDendrite) Save(db *sql.DB) error {
 	cols, vals = appendIfNonEmpty(cols, vals, "database_server_version", sr.Common.DatabaseServerVersion)
 
 	cols, vals = appendIfNonEmpty(cols, vals, "log_level", sr.Common.LogLevel)
+	cols, vals = appendIfNonNilBool(cols, vals, "stale", sr.Common.Stale)
+	cols, vals = appendIfNonEmpty(cols, vals, "adjusted_fields", sr.Common.AdjustedFields)
+	cols, vals = appendIfNonNilBool(cols, vals, "backfilled", sr.Common.Backfilled)
+	cols, vals = appendIfNonNil(cols, vals, "sample_rate", sr.Common.SampleRate)
+	cols, vals = appendIfNonEmpty(cols, vals, "schema_version", sr.Common.SchemaVersion)
+	cols, vals = appendIfNonEmpty(cols, vals, "user_agent_filter", sr.Common.UserAgentFilter)
+	cols, vals = appendIfNonNilBool(cols, vals, "verified", sr.Common.Verified)
 
 	cols, vals = appendIfNonEmpty(cols, vals, "goos", sr.GoOS)
 	cols, vals = appendIfNonEmpty(cols, vals, "goarch", sr.GoArch)
@@ -155,15 +187,6 @@ func (sr *ReportStatsDendrite) Save(db *sql.DB) error {
 	cols, vals = appendIfNonNil(cols, vals, "num_go_routine", sr.NumGoRoutine)
 	cols, vals = appendIfNonEmpty(cols, vals, "version", sr.Version)
 
-	var valuePlaceholders []string
-	for i := range vals {
-		if *dbDriver == "mysql" {
-			valuePlaceholders = append(valuePlaceholders, "?")
-		} else {
-			valuePlaceholders = append(valuePlaceholders, fmt.Sprintf("$%d", i+1))
-		}
-	}
-	qry := fmt.Sprintf("INSERT INTO dendrite_stats (%s) VALUES (%s)", strings.Join(cols, ", "), strings.Join(valuePlaceholders, ", "))
-	_, err := db.Exec(qry, vals...)
-	return err
+	vals = applyFloats(cols, vals, sr.Common.Floats)
+	return cols, vals
 }
@@ -0,0 +1,62 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"strings"
+)
+
+var homeserverHashSalt = flag.String("homeserver-hash-salt", "", "store a salted hash of each homeserver's name instead of the name itself; keep the salt secret and unchanged, or a homeserver's history is split")
+
+func validateHomeserverHashFlags() error {
+	if *homeserverHashSalt != "" && *storeRawReports {
+		return errors.New("-store-raw-reports would keep homeserver names in raw bodies, so can't be used with -homeserver-hash-salt")
+	}
+	return nil
+}
+
+// storedHomeserver returns the name a homeserver is stored under: the name
+// itself, or with a HomeserverHashSalt the hex HMAC-SHA256 of its
+// lowercased name keyed by the salt, which is the same for every report so
+// trends and counts still work. Hashes are returned unchanged, so that the
+// admin and read APIs accept either; no server name looks like one, as DNS
+// labels are at most 63 characters.
+func (s *Storage) storedHomeserver(name string) string {
+	if s.HomeserverHashSalt == "" || name == "" || isHomeserverHash(name) {
+		return name
+	}
+	return homeserverHMAC(s.HomeserverHashSalt, name)
+}
+
+// homeserverHMAC returns the hex HMAC-SHA256 of a homeserver's lowercased
+// name keyed by key.
+func homeserverHMAC(key, name string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(strings.ToLower(name)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func isHomeserverHash(name string) bool {
+	if len(name) != 2*sha256.Size {
+		return false
+	}
+	_, err := hex.DecodeString(name)
+	return err == nil
+}
@@ -16,8 +16,6 @@ package main
 
 import (
 	"database/sql"
-	"fmt"
-	"strings"
 )
 
 // Synapse specific stats
@@ -29,66 +27,89 @@ type ReportStatsSynapse struct {
 	ServerContext  string   `json:"server_context"`
 }
 
-func createTableSynapse(db *sql.DB) error {
-	autoincrement := "AUTOINCREMENT"
-	primaryKeyType := "INTEGER"
-	doubleType := "DOUBLE"
+// synapseTable describes the stats table, besides its id primary key.
+func synapseTable(s *Storage) *tableDef {
+	return withFieldTypes(&tableDef{Name: s.tableName("stats"), Kind: "stats", GeneratedIDs: s.generatedIDs(), Indexes: reportIndexes, Columns: []columnDef{
+		{"homeserver", "VARCHAR(256)"},
+		{"local_timestamp", "BIGINT"},
+		{"remote_timestamp", "BIGINT"},
+		{"remote_addr", "TEXT"},
+		{"forwarded_for", "TEXT"},
+		{"uptime_seconds", "BIGINT"},
+		{"total_users", "BIGINT"},
+		{"total_nonbridged_users", "BIGINT"},
+		{"total_room_count", "BIGINT"},
+		{"daily_active_users", "BIGINT"},
+		{"daily_active_rooms", "BIGINT"},
+		{"daily_messages", "BIGINT"},
+		{"daily_sent_messages", "BIGINT"},
+		{"daily_active_e2ee_rooms", "BIGINT"},
+		{"daily_e2ee_messages", "BIGINT"},
+		{"daily_sent_e2ee_messages", "BIGINT"},
+		{"monthly_active_users", "BIGINT"},
+		{"r30_users_all", "BIGINT"},
+		{"r30_users_android", "BIGINT"},
+		{"r30_users_ios", "BIGINT"},
+		{"r30_users_electron", "BIGINT"},
+		{"r30_users_web", "BIGINT"},
+		{"r30v2_users_all", "BIGINT"},
+		{"r30v2_users_android", "BIGINT"},
+		{"r30v2_users_ios", "BIGINT"},
+		{"r30v2_users_electron", "BIGINT"},
+		{"r30v2_users_web", "BIGINT"},
+		{"cpu_average", "BIGINT"},
+		{"memory_rss", "BIGINT"},
+		{"cache_factor", floatColumnType(s.Driver)},
+		{"event_cache_size", "BIGINT"},
+		{"user_agent", "TEXT"},
+		{"daily_user_type_native", "BIGINT"},
+		{"daily_user_type_bridged", "BIGINT"},
+		{"daily_user_type_guest", "BIGINT"},
+		{"python_version", "TEXT"},
+		{"database_engine", "TEXT"},
+		{"database_server_version", "TEXT"},
+		{"server_context", "TEXT"},
+		{"log_level", "TEXT"},
+		{"stale", "INT"},
+		{"remote_ip", "VARCHAR(45)"},
+		{"remote_ip_family", "INT"},
+		{"adjusted_fields", "TEXT"},
+		{"backfilled", "INT"},
+		{"sample_rate", "BIGINT"},
+		{"compacted_reports", "BIGINT"},
+		{"compacted_ranges", "TEXT"},
+		{"schema_version", "VARCHAR(32)"},
+		{"user_agent_filter", "VARCHAR(64)"},
+		{"verified", "INT"},
+	}}, s)
+}
 
-	if *dbDriver == "mysql" {
-		autoincrement = "AUTO_INCREMENT"
-	} else if *dbDriver == "postgres" {
-		autoincrement = ""
-		primaryKeyType = "SERIAL"
-		doubleType = "DOUBLE PRECISION"
+// Save inserts the report into the stats table of s with the given id, or
+// if that is "" one the database assigns, returning the row's ID and the columns it has values
+// for. Every other column is explicitly NULL.
+func (sr *ReportStatsSynapse) Save(db *sql.DB, s *Storage, id string) (interface{}, []string, error) {
+	ins, cols, err := sr.prepareSave(db, s, id)
+	if err != nil {
+		return nil, nil, err
 	}
-	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS stats(
-		id ` + primaryKeyType + ` NOT NULL PRIMARY KEY ` + autoincrement + ` ,
-		homeserver VARCHAR(256),
-		local_timestamp BIGINT,
-		remote_timestamp BIGINT,
-		remote_addr TEXT,
-		forwarded_for TEXT,
-		uptime_seconds BIGINT,
-		total_users BIGINT,
-		total_nonbridged_users BIGINT,
-		total_room_count BIGINT,
-		daily_active_users BIGINT,
-		daily_active_rooms BIGINT,
-		daily_messages BIGINT,
-		daily_sent_messages BIGINT,
-		daily_active_e2ee_rooms BIGINT,
-		daily_e2ee_messages BIGINT,
-		daily_sent_e2ee_messages BIGINT,
-		monthly_active_users BIGINT,
-		r30_users_all BIGINT,
-		r30_users_android BIGINT,
-		r30_users_ios BIGINT,
-		r30_users_electron BIGINT,
-		r30_users_web BIGINT,
-		r30v2_users_all BIGINT,
-		r30v2_users_android BIGINT,
-		r30v2_users_ios BIGINT,
-		r30v2_users_electron BIGINT,
-		r30v2_users_web BIGINT,
-		cpu_average BIGINT,
-		memory_rss BIGINT,
-		cache_factor ` + doubleType + `,
-		event_cache_size BIGINT,
-		user_agent TEXT,
-		daily_user_type_native BIGINT,
-		daily_user_type_bridged BIGINT,
-		daily_user_type_guest BIGINT,
-		python_version TEXT,
-		database_engine TEXT,
-		database_server_version TEXT,
-		server_context TEXT,
-		log_level TEXT
-		)`)
+	rowID, err := ins.exec(nil)
+	return rowID, cols, err
+}
 
-	return err
+// prepareSave prepares the insert of the report into the stats table of s,
+// returning it and the columns the report has values for.
+func (sr *ReportStatsSynapse) prepareSave(db *sql.DB, s *Storage, id string) (*reportInsert, []string, error) {
+	cols, vals := sr.Columns()
+	allCols, allVals, err := withNulls(db, synapseTable(s), cols, vals)
+	if err != nil {
+		return nil, nil, err
+	}
+	ins, err := prepareReport(db, s, s.tableName("stats"), id, allCols, allVals)
+	return ins, cols, err
 }
 
-func (sr *ReportStatsSynapse) Save(db *sql.DB) error {
+// Columns returns the stats columns the report has values for.
+func (sr *ReportStatsSynapse) Columns() ([]string, []interface{}) {
 	cols := []string{"homeserver", "local_timestamp", "remote_addr"}
 	vals := []interface{}{sr.Homeserver, sr.LocalTimestamp, sr.RemoteAddr}
 
@@ -118,6 +139,8 @@ func (sr *ReportStatsSynapse) Save(db *sql.DB) error {
 	cols, vals = appendIfNonNil(cols, vals, "r30v2_users_electron", sr.R30V2UsersElectron)
 	cols, vals = appendIfNonNil(cols, vals, "r30v2_users_web", sr.R30V2UsersWeb)
 
+	cols, vals = appendIfNonEmpty(cols, vals, "remote_ip", sr.RemoteIP)
+	cols, vals = appendIfNonNil(cols, vals, "remote_ip_family", sr.RemoteIPFamily)
 	cols, vals = appendIfNonEmpty(cols, vals, "forwarded_for", sr.XForwardedFor)
 	cols, vals = appendIfNonEmpty(cols, vals, "user_agent", sr.UserAgent)
 
@@ -135,16 +158,13 @@ func (sr *ReportStatsSynapse) Save(db *sql.DB) error {
 	cols, vals = appendIfNonEmpty(cols, vals, "database_server_version", sr.DatabaseServerVersion)
 	cols, vals = appendIfNonEmpty(cols, vals, "server_context", sr.ServerContext)
 	cols, vals = appendIfNonEmpty(cols, vals, "log_level", sr.LogLevel)
-
-	var valuePlaceholders []string
-	for i := range vals {
-		if *dbDriver == "mysql" {
-			valuePlaceholders = append(valuePlaceholders, "?")
-		} else {
-			valuePlaceholders = append(valuePlaceholders, fmt.Sprintf("$%d", i+1))
-		}
-	}
-	qry := fmt.Sprintf("INSERT INTO stats (%s) VALUES (%s)", strings.Join(cols, ", "), strings.Join(valuePlaceholders, ", "))
-	_, err := db.Exec(qry, vals...)
-	return err
+	cols, vals = appendIfNonNilBool(cols, vals, "stale", sr.Stale)
+	cols, vals = appendIfNonEmpty(cols, vals, "adjusted_fields", sr.AdjustedFields)
+	cols, vals = appendIfNonNilBool(cols, vals, "backfilled", sr.Backfilled)
+	cols, vals = appendIfNonNil(cols, vals, "sample_rate", sr.SampleRate)
+	cols, vals = appendIfNonEmpty(cols, vals, "schema_version", sr.SchemaVersion)
+	cols, vals = appendIfNonEmpty(cols, vals, "user_agent_filter", sr.UserAgentFilter)
+	cols, vals = appendIfNonNilBool(cols, vals, "verified", sr.Verified)
+	vals = applyFloats(cols, vals, sr.Floats)
+	return cols, vals
 }
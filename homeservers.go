@@ -0,0 +1,174 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The homeservers table keeps one row per homeserver which has ever
+// reported, so that questions like "how many servers reported this week"
+// don't need to scan the stats tables.
+func createTableHomeservers(db *sql.DB, s *Storage) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS homeservers(
+		homeserver VARCHAR(256) NOT NULL PRIMARY KEY,
+		first_seen BIGINT NOT NULL,
+		last_seen BIGINT NOT NULL,
+		report_count BIGINT NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+	if err := createIndex(db, "homeservers_last_seen", "homeservers", "last_seen"); err != nil {
+		return err
+	}
+
+	// Populate the table from the existing history the first time round.
+	var n int
+	if err := db.QueryRow("SELECT COUNT(*) FROM homeservers").Scan(&n); err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf(`INSERT INTO homeservers (homeserver, first_seen, last_seen, report_count)
+		SELECT homeserver, MIN(local_timestamp), MAX(local_timestamp), COUNT(*) FROM (
+			SELECT homeserver, local_timestamp FROM %s
+			UNION ALL
+			SELECT homeserver, local_timestamp FROM %s
+		) AS s WHERE homeserver IS NOT NULL GROUP BY homeserver`, s.tableName("stats"), s.tableName("dendrite_stats")))
+	return err
+}
+
+// touchHomeserver records that a homeserver reported at ts, which may be
+// earlier than its last report if it was backfilled.
+func touchHomeserver(ex execer, d dialect, homeserver string, ts int64) error {
+	firstSeen, lastSeen := d.quote("homeservers.first_seen"), d.quote("homeservers.last_seen")
+	_, err := ex.Exec(
+		d.upsert("homeservers", []string{"homeserver"}, []string{"homeserver", "first_seen", "last_seen", "report_count"}, map[string]string{
+			"first_seen":   fmt.Sprintf("CASE WHEN %s > %s THEN %[2]s ELSE %[1]s END", firstSeen, d.excluded("first_seen")),
+			"last_seen":    fmt.Sprintf("CASE WHEN %s < %s THEN %[2]s ELSE %[1]s END", lastSeen, d.excluded("last_seen")),
+			"report_count": d.quote("homeservers.report_count") + " + 1",
+		}),
+		homeserver, ts, ts, 1,
+	)
+	return err
+}
+
+// activeWindows are the sliding windows reported by /api/v1/active-homeservers.
+var activeWindows = []struct {
+	name     string
+	duration time.Duration
+}{
+	{"24h", 24 * time.Hour},
+	{"7d", 7 * 24 * time.Hour},
+	{"30d", 30 * 24 * time.Hour},
+}
+
+// ActiveHomeserversHandler serves /api/v1/active-homeservers, the number of
+// distinct homeservers which reported within each window. Decommissioned
+// homeservers aren't counted. The tag and exclude_tag parameters filter by
+// homeserver tags, product and version by what sent their reports within
+// the window, and group_by=tag gives the counts for each tag.
+type ActiveHomeserversHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *ActiveHomeserversHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	q := req.URL.Query()
+	p := privacyOf(req)
+	product, ok := productFilterOf(w, req)
+	if !ok {
+		return
+	}
+	if q.Get("group_by") == "tag" {
+		h.serveByTag(w, req, p, product)
+		return
+	}
+	now := time.Now().UTC()
+	filtered := len(q["tag"]) > 0 || len(q["exclude_tag"]) > 0 || product != nil
+	counts := map[string]*int64{}
+	for _, window := range activeWindows {
+		since := now.Add(-window.duration).Unix()
+		args := []interface{}{since}
+		where := append([]string{
+			"last_seen >= " + d.placeholder(1),
+			"homeserver NOT IN (SELECT homeserver FROM tombstones)",
+		}, tagConditions(q, d, "homeserver", &args)...)
+		where = append(where, product.homeserverConditions(h.Storage, d, "homeserver", since, &args)...)
+		var n int64
+		err := h.DB.QueryRowContext(req.Context(), "SELECT COUNT(*) FROM homeservers WHERE "+strings.Join(where, " AND "), args...).Scan(&n)
+		if err != nil {
+			replyQueryError(w, req, err, "Error counting homeservers")
+			return
+		}
+		counts[window.name] = p.count(n, "active_homeservers", slidingWindow(window.name, now))
+		if !filtered {
+			metrics.Set("panopticon_active_homeservers", float64(n), "window", window.name)
+		}
+	}
+	writeJSON(w, counts)
+}
+
+// serveByTag counts active homeservers per tag. Homeservers with several
+// tags are counted under each of them.
+func (h *ActiveHomeserversHandler) serveByTag(w http.ResponseWriter, req *http.Request, p *Privacy, product *productFilter) {
+	d := dialectFor(h.DB)
+	q := req.URL.Query()
+	now := time.Now().UTC()
+	counts := map[string]map[string]*int64{}
+	for _, window := range activeWindows {
+		since := now.Add(-window.duration).Unix()
+		args := []interface{}{since}
+		where := append([]string{
+			"h.last_seen >= " + d.placeholder(1),
+			"h.homeserver NOT IN (SELECT homeserver FROM tombstones)",
+		}, tagConditions(q, d, "h.homeserver", &args)...)
+		where = append(where, product.homeserverConditions(h.Storage, d, "h.homeserver", since, &args)...)
+		rows, err := h.DB.QueryContext(req.Context(), `SELECT t.tag, COUNT(*) FROM homeservers h
+			JOIN homeserver_tags t ON t.homeserver = h.homeserver
+			WHERE `+strings.Join(where, " AND ")+" GROUP BY t.tag", args...)
+		if err != nil {
+			replyQueryError(w, req, err, "Error counting homeservers")
+			return
+		}
+		for rows.Next() {
+			var tag string
+			var n int64
+			if err := rows.Scan(&tag, &n); err != nil {
+				rows.Close()
+				logAndReplyError(w, err, 500, "Error counting homeservers")
+				return
+			}
+			if counts[tag] == nil {
+				counts[tag] = map[string]*int64{}
+				for _, w := range activeWindows {
+					counts[tag][w.name] = p.count(0, "active_homeservers tagged "+tag, slidingWindow(w.name, now))
+				}
+			}
+			counts[tag][window.name] = p.count(n, "active_homeservers tagged "+tag, slidingWindow(window.name, now))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			replyQueryError(w, req, err, "Error counting homeservers")
+			return
+		}
+	}
+	writeJSON(w, counts)
+}
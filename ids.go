@@ -0,0 +1,85 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"flag"
+	"time"
+)
+
+var idType = flag.String("id-type", "integer", "primary keys of the stats tables: integer (auto-increment), uuid (time-ordered UUIDv7) or ulid; fixed when the tables are created")
+
+// idGenerators make the primary keys of stats rows application-side, so
+// that rows written by several instances, or copied to write targets, keep
+// the same unique ids and can be merged. Both sort by creation time, so
+// reports still list newest first.
+var idGenerators = map[string]func(time.Time) string{
+	"uuid": newUUIDv7,
+	"ulid": newULID,
+}
+
+// generatedIDs reports whether stats rows get their ids from s.IDType
+// rather than from the database.
+func (s *Storage) generatedIDs() bool {
+	_, ok := idGenerators[s.IDType]
+	return ok
+}
+
+// newRowID returns the id of a new stats row, or "" if the database
+// assigns it.
+func (s *Storage) newRowID() string {
+	if gen, ok := idGenerators[s.IDType]; ok {
+		return gen(time.Now())
+	}
+	return ""
+}
+
+// timeOrderedBytes returns 16 bytes starting with the 48-bit Unix
+// millisecond timestamp of t, followed by random bits.
+func timeOrderedBytes(t time.Time) [16]byte {
+	var b [16]byte
+	rand.Read(b[6:])
+	var ms [8]byte
+	binary.BigEndian.PutUint64(ms[:], uint64(t.UnixMilli()))
+	copy(b[:6], ms[2:])
+	return b
+}
+
+// newUUIDv7 returns a version 7 UUID, as in RFC 9562.
+func newUUIDv7(t time.Time) string {
+	b := timeOrderedBytes(t)
+	b[6] = b[6]&0x0f | 0x70
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b[:])
+	return h[:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:]
+}
+
+const crockford = "0123456789ABCDEFGHJKMNPQRSTVWXYZ"
+
+// newULID returns a ULID: 128 bits in 26 characters of Crockford's base32.
+func newULID(t time.Time) string {
+	b := timeOrderedBytes(t)
+	hi, lo := binary.BigEndian.Uint64(b[:8]), binary.BigEndian.Uint64(b[8:])
+	var s [26]byte
+	for i := 25; i >= 0; i-- {
+		s[i] = crockford[lo&31]
+		lo = lo>>5 | hi<<59
+		hi >>= 5
+	}
+	return string(s[:])
+}
@@ -0,0 +1,119 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// influxClient posts line protocol to write targets using the "influx"
+// driver. A slow TSDB mustn't hold up pushes for long.
+var influxClient = &http.Client{Timeout: 10 * time.Second}
+
+// Columns which aren't metrics, so aren't written as fields.
+var influxSkipColumns = map[string]bool{
+	"local_timestamp":  true,
+	"remote_timestamp": true,
+	"remote_ip_family": true,
+}
+
+var (
+	influxTagEscaper  = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+	influxMeasEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+)
+
+// lineProtocol renders the numeric columns of a report as a single Influx
+// line protocol point, tagged with the homeserver and the table the report
+// belongs in. ok is false if the report has no numeric columns.
+func lineProtocol(measurement, table, homeserver string, ts int64, cols []string, vals []interface{}) (line string, ok bool) {
+	var fields []string
+	for i, c := range cols {
+		if influxSkipColumns[c] {
+			continue
+		}
+		var v string
+		switch val := vals[i].(type) {
+		case *int64:
+			if val == nil {
+				continue
+			}
+			v = strconv.FormatInt(*val, 10) + "i"
+		case int64:
+			v = strconv.FormatInt(val, 10) + "i"
+		case *float64:
+			if val == nil {
+				continue
+			}
+			v = strconv.FormatFloat(*val, 'g', -1, 64)
+		case float64:
+			v = strconv.FormatFloat(val, 'g', -1, 64)
+		case *bool:
+			if val == nil {
+				continue
+			}
+			v = strconv.FormatBool(*val)
+		default:
+			continue
+		}
+		fields = append(fields, influxTagEscaper.Replace(c)+"="+v)
+	}
+	if len(fields) == 0 {
+		return "", false
+	}
+	return fmt.Sprintf("%s,homeserver=%s,table=%s %s %d\n",
+		influxMeasEscaper.Replace(measurement),
+		influxTagEscaper.Replace(homeserver),
+		influxTagEscaper.Replace(table),
+		strings.Join(fields, ","),
+		time.Unix(ts, 0).UnixNano(),
+	), true
+}
+
+// writeInflux posts a report to an Influx line protocol endpoint, such as
+// InfluxDB's /api/v2/write or VictoriaMetrics' /write.
+func (t *writeTarget) writeInflux(table, homeserver string, ts int64, cols []string, vals []interface{}) error {
+	measurement := t.Measurement
+	if measurement == "" {
+		measurement = "panopticon"
+	}
+	line, ok := lineProtocol(measurement, table, homeserver, ts, cols, vals)
+	if !ok {
+		return nil
+	}
+	req, err := http.NewRequest(http.MethodPost, t.DSN, strings.NewReader(line))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if t.Token != "" {
+		req.Header.Set("Authorization", "Token "+t.Token)
+	}
+	resp, err := influxClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// Benchmarks of the ingest path, for sizing hardware. Behaviour is tested
+// end to end by the scripts in tests/. Run with:
+//
+//	go test -run '^$' -bench . -benchmem
+
+import (
+	"bytes"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func benchRecorder(b *testing.B) *Recorder {
+	b.Helper()
+	log.SetOutput(io.Discard)
+	b.Cleanup(func() { log.SetOutput(os.Stderr) })
+	db, err := openDB("sqlite3", filepath.Join(b.TempDir(), "stats.db"))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { db.Close() })
+	storage := &Storage{Driver: "sqlite3", StatsTable: "stats", DendriteStatsTable: "dendrite_stats"}
+	if err := createTables(db, storage); err != nil {
+		b.Fatal(err)
+	}
+	return &Recorder{DB: db, Storage: storage, Config: &Config{}}
+}
+
+func benchmarkPush(b *testing.B, dendrite bool, homeservers int) {
+	r := benchRecorder(b)
+	rnd := rand.New(rand.NewSource(1))
+	reports := make([]loadtestReport, 1000)
+	for i := range reports {
+		reports[i] = loadtestReport{syntheticReport(rnd, rnd.Intn(homeservers), dendrite), dendrite}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		report := reports[i%len(reports)]
+		req := httptest.NewRequest(http.MethodPost, "/push", bytes.NewReader(report.body))
+		if dendrite {
+			req.Header.Set("User-Agent", "Dendrite/0.13.0")
+		}
+		w := httptest.NewRecorder()
+		r.Handle(w, req)
+		if w.Code != http.StatusOK {
+			b.Fatalf("push failed with %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkPushSynapse(b *testing.B)  { benchmarkPush(b, false, 1000) }
+func BenchmarkPushDendrite(b *testing.B) { benchmarkPush(b, true, 1000) }
+
+// BenchmarkPushOneHomeserver exercises the per-homeserver bookkeeping
+// (staleness checks, downsampling, last seen) on a single hot row.
+func BenchmarkPushOneHomeserver(b *testing.B) { benchmarkPush(b, false, 1) }
+
+func BenchmarkSanitizeNumbers(b *testing.B) {
+	body := syntheticReport(rand.New(rand.NewSource(1)), 0, false)
+	b.SetBytes(int64(len(body)))
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := sanitizeNumbers(body, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,203 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	ingestBucket    = time.Hour
+	ingestRetention = 30 * 24 * time.Hour
+	ingestTopN      = 10
+
+	// ingestMaxHomeservers caps the homeservers counted separately in each
+	// bucket, since anyone can push under any name. Pushes from any more
+	// are counted under ingestOtherHomeservers, which no server name can be.
+	ingestMaxHomeservers   = 1000
+	ingestOtherHomeservers = "(other)"
+)
+
+// ingestStats keeps hourly counts of pushes received by this process over
+// the last 30 days, for /api/v1/ingest-stats.
+var ingestStats = &ingestCounter{buckets: map[int64]*ingestBucketCounts{}}
+
+type ingestCounter struct {
+	mu      sync.Mutex
+	buckets map[int64]*ingestBucketCounts // Keyed by start of the hour, in seconds
+}
+
+type ingestBucketCounts struct {
+	accepted    int64
+	rejected    int64
+	bytes       int64
+	homeservers map[string]int64
+	reasons     map[string]int64
+}
+
+// rejectionReason names the reason for a push refused with status.
+func rejectionReason(status int) string {
+	switch status {
+	case http.StatusBadRequest:
+		return "bad_request"
+	case http.StatusConflict:
+		return "stale"
+	case http.StatusGone:
+		return "tombstoned"
+	case http.StatusTooManyRequests:
+		return "rate_limited"
+	case http.StatusServiceUnavailable:
+		return "maintenance"
+	case http.StatusInternalServerError:
+		return "storage_error"
+	}
+	return "status_" + strconv.Itoa(status)
+}
+
+// Record counts a push answered with status.
+func (c *ingestCounter) Record(t time.Time, homeserver string, size, status int) {
+	key := t.Truncate(ingestBucket).Unix()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	b, ok := c.buckets[key]
+	if !ok {
+		b = &ingestBucketCounts{homeservers: map[string]int64{}, reasons: map[string]int64{}}
+		c.buckets[key] = b
+		// Expire old buckets whenever a new one is started.
+		for k := range c.buckets {
+			if k < t.Add(-ingestRetention).Unix() {
+				delete(c.buckets, k)
+			}
+		}
+	}
+	b.bytes += int64(size)
+	if status >= 200 && status < 300 {
+		b.accepted++
+		if homeserver != "" {
+			if _, ok := b.homeservers[homeserver]; !ok && len(b.homeservers) >= ingestMaxHomeservers {
+				homeserver = ingestOtherHomeservers
+			}
+			b.homeservers[homeserver]++
+		}
+	} else {
+		b.rejected++
+		b.reasons[rejectionReason(status)]++
+	}
+	metrics.Add("panopticon_ingest_bytes_total", float64(size))
+	if status >= 200 && status < 300 {
+		metrics.Inc("panopticon_pushes_total", "result", "accepted")
+	} else {
+		metrics.Inc("panopticon_pushes_total", "result", "rejected", "reason", rejectionReason(status))
+	}
+}
+
+type countEntry struct {
+	Name  string `json:"name"`
+	Count int64  `json:"count"`
+}
+
+// IngestSummary is the response of /api/v1/ingest-stats.
+type IngestSummary struct {
+	Window           string       `json:"window"`
+	Accepted         int64        `json:"accepted"`
+	Rejected         int64        `json:"rejected"`
+	Bytes            int64        `json:"bytes"`
+	TopHomeservers   []countEntry `json:"top_homeservers"`
+	RejectionReasons []countEntry `json:"rejection_reasons"`
+}
+
+// Summarise totals the buckets which overlap the window ending at t.
+func (c *ingestCounter) Summarise(t time.Time, window time.Duration) *IngestSummary {
+	since := t.Add(-window).Truncate(ingestBucket).Unix()
+	s := &IngestSummary{Window: window.String()}
+	homeservers := map[string]int64{}
+	reasons := map[string]int64{}
+	c.mu.Lock()
+	for k, b := range c.buckets {
+		if k < since {
+			continue
+		}
+		s.Accepted += b.accepted
+		s.Rejected += b.rejected
+		s.Bytes += b.bytes
+		for hs, n := range b.homeservers {
+			homeservers[hs] += n
+		}
+		for r, n := range b.reasons {
+			reasons[r] += n
+		}
+	}
+	c.mu.Unlock()
+	s.TopHomeservers = topCounts(homeservers, ingestTopN)
+	s.RejectionReasons = topCounts(reasons, ingestTopN)
+	return s
+}
+
+func topCounts(counts map[string]int64, n int) []countEntry {
+	entries := []countEntry{}
+	for name, count := range counts {
+		entries = append(entries, countEntry{name, count})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Count != entries[j].Count {
+			return entries[i].Count > entries[j].Count
+		}
+		return entries[i].Name < entries[j].Name
+	})
+	if len(entries) > n {
+		entries = entries[:n]
+	}
+	return entries
+}
+
+// serveIngestStats serves /api/v1/ingest-stats?window=24h. The window
+// defaults to 24 hours and can be at most 30 days.
+func serveIngestStats(w http.ResponseWriter, req *http.Request) {
+	if aggregatesOnly(w, req) {
+		return
+	}
+	window := 24 * time.Hour
+	if v := req.URL.Query().Get("window"); v != "" {
+		var err error
+		if window, err = parseWindow(v); err != nil || window <= 0 || window > ingestRetention {
+			logAndReplyError(w, fmt.Errorf("bad window %q", v), 400, "Bad query")
+			return
+		}
+	}
+	writeJSON(w, ingestStats.Summarise(time.Now(), window))
+}
+
+// parseWindow parses a duration which may also be given in days, e.g. "7d".
+// Day counts too large for a Duration are an error, rather than wrapping
+// around to one which callers would accept.
+func parseWindow(v string) (time.Duration, error) {
+	if n := len(v); n > 1 && v[n-1] == 'd' {
+		days, err := strconv.ParseInt(v[:n-1], 10, 64)
+		if err != nil {
+			return 0, err
+		}
+		if maxDays := int64(math.MaxInt64 / (24 * time.Hour)); days > maxDays || days < -maxDays {
+			return 0, fmt.Errorf("%d days is out of range", days)
+		}
+		return time.Duration(days) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(v)
+}
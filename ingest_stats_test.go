@@ -0,0 +1,49 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestIngestCounterCapsHomeservers(t *testing.T) {
+	c := &ingestCounter{buckets: map[int64]*ingestBucketCounts{}}
+	now := time.Date(2026, 5, 1, 12, 30, 0, 0, time.UTC)
+	for i := 0; i < ingestMaxHomeservers+50; i++ {
+		c.Record(now, fmt.Sprintf("hs%d.turtles", i), 10, 200)
+	}
+	// Homeservers already counted this hour keep their own entry.
+	for i := 0; i < 3; i++ {
+		c.Record(now, "hs0.turtles", 10, 200)
+	}
+	b := c.buckets[now.Truncate(ingestBucket).Unix()]
+	if len(b.homeservers) != ingestMaxHomeservers+1 {
+		t.Errorf("%d homeservers counted separately, want %d and %s", len(b.homeservers), ingestMaxHomeservers, ingestOtherHomeservers)
+	}
+
+	s := c.Summarise(now, time.Hour)
+	if s.Accepted != ingestMaxHomeservers+53 {
+		t.Errorf("accepted %d", s.Accepted)
+	}
+	assertJSON(t, "top homeservers", s.TopHomeservers[:2], `[{"name":"(other)","count":50},{"name":"hs0.turtles","count":4}]`)
+
+	// The next hour starts counting separately again.
+	c.Record(now.Add(time.Hour), "new.turtles", 10, 200)
+	if n := c.buckets[now.Add(time.Hour).Truncate(ingestBucket).Unix()].homeservers["new.turtles"]; n != 1 {
+		t.Errorf("new.turtles counted %d times in the next hour", n)
+	}
+}
@@ -0,0 +1,177 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+var (
+	trustedProxies  = flag.String("trusted-proxies", "", "comma separated CIDRs of reverse proxies whose client IP headers are believed")
+	clientIPHeaders = flag.String("client-ip-headers", "Forwarded,X-Forwarded-For,X-Real-IP", "headers from trusted proxies to take the client IP from, in order of precedence")
+	nat64Prefixes   = flag.String("nat64-prefixes", "64:ff9b::/96", "comma separated /96 prefixes of NAT64 gateways, whose clients are recorded by the IPv4 address embedded in their IPv6 one; empty for none")
+)
+
+// trustedProxyNets is parsed from -trusted-proxies by parseTrustedProxies.
+var trustedProxyNets []*net.IPNet
+
+// nat64Nets is parsed from -nat64-prefixes by parseTrustedProxies.
+var nat64Nets []*net.IPNet
+
+func parseTrustedProxies() error {
+	for _, c := range strings.Split(*trustedProxies, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		if !strings.Contains(c, "/") {
+			if strings.Contains(c, ":") {
+				c += "/128"
+			} else {
+				c += "/32"
+			}
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("invalid -trusted-proxies: %v", err)
+		}
+		trustedProxyNets = append(trustedProxyNets, n)
+	}
+	for _, c := range strings.Split(*nat64Prefixes, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("invalid -nat64-prefixes: %v", err)
+		}
+		// Shorter prefixes embed the IPv4 address around the u octet, which
+		// no common gateway uses.
+		if ones, bits := n.Mask.Size(); ones != 96 || bits != 128 {
+			return fmt.Errorf("invalid -nat64-prefixes: %s isn't an IPv6 /96", c)
+		}
+		nat64Nets = append(nat64Nets, n)
+	}
+	for _, h := range strings.Split(*clientIPHeaders, ",") {
+		switch http.CanonicalHeaderKey(strings.TrimSpace(h)) {
+		case "Forwarded", "X-Forwarded-For", "X-Real-Ip":
+		default:
+			return fmt.Errorf("invalid -client-ip-headers: unsupported header %q", h)
+		}
+	}
+	return nil
+}
+
+func isTrustedProxy(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, n := range trustedProxyNets {
+		if n.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the canonical IP of the client which made the request.
+// If the request came through a trusted proxy, it is taken from the first
+// of -client-ip-headers which is present; otherwise it is the peer address.
+func clientIP(req *http.Request) (string, int64) {
+	peer, family := canonicalIP(req.RemoteAddr)
+	if !isTrustedProxy(peer) {
+		return peer, family
+	}
+	for _, h := range strings.Split(*clientIPHeaders, ",") {
+		var chain []string
+		switch http.CanonicalHeaderKey(strings.TrimSpace(h)) {
+		case "Forwarded":
+			chain = forwardedFor(req.Header.Values("Forwarded"))
+		case "X-Forwarded-For":
+			for _, v := range req.Header.Values("X-Forwarded-For") {
+				chain = append(chain, strings.Split(v, ",")...)
+			}
+		case "X-Real-Ip":
+			chain = req.Header.Values("X-Real-IP")
+		}
+		// Each proxy appends the address it received the request from, so
+		// the client is the rightmost address which isn't a trusted proxy.
+		for i := len(chain) - 1; i >= 0; i-- {
+			ip, family := canonicalIP(chain[i])
+			if ip == "" {
+				break
+			}
+			if !isTrustedProxy(ip) || i == 0 {
+				return ip, family
+			}
+		}
+	}
+	return peer, family
+}
+
+// forwardedFor extracts the "for" parameters from RFC 7239 Forwarded header
+// values, in order.
+func forwardedFor(values []string) []string {
+	var addrs []string
+	for _, v := range values {
+		for _, element := range strings.Split(v, ",") {
+			for _, pair := range strings.Split(element, ";") {
+				kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+				if len(kv) == 2 && strings.EqualFold(kv[0], "for") {
+					addrs = append(addrs, strings.Trim(kv[1], `"`))
+				}
+			}
+		}
+	}
+	return addrs
+}
+
+// canonicalIP extracts the IP address from a "host:port" remote address (or
+// a bare address) and returns it in canonical form along with its address
+// family, 4 or 6. IPv4-mapped IPv6 addresses, and those in one of
+// -nat64-prefixes, are reported as IPv4. It returns an empty string and 0
+// if no IP address can be found.
+func canonicalIP(addr string) (string, int64) {
+	addr = strings.TrimSpace(addr)
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	addr = strings.TrimSuffix(strings.TrimPrefix(addr, "["), "]")
+	// Drop any IPv6 zone, which ParseIP doesn't accept.
+	if i := strings.IndexByte(addr, '%'); i >= 0 {
+		addr = addr[:i]
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return "", 0
+	}
+	if v4 := ip.To4(); v4 != nil {
+		return v4.String(), 4
+	}
+	// IPv4 clients reaching an IPv6-only host through NAT64 are recorded
+	// as themselves, as they would be over IPv4.
+	for _, n := range nat64Nets {
+		if n.Contains(ip) {
+			return net.IP(ip[12:16]).String(), 4
+		}
+	}
+	return ip.String(), 6
+}
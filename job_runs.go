@@ -0,0 +1,209 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+var jobRunRetention = flag.Duration("job-run-retention", 90*24*time.Hour, "how long to keep the history of job runs in the job_runs table")
+
+// JobRun describes a run of a job, whether scheduled or triggered through
+// the admin API. Runs are recorded in the job_runs table once they start.
+type JobRun struct {
+	ID           int64  `json:"id"`
+	Job          string `json:"job"`
+	Trigger      string `json:"trigger"` // "schedule" or "manual"
+	Instance     string `json:"instance"`
+	State        string `json:"state"` // "running", "succeeded", "failed" or "skipped"
+	StartedAt    int64  `json:"started_at"`
+	FinishedAt   *int64 `json:"finished_at"`
+	RowsAffected int64  `json:"rows_affected"`
+	Error        string `json:"error,omitempty"`
+
+	rows int64 // Counted by the job as it runs
+}
+
+// snapshot copies a run, including the rows it has affected so far.
+func (r *JobRun) snapshot() *JobRun {
+	copied := *r
+	copied.RowsAffected = atomic.LoadInt64(&r.rows)
+	return &copied
+}
+
+type rowsAffectedKey struct{}
+
+func withRowsAffected(ctx context.Context, counter *int64) context.Context {
+	return context.WithValue(ctx, rowsAffectedKey{}, counter)
+}
+
+// addRowsAffected lets a job report how many rows it has inserted, updated
+// or deleted, for the job_runs history.
+func addRowsAffected(ctx context.Context, n int64) {
+	if counter, ok := ctx.Value(rowsAffectedKey{}).(*int64); ok {
+		atomic.AddInt64(counter, n)
+	}
+}
+
+func jobRunsTable() *tableDef {
+	return &tableDef{Name: "job_runs", Columns: []columnDef{
+		{"job", "VARCHAR(64) NOT NULL"},
+		{"triggered_by", "VARCHAR(16) NOT NULL"},
+		{"instance", "VARCHAR(256) NOT NULL"},
+		{"state", "VARCHAR(16) NOT NULL"},
+		{"started_at", "BIGINT NOT NULL"},
+		{"finished_at", "BIGINT"},
+		{"rows_affected", "BIGINT"},
+		{"error", "TEXT"},
+	}}
+}
+
+func createTableJobRuns(db *sql.DB) error {
+	if err := createTable(db, jobRunsTable()); err != nil {
+		return err
+	}
+	return createIndex(db, "job_runs_job_started_at", "job_runs", "job, started_at")
+}
+
+// begin marks a job as running here, unless it already is.
+func (s *Scheduler) begin(j *scheduledJob, trigger string) *JobRun {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.running[j.name] != nil {
+		return nil
+	}
+	run := &JobRun{Job: j.name, Trigger: trigger, Instance: s.holder, State: "running", StartedAt: time.Now().Unix()}
+	s.running[j.name] = run
+	return run
+}
+
+// record inserts a run into job_runs, giving it its ID. A run which can't
+// be recorded still goes ahead.
+func (s *Scheduler) record(run *JobRun) {
+	id, err := insertRow(s.db, "job_runs",
+		[]string{"job", "triggered_by", "instance", "state", "started_at"},
+		[]interface{}{run.Job, run.Trigger, run.Instance, run.State, run.StartedAt},
+	)
+	if err != nil {
+		log.Printf("Job %s: error recording run: %v", run.Job, err)
+		return
+	}
+	s.mu.Lock()
+	run.ID = id
+	s.mu.Unlock()
+}
+
+// finish records the outcome of a run, and forgets the job's runs which
+// are older than -job-run-retention.
+func (s *Scheduler) finish(run *JobRun, state string, err error) {
+	s.mu.Lock()
+	finished := time.Now().Unix()
+	run.State = state
+	run.FinishedAt = &finished
+	run.RowsAffected = atomic.LoadInt64(&run.rows)
+	if err != nil {
+		run.Error = err.Error()
+	}
+	delete(s.running, run.Job)
+	s.mu.Unlock()
+	if run.ID == 0 {
+		// Not recorded, such as a scheduled run which another instance
+		// took care of.
+		return
+	}
+	d := dialectFor(s.db)
+	_, err = s.db.Exec(
+		fmt.Sprintf("UPDATE job_runs SET state = %s, finished_at = %s, rows_affected = %s, error = %s WHERE id = %s",
+			d.placeholder(1), d.placeholder(2), d.placeholder(3), d.placeholder(4), d.placeholder(5)),
+		run.State, finished, run.RowsAffected, run.Error, run.ID,
+	)
+	if err != nil {
+		log.Printf("Job %s: error recording run: %v", run.Job, err)
+	}
+	cutoff := time.Now().Add(-*jobRunRetention).Unix()
+	_, err = s.db.Exec(
+		fmt.Sprintf("DELETE FROM job_runs WHERE job = %s AND started_at < %s", d.placeholder(1), d.placeholder(2)),
+		run.Job, cutoff,
+	)
+	if err != nil {
+		log.Printf("Job %s: error pruning runs: %v", run.Job, err)
+	}
+}
+
+// Run returns the run with the given ID, or nil if there isn't one. Runs
+// still going here report the rows they've affected so far.
+func (s *Scheduler) Run(id int64) (*JobRun, error) {
+	s.mu.Lock()
+	for _, r := range s.running {
+		if r.ID == id {
+			s.mu.Unlock()
+			return r.snapshot(), nil
+		}
+	}
+	s.mu.Unlock()
+	runs, err := queryJobRuns(s.db, "id = "+dialectFor(s.db).placeholder(1), []interface{}{id}, 1)
+	if err != nil || len(runs) == 0 {
+		return nil, err
+	}
+	return runs[0], nil
+}
+
+// loadJobRuns returns the latest runs, newest first, of a job or of every
+// job if job is "".
+func loadJobRuns(db *sql.DB, job string, limit int) ([]*JobRun, error) {
+	if job == "" {
+		return queryJobRuns(db, "", nil, limit)
+	}
+	return queryJobRuns(db, "job = "+dialectFor(db).placeholder(1), []interface{}{job}, limit)
+}
+
+func queryJobRuns(db *sql.DB, where string, args []interface{}, limit int) ([]*JobRun, error) {
+	qry := "SELECT id, job, triggered_by, instance, state, started_at, finished_at, rows_affected, error FROM job_runs"
+	if where != "" {
+		qry += " WHERE " + where
+	}
+	qry += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", limit)
+	rows, err := db.Query(qry, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	runs := []*JobRun{}
+	for rows.Next() {
+		var (
+			r            JobRun
+			finished     sql.NullInt64
+			rowsAffected sql.NullInt64
+			errMsg       sql.NullString
+		)
+		if err := rows.Scan(&r.ID, &r.Job, &r.Trigger, &r.Instance, &r.State, &r.StartedAt, &finished, &rowsAffected, &errMsg); err != nil {
+			return nil, err
+		}
+		if finished.Valid {
+			r.FinishedAt = &finished.Int64
+		}
+		r.RowsAffected = rowsAffected.Int64
+		r.Error = strings.TrimSpace(errMsg.String)
+		runs = append(runs, &r)
+	}
+	return runs, rows.Err()
+}
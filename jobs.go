@@ -0,0 +1,376 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lockLease is how long a running job holds its lock before another
+// instance may assume it has died and take over.
+const lockLease = 30 * time.Minute
+
+// Scheduler runs background jobs on cron-like schedules. Every job run is
+// guarded by a lease in the job_locks table, so that when several instances
+// share a database only one of them runs each job per scheduled slot.
+type Scheduler struct {
+	db     *sql.DB
+	holder string
+	config map[string]JobConfig
+	jobs   []*scheduledJob
+	ctx    context.Context
+
+	mu      sync.Mutex
+	running map[string]*JobRun
+}
+
+type scheduledJob struct {
+	name     string
+	spec     string
+	schedule schedule
+	run      func(ctx context.Context) error
+}
+
+func NewScheduler(db *sql.DB, config map[string]JobConfig) *Scheduler {
+	host, _ := os.Hostname()
+	return &Scheduler{
+		db:      db,
+		holder:  fmt.Sprintf("%s/%d/%08x", host, os.Getpid(), rand.Uint32()),
+		config:  config,
+		ctx:     context.Background(),
+		running: map[string]*JobRun{},
+	}
+}
+
+// Register adds a job to the scheduler. defaultSchedule is used unless the
+// config overrides it; jobs disabled in the config are not registered.
+func (s *Scheduler) Register(name, defaultSchedule string, run func(ctx context.Context) error) error {
+	spec := defaultSchedule
+	if c, ok := s.config[name]; ok {
+		if c.Enabled != nil && !*c.Enabled {
+			log.Printf("Job %s is disabled", name)
+			return nil
+		}
+		if c.Schedule != "" {
+			spec = c.Schedule
+		}
+	}
+	sched, err := parseSchedule(spec)
+	if err != nil {
+		return fmt.Errorf("job %s: %v", name, err)
+	}
+	s.jobs = append(s.jobs, &scheduledJob{name: name, spec: spec, schedule: sched, run: run})
+	return nil
+}
+
+// Start runs each registered job in its own goroutine until ctx is done.
+func (s *Scheduler) Start(ctx context.Context) {
+	s.ctx = ctx
+	for _, j := range s.jobs {
+		go s.loop(ctx, j)
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, j *scheduledJob) {
+	for {
+		next := j.schedule.Next(time.Now())
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		if run := s.begin(j, "schedule"); run != nil {
+			s.runOnce(ctx, j, run, j.schedule.Next(next))
+		}
+	}
+}
+
+// Trigger starts a run of the named job straight away, outside its
+// schedule. It fails if the job isn't registered or is already running
+// here, in which case the running run is returned.
+func (s *Scheduler) Trigger(name string) (*JobRun, error) {
+	j := s.job(name)
+	if j == nil {
+		return nil, fmt.Errorf("no job %s", name)
+	}
+	run := s.begin(j, "manual")
+	if run == nil {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return s.running[name].snapshot(), fmt.Errorf("job %s is already running", name)
+	}
+	// Record it now, so that the caller has an ID to follow.
+	s.record(run)
+	snapshot := run.snapshot()
+	go s.runOnce(s.ctx, j, run, j.schedule.Next(time.Now()))
+	return snapshot, nil
+}
+
+// JobStatus describes a registered job.
+type JobStatus struct {
+	Name     string  `json:"name"`
+	Schedule string  `json:"schedule"`
+	NextRun  int64   `json:"next_run"`
+	Running  *JobRun `json:"running"`
+	LastRun  *JobRun `json:"last_run"`
+}
+
+// Jobs describes the registered jobs, in the order they were registered.
+func (s *Scheduler) Jobs() ([]JobStatus, error) {
+	s.mu.Lock()
+	statuses := []JobStatus{}
+	for _, j := range s.jobs {
+		st := JobStatus{Name: j.name, Schedule: j.spec, NextRun: j.schedule.Next(time.Now()).Unix()}
+		if r := s.running[j.name]; r != nil {
+			st.Running = r.snapshot()
+		}
+		statuses = append(statuses, st)
+	}
+	s.mu.Unlock()
+	for i := range statuses {
+		runs, err := loadJobRuns(s.db, statuses[i].Name, 1)
+		if err != nil {
+			return nil, err
+		}
+		if len(runs) > 0 {
+			statuses[i].LastRun = runs[0]
+		}
+	}
+	return statuses, nil
+}
+
+func (s *Scheduler) job(name string) *scheduledJob {
+	for _, j := range s.jobs {
+		if j.name == name {
+			return j
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) runOnce(ctx context.Context, j *scheduledJob, run *JobRun, following time.Time) {
+	acquired, err := s.acquireLock(j.name, time.Now().Add(lockLease))
+	if err != nil {
+		log.Printf("Job %s: error acquiring lock: %v", j.name, err)
+		s.finish(run, "failed", fmt.Errorf("acquiring lock: %v", err))
+		return
+	}
+	if !acquired {
+		metrics.Inc("panopticon_job_skipped_total", "job", j.name)
+		s.finish(run, "skipped", errors.New("running on another instance"))
+		return
+	}
+
+	if run.ID == 0 {
+		s.record(run)
+	}
+	start := time.Now()
+	err = j.run(withRowsAffected(ctx, &run.rows))
+	metrics.Set("panopticon_job_last_duration_seconds", time.Since(start).Seconds(), "job", j.name)
+	metrics.Inc("panopticon_job_runs_total", "job", j.name)
+	if err != nil {
+		log.Printf("Job %s failed: %v", j.name, err)
+		metrics.Inc("panopticon_job_failures_total", "job", j.name)
+		s.finish(run, "failed", err)
+	} else {
+		metrics.Set("panopticon_job_last_success_timestamp_seconds", float64(time.Now().Unix()), "job", j.name)
+		s.finish(run, "succeeded", nil)
+	}
+
+	// Keep holding the lock until just before the next slot rather than
+	// releasing it, so an instance whose clock lags ours doesn't re-run the
+	// job for the slot we've just handled.
+	if _, err := s.acquireLock(j.name, following.Add(-time.Second)); err != nil {
+		log.Printf("Job %s: error extending lock: %v", j.name, err)
+	}
+}
+
+func createTableJobLocks(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS job_locks(
+		job_name VARCHAR(64) NOT NULL PRIMARY KEY,
+		holder VARCHAR(256) NOT NULL,
+		expires_at BIGINT NOT NULL
+		)`)
+	return err
+}
+
+// acquireLock takes (or extends) the lease on a job until the given time.
+// It reports false if another instance holds an unexpired lease.
+func (s *Scheduler) acquireLock(name string, until time.Time) (bool, error) {
+	d := dialectFor(s.db)
+	now := time.Now().Unix()
+	res, err := s.db.Exec(
+		fmt.Sprintf("UPDATE job_locks SET holder = %s, expires_at = %s WHERE job_name = %s AND (expires_at < %s OR holder = %s)",
+			d.placeholder(1), d.placeholder(2), d.placeholder(3), d.placeholder(4), d.placeholder(5)),
+		s.holder, until.Unix(), name, now, s.holder,
+	)
+	if err != nil {
+		return false, err
+	}
+	if n, err := res.RowsAffected(); err == nil && n > 0 {
+		return true, nil
+	}
+	// Either the lock is held elsewhere or the row doesn't exist yet. If the
+	// insert fails because the row now exists, somebody else won the race to
+	// create it; any other failure is an error.
+	_, err = s.db.Exec(
+		d.insert("job_locks", "job_name", "holder", "expires_at"),
+		name, s.holder, until.Unix(),
+	)
+	if err == nil {
+		return true, nil
+	}
+	var holder string
+	if qerr := s.db.QueryRow("SELECT holder FROM job_locks WHERE job_name = "+d.placeholder(1), name).Scan(&holder); qerr != nil {
+		return false, err
+	}
+	return false, nil
+}
+
+// schedule computes the next time a job should run.
+type schedule interface {
+	Next(after time.Time) time.Time
+}
+
+type everySchedule time.Duration
+
+func (e everySchedule) Next(after time.Time) time.Time {
+	return after.Truncate(time.Duration(e)).Add(time.Duration(e))
+}
+
+// cronSchedule is a standard five field cron expression, evaluated in UTC.
+// As in cron, if both the day of month and day of week are restricted, a
+// day matching either of them matches.
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	anyDom, anyDow                bool
+}
+
+func (c *cronSchedule) matchesDay(t time.Time) bool {
+	dom := c.dom&(1<<uint(t.Day())) != 0
+	dow := c.dow&(1<<uint(t.Weekday())) != 0
+	if c.anyDom || c.anyDow {
+		return dom && dow
+	}
+	return dom || dow
+}
+
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.UTC().Truncate(time.Minute).Add(time.Minute)
+	// Every valid expression matches at least once in a leap cycle.
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); t = t.Add(time.Minute) {
+		if c.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+			continue
+		}
+		if !c.matchesDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, time.UTC).Add(-time.Minute)
+			continue
+		}
+		if c.hour&(1<<uint(t.Hour())) == 0 {
+			t = t.Truncate(time.Hour).Add(time.Hour - time.Minute)
+			continue
+		}
+		if c.minute&(1<<uint(t.Minute())) != 0 {
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func parseSchedule(spec string) (schedule, error) {
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimPrefix(spec, "@every "))
+		if err != nil {
+			return nil, err
+		}
+		if d < time.Second {
+			return nil, fmt.Errorf("interval %s is too short", d)
+		}
+		return everySchedule(d), nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid schedule %q: expected five fields", spec)
+	}
+	var c cronSchedule
+	var err error
+	bounds := [][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 6}}
+	targets := []*uint64{&c.minute, &c.hour, &c.dom, &c.month, &c.dow}
+	for i, f := range fields {
+		if *targets[i], err = parseCronField(f, bounds[i][0], bounds[i][1]); err != nil {
+			return nil, fmt.Errorf("invalid schedule %q: %v", spec, err)
+		}
+	}
+	c.anyDom = strings.HasPrefix(fields[2], "*")
+	c.anyDow = strings.HasPrefix(fields[4], "*")
+	return &c, nil
+}
+
+// parseCronField parses a comma separated list of "*", "n", "a-b" or any of
+// those followed by "/step" into a bitmask.
+func parseCronField(field string, min, max int) (uint64, error) {
+	var mask uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step in %q", part)
+			}
+			part = part[:i]
+		}
+		lo, hi := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if lo, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("bad value %q", part)
+			}
+			hi = lo
+			if len(bounds) == 2 {
+				if hi, err = strconv.Atoi(bounds[1]); err != nil {
+					return 0, fmt.Errorf("bad range %q", part)
+				}
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", part, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			mask |= 1 << uint(v)
+		}
+	}
+	return mask, nil
+}
@@ -0,0 +1,103 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// JobsHandler serves /admin/jobs, which lists the registered jobs,
+// /admin/jobs/<name>, to which a POST starts a run of the job straight away,
+// /admin/jobs/runs, the history of runs, optionally filtered by job and
+// limited by limit, and /admin/jobs/runs/<id>, which reports how a run is
+// getting on.
+type JobsHandler struct {
+	Scheduler *Scheduler
+}
+
+func (h *JobsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin/jobs"), "/")
+	switch {
+	case path == "":
+		if req.Method != http.MethodGet {
+			methodNotAllowed(w, req, http.MethodGet)
+			return
+		}
+		jobs, err := h.Scheduler.Jobs()
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error listing jobs")
+			return
+		}
+		writeJSON(w, jobs)
+	case path == "runs":
+		if req.Method != http.MethodGet {
+			methodNotAllowed(w, req, http.MethodGet)
+			return
+		}
+		q := req.URL.Query()
+		limit, err := intParam(q.Get("limit"), defaultRowLimit)
+		if err != nil || limit <= 0 || limit > *maxRowLimit {
+			logAndReplyError(w, fmt.Errorf("bad limit %q", q.Get("limit")), 400, "Bad query")
+			return
+		}
+		runs, err := loadJobRuns(h.Scheduler.db, q.Get("job"), limit)
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error loading job runs")
+			return
+		}
+		writeJSON(w, runs)
+	case strings.HasPrefix(path, "runs/"):
+		if req.Method != http.MethodGet {
+			methodNotAllowed(w, req, http.MethodGet)
+			return
+		}
+		id, err := strconv.ParseInt(strings.TrimPrefix(path, "runs/"), 10, 64)
+		if err != nil {
+			logAndReplyError(w, err, 400, "Bad run ID")
+			return
+		}
+		run, err := h.Scheduler.Run(id)
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error loading job run")
+			return
+		}
+		if run == nil {
+			notFound(w, req)
+			return
+		}
+		writeJSON(w, run)
+	default:
+		if req.Method != http.MethodPost {
+			methodNotAllowed(w, req, http.MethodPost)
+			return
+		}
+		if h.Scheduler.job(path) == nil {
+			notFound(w, req)
+			return
+		}
+		run, err := h.Scheduler.Trigger(path)
+		if err != nil {
+			logAndReplyError(w, fmt.Errorf("%v (run %d)", err, run.ID), http.StatusConflict, "Not starting job")
+			return
+		}
+		w.Header().Set("Location", fmt.Sprintf("/admin/jobs/runs/%d", run.ID))
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, run)
+	}
+}
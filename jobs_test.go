@@ -0,0 +1,99 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCronScheduleNext(t *testing.T) {
+	// 2026-01-01 is a Thursday.
+	after := time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		spec string
+		want time.Time
+	}{
+		{"*/15 * * * *", time.Date(2026, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{"0 * * * *", time.Date(2026, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{"@daily", time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 1, 4, 0, 0, 0, 0, time.UTC)},
+		{"30 4 1-3 2 *", time.Date(2026, 2, 1, 4, 30, 0, 0, time.UTC)},
+		// Only the day of week is restricted: the next Monday.
+		{"0 0 * * 1", time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)},
+		// Only the day of month is restricted: the 15th, a Thursday.
+		{"0 0 15 * *", time.Date(2026, 1, 15, 0, 0, 0, 0, time.UTC)},
+		// Both are restricted, so either matches: Monday the 5th comes
+		// before the 15th, and the 15th before Monday the 19th.
+		{"0 0 15 * 1", time.Date(2026, 1, 5, 0, 0, 0, 0, time.UTC)},
+		{"0 0 2 * 1", time.Date(2026, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+	} {
+		s, err := parseSchedule(tc.spec)
+		if err != nil {
+			t.Errorf("%q: %v", tc.spec, err)
+			continue
+		}
+		if got := s.Next(after); !got.Equal(tc.want) {
+			t.Errorf("%q: next after %s is %s, want %s", tc.spec, after, got, tc.want)
+		}
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	db, err := openDB("sqlite3", filepath.Join(t.TempDir(), "locks.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if err := createTableJobLocks(db); err != nil {
+		t.Fatal(err)
+	}
+	a, b := NewScheduler(db, nil), NewScheduler(db, nil)
+	now := time.Now()
+
+	for _, step := range []struct {
+		what  string
+		s     *Scheduler
+		until time.Time
+		want  bool
+	}{
+		{"first instance takes the lock", a, now.Add(time.Minute), true},
+		{"second instance is refused while it is held", b, now.Add(time.Minute), false},
+		{"holder extends it", a, now.Add(time.Hour), true},
+		{"second instance is still refused", b, now.Add(time.Minute), false},
+		{"holder lets it expire", a, now.Add(-time.Minute), true},
+		{"second instance takes the expired lock", b, now.Add(time.Minute), true},
+		{"first instance is refused", a, now.Add(time.Minute), false},
+	} {
+		got, err := step.s.acquireLock("test", step.until)
+		if err != nil {
+			t.Fatalf("%s: %v", step.what, err)
+		}
+		if got != step.want {
+			t.Fatalf("%s: acquired %v, want %v", step.what, got, step.want)
+		}
+	}
+
+	// Failing to create a lock for any reason other than losing the race is
+	// an error, not contention.
+	if _, err := db.Exec("CREATE TRIGGER fail_inserts BEFORE INSERT ON job_locks BEGIN SELECT RAISE(ABORT, 'disk full'); END"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := a.acquireLock("other", now.Add(time.Minute)); err == nil {
+		t.Errorf("failed insert: acquired %v with no error", got)
+	}
+}
@@ -0,0 +1,238 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// lambdaRuntimeAPI returns the address of the AWS Lambda runtime API, which
+// Lambda sets when it runs panopticon as a custom runtime, or "".
+func lambdaRuntimeAPI() string {
+	return os.Getenv("AWS_LAMBDA_RUNTIME_API")
+}
+
+// apiGatewayRequest is an invocation from API Gateway, in either payload
+// format: 1.0, used by REST APIs, or 2.0, the default of HTTP APIs.
+type apiGatewayRequest struct {
+	Version         string            `json:"version"`
+	Headers         map[string]string `json:"headers"`
+	Body            string            `json:"body"`
+	IsBase64Encoded bool              `json:"isBase64Encoded"`
+
+	// Payload format 1.0
+	HTTPMethod                      string              `json:"httpMethod"`
+	Path                            string              `json:"path"`
+	QueryStringParameters           map[string]string   `json:"queryStringParameters"`
+	MultiValueQueryStringParameters map[string][]string `json:"multiValueQueryStringParameters"`
+	MultiValueHeaders               map[string][]string `json:"multiValueHeaders"`
+
+	// Payload format 2.0
+	RawPath        string   `json:"rawPath"`
+	RawQueryString string   `json:"rawQueryString"`
+	Cookies        []string `json:"cookies"`
+
+	RequestContext struct {
+		Identity struct {
+			SourceIP string `json:"sourceIp"`
+		} `json:"identity"` // 1.0
+		HTTP struct {
+			Method   string `json:"method"`
+			SourceIP string `json:"sourceIp"`
+		} `json:"http"` // 2.0
+	} `json:"requestContext"`
+}
+
+type apiGatewayResponse struct {
+	StatusCode        int                 `json:"statusCode"`
+	Headers           map[string]string   `json:"headers,omitempty"`
+	MultiValueHeaders map[string][]string `json:"multiValueHeaders,omitempty"` // 1.0
+	Cookies           []string            `json:"cookies,omitempty"`           // 2.0
+	Body              string              `json:"body"`
+	IsBase64Encoded   bool                `json:"isBase64Encoded"`
+}
+
+// httpRequest converts the invocation into the request it stands for. The
+// client's address, as seen by API Gateway, is its RemoteAddr.
+func (e *apiGatewayRequest) httpRequest(ctx context.Context) (*http.Request, error) {
+	body := []byte(e.Body)
+	if e.IsBase64Encoded {
+		var err error
+		if body, err = base64.StdEncoding.DecodeString(e.Body); err != nil {
+			return nil, fmt.Errorf("decoding body: %v", err)
+		}
+	}
+	u := &url.URL{Path: e.Path}
+	method, ip := e.HTTPMethod, e.RequestContext.Identity.SourceIP
+	if e.Version == "2.0" {
+		u = &url.URL{Path: e.RawPath, RawQuery: e.RawQueryString}
+		method, ip = e.RequestContext.HTTP.Method, e.RequestContext.HTTP.SourceIP
+	} else if e.MultiValueQueryStringParameters != nil {
+		u.RawQuery = url.Values(e.MultiValueQueryStringParameters).Encode()
+	} else {
+		q := url.Values{}
+		for k, v := range e.QueryStringParameters {
+			q.Set(k, v)
+		}
+		u.RawQuery = q.Encode()
+	}
+	if method == "" {
+		return nil, errors.New("not an API Gateway request")
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if e.MultiValueHeaders != nil {
+		for k, vs := range e.MultiValueHeaders {
+			for _, v := range vs {
+				req.Header.Add(k, v)
+			}
+		}
+	} else {
+		for k, v := range e.Headers {
+			req.Header.Set(k, v)
+		}
+	}
+	for _, c := range e.Cookies {
+		req.Header.Add("Cookie", c)
+	}
+	req.Host = req.Header.Get("Host")
+	req.RemoteAddr = net.JoinHostPort(ip, "0")
+	return req, nil
+}
+
+// bufferedResponseWriter buffers a response, so it can be handed on in one
+// piece rather than written to a connection.
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// response encodes the buffered response in the payload format of the
+// invocation.
+func (w *bufferedResponseWriter) response(version string) *apiGatewayResponse {
+	w.WriteHeader(http.StatusOK)
+	resp := &apiGatewayResponse{StatusCode: w.status}
+	if utf8.Valid(w.body.Bytes()) {
+		resp.Body = w.body.String()
+	} else {
+		resp.Body = base64.StdEncoding.EncodeToString(w.body.Bytes())
+		resp.IsBase64Encoded = true
+	}
+	if version != "2.0" {
+		resp.MultiValueHeaders = w.header
+		return resp
+	}
+	resp.Headers = map[string]string{}
+	for k, vs := range w.header {
+		if k == "Set-Cookie" {
+			resp.Cookies = vs
+		} else {
+			resp.Headers[k] = strings.Join(vs, ", ")
+		}
+	}
+	return resp
+}
+
+// invokeLambda serves one API Gateway invocation with h.
+func invokeLambda(ctx context.Context, h http.Handler, event []byte) ([]byte, error) {
+	var e apiGatewayRequest
+	if err := json.Unmarshal(event, &e); err != nil {
+		return nil, err
+	}
+	req, err := e.httpRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w := &bufferedResponseWriter{header: http.Header{}}
+	h.ServeHTTP(w, req)
+	return json.Marshal(w.response(e.Version))
+}
+
+// serveLambda serves invocations fetched from the Lambda runtime API at
+// api with h, until the runtime API can't be reached. Each invocation has
+// until its deadline.
+func serveLambda(api string, h http.Handler) error {
+	base := "http://" + api + "/2018-06-01/runtime/invocation/"
+	log.Printf("Serving AWS Lambda invocations from %s", api)
+	for {
+		// Waits for as long as there's no invocation.
+		resp, err := http.Get(base + "next")
+		if err != nil {
+			return err
+		}
+		event, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return err
+		}
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("fetching invocation: %s", resp.Status)
+		}
+		id := resp.Header.Get("Lambda-Runtime-Aws-Request-Id")
+		ctx, cancel := context.Background(), func() {}
+		if ms, err := strconv.ParseInt(resp.Header.Get("Lambda-Runtime-Deadline-Ms"), 10, 64); err == nil {
+			ctx, cancel = context.WithDeadline(ctx, time.UnixMilli(ms))
+		}
+		out, err := invokeLambda(ctx, h, event)
+		cancel()
+		path := base + id + "/response"
+		if err != nil {
+			log.Printf("Error handling Lambda invocation %s: %v", id, err)
+			out, _ = json.Marshal(map[string]string{"errorMessage": err.Error(), "errorType": "InvalidEvent"})
+			path = base + id + "/error"
+		}
+		resp, err = http.Post(path, "application/json", bytes.NewReader(out))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusAccepted {
+			return fmt.Errorf("returning invocation %s: %s", id, resp.Status)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"sync/atomic"
+)
+
+var (
+	maxConcurrentWrites = flag.Int("max-concurrent-writes", 0, "how many pushes may write to the database at once (0 for no limit)")
+	maxQueuedWrites     = flag.Int("max-queued-writes", 100, "how many pushes may wait for a write slot before further ones are refused with 429")
+)
+
+var errWriteQueueFull = errors.New("write queue full")
+
+// writeLimiter bounds the number of pushes writing to the database at
+// once, queueing a limited number of others and shedding the rest, so that
+// a burst of pushes can't exhaust database connections.
+type writeLimiter struct {
+	slots     chan struct{}
+	queued    int64
+	maxQueued int64
+}
+
+func newWriteLimiter(concurrency, maxQueued int) *writeLimiter {
+	if concurrency <= 0 {
+		return nil
+	}
+	return &writeLimiter{
+		slots:     make(chan struct{}, concurrency),
+		maxQueued: int64(maxQueued),
+	}
+}
+
+// Acquire waits for a write slot. It fails immediately if too many pushes
+// are already waiting, or when ctx is done. A nil limiter never blocks.
+func (l *writeLimiter) Acquire(ctx context.Context) error {
+	if l == nil {
+		return nil
+	}
+	select {
+	case l.slots <- struct{}{}:
+		l.report()
+		return nil
+	default:
+	}
+	if atomic.AddInt64(&l.queued, 1) > l.maxQueued {
+		atomic.AddInt64(&l.queued, -1)
+		metrics.Inc("panopticon_write_queue_rejected_total")
+		return errWriteQueueFull
+	}
+	defer atomic.AddInt64(&l.queued, -1)
+	l.report()
+	select {
+	case l.slots <- struct{}{}:
+		l.report()
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l *writeLimiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+	l.report()
+}
+
+func (l *writeLimiter) report() {
+	metrics.Set("panopticon_writes_in_flight", float64(len(l.slots)))
+	metrics.Set("panopticon_writes_queued", float64(atomic.LoadInt64(&l.queued)))
+}
@@ -0,0 +1,77 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWriteLimiter(t *testing.T) {
+	if newWriteLimiter(0, 10) != nil {
+		t.Error("a limit of 0 made a limiter")
+	}
+	var unlimited *writeLimiter
+	if err := unlimited.Acquire(context.Background()); err != nil {
+		t.Errorf("nil limiter: %v", err)
+	}
+	unlimited.Release()
+
+	l := newWriteLimiter(1, 1)
+	ctx := context.Background()
+	if err := l.Acquire(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// A second push waits for the slot, and a third is shed.
+	acquired := make(chan error, 1)
+	go func() { acquired <- l.Acquire(ctx) }()
+	for atomic.LoadInt64(&l.queued) != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	if err := l.Acquire(ctx); err != errWriteQueueFull {
+		t.Errorf("third push: got %v, want %v", err, errWriteQueueFull)
+	}
+	select {
+	case err := <-acquired:
+		t.Fatalf("queued push acquired a slot while it was held: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	l.Release()
+	if err := <-acquired; err != nil {
+		t.Fatalf("queued push: %v", err)
+	}
+
+	// A queued push gives up when its request does.
+	cancelled, cancel := context.WithCancel(ctx)
+	go func() {
+		for atomic.LoadInt64(&l.queued) != 1 {
+			time.Sleep(time.Millisecond)
+		}
+		cancel()
+	}()
+	if err := l.Acquire(cancelled); err != context.Canceled {
+		t.Errorf("cancelled push: got %v", err)
+	}
+	if n := atomic.LoadInt64(&l.queued); n != 0 {
+		t.Errorf("%d pushes still queued", n)
+	}
+	l.Release()
+	if len(l.slots) != 0 {
+		t.Errorf("%d slots still held", len(l.slots))
+	}
+}
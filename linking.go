@@ -0,0 +1,284 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	linkIPv4Prefix = flag.Int("link-ipv4-prefix", 32, "homeservers reporting from IPv4 addresses sharing this many leading bits are linked as likely having the same operator")
+	linkIPv6Prefix = flag.Int("link-ipv6-prefix", 64, "homeservers reporting from IPv6 addresses sharing this many leading bits are linked as likely having the same operator")
+	linkExclude    = flag.String("link-exclude", "", "comma separated CIDRs, such as carrier-grade NAT ranges, whose addresses don't link homeservers")
+)
+
+const (
+	defaultLinkWindow = 30 * 24 * time.Hour
+	maxLinkWindow     = 365 * 24 * time.Hour
+)
+
+// linkExcludeNets is parsed from -link-exclude by validateLinkFlags.
+var linkExcludeNets []*net.IPNet
+
+func validateLinkFlags() error {
+	if *linkIPv4Prefix < 1 || *linkIPv4Prefix > 32 {
+		return errors.New("-link-ipv4-prefix must be between 1 and 32")
+	}
+	if *linkIPv6Prefix < 1 || *linkIPv6Prefix > 128 {
+		return errors.New("-link-ipv6-prefix must be between 1 and 128")
+	}
+	for _, c := range strings.Split(*linkExclude, ",") {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			return fmt.Errorf("invalid -link-exclude: %v", err)
+		}
+		linkExcludeNets = append(linkExcludeNets, n)
+	}
+	return nil
+}
+
+// linkNetwork returns the network of the given prefix lengths which ip is
+// in, or "" if it isn't an address or is excluded from linking.
+func linkNetwork(ip string, v4Prefix, v6Prefix int) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ""
+	}
+	for _, n := range linkExcludeNets {
+		if n.Contains(parsed) {
+			return ""
+		}
+	}
+	mask := net.CIDRMask(v6Prefix, 128)
+	if v4 := parsed.To4(); v4 != nil {
+		parsed, mask = v4, net.CIDRMask(v4Prefix, 32)
+	}
+	return (&net.IPNet{IP: parsed.Mask(mask), Mask: mask}).String()
+}
+
+// homeserverLinks groups homeservers into sets which, directly or through
+// others, reported from the same networks.
+type homeserverLinks struct {
+	parent   map[string]string
+	networks map[string]string // The first homeserver seen in each network
+}
+
+func newHomeserverLinks() *homeserverLinks {
+	return &homeserverLinks{parent: map[string]string{}, networks: map[string]string{}}
+}
+
+func (l *homeserverLinks) find(hs string) string {
+	if _, ok := l.parent[hs]; !ok {
+		l.parent[hs] = hs
+	}
+	for l.parent[hs] != hs {
+		l.parent[hs] = l.parent[l.parent[hs]]
+		hs = l.parent[hs]
+	}
+	return hs
+}
+
+// add records that a homeserver reported from network, if it is known.
+func (l *homeserverLinks) add(hs, network string) {
+	root := l.find(hs)
+	if network == "" {
+		return
+	}
+	other, ok := l.networks[network]
+	if !ok {
+		l.networks[network] = hs
+		return
+	}
+	if otherRoot := l.find(other); otherRoot != root {
+		l.parent[root] = otherRoot
+	}
+}
+
+// LinkedGroup is a set of homeservers which reported from the same
+// networks.
+type LinkedGroup struct {
+	Homeservers []string `json:"homeservers"`
+	Networks    []string `json:"networks"`
+}
+
+// groups returns the sets of more than one homeserver, largest first.
+func (l *homeserverLinks) groups() []LinkedGroup {
+	byRoot := map[string]*LinkedGroup{}
+	for hs := range l.parent {
+		root := l.find(hs)
+		if byRoot[root] == nil {
+			byRoot[root] = &LinkedGroup{}
+		}
+		byRoot[root].Homeservers = append(byRoot[root].Homeservers, hs)
+	}
+	for network, hs := range l.networks {
+		g := byRoot[l.find(hs)]
+		g.Networks = append(g.Networks, network)
+	}
+	groups := []LinkedGroup{}
+	for _, g := range byRoot {
+		if len(g.Homeservers) < 2 {
+			continue
+		}
+		sort.Strings(g.Homeservers)
+		sort.Strings(g.Networks)
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if len(groups[i].Homeservers) != len(groups[j].Homeservers) {
+			return len(groups[i].Homeservers) > len(groups[j].Homeservers)
+		}
+		return groups[i].Homeservers[0] < groups[j].Homeservers[0]
+	})
+	return groups
+}
+
+// operators estimates the distinct operators of the homeservers: one for
+// each set of linked homeservers.
+func (l *homeserverLinks) operators() int64 {
+	roots := map[string]bool{}
+	for hs := range l.parent {
+		roots[l.find(hs)] = true
+	}
+	return int64(len(roots))
+}
+
+// linkHomeservers links the homeservers which reported since the given time
+// by the networks they reported from.
+func linkHomeservers(ctx context.Context, db *sql.DB, s *Storage, since int64, v4Prefix, v6Prefix int) (*homeserverLinks, error) {
+	d := dialectFor(db)
+	links := newHomeserverLinks()
+	for _, table := range []string{"stats", "dendrite_stats"} {
+		rows, err := db.QueryContext(ctx, fmt.Sprintf(`SELECT DISTINCT homeserver, remote_ip, remote_addr FROM %s
+			WHERE local_timestamp >= %s AND homeserver IS NOT NULL AND homeserver NOT IN (SELECT homeserver FROM tombstones)`,
+			s.tableName(table), d.placeholder(1)), since)
+		if err != nil {
+			return nil, err
+		}
+		for rows.Next() {
+			var hs string
+			var ip, addr sql.NullString
+			if err := rows.Scan(&hs, &ip, &addr); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			if !ip.Valid || ip.String == "" {
+				plain, err := s.Encryption.decrypt("remote_addr", addr.String)
+				if err != nil {
+					rows.Close()
+					return nil, err
+				}
+				ip.String, _ = canonicalIP(plain)
+			}
+			links.add(hs, linkNetwork(ip.String, v4Prefix, v6Prefix))
+		}
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// LinkedHomeservers is the reply of /api/v1/linked-homeservers.
+type LinkedHomeservers struct {
+	Since       int64         `json:"since"`
+	Homeservers *int64        `json:"homeservers"` // Null if suppressed for privacy
+	Operators   *int64        `json:"operators"`
+	Groups      []LinkedGroup `json:"groups"` // Null for roles with privacy settings
+}
+
+// LinkedHomeserversHandler serves /api/v1/linked-homeservers, which links
+// homeservers reporting from the same IP address or subnet, as likely
+// having the same operator, to estimate the number of distinct operators.
+// It accepts the query parameters window (such as 30d), ipv4_prefix,
+// ipv6_prefix and limit, which limits the groups listed.
+type LinkedHomeserversHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *LinkedHomeserversHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	q := req.URL.Query()
+	role, p := roleOf(req), privacyOf(req)
+	if hiddenColumnError(w, role, "remote_ip", "linking") || hiddenColumnError(w, role, "remote_addr", "linking") {
+		return
+	}
+	if e := h.Storage.Encryption; e.encrypts("remote_addr") && !e.mayDecrypt(role) {
+		logAndReplyError(w, errors.New("linking needs remote_addr, which is encrypted"), http.StatusForbidden, "Forbidden query")
+		return
+	}
+	window := defaultLinkWindow
+	if v := q.Get("window"); v != "" {
+		var err error
+		if window, err = parseWindow(v); err != nil || window <= 0 || window > maxLinkWindow {
+			logAndReplyError(w, fmt.Errorf("bad window %q", v), 400, "Bad query")
+			return
+		}
+	}
+	v4Prefix, v6Prefix := *linkIPv4Prefix, *linkIPv6Prefix
+	for _, pr := range []struct {
+		param string
+		value *int
+		max   int
+	}{{"ipv4_prefix", &v4Prefix, 32}, {"ipv6_prefix", &v6Prefix, 128}} {
+		if v := q.Get(pr.param); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > pr.max {
+				logAndReplyError(w, fmt.Errorf("bad %s %q", pr.param, v), 400, "Bad query")
+				return
+			}
+			*pr.value = n
+		}
+	}
+	limit, err := intParam(q.Get("limit"), defaultRowLimit)
+	if err != nil || limit <= 0 || limit > *maxRowLimit {
+		logAndReplyError(w, fmt.Errorf("bad limit %q", q.Get("limit")), 400, "Bad query")
+		return
+	}
+	now := time.Now().UTC()
+	since := now.Add(-window).Unix()
+	links, err := linkHomeservers(req.Context(), h.DB, h.Storage, since, v4Prefix, v6Prefix)
+	if err != nil {
+		replyQueryError(w, req, err, "Error linking homeservers")
+		return
+	}
+	result := LinkedHomeservers{
+		Since:       since,
+		Homeservers: p.count(int64(len(links.parent)), "linked_homeservers", slidingWindow(window.String(), now)),
+		Operators:   p.count(links.operators(), fmt.Sprintf("operators by /%d and /%d", v4Prefix, v6Prefix), slidingWindow(window.String(), now)),
+	}
+	if p == nil && role.visible("homeserver") {
+		result.Groups = links.groups()
+		if len(result.Groups) > limit {
+			result.Groups = result.Groups[:limit]
+		}
+	}
+	writeJSON(w, result)
+}
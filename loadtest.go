@@ -0,0 +1,200 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// loadtestReport is a synthetic push.
+type loadtestReport struct {
+	body     []byte
+	dendrite bool
+}
+
+// loadtestResult is the outcome of one synthetic push.
+type loadtestResult struct {
+	status  int // 0 if the request failed
+	latency time.Duration
+}
+
+// runLoadtest implements "panopticon loadtest", which pushes synthetic
+// reports to an instance at a fixed rate and summarises how it coped.
+func runLoadtest(args []string) int {
+	fs := flag.NewFlagSet("loadtest", flag.ExitOnError)
+	target := fs.String("target", "http://localhost:9001/push", "the push URL to send reports to")
+	rate := fs.Float64("rate", 10, "reports to send per second")
+	duration := fs.Duration("duration", 30*time.Second, "how long to send reports for")
+	homeservers := fs.Int("homeservers", 1000, "the number of distinct homeservers to report as")
+	concurrency := fs.Int("concurrency", 16, "the maximum number of requests in flight")
+	dendrite := fs.Float64("dendrite", 0.1, "the fraction of reports which are from Dendrite")
+	seed := fs.Int64("seed", 1, "seed for the synthetic report values")
+	fs.Parse(args)
+	if *rate <= 0 || *homeservers <= 0 || *concurrency <= 0 {
+		fmt.Fprintln(os.Stderr, "-rate, -homeservers and -concurrency must be positive")
+		return 2
+	}
+
+	rnd := rand.New(rand.NewSource(*seed))
+	client := &http.Client{Timeout: 30 * time.Second}
+	work := make(chan loadtestReport, *concurrency)
+	results := make(chan loadtestResult, *concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < *concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for report := range work {
+				start := time.Now()
+				res := loadtestResult{}
+				resp, err := postSynthetic(client, *target, report)
+				if err == nil {
+					io.Copy(io.Discard, resp.Body)
+					resp.Body.Close()
+					res.status = resp.StatusCode
+				}
+				res.latency = time.Since(start)
+				results <- res
+			}
+		}()
+	}
+
+	var (
+		statuses  = map[int]int{}
+		latencies []time.Duration
+		collected = make(chan struct{})
+	)
+	go func() {
+		for res := range results {
+			statuses[res.status]++
+			latencies = append(latencies, res.latency)
+		}
+		close(collected)
+	}()
+
+	// Reports which can't be handed to a worker because they're all busy
+	// are skipped rather than queued, so that a slow target shows up as a
+	// shortfall instead of a growing backlog.
+	skipped := 0
+	start := time.Now()
+	ticker := time.NewTicker(time.Duration(float64(time.Second) / *rate))
+	for now := range ticker.C {
+		if now.Sub(start) >= *duration {
+			break
+		}
+		isDendrite := rnd.Float64() < *dendrite
+		report := loadtestReport{syntheticReport(rnd, rnd.Intn(*homeservers), isDendrite), isDendrite}
+		select {
+		case work <- report:
+		default:
+			skipped++
+		}
+	}
+	ticker.Stop()
+	close(work)
+	wg.Wait()
+	close(results)
+	<-collected
+	elapsed := time.Since(start)
+
+	sent := len(latencies)
+	fmt.Printf("Sent %d reports in %s (%.1f/s), skipped %d\n", sent, elapsed.Round(time.Millisecond), float64(sent)/elapsed.Seconds(), skipped)
+	codes := make([]int, 0, len(statuses))
+	for code := range statuses {
+		codes = append(codes, code)
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		label := fmt.Sprint(code)
+		if code == 0 {
+			label = "failed"
+		}
+		fmt.Printf("  %s: %d\n", label, statuses[code])
+	}
+	if sent > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		fmt.Printf("Latency p50 %s, p95 %s, p99 %s, max %s\n",
+			percentile(latencies, 0.5), percentile(latencies, 0.95), percentile(latencies, 0.99), latencies[sent-1])
+	}
+	return 0
+}
+
+// postSynthetic pushes a synthetic report with a user agent matching its
+// kind, since Dendrite reports are told apart by theirs.
+func postSynthetic(client *http.Client, target string, report loadtestReport) (*http.Response, error) {
+	req, err := http.NewRequest(http.MethodPost, target, bytes.NewReader(report.body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if report.dendrite {
+		req.Header.Set("User-Agent", "Dendrite/0.13.0")
+	} else {
+		req.Header.Set("User-Agent", "Synapse/1.90.0")
+	}
+	return client.Do(req)
+}
+
+// percentile returns the p-th quantile of sorted durations.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	i := int(p * float64(len(sorted)-1))
+	return sorted[i].Round(time.Microsecond)
+}
+
+// syntheticReport builds a plausible report for the n-th synthetic
+// homeserver.
+func syntheticReport(rnd *rand.Rand, n int, dendrite bool) []byte {
+	users := rnd.Int63n(100000) + 1
+	daily := rnd.Int63n(users) + 1
+	report := map[string]interface{}{
+		"homeserver":           fmt.Sprintf("loadtest-%d.example.org", n),
+		"timestamp":            time.Now().Unix(),
+		"uptime_seconds":       rnd.Int63n(30 * 24 * 3600),
+		"total_users":          users,
+		"total_room_count":     rnd.Int63n(users*2) + 1,
+		"daily_active_users":   daily,
+		"monthly_active_users": daily + rnd.Int63n(users-daily+1),
+		"daily_messages":       rnd.Int63n(daily * 50),
+		"daily_active_rooms":   rnd.Int63n(daily) + 1,
+		"r30v2_users_all":      rnd.Int63n(daily),
+		"memory_rss":           rnd.Int63n(8 << 30),
+		"cpu_average":          rnd.Int63n(400),
+		"database_engine":      "PostgreSQL",
+	}
+	if dendrite {
+		report["version"] = "0.13.0"
+		report["go_version"] = "go1.21"
+		report["go_arch"] = "amd64"
+		report["go_os"] = "linux"
+		report["num_cpu"] = rnd.Int63n(32) + 1
+		report["num_go_routine"] = rnd.Int63n(10000)
+	} else {
+		report["python_version"] = "3.11.4"
+		report["cache_factor"] = 0.5
+		report["event_cache_size"] = 10000
+	}
+	b, _ := json.Marshal(report)
+	return b
+}
@@ -20,6 +20,8 @@ limitations under the License.
 package main
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
 	"encoding/json"
 	"flag"
@@ -27,18 +29,24 @@ import (
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"reflect"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
-	_ "github.com/mattn/go-sqlite3"
 	_ "github.com/lib/pq"
 )
 
 var (
-	dbDriver = flag.String("db-driver", "sqlite3", "the database driver to use")
-	dbPath   = flag.String("db", "stats.db", "the data source to use, for sqlite this is the path to the file")
-	port     = flag.Int("port", 9001, "Port on which to serve HTTP")
+	dbDriver   = flag.String("db-driver", "sqlite3", "the database driver to use")
+	dbPath     = flag.String("db", "stats.db", "the data source to use, for sqlite this is the path to the file")
+	readDBPath = flag.String("read-db", "", "the data source for the read API, such as a replica of -db; defaults to -db")
+	port       = flag.Int("port", 9001, "Port on which to serve HTTP")
+
+	configPath = flag.String("config", "", "path to an optional JSON config file")
 )
 
 type StatsReport struct {
@@ -82,64 +90,829 @@ type CommonStats struct {
 	DatabaseEngine        string `json:"database_engine"`
 	DatabaseServerVersion string `json:"database_server_version"`
 	LogLevel              string `json:"log_level"`
+	SchemaVersion         string `json:"-"` // The payload schema version the reporter declared
+	UserAgentFilter       string `json:"-"` // The name of the user agent filter which flagged the report
+	Stale                 *bool  `json:"-"` // Set if the report looks like a replay of old data
+	Verified              *bool  `json:"-"` // With -verification-period, whether the homeserver had proven control of its domain
 	RemoteAddr            string
+	RemoteIP              string `json:"-"` // Canonical client IP, from RemoteAddr or trusted proxy headers
+	RemoteIPFamily        *int64 `json:"-"` // 4 or 6
+	AdjustedFields        string `json:"-"` // Comma separated fields whose values were clamped, rounded or dropped
+	Backfilled            *bool  `json:"-"` // Set if submitted through the backfill API with an explicit local timestamp
+	SampleRate            *int64 `json:"-"` // N if only one in N reports from the homeserver is stored
 	XForwardedFor         string
 	UserAgent             string
+
+	Floats  map[string]float64 `json:"-"` // Exact values of fields configured as floats
+	Strings map[string]string  `json:"-"` // Values of configured string metrics
 }
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "loadtest" {
+		os.Exit(runLoadtest(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "merge" {
+		os.Exit(runMerge(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "check" {
+		os.Exit(runCheck(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "reaggregate" {
+		os.Exit(runReaggregate(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "diff" {
+		os.Exit(runDiff(os.Args[2:]))
+	}
+	if len(os.Args) > 1 && os.Args[1] == "query-archive" {
+		os.Exit(runQueryArchive(os.Args[2:]))
+	}
 	flag.Parse()
 
-	db, err := sql.Open(*dbDriver, *dbPath)
+	if err := startService(); err != nil {
+		log.Fatalf("Could not start as a service: %v", err)
+	}
+	if err := validateStaleReportsFlag(); err != nil {
+		log.Fatal(err)
+	}
+	storage := storageFromFlags()
+	if err := storage.validate(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateHomeserverHashFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateFaultFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateKnownBotsFlag(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateSignedURLFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateLinkFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateListenFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := validateQueryLimitFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if err := parseTrustedProxies(); err != nil {
+		log.Fatal(err)
+	}
+
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		log.Fatalf("Could not load config: %v", err)
+	}
+	setFeatures(config.Features)
+	storage = storage.withConfig(config)
+
+	db, err := openDB(storage.Driver, *dbPath)
 	if err != nil {
 		log.Fatalf("Could not open database: %v", err)
 	}
 	defer db.Close()
+	if err := waitForDB(db, *dbConnectTimeout); err != nil {
+		log.Fatalf("Could not connect to database: %v", err)
+	}
+	go watchDB(context.Background(), db, *dbHealthInterval)
 
-	if err := createTableSynapse(db); err != nil {
-		log.Fatalf("Error creating database: %v", err)
+	if isSQLite(storage.Driver) {
+		if err := enableIncrementalVacuum(db); err != nil {
+			log.Fatalf("Error setting up the database: %v", err)
+		}
 	}
-	if err := createTableDendrite(db); err != nil {
+
+	if err := createTables(db, storage); err != nil {
 		log.Fatalf("Error creating database: %v", err)
 	}
+	missingColumns, err := checkSchema(db, storage.tables(), *autoMigrate)
+	if err != nil {
+		log.Fatalf("Error checking schema: %v", err)
+	}
+	if storage.Driver == "memory" {
+		if err := limitMemoryReports(db, storage, *memoryMaxReports); err != nil {
+			log.Fatalf("Error creating database: %v", err)
+		}
+	}
+
+	scheduler := NewScheduler(db, config.Jobs)
+	if err := scheduler.Register("schema_check", "@hourly", schemaCheck(db, storage)); err != nil {
+		log.Fatal(err)
+	}
+	notifiers, err := newNotifiers(config.Notifiers)
+	if err != nil {
+		log.Fatalf("Error configuring notifiers: %v", err)
+	}
+	if len(notifiers) > 0 {
+		if err := scheduler.Register("weekly_digest", "0 9 * * 1", weeklyDigest(db, notifiers)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if isSQLite(storage.Driver) {
+		if err := scheduler.Register("optimize_db", "@daily", optimizeDB(db)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(config.Alerts) > 0 {
+		if err := scheduler.Register("evaluate_alerts", "@every 5m", evaluateAlerts(db, storage, config.Alerts, notifiers)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *heartbeatInterval > 0 {
+		// The handlers are registered below, before the scheduler first runs it.
+		schedule := fmt.Sprintf("@every %s", *heartbeatInterval)
+		if err := scheduler.Register("heartbeat", schedule, heartbeat(db, storage, http.DefaultServeMux, groupToken(config.EndpointGroups, "push"))); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *verificationPeriod > 0 {
+		if err := scheduler.Register("prune_verification_nonces", "@hourly", pruneVerificationNonces(db)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if len(config.Quotas) > 0 {
+		if err := scheduler.Register("prune_quota_usage", "@daily", pruneQuotaUsage(db)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *storeRawReports {
+		if err := scheduler.Register("prune_raw_reports", "@hourly", pruneRawReports(db)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	histograms := config.Histograms
+	if !featureEnabled("histograms") {
+		histograms = nil
+	}
+	if *statsRetention > 0 {
+		if err := scheduler.Register("prune_stats", "@daily", pruneStats(db, storage, histograms)); err != nil {
+			log.Fatal(err)
+		}
+	} else if len(histograms) > 0 {
+		if err := scheduler.Register("build_histograms", "@daily", histogramsJob(db, storage, histograms)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *compactAfter > 0 {
+		if err := scheduler.Register("compact_stats", "@daily", compactStats(db, storage)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if config.BigQuery != nil {
+		exporter, err := newBigQueryExporter(config.BigQuery, storage)
+		if err != nil {
+			log.Fatalf("Error configuring BigQuery export: %v", err)
+		}
+		if err := scheduler.Register("bigquery_export", "@hourly", bigQueryExport(db, storage, exporter)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if storage.Driver == "memory" && *memoryDump != "" {
+		schedule := fmt.Sprintf("@every %s", *memoryDumpInterval)
+		if err := scheduler.Register("memory_dump", schedule, memoryDumpJob(db, *memoryDump)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	readDB := db
+	if *readDBPath != "" && *readSnapshot != "" {
+		log.Fatal("-read-db and -read-snapshot are mutually exclusive")
+	}
+	if *readDBPath != "" {
+		if readDB, err = openDB(storage.Driver, *readDBPath); err != nil {
+			log.Fatalf("Could not open read database: %v", err)
+		}
+		defer readDB.Close()
+	}
+	if *readSnapshot != "" {
+		if readDB, err = openReadSnapshot(db, *readSnapshot); err != nil {
+			log.Fatalf("Could not open read snapshot: %v", err)
+		}
+		defer readDB.Close()
+		schedule := fmt.Sprintf("@every %s", *readSnapshotInterval)
+		if err := scheduler.Register("snapshot_db", schedule, snapshotDB(db, *readSnapshot)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	targets, err := openWriteTargets(config.WriteTargets, storage)
+	if err != nil {
+		log.Fatalf("Error opening write targets: %v", err)
+	}
+	if *selfCheck || *selfCheckOnly {
+		ok := runStartupChecks(startupChecks(db, readDB, missingColumns, targets))
+		if *selfCheckOnly {
+			if ok {
+				os.Exit(0)
+			}
+			os.Exit(1)
+		}
+		if !ok {
+			log.Fatal("Refusing to start; fix the failed checks, or run with -self-check=false")
+		}
+	}
+	scheduler.Start(context.Background())
+
+	maintenance.set(*readOnly, int64(maintenanceRetryAfter.Seconds()))
 
-	r := &Recorder{db}
+	r := &Recorder{
+		DB:      db,
+		Storage: storage,
+		Config:  config,
+		Targets: targets,
+		Limiter: newWriteLimiter(*maxConcurrentWrites, *maxQueuedWrites),
 
-	http.HandleFunc("/push", r.Handle)
-	http.HandleFunc("/test", serveText("ok"))
-	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", *port), nil))
+		StaleReports: *staleReports,
+		MaxReportAge: *maxReportAge,
+	}
+
+	pushGroup := endpointGroup(config.EndpointGroups, "push")
+	readGroup := chain(endpointGroup(config.EndpointGroups, "read"), limitQueryTime)
+	adminGroup := endpointGroup(config.EndpointGroups, "admin")
+	publicGroup := endpointGroup(config.EndpointGroups, "public")
+
+	push := pushGroup(allowMethods(newShadow(db, storage).wrap(r.Handle), http.MethodPost))
+	http.HandleFunc("/push", push)
+	http.HandleFunc(dryRunPath, push)
+	for path := range config.PushEndpoints {
+		if path != "/push" {
+			http.HandleFunc(path, push)
+		}
+	}
+	if _, ok := config.PushEndpoints["/"]; !ok {
+		http.HandleFunc("/", publicGroup(notFound))
+	}
+	http.HandleFunc("/test", publicGroup(allowMethods(serveText("ok"), http.MethodGet, http.MethodHead)))
+	http.HandleFunc("/healthz", publicGroup(allowMethods(serveText("ok"), http.MethodGet, http.MethodHead)))
+	http.HandleFunc("/version", publicGroup(allowMethods(serveVersion, http.MethodGet, http.MethodHead)))
+	http.HandleFunc("/metrics", publicGroup(allowMethods(metrics.ServeHTTP, http.MethodGet, http.MethodHead)))
+	if ui := uiHandler(); ui != nil {
+		http.HandleFunc("/ui/", publicGroup(http.StripPrefix("/ui/", ui).ServeHTTP))
+	}
+	serverFetchGroup := chain(publicGroup, newServerFetchLimiter())
+	verify := serverFetchGroup(allowMethods((&VerifyHandler{db, storage, serverFetchClient(*verificationURL, *optOutKeyURL)}).ServeHTTP, http.MethodPost))
+	http.HandleFunc("/verify", verify)
+	http.HandleFunc("/verify/challenge", verify)
+	http.HandleFunc("/opt-out", serverFetchGroup(allowMethods((&OptOutHandler{db, storage, serverFetchClient(*optOutKeyURL)}).ServeHTTP, http.MethodPost)))
+	reports := (&ReportsHandler{readDB, storage}).ServeHTTP
+	http.HandleFunc("/api/v1/reports", readGroup(acceptSignedURLs(config.Roles, reports, requireReader(config.Roles, reports))))
+	http.HandleFunc("/api/v1/ingest-stats", readGroup(requireReader(config.Roles, serveIngestStats)))
+	http.HandleFunc("/api/v1/series", readGroup(requireReader(config.Roles, (&SeriesHandler{readDB, storage, config.DerivedMetrics}).ServeHTTP)))
+	http.HandleFunc("/api/v1/histograms", readGroup(requireReader(config.Roles, (&HistogramsHandler{readDB}).ServeHTTP)))
+	http.HandleFunc("/api/v1/diff", readGroup(requireReader(config.Roles, (&DiffHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/api/v1/string-metrics", readGroup(requireReader(config.Roles, (&StringMetricsHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/api/v1/annotations", readGroup(requireReader(config.Roles, (&AnnotationsHandler{readDB}).ServeHTTP)))
+	http.HandleFunc("/api/v1/grafana/", readGroup(requireReader(config.Roles, (&GrafanaHandler{readDB}).ServeHTTP)))
+	http.HandleFunc("/api/v1/feed.atom", readGroup(requireFeedReader(config.Roles, (&FeedHandler{readDB}).ServeHTTP)))
+	http.HandleFunc("/api/v1/cadence", readGroup(requireReader(config.Roles, (&CadenceHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/api/v1/changes", readGroup(requireReader(config.Roles, (&ChangesHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/api/v1/backfill", adminGroup(requireBackfiller(r.Backfill)))
+	http.HandleFunc("/api/v1/active-homeservers", readGroup(requireReader(config.Roles, (&ActiveHomeserversHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/api/v1/linked-homeservers", readGroup(requireReader(config.Roles, (&LinkedHomeserversHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/api/v1/new-homeservers", readGroup(requireReader(config.Roles, (&NewHomeserversHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/api/v1/columns", readGroup(requireReader(config.Roles, (&ColumnStatsHandler{readDB, storage}).ServeHTTP)))
+	http.HandleFunc("/admin/tombstones", adminGroup(requireAdmin((&TombstonesHandler{db, storage}).ServeHTTP)))
+	http.HandleFunc("/admin/homeservers", adminGroup(requireAdmin((&HomeserverMetadataHandler{db, storage}).ServeHTTP)))
+	queries := limitQueryTime((&QueriesHandler{readDB, storage, config.Queries}).ServeHTTP)
+	http.HandleFunc("/admin/queries", adminGroup(requireAdmin(queries)))
+	http.HandleFunc("/admin/queries/", adminGroup(acceptSignedURLs(config.Roles, queries, requireAdmin(queries))))
+	http.HandleFunc("/admin/storage", adminGroup(requireAdmin(allowMethods((&StorageHandler{db, storage}).ServeHTTP, http.MethodGet))))
+	http.HandleFunc("/admin/signed-urls", adminGroup(requireAdmin((&SignedURLsHandler{config.Roles}).ServeHTTP)))
+	http.HandleFunc("/admin/shadow", adminGroup(requireAdmin(allowMethods((&ShadowHandler{db}).ServeHTTP, http.MethodGet, http.MethodDelete))))
+	http.HandleFunc("/admin/maintenance", adminGroup(requireAdmin(serveMaintenance)))
+	http.HandleFunc("/admin/alerts", adminGroup(requireAdmin((&AlertsHandler{db}).ServeHTTP)))
+	http.HandleFunc("/admin/silences", adminGroup(requireAdmin((&SilencesHandler{db, storage}).ServeHTTP)))
+	http.HandleFunc("/admin/annotations", adminGroup(requireAdmin((&AdminAnnotationsHandler{db}).ServeHTTP)))
+	jobs := adminGroup(requireAdmin((&JobsHandler{scheduler}).ServeHTTP))
+	http.HandleFunc("/admin/jobs", jobs)
+	http.HandleFunc("/admin/jobs/", jobs)
+	handler := traceRequests(withResponseHeaders(trapScanners(http.DefaultServeMux), config.ResponseHeaders))
+	if api := lambdaRuntimeAPI(); api != "" {
+		log.Fatal(serveLambda(api, handler))
+	}
+	ln, err := listen(fmt.Sprintf(":%d", *port))
+	if err != nil {
+		log.Fatalf("Could not listen: %v", err)
+	}
+	if err := serve(&http.Server{Handler: handler}, ln); err != nil {
+		log.Fatal(err)
+	}
+	dumpMemoryDB(db)
+	stopService()
 }
 
 type Recorder struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Storage *Storage
+	Config  *Config
+	Targets []*writeTarget
+	Limiter *writeLimiter
+
+	StaleReports string        // "accept" (the default), "flag" or "reject"
+	MaxReportAge time.Duration // Reports older than this are stale; 0 to disable
+}
+
+// PushResult describes what was stored for a push. It is returned to
+// clients which ask for it with ?verbose=1.
+type PushResult struct {
+	ID      interface{} `json:"id"` // An integer, a string with -id-type, or 0 if not stored
+	Table   string      `json:"table"`
+	Stored  []string    `json:"stored"`
+	Ignored []string    `json:"ignored"`
+
+	// Downsampled is set if the report arrived too soon after the previous
+	// one and was only counted rather than stored.
+	Downsampled bool `json:"downsampled,omitempty"`
+
+	// SampledOut is set if the report was dropped by sampling.
+	SampledOut bool `json:"sampled_out,omitempty"`
+
+	// DryRun is set for pushes to /push/dry-run, which aren't stored.
+	// Report then holds the value of each column which would have been.
+	DryRun bool                   `json:"dry_run,omitempty"`
+	Report map[string]interface{} `json:"report,omitempty"`
 }
 
 func (r *Recorder) Handle(w http.ResponseWriter, req *http.Request) {
-	dec := json.NewDecoder(req.Body)
 	defer req.Body.Close()
-	var sr StatsReport
-	if err := dec.Decode(&sr); err != nil {
-		logAndReplyError(w, err, 400, "Error decoding JSON")
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	w = rec
+	var (
+		body []byte
+		sr   StatsReport
+	)
+	dryRun := req.URL.Path == dryRunPath
+	defer func() {
+		if !dryRun {
+			ingestStats.Record(time.Now(), sr.Homeserver, len(body), rec.status)
+		}
+	}()
+	if rejectDuringMaintenance(w) {
+		return
+	}
+	filter := userAgentFilterFor(r.Config.UserAgentFilters, req.UserAgent())
+	if filter != nil {
+		metrics.Inc("panopticon_filtered_pushes_total", "filter", filter.Name, "action", filter.Action)
+		if filter.Action == "drop" {
+			// Dropped without error, so that the client doesn't retry.
+			io.WriteString(w, "{}")
+			return
+		}
+	}
+	body, ok := readPushBody(w, req)
+	if !ok {
+		return
+	}
+	optedOut := false
+	if *storeRawReports && !dryRun {
+		defer func() {
+			if optedOut {
+				return
+			}
+			if err := saveRawReport(r.DB, r.Storage, req, body, rec.status); err != nil {
+				log.Printf("Error saving raw report: %v%s", err, traceField(req.Context()))
+			}
+		}()
+	}
+	if err := checkPushBody(body); err != nil {
+		logAndReplyError(w, err, 400, "Rejected report")
+		return
+	}
+	mapped, err := mapFieldPaths(body, r.Config.FieldPaths)
+	if err != nil {
+		replyError(w, err, 400, jsonErrcode(err), "Error decoding JSON")
+		return
+	}
+	version, mapped, err := payloadSchemaVersion(req, mapped)
+	if err != nil {
+		logAndReplyError(w, err, 400, "Rejected report")
 		return
 	}
+	schema, err := r.Config.payloadSchema(version)
+	if err != nil {
+		logAndReplyError(w, err, 400, "Rejected report")
+		return
+	}
+	if schema != nil {
+		if mapped, err = applyTransforms(mapped, schema.Transforms); err != nil {
+			logAndReplyError(w, err, 400, "Rejected report")
+			return
+		}
+	}
+	if mapped, err = applyTransforms(mapped, r.Config.Transforms); err != nil {
+		logAndReplyError(w, err, 400, "Rejected report")
+		return
+	}
+	// Without delta_reports, delta_of is ignored like any unknown field.
+	var delta bool
+	if featureEnabled("delta_reports") {
+		mapped, delta, err = r.applyDelta(mapped, strings.HasPrefix(req.Header.Get("User-Agent"), "Dendrite"))
+	}
+	if err != nil {
+		switch err {
+		case errBadDeltaOf:
+			replyError(w, err, 400, errcodeValidationFailed, "Rejected report")
+		case errUnknownDeltaBase:
+			metrics.Inc("panopticon_delta_reports_total", "result", "unknown_base")
+			replyError(w, err, http.StatusConflict, errcodeUnknownDeltaBase, "Rejected report")
+		default:
+			logAndReplyError(w, err, 500, "Error loading delta base")
+		}
+		return
+	}
+	if delta {
+		metrics.Inc("panopticon_delta_reports_total", "result", "ok")
+	}
+	if err := schema.check(mapped, strings.HasPrefix(req.Header.Get("User-Agent"), "Dendrite"), r.Storage.StringMetrics); err != nil {
+		logAndReplyError(w, err, 400, "Rejected report")
+		return
+	}
+	clean, adjusted, floats, err := sanitizeNumbers(mapped, r.Storage.Fields)
+	if err != nil {
+		logAndReplyError(w, err, 400, "Rejected report")
+		return
+	}
+	if err := json.NewDecoder(bytes.NewReader(clean)).Decode(&sr); err != nil {
+		replyError(w, err, 400, jsonErrcode(err), "Error decoding JSON")
+		return
+	}
+	// Settings are configured by name, but only the stored name is kept.
+	name := sr.Homeserver
+	sr.Homeserver = r.Storage.storedHomeserver(name)
+	sr.SchemaVersion = version
+	if filter != nil {
+		sr.UserAgentFilter = filter.Name
+	}
+	if len(adjusted) > 0 {
+		log.Printf("Adjusted out of range fields from %s: %s%s", sr.Homeserver, strings.Join(adjusted, ", "), traceField(req.Context()))
+		metrics.Add("panopticon_adjusted_fields_total", float64(len(adjusted)))
+		sr.AdjustedFields = strings.Join(adjusted, ",")
+	}
+	sr.Floats = floats
+	sr.Strings = stringMetricValues(mapped, r.Storage.StringMetrics)
+	if optedOut, err = isOptedOut(r.DB, r.Storage, name); err != nil {
+		logAndReplyError(w, err, 500, "Error checking opt-outs")
+		return
+	}
+	if optedOut {
+		// Dropped without error, so that the homeserver doesn't retry.
+		metrics.Inc("panopticon_opted_out_reports_total")
+		io.WriteString(w, "{}")
+		return
+	}
+	if _, backfilled := backfillTimestamp(req.Context()); !dryRun && !backfilled {
+		wait, err := tooSoonAfterLastReport(r.DB, sr.Homeserver, time.Now().UTC().Unix(), r.minReportInterval(name))
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error checking report interval")
+			return
+		}
+		if wait > 0 {
+			metrics.Inc("panopticon_early_reports_total")
+			replyRetryLater(w, http.StatusTooManyRequests, errcodeRateLimited, wait, "Refused push",
+				fmt.Errorf("%s reported again within %s", sr.Homeserver, r.minReportInterval(name)))
+			return
+		}
+	}
+	var tenant string
+	if !dryRun {
+		var refused bool
+		if tenant, refused = r.checkQuota(w, req, name, len(body)); refused {
+			return
+		}
+		if err := r.Limiter.Acquire(req.Context()); err != nil {
+			replyRetryLater(w, http.StatusTooManyRequests, errcodeRateLimited, *writeQueueRetryAfter, "Refused push", err)
+			return
+		}
+		defer r.Limiter.Release()
+	}
 	sr.LocalTimestamp = time.Now().UTC().Unix()
+	if ts, ok := backfillTimestamp(req.Context()); ok {
+		backfilled := true
+		sr.LocalTimestamp = ts
+		sr.Backfilled = &backfilled
+	}
 	sr.RemoteAddr = req.RemoteAddr
+	if ip, family := clientIP(req); ip != "" {
+		sr.RemoteIP = ip
+		sr.RemoteIPFamily = &family
+	}
 	sr.XForwardedFor = req.Header.Get("X-Forwarded-For")
+	if sr.XForwardedFor == "" {
+		sr.XForwardedFor = strings.Join(forwardedFor(req.Header.Values("Forwarded")), ", ")
+	}
 	sr.UserAgent = req.Header.Get("User-Agent")
-	if err := r.Save(sr, strings.HasPrefix(sr.UserAgent, "Dendrite")); err != nil {
+	tombstoned, err := isTombstoned(r.DB, sr.Homeserver)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error checking tombstones")
+		return
+	}
+	if tombstoned {
+		logAndReplyError(w, fmt.Errorf("%s is decommissioned", sr.Homeserver), 410, "Rejected report")
+		return
+	}
+	if *verificationPeriod > 0 && featureEnabled("verification") {
+		verified, err := isVerified(r.DB, sr.Homeserver, sr.LocalTimestamp)
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error checking verification")
+			return
+		}
+		sr.Verified = &verified
+	}
+	isDendrite := strings.HasPrefix(sr.UserAgent, "Dendrite")
+	table := "stats"
+	if isDendrite {
+		table = "dendrite_stats"
+	}
+	reason, err := r.staleReason(table, &sr.ReportStatsSynapse.CommonStats)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error checking for stale report")
+		return
+	}
+	if reason != "" {
+		if r.StaleReports == "reject" {
+			logAndReplyError(w, fmt.Errorf("%s: %s", sr.Homeserver, reason), 409, "Rejected stale report")
+			return
+		}
+		stale := true
+		sr.Stale = &stale
+	}
+	var result *PushResult
+	if rate := r.sampleRate(req, name); rate > 1 && sr.Backfilled == nil {
+		sr.SampleRate = &rate
+	}
+	interval := r.downsampleInterval(name)
+	downsample, err := withinDownsampleInterval(r.DB, r.Storage, table, &sr.ReportStatsSynapse.CommonStats, interval)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error checking downsampling")
+		return
+	}
+	if dryRun {
+		result = dryRunResult(sr, isDendrite, downsample)
+	} else if sr.SampleRate != nil && sampledOut(*sr.SampleRate) {
+		result = &PushResult{ID: 0, Table: table, Stored: []string{}, SampledOut: true}
+		err = touchHomeserver(r.DB, dialectFor(r.DB), sr.Homeserver, sr.LocalTimestamp)
+	} else if downsample {
+		result = &PushResult{ID: 0, Table: table, Stored: []string{}, Downsampled: true}
+		err = inWriteTx(r.DB, func(tx *sql.Tx) error {
+			d := dialectFor(r.DB)
+			if err := recordDownsampled(tx, d, sr.Homeserver, sr.LocalTimestamp, interval); err != nil {
+				return err
+			}
+			return touchHomeserver(tx, d, sr.Homeserver, sr.LocalTimestamp)
+		})
+	} else {
+		result, err = r.Save(sr, isDendrite)
+	}
+	if err != nil {
 		logAndReplyError(w, err, 500, "Error saving to DB")
 		return
 	}
+	if tenant != "" {
+		if err := countQuota(r.DB, tenant, int64(len(body)), time.Now()); err != nil {
+			log.Printf("Error counting push against the quota of %s: %v", tenant, err)
+		}
+	}
+	if dryRun {
+		result.Ignored = unknownFields(mapped, isDendrite, r.Storage.StringMetrics)
+		writeJSON(w, result)
+		return
+	}
+	if req.URL.Query().Get("verbose") == "1" {
+		result.Ignored = unknownFields(mapped, isDendrite, r.Storage.StringMetrics)
+		json.NewEncoder(w).Encode(result)
+		return
+	}
+	if delta || req.URL.Query().Get("delta") == "1" {
+		replyDelta(w, result)
+		return
+	}
+	if e, ok := r.Config.PushEndpoints[req.URL.Path]; ok {
+		e.Reply(w, result)
+		return
+	}
 	io.WriteString(w, "{}")
 }
 
-func (r *Recorder) Save(sr StatsReport, isDendrite bool) error {
+func (r *Recorder) Save(sr StatsReport, isDendrite bool) (*PushResult, error) {
+	var (
+		res PushResult
+		err error
+	)
+	// Write targets get the same id, so that their rows can be merged back.
+	id := r.Storage.newRowID()
+	if err := injectFault("primary"); err != nil {
+		metrics.Inc("panopticon_target_writes_total", "target", "primary", "result", "error")
+		return nil, err
+	}
+	var ins *reportInsert
 	if isDendrite {
 		s := sr.ReportStatsDendrite
 		s.Common = sr.ReportStatsSynapse.CommonStats
-		return s.Save(r.DB)
+		res.Table = "dendrite_stats"
+		ins, res.Stored, err = s.prepareSave(r.DB, r.Storage, id)
+	} else {
+		res.Table = "stats"
+		ins, res.Stored, err = sr.ReportStatsSynapse.prepareSave(r.DB, r.Storage, id)
+	}
+	if err == nil {
+		// The row, its string metrics and its homeserver's entry are
+		// written together, so that a failure part way through can't leave
+		// them disagreeing.
+		err = inWriteTx(r.DB, func(tx *sql.Tx) error {
+			var err error
+			if res.ID, err = ins.exec(tx); err != nil {
+				return err
+			}
+			d := dialectFor(r.DB)
+			if err := saveStringMetrics(tx, d, sr.Homeserver, sr.LocalTimestamp, sr.Strings); err != nil {
+				return err
+			}
+			return touchHomeserver(tx, d, sr.Homeserver, sr.LocalTimestamp)
+		})
+	}
+	if err != nil {
+		metrics.Inc("panopticon_target_writes_total", "target", "primary", "result", "error")
+		return nil, err
+	}
+	metrics.Inc("panopticon_target_writes_total", "target", "primary", "result", "ok")
+	if err := r.saveToTargets(sr, isDendrite, id); err != nil {
+		return nil, err
+	}
+	return &res, nil
+}
+
+// insertRow inserts a row into table and returns its ID.
+func insertRow(db *sql.DB, table string, cols []string, vals []interface{}) (int64, error) {
+	d := dialectFor(db)
+	qry := d.insert(table, cols...)
+	if d.returnsIDs() {
+		var id int64
+		err := db.QueryRow(qry+" RETURNING id", vals...).Scan(&id)
+		return id, err
+	}
+	res, err := db.Exec(qry, vals...)
+	if err != nil {
+		return 0, err
+	}
+	return res.LastInsertId()
+}
+
+// reportInsert is the insert of a row into a stats table. Reports always
+// have the same columns, so the statement is prepared once, and before any
+// transaction it runs in.
+type reportInsert struct {
+	stmt      *sql.Stmt
+	id        string // "" if the database assigns it
+	returning bool   // Whether the statement returns the assigned id
+	vals      []interface{}
+}
+
+func prepareReport(db *sql.DB, s *Storage, table, id string, cols []string, vals []interface{}) (*reportInsert, error) {
+	d := dialectFor(db)
+	vals = s.Encryption.encryptRow(cols, vals)
+	ins := &reportInsert{id: id, vals: vals}
+	if id != "" {
+		cols = append([]string{"id"}, cols...)
+		ins.vals = append([]interface{}{id}, vals...)
+	}
+	qry := d.insert(table, cols...)
+	if id == "" && d.returnsIDs() {
+		qry += " RETURNING id"
+		ins.returning = true
+	}
+	var err error
+	ins.stmt, err = prepare(db, qry)
+	return ins, err
+}
+
+// exec inserts the row, within tx unless it is nil, and returns its ID.
+func (ins *reportInsert) exec(tx *sql.Tx) (interface{}, error) {
+	stmt := ins.stmt
+	if tx != nil {
+		stmt = tx.Stmt(stmt)
+	}
+	if ins.id != "" {
+		_, err := stmt.Exec(ins.vals...)
+		return ins.id, err
+	}
+	if ins.returning {
+		var rowID int64
+		err := stmt.QueryRow(ins.vals...).Scan(&rowID)
+		return rowID, err
+	}
+	res, err := stmt.Exec(ins.vals...)
+	if err != nil {
+		return nil, err
+	}
+	return res.LastInsertId()
+}
+
+type statementKey struct {
+	db  *sql.DB
+	qry string
+}
+
+// statements caches prepared statements for the lifetime of the process.
+var statements sync.Map // statementKey -> *sql.Stmt
+
+// prepare returns a prepared statement for qry on db, preparing it the
+// first time it is used.
+func prepare(db *sql.DB, qry string) (*sql.Stmt, error) {
+	key := statementKey{db, qry}
+	if stmt, ok := statements.Load(key); ok {
+		return stmt.(*sql.Stmt), nil
+	}
+	stmt, err := db.Prepare(qry)
+	if err != nil {
+		return nil, err
+	}
+	if prev, loaded := statements.LoadOrStore(key, stmt); loaded {
+		stmt.Close()
+		return prev.(*sql.Stmt), nil
+	}
+	return stmt, nil
+}
+
+// createIndex creates an index unless it already exists.
+func createIndex(db *sql.DB, name, table, columns string) error {
+	driver := driverFor(db)
+	if driver == "duckdb" {
+		// DuckDB scans columns quickly without them, and updating an indexed
+		// column trips its primary key checks.
+		return nil
+	}
+	if driver != "mysql" {
+		_, err := db.Exec(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s(%s)", name, table, columns))
+		return err
+	}
+	// MySQL has no CREATE INDEX IF NOT EXISTS.
+	schema := "DATABASE()"
+	args := []interface{}{table, name}
+	if i := strings.LastIndex(table, "."); i >= 0 {
+		schema = "?"
+		args = []interface{}{table[:i], table[i+1:], name}
+	}
+	var n int
+	err := db.QueryRow(
+		fmt.Sprintf("SELECT COUNT(*) FROM information_schema.statistics WHERE table_schema = %s AND table_name = ? AND index_name = ?", schema),
+		args...,
+	).Scan(&n)
+	if err != nil || n > 0 {
+		return err
+	}
+	_, err = db.Exec(fmt.Sprintf("CREATE INDEX %s ON %s(%s)", name, table, columns))
+	return err
+}
+
+// unknownFields returns the top level keys of a JSON object which don't
+// correspond to any field of the report type they were stored as.
+func unknownFields(body []byte, isDendrite bool, stringMetrics map[string]*StringMetric) []string {
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+	known := jsonFieldNames(reflect.TypeOf(CommonStats{}))
+	if isDendrite {
+		known = append(known, jsonFieldNames(reflect.TypeOf(ReportStatsDendrite{}))...)
+	} else {
+		known = append(known, jsonFieldNames(reflect.TypeOf(ReportStatsSynapse{}))...)
+	}
+	ignored := []string{}
+	for key := range raw {
+		_, found := stringMetrics[key]
+		for _, k := range known {
+			// encoding/json matches keys case-insensitively
+			if strings.EqualFold(k, key) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ignored = append(ignored, key)
+		}
+	}
+	sort.Strings(ignored)
+	return ignored
+}
+
+// jsonFieldNames lists the JSON keys encoding/json would decode into t,
+// excluding embedded and nested structs.
+func jsonFieldNames(t reflect.Type) []string {
+	var names []string
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.Anonymous || f.Type.Kind() == reflect.Struct {
+			continue
+		}
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
 	}
-	return sr.ReportStatsSynapse.Save(r.DB)
+	return names
 }
 
 func appendIfNonNilBool(cols []string, vals []interface{}, name string, value *bool) ([]string, []interface{}) {
@@ -174,9 +947,7 @@ func appendIfNonEmpty(cols []string, vals []interface{}, name string, value stri
 }
 
 func logAndReplyError(w http.ResponseWriter, err error, code int, description string) {
-	log.Printf("%s: %v", description, err)
-	w.WriteHeader(code)
-	io.WriteString(w, `{"error_message": "unable to process request"}`)
+	replyError(w, err, code, errcodeForStatus(code), description)
 }
 
 func serveText(s string) func(http.ResponseWriter, *http.Request) {
@@ -0,0 +1,92 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"net/http"
+	"sync"
+	"time"
+)
+
+var (
+	readOnly              = flag.Bool("read-only", false, "start in maintenance mode, rejecting pushes with 503")
+	maintenanceRetryAfter = flag.Duration("maintenance-retry-after", 10*time.Minute, "Retry-After sent to reporters during maintenance")
+)
+
+// maintenance is the process-wide read-only switch. While it is enabled
+// /push is refused, so that reporters retry after the schema migration or
+// DB maintenance is over, but everything else keeps working.
+var maintenance = &maintenanceMode{}
+
+type maintenanceMode struct {
+	mu         sync.Mutex
+	enabled    bool
+	retryAfter int64 // Seconds
+}
+
+func (m *maintenanceMode) get() (bool, int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.enabled, m.retryAfter
+}
+
+func (m *maintenanceMode) set(enabled bool, retryAfter int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = enabled
+	m.retryAfter = retryAfter
+}
+
+// rejectDuringMaintenance replies 503 and returns true if pushes are
+// currently refused.
+func rejectDuringMaintenance(w http.ResponseWriter) bool {
+	enabled, retryAfter := maintenance.get()
+	if !enabled {
+		return false
+	}
+	replyRetryLater(w, http.StatusServiceUnavailable, errcodeStorageUnavailable, time.Duration(retryAfter)*time.Second,
+		"Refused push", errors.New("down for maintenance"))
+	return true
+}
+
+// serveMaintenance serves /admin/maintenance: GET reports the current mode
+// and POST {"enabled": true, "retry_after": 600} changes it.
+func serveMaintenance(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var body struct {
+			Enabled    bool   `json:"enabled"`
+			RetryAfter *int64 `json:"retry_after"`
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil {
+			logAndReplyError(w, err, 400, "Error decoding maintenance mode")
+			return
+		}
+		retryAfter := int64(maintenanceRetryAfter.Seconds())
+		if body.RetryAfter != nil {
+			retryAfter = *body.RetryAfter
+		}
+		maintenance.set(body.Enabled, retryAfter)
+	default:
+		methodNotAllowed(w, req, http.MethodGet, http.MethodPost)
+		return
+	}
+	enabled, retryAfter := maintenance.get()
+	writeJSON(w, map[string]interface{}{"enabled": enabled, "retry_after": retryAfter})
+}
@@ -0,0 +1,90 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"time"
+)
+
+var (
+	memoryMaxReports   = flag.Int("memory-max-reports", 100000, "memory driver: keep at most this many reports in each stats table, dropping the oldest")
+	memoryDump         = flag.String("memory-dump", "", "memory driver: periodically, and on shutdown, write the database to this sqlite file")
+	memoryDumpInterval = flag.Duration("memory-dump-interval", 5*time.Minute, "how often to write the -memory-dump file")
+)
+
+// openMemoryDB opens the database of the memory driver: an sqlite database
+// which only lives in memory, so every query and migration works as it does
+// with sqlite3. name only tells apart databases within one process.
+func openMemoryDB(name string) (*sql.DB, error) {
+	db, err := sql.Open("sqlite3", "file:"+name+"?mode=memory&cache=shared")
+	if err != nil {
+		return nil, err
+	}
+	// The database is dropped when its last connection closes, and
+	// connections sharing its cache get "table is locked" errors rather
+	// than waiting for each other, so they're funnelled through one which
+	// is kept open.
+	db.SetMaxOpenConns(1)
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+	dbDrivers.Store(db, "sqlite3")
+	return db, db.Ping()
+}
+
+// limitMemoryReports turns the stats tables into ring buffers holding the
+// last max reports, so that a long soak test can't exhaust memory.
+func limitMemoryReports(db *sql.DB, s *Storage, max int) error {
+	if max <= 0 {
+		return nil
+	}
+	evict := "DELETE FROM %[1]s WHERE id <= NEW.id - %[2]d"
+	if s.generatedIDs() {
+		// Generated ids sort by time but can't be counted back from.
+		evict = "DELETE FROM %[1]s WHERE id IN (SELECT id FROM %[1]s ORDER BY id DESC LIMIT -1 OFFSET %[2]d)"
+	}
+	for _, table := range []string{s.tableName("stats"), s.tableName("dendrite_stats")} {
+		_, err := db.Exec(fmt.Sprintf(`CREATE TRIGGER IF NOT EXISTS %[1]s_ring_buffer AFTER INSERT ON %[1]s
+			BEGIN `+evict+`; END`, table, max))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// memoryDumpJob is the memory_dump job.
+func memoryDumpJob(db *sql.DB, path string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return takeSnapshot(ctx, db, path)
+	}
+}
+
+// dumpMemoryDB writes the memory database to -memory-dump one last time,
+// once pushes have drained on shutdown.
+func dumpMemoryDB(db *sql.DB) {
+	if *dbDriver != "memory" || *memoryDump == "" {
+		return
+	}
+	if err := takeSnapshot(context.Background(), db, *memoryDump); err != nil {
+		log.Printf("Error dumping memory database: %v", err)
+		return
+	}
+	log.Printf("Dumped memory database to %s", *memoryDump)
+}
@@ -0,0 +1,214 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+// mergeStats counts what merging one table from one source did.
+type mergeStats struct {
+	copied, duplicates int
+	dropped            map[string]bool // Source columns the destination lacks
+}
+
+// runMerge implements "panopticon merge", which copies the reports of
+// several stats databases, such as those of per-region collectors, into
+// one, skipping reports the destination already has.
+func runMerge(args []string) int {
+	fs := flag.NewFlagSet("merge", flag.ExitOnError)
+	fs.StringVar(dbDriver, "db-driver", "sqlite3", "the driver of the database to merge into")
+	fs.StringVar(dbPath, "db", "stats.db", "the database to merge into, created if need be")
+	fs.StringVar(idType, "id-type", "integer", "primary keys of the stats tables, if they are created")
+	fs.StringVar(statsTable, "stats-table", "stats", "the table Synapse reports are stored in, in every database")
+	fs.StringVar(dendriteStatsTable, "dendrite-stats-table", "dendrite_stats", "the table Dendrite reports are stored in, in every database")
+	sourceDriver := fs.String("source-driver", "sqlite3", "the driver of the databases to merge from")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: panopticon merge [flags] <source DSN>...")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if fs.NArg() == 0 || *dbDriver == "memory" || *sourceDriver == "memory" {
+		fs.Usage()
+		return 2
+	}
+	storage := storageFromFlags()
+	if err := storage.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+
+	dest, err := openDB(storage.Driver, *dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", *dbPath, err)
+		return 1
+	}
+	defer dest.Close()
+	if err := createTables(dest, storage); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating tables: %v\n", err)
+		return 1
+	}
+	if _, err := checkSchema(dest, storage.tables(), true); err != nil {
+		fmt.Fprintf(os.Stderr, "Error migrating %s: %v\n", *dbPath, err)
+		return 1
+	}
+
+	for _, dsn := range fs.Args() {
+		src, err := openDB(*sourceDriver, dsn)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", dsn, err)
+			return 1
+		}
+		for _, table := range []string{storage.tableName("stats"), storage.tableName("dendrite_stats")} {
+			seen, err := reportKeys(dest, table)
+			if err != nil {
+				src.Close()
+				fmt.Fprintf(os.Stderr, "Error reading %s: %v\n", table, err)
+				return 1
+			}
+			s, err := mergeTable(dest, src, storage, table, seen)
+			if err != nil {
+				src.Close()
+				fmt.Fprintf(os.Stderr, "Error merging %s from %s: %v\n", table, dsn, err)
+				return 1
+			}
+			fmt.Printf("%s %s: copied %d, skipped %d duplicates\n", dsn, table, s.copied, s.duplicates)
+			if len(s.dropped) > 0 {
+				dropped := make([]string, 0, len(s.dropped))
+				for c := range s.dropped {
+					dropped = append(dropped, c)
+				}
+				sort.Strings(dropped)
+				fmt.Printf("  dropped unknown columns %v\n", dropped)
+			}
+		}
+		src.Close()
+	}
+	return 0
+}
+
+// reportKey identifies a report by its homeserver, timestamp and payload:
+// the hash of every non-null column except the id, which differs between
+// instances numbering their own rows.
+func reportKey(row map[string]interface{}) string {
+	values := make(map[string]interface{}, len(row))
+	for col, v := range row {
+		if col != "id" && v != nil {
+			values[col] = v
+		}
+	}
+	// Maps encode with sorted keys, so equal rows hash alike.
+	b, _ := json.Marshal(values)
+	sum := sha256.Sum256(b)
+	return string(sum[:])
+}
+
+// reportKeys returns the keys of the reports already in a table.
+func reportKeys(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT * FROM " + table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	seen := map[string]bool{}
+	err = eachRow(rows, func(row map[string]interface{}) error {
+		seen[reportKey(row)] = true
+		return nil
+	})
+	return seen, err
+}
+
+// eachRow calls f with every row, converted as for the read API.
+func eachRow(rows *sql.Rows, f func(map[string]interface{}) error) error {
+	types, err := rows.ColumnTypes()
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		row, err := scanRow(rows, types)
+		if err != nil {
+			return err
+		}
+		if err := f(row); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// mergeTable copies the reports of table in src which aren't in seen to
+// dest. If storage generates ids, generated ids are kept, since they are
+// unique across instances, and other rows get new ones; otherwise dest
+// numbers the rows.
+// Rows are inserted one at a time, so an interrupted merge can simply be
+// run again.
+func mergeTable(dest, src *sql.DB, storage *Storage, table string, seen map[string]bool) (*mergeStats, error) {
+	live, err := liveColumns(dest, table)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := src.Query("SELECT * FROM " + table + " ORDER BY id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	d := dialectFor(dest)
+	s := &mergeStats{dropped: map[string]bool{}}
+	err = eachRow(rows, func(row map[string]interface{}) error {
+		key := reportKey(row)
+		if seen[key] {
+			s.duplicates++
+			return nil
+		}
+		var cols []string
+		var vals []interface{}
+		if storage.generatedIDs() {
+			id, generated := row["id"].(string)
+			if !generated {
+				id = storage.newRowID()
+			}
+			cols, vals = append(cols, "id"), append(vals, id)
+		}
+		for col, v := range row {
+			if v == nil || col == "id" {
+				continue
+			}
+			if !live[col] {
+				s.dropped[col] = true
+				continue
+			}
+			cols = append(cols, col)
+			vals = append(vals, v)
+		}
+		if _, err := dest.Exec(d.insert(table, cols...), vals...); err != nil {
+			return err
+		}
+		homeserver, _ := row["homeserver"].(string)
+		ts, _ := row["local_timestamp"].(int64)
+		if err := touchHomeserver(dest, d, homeserver, ts); err != nil {
+			return err
+		}
+		seen[key] = true
+		s.copied++
+		return nil
+	})
+	return s, err
+}
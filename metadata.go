@@ -0,0 +1,274 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// HomeserverMetadata is what admins record about a homeserver, such as who
+// runs it. Tags such as "internal" or "public" can be used to filter it out
+// of or into figures.
+type HomeserverMetadata struct {
+	Homeserver   string   `json:"homeserver"`
+	DisplayName  string   `json:"display_name"`
+	OwnerContact string   `json:"owner_contact"`
+	Tags         []string `json:"tags"`
+	Notes        string   `json:"notes"`
+	UpdatedAt    int64    `json:"updated_at"`
+}
+
+func createTableHomeserverMetadata(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS homeserver_metadata(
+		homeserver VARCHAR(256) NOT NULL PRIMARY KEY,
+		display_name TEXT,
+		owner_contact TEXT,
+		notes TEXT,
+		updated_at BIGINT
+		)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS homeserver_tags(
+		homeserver VARCHAR(256) NOT NULL,
+		tag VARCHAR(64) NOT NULL,
+		PRIMARY KEY (homeserver, tag)
+		)`)
+	return err
+}
+
+// loadMetadata returns the metadata of the given homeservers, or of all of
+// them if none are given, keyed by homeserver.
+func loadMetadata(db *sql.DB, homeservers ...string) (map[string]*HomeserverMetadata, error) {
+	d := dialectFor(db)
+	var where string
+	var args []interface{}
+	if len(homeservers) > 0 {
+		var ps []string
+		for _, hs := range homeservers {
+			args = append(args, hs)
+			ps = append(ps, d.placeholder(len(args)))
+		}
+		where = " WHERE homeserver IN (" + strings.Join(ps, ", ") + ")"
+	}
+	rows, err := db.Query("SELECT homeserver, display_name, owner_contact, notes, updated_at FROM homeserver_metadata"+where, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	result := map[string]*HomeserverMetadata{}
+	for rows.Next() {
+		m := &HomeserverMetadata{Tags: []string{}}
+		var displayName, ownerContact, notes sql.NullString
+		var updatedAt sql.NullInt64
+		if err := rows.Scan(&m.Homeserver, &displayName, &ownerContact, &notes, &updatedAt); err != nil {
+			return nil, err
+		}
+		m.DisplayName, m.OwnerContact, m.Notes, m.UpdatedAt = displayName.String, ownerContact.String, notes.String, updatedAt.Int64
+		result[m.Homeserver] = m
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = db.Query("SELECT homeserver, tag FROM homeserver_tags"+where+" ORDER BY tag", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hs, tag string
+		if err := rows.Scan(&hs, &tag); err != nil {
+			return nil, err
+		}
+		if m, ok := result[hs]; ok {
+			m.Tags = append(m.Tags, tag)
+		}
+	}
+	return result, rows.Err()
+}
+
+func saveMetadata(db *sql.DB, m *HomeserverMetadata) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if err := deleteMetadata(tx, dialectFor(db), m.Homeserver); err != nil {
+		return err
+	}
+	_, err = tx.Exec(
+		dialectFor(db).insert("homeserver_metadata", "homeserver", "display_name", "owner_contact", "notes", "updated_at"),
+		m.Homeserver, m.DisplayName, m.OwnerContact, m.Notes, m.UpdatedAt,
+	)
+	if err != nil {
+		return err
+	}
+	for _, tag := range m.Tags {
+		_, err := tx.Exec(
+			dialectFor(db).insert("homeserver_tags", "homeserver", "tag"),
+			m.Homeserver, tag,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func deleteMetadata(tx *sql.Tx, d dialect, homeserver string) error {
+	if _, err := tx.Exec("DELETE FROM homeserver_metadata WHERE homeserver = "+d.placeholder(1), homeserver); err != nil {
+		return err
+	}
+	_, err := tx.Exec("DELETE FROM homeserver_tags WHERE homeserver = "+d.placeholder(1), homeserver)
+	return err
+}
+
+// attachMetadata sets the "metadata" key of each row to the metadata of its
+// homeserver, or null if there is none.
+func attachMetadata(db *sql.DB, rows []map[string]interface{}) error {
+	seen := map[string]bool{}
+	var homeservers []string
+	for _, row := range rows {
+		if hs, ok := row["homeserver"].(string); ok && !seen[hs] {
+			seen[hs] = true
+			homeservers = append(homeservers, hs)
+		}
+	}
+	if len(homeservers) == 0 {
+		return nil
+	}
+	metadata, err := loadMetadata(db, homeservers...)
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		hs, _ := row["homeserver"].(string)
+		if m, ok := metadata[hs]; ok {
+			row["metadata"] = m
+		} else {
+			row["metadata"] = nil
+		}
+	}
+	return nil
+}
+
+// tagConditions returns SQL conditions on the homeserver column restricting
+// results to homeservers with every tag given by the "tag" query parameter
+// and none given by "exclude_tag". Both may be repeated. Arguments are
+// appended to args.
+func tagConditions(q url.Values, d dialect, column string, args *[]interface{}) []string {
+	var where []string
+	for _, tag := range normaliseTags(q["tag"]) {
+		*args = append(*args, tag)
+		where = append(where, fmt.Sprintf("%s IN (SELECT homeserver FROM homeserver_tags WHERE tag = %s)", column, d.placeholder(len(*args))))
+	}
+	if excluded := normaliseTags(q["exclude_tag"]); len(excluded) > 0 {
+		var ps []string
+		for _, tag := range excluded {
+			*args = append(*args, tag)
+			ps = append(ps, d.placeholder(len(*args)))
+		}
+		where = append(where, fmt.Sprintf("%s NOT IN (SELECT homeserver FROM homeserver_tags WHERE tag IN (%s))", column, strings.Join(ps, ", ")))
+	}
+	return where
+}
+
+// normaliseTags lower-cases, de-duplicates and sorts tags.
+func normaliseTags(tags []string) []string {
+	seen := map[string]bool{}
+	result := []string{}
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			result = append(result, tag)
+		}
+	}
+	sort.Strings(result)
+	return result
+}
+
+// HomeserverMetadataHandler serves /admin/homeservers: GET lists metadata
+// (optionally ?homeserver=...), POST sets the metadata of a homeserver,
+// replacing what was there, and DELETE ?homeserver=... removes it.
+type HomeserverMetadataHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *HomeserverMetadataHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	switch req.Method {
+	case http.MethodGet:
+		var homeservers []string
+		if hs := req.URL.Query().Get("homeserver"); hs != "" {
+			homeservers = append(homeservers, h.Storage.storedHomeserver(hs))
+		}
+		metadata, err := loadMetadata(h.DB, homeservers...)
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error listing homeserver metadata")
+			return
+		}
+		result := []*HomeserverMetadata{}
+		for _, m := range metadata {
+			result = append(result, m)
+		}
+		sort.Slice(result, func(i, j int) bool { return result[i].Homeserver < result[j].Homeserver })
+		writeJSON(w, result)
+	case http.MethodPost:
+		var m HomeserverMetadata
+		if err := json.NewDecoder(req.Body).Decode(&m); err != nil {
+			logAndReplyError(w, err, 400, "Error decoding homeserver metadata")
+			return
+		}
+		if m.Homeserver == "" {
+			logAndReplyError(w, errors.New("missing homeserver"), 400, "Error decoding homeserver metadata")
+			return
+		}
+		m.Homeserver = h.Storage.storedHomeserver(m.Homeserver)
+		m.Tags = normaliseTags(m.Tags)
+		m.UpdatedAt = time.Now().UTC().Unix()
+		if err := saveMetadata(h.DB, &m); err != nil {
+			logAndReplyError(w, err, 500, "Error saving homeserver metadata")
+			return
+		}
+		writeJSON(w, m)
+	case http.MethodDelete:
+		homeserver := h.Storage.storedHomeserver(req.URL.Query().Get("homeserver"))
+		tx, err := h.DB.Begin()
+		if err == nil {
+			if err = deleteMetadata(tx, dialectFor(h.DB), homeserver); err == nil {
+				err = tx.Commit()
+			} else {
+				tx.Rollback()
+			}
+		}
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error removing homeserver metadata")
+			return
+		}
+		writeJSON(w, struct{}{})
+	default:
+		methodNotAllowed(w, req, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
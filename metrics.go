@@ -0,0 +1,80 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// metrics is a minimal registry of counters and gauges, served in the
+// Prometheus text exposition format on /metrics.
+var metrics = &metricRegistry{values: map[string]float64{}}
+
+type metricRegistry struct {
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// metricKey renders a metric name and alternating label names and values
+// as a series identifier, e.g. `panopticon_job_runs_total{job="prune"}`.
+func metricKey(name string, labels ...string) string {
+	if len(labels) == 0 {
+		return name
+	}
+	var pairs []string
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
+
+func (m *metricRegistry) Add(name string, delta float64, labels ...string) {
+	key := metricKey(name, labels...)
+	m.mu.Lock()
+	m.values[key] += delta
+	m.mu.Unlock()
+}
+
+func (m *metricRegistry) Inc(name string, labels ...string) {
+	m.Add(name, 1, labels...)
+}
+
+func (m *metricRegistry) Set(name string, value float64, labels ...string) {
+	key := metricKey(name, labels...)
+	m.mu.Lock()
+	m.values[key] = value
+	m.mu.Unlock()
+}
+
+func (m *metricRegistry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	m.mu.Lock()
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s %v\n", k, m.values[k])
+	}
+	m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	io.WriteString(w, b.String())
+}
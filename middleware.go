@@ -0,0 +1,337 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"compress/gzip"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// endpointGroups are the groups of endpoints whose middleware can be
+// configured separately: pushes, the read API, the admin API (including
+// backfills), and everything else, such as /metrics and /opt-out.
+var endpointGroups = []string{"push", "read", "admin", "public"}
+
+// EndpointGroup configures the middleware wrapped around the endpoints of
+// a group, besides the authentication the read and admin APIs always need.
+type EndpointGroup struct {
+	Tokens      []string `json:"tokens"`       // If set, requests need one of these bearer tokens; push and public only
+	RateLimit   float64  `json:"rate_limit"`   // Requests per second allowed from each client IP; 0 for no limit
+	RateBurst   int      `json:"rate_burst"`   // Requests a client may make at once; defaults to rate_limit, rounded up
+	LogRequests bool     `json:"log_requests"` // Log every request with its status and duration
+	CORSOrigins []string `json:"cors_origins"` // Origins allowed to make cross-origin requests; "*" for any
+	Compress    bool     `json:"compress"`     // Gzip responses for clients which accept it
+}
+
+func validateEndpointGroups(groups map[string]*EndpointGroup) error {
+	for name, g := range groups {
+		known := false
+		for _, n := range endpointGroups {
+			known = known || n == name
+		}
+		if !known {
+			return fmt.Errorf("unknown group %s; groups are %s", name, strings.Join(endpointGroups, ", "))
+		}
+		if len(g.Tokens) > 0 && (name == "read" || name == "admin") {
+			return fmt.Errorf("%s: tokens can't be set; the %s API authenticates with its own tokens", name, name)
+		}
+		for _, t := range g.Tokens {
+			if t == "" {
+				return fmt.Errorf("%s: empty token", name)
+			}
+		}
+		if g.RateLimit < 0 || g.RateBurst < 0 {
+			return fmt.Errorf("%s: negative rate_limit or rate_burst", name)
+		}
+		if g.RateBurst > 0 && g.RateLimit == 0 {
+			return fmt.Errorf("%s: rate_burst without rate_limit", name)
+		}
+		for _, o := range g.CORSOrigins {
+			if o != "*" && !strings.HasPrefix(o, "http://") && !strings.HasPrefix(o, "https://") {
+				return fmt.Errorf("%s: bad CORS origin %q", name, o)
+			}
+		}
+	}
+	return nil
+}
+
+// middleware wraps a handler with some behaviour.
+type middleware func(http.HandlerFunc) http.HandlerFunc
+
+// chain composes middleware, the first of which sees requests first.
+func chain(ms ...middleware) middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		for i := len(ms) - 1; i >= 0; i-- {
+			h = ms[i](h)
+		}
+		return h
+	}
+}
+
+// endpointGroup returns the middleware chain of a group: request logging,
+// CORS, rate limiting, tokens and compression, in that order, each only if
+// the group configures it. CORS comes before authentication so that
+// preflight requests, which carry no credentials, are answered.
+func endpointGroup(groups map[string]*EndpointGroup, name string) middleware {
+	g := groups[name]
+	if g == nil {
+		return chain()
+	}
+	var ms []middleware
+	if g.LogRequests {
+		ms = append(ms, logRequests(name))
+	}
+	if len(g.CORSOrigins) > 0 {
+		ms = append(ms, allowCORS(g.CORSOrigins))
+	}
+	if g.RateLimit > 0 {
+		burst := g.RateBurst
+		if burst == 0 {
+			burst = int(math.Ceil(g.RateLimit))
+		}
+		ms = append(ms, rateLimit(name, newRateLimiter(g.RateLimit, burst)))
+	}
+	if len(g.Tokens) > 0 && featureEnabled("group_tokens") {
+		ms = append(ms, requireGroupToken(g.Tokens))
+	}
+	if g.Compress {
+		ms = append(ms, compress)
+	}
+	return chain(ms...)
+}
+
+// groupToken returns a token accepted by a group, or "" if it needs none.
+func groupToken(groups map[string]*EndpointGroup, name string) string {
+	if g := groups[name]; g != nil && len(g.Tokens) > 0 {
+		return g.Tokens[0]
+	}
+	return ""
+}
+
+// logRequests logs each request to a group once it has been answered.
+func logRequests(group string) middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			start := time.Now()
+			rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+			h(rec, req)
+			ip, _ := clientIP(req)
+			log.Printf("%s: %s %s %s %d %s%s", group, ip, req.Method, req.URL.Path, rec.status, time.Since(start).Round(time.Millisecond), traceField(req.Context()))
+		}
+	}
+}
+
+// allowCORS lets pages from the given origins make requests, answering
+// their preflight requests itself.
+func allowCORS(origins []string) middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			origin := req.Header.Get("Origin")
+			allowed := ""
+			for _, o := range origins {
+				if o == "*" || o == origin {
+					allowed = o
+					break
+				}
+			}
+			if origin == "" || allowed == "" {
+				h(w, req)
+				return
+			}
+			header := w.Header()
+			header.Set("Access-Control-Allow-Origin", allowed)
+			header.Add("Vary", "Origin")
+			if req.Method != http.MethodOptions || req.Header.Get("Access-Control-Request-Method") == "" {
+				h(w, req)
+				return
+			}
+			header.Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE")
+			header.Set("Access-Control-Allow-Headers", "Authorization, Content-Type, Content-Encoding, traceparent, tracestate")
+			header.Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
+
+// rateLimiter is a token bucket per client IP. Like quotas, limits apply
+// per instance.
+type rateLimiter struct {
+	mu      sync.Mutex
+	rate    float64
+	burst   float64
+	buckets map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// maxRateBuckets bounds the memory used by the buckets of clients which
+// have stopped making requests; full buckets are dropped beyond it.
+const maxRateBuckets = 10000
+
+func newRateLimiter(rate float64, burst int) *rateLimiter {
+	return &rateLimiter{rate: rate, burst: float64(burst), buckets: map[string]*rateBucket{}}
+}
+
+// Allow takes a token from a client's bucket, returning how long it must
+// wait if the bucket is empty.
+func (l *rateLimiter) Allow(ip string, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	b, ok := l.buckets[ip]
+	if !ok {
+		if len(l.buckets) >= maxRateBuckets {
+			l.dropFull(now)
+		}
+		b = &rateBucket{tokens: l.burst, last: now}
+		l.buckets[ip] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return time.Duration((1 - b.tokens) / l.rate * float64(time.Second)), false
+	}
+	b.tokens--
+	return 0, true
+}
+
+func (l *rateLimiter) dropFull(now time.Time) {
+	for ip, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, ip)
+		}
+	}
+}
+
+// rateLimit refuses requests from clients which exceed a group's rate.
+func rateLimit(group string, l *rateLimiter) middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			ip, _ := clientIP(req)
+			wait, ok := l.Allow(ip, time.Now())
+			if !ok {
+				metrics.Inc("panopticon_rate_limited_requests_total", "group", group)
+				// Round up, so that a client waiting as told isn't refused again.
+				replyRetryLater(w, http.StatusTooManyRequests, errcodeRateLimited, wait.Truncate(time.Second)+time.Second, "Refused request",
+					fmt.Errorf("%s exceeded the %s rate limit", ip, group))
+				return
+			}
+			h(w, req)
+		}
+	}
+}
+
+var errNoGroupToken = errors.New("missing or unknown token")
+
+// requireGroupToken refuses requests without one of tokens.
+func requireGroupToken(tokens []string) middleware {
+	return func(h http.HandlerFunc) http.HandlerFunc {
+		return func(w http.ResponseWriter, req *http.Request) {
+			token := []byte(strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "))
+			for _, t := range tokens {
+				if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+					h(w, req)
+					return
+				}
+			}
+			replyError(w, errNoGroupToken, http.StatusUnauthorized, errcodeUnauthorized, "unauthorized")
+		}
+	}
+}
+
+// compress gzips responses for clients which accept it, unless the handler
+// already encoded them.
+func compress(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		if !acceptsGzip(req) {
+			h(w, req)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.Close()
+		h(gw, req)
+	}
+}
+
+func acceptsGzip(req *http.Request) bool {
+	for _, v := range req.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(v, ",") {
+			coding = strings.TrimSpace(coding)
+			if coding == "gzip" || strings.HasPrefix(coding, "gzip;") && !strings.HasSuffix(strings.ReplaceAll(coding, " ", ""), "q=0") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter compresses a response once its status shows it has a
+// body.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	zw          *gzip.Writer
+	wroteHeader bool
+}
+
+func (g *gzipResponseWriter) WriteHeader(code int) {
+	if g.wroteHeader {
+		return
+	}
+	g.wroteHeader = true
+	header := g.Header()
+	if code != http.StatusNoContent && code != http.StatusNotModified && header.Get("Content-Encoding") == "" {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", "gzip")
+		g.zw = gzip.NewWriter(g.ResponseWriter)
+	}
+	g.ResponseWriter.WriteHeader(code)
+}
+
+func (g *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !g.wroteHeader {
+		g.WriteHeader(http.StatusOK)
+	}
+	if g.zw == nil {
+		return g.ResponseWriter.Write(b)
+	}
+	return g.zw.Write(b)
+}
+
+func (g *gzipResponseWriter) Flush() {
+	if g.zw != nil {
+		g.zw.Flush()
+	}
+	if f, ok := g.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (g *gzipResponseWriter) Close() error {
+	if g.zw == nil {
+		return nil
+	}
+	return g.zw.Close()
+}
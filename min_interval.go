@@ -0,0 +1,58 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"time"
+)
+
+var minReportInterval = flag.Duration("min-report-interval", 0, "refuse reports arriving sooner than this after the last accepted report from the same homeserver with 429 Too Many Requests (0 to disable)")
+
+// minReportInterval returns the minimum time between accepted reports for
+// a homeserver, taking per-homeserver overrides from the config into
+// account.
+func (r *Recorder) minReportInterval(homeserver string) time.Duration {
+	if d, ok := r.Config.MinReportIntervals[homeserver]; ok {
+		return time.Duration(d)
+	}
+	return *minReportInterval
+}
+
+// tooSoonAfterLastReport returns how long a homeserver must wait before it
+// may report again, or 0 if it may report now, going by when its last
+// accepted report arrived.
+func tooSoonAfterLastReport(db *sql.DB, homeserver string, now int64, interval time.Duration) (time.Duration, error) {
+	if interval <= 0 {
+		return 0, nil
+	}
+	var last sql.NullInt64
+	err := db.QueryRow("SELECT last_seen FROM homeservers WHERE homeserver = "+dialectFor(db).placeholder(1), homeserver).Scan(&last)
+	if err == sql.ErrNoRows {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	if !last.Valid {
+		return 0, nil
+	}
+	next := last.Int64 + int64(interval.Seconds())
+	if next <= now {
+		return 0, nil
+	}
+	return time.Duration(next-now) * time.Second, nil
+}
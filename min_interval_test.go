@@ -0,0 +1,58 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTooSoonAfterLastReport(t *testing.T) {
+	db, err := openDB("sqlite3", filepath.Join(t.TempDir(), "interval.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	// A failed lookup is an error, not a homeserver which never reported.
+	if wait, err := tooSoonAfterLastReport(db, "one.turtles", 1000, time.Minute); err == nil {
+		t.Errorf("without a homeservers table: wait %s with no error", wait)
+	}
+
+	if err := createTables(db, storageFromFlags().withDriver("sqlite3")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.Exec("INSERT INTO homeservers (homeserver, first_seen, last_seen, report_count) VALUES ('one.turtles', 900, 900, 1)"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		homeserver string
+		now        int64
+		want       time.Duration
+	}{
+		{"one.turtles", 930, 30 * time.Second},
+		{"one.turtles", 960, 0},
+		{"new.turtles", 930, 0},
+	} {
+		wait, err := tooSoonAfterLastReport(db, tc.homeserver, tc.now, time.Minute)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if wait != tc.want {
+			t.Errorf("%s at %d: wait %s, want %s", tc.homeserver, tc.now, wait, tc.want)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	defaultNewHomeserversWindow = 30 * 24 * time.Hour
+	maxNewHomeserversWindow     = 366 * 24 * time.Hour
+)
+
+// NewHomeserversDay lists the homeservers first seen on a day, in UTC.
+type NewHomeserversDay struct {
+	Date        string   `json:"date"`
+	Count       *int64   `json:"count"`
+	Homeservers []string `json:"homeservers,omitempty"`
+}
+
+// NewHomeserversHandler serves /api/v1/new-homeservers, the homeservers
+// first seen on each day of a window ending today (e.g. "7d", up to a
+// year), oldest first, and how many there were in all. Decommissioned
+// homeservers aren't listed. The tag and exclude_tag parameters filter by
+// homeserver tags, and product and version by what has sent their reports
+// since. Roles which can't see homeservers, or only see
+// protected aggregates, get the counts alone.
+type NewHomeserversHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *NewHomeserversHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	q := req.URL.Query()
+	window := defaultNewHomeserversWindow
+	if v := q.Get("window"); v != "" {
+		var err error
+		if window, err = parseWindow(v); err != nil || window <= 0 || window > maxNewHomeserversWindow {
+			logAndReplyError(w, fmt.Errorf("bad window %q", v), 400, "Bad query")
+			return
+		}
+	}
+	product, ok := productFilterOf(w, req)
+	if !ok {
+		return
+	}
+	p := privacyOf(req)
+	names := p == nil && roleOf(req).visible("homeserver")
+	now := time.Now().UTC()
+	since := now.Add(-window).Unix() / oneDay * oneDay
+	args := []interface{}{since}
+	where := append([]string{
+		"first_seen >= " + d.placeholder(1),
+		"homeserver NOT IN (SELECT homeserver FROM tombstones)",
+	}, tagConditions(q, d, "homeserver", &args)...)
+	where = append(where, product.homeserverConditions(h.Storage, d, "homeserver", since, &args)...)
+	rows, err := h.DB.QueryContext(req.Context(), "SELECT homeserver, first_seen FROM homeservers WHERE "+strings.Join(where, " AND "), args...)
+	if err != nil {
+		replyQueryError(w, req, err, "Error querying homeservers")
+		return
+	}
+	defer rows.Close()
+	byDay := map[int64][]string{}
+	var total int64
+	for rows.Next() {
+		var hs string
+		var firstSeen int64
+		if err := rows.Scan(&hs, &firstSeen); err != nil {
+			logAndReplyError(w, err, 500, "Error querying homeservers")
+			return
+		}
+		day := firstSeen / oneDay
+		byDay[day] = append(byDay[day], hs)
+		total++
+	}
+	if err := rows.Err(); err != nil {
+		replyQueryError(w, req, err, "Error querying homeservers")
+		return
+	}
+	days := []*NewHomeserversDay{}
+	for day := since / oneDay; day <= now.Unix()/oneDay; day++ {
+		d := &NewHomeserversDay{
+			Date:  time.Unix(day*oneDay, 0).UTC().Format("2006-01-02"),
+			Count: p.count(int64(len(byDay[day])), "new_homeservers", fmt.Sprint(day*oneDay)),
+		}
+		if names {
+			d.Homeservers = byDay[day]
+			sort.Strings(d.Homeservers)
+		}
+		days = append(days, d)
+	}
+	writeJSON(w, map[string]interface{}{"days": days, "total": p.count(total, "new_homeservers", slidingWindow(window.String(), now))})
+}
@@ -0,0 +1,118 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/smtp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Notifier delivers a message, such as the weekly digest, to people.
+type Notifier interface {
+	Name() string
+	Notify(ctx context.Context, subject, body string) error
+}
+
+// NotifiersConfig configures where notifications are sent.
+type NotifiersConfig struct {
+	Email  *EmailConfig  `json:"email"`
+	Matrix *MatrixConfig `json:"matrix"`
+
+	Webhooks []*WebhookConfig `json:"webhooks"`
+}
+
+func newNotifiers(c NotifiersConfig) ([]Notifier, error) {
+	var notifiers []Notifier
+	if c.Email != nil {
+		if c.Email.Host == "" || c.Email.From == "" || len(c.Email.To) == 0 {
+			return nil, errors.New("email notifier needs host, from and to")
+		}
+		notifiers = append(notifiers, c.Email)
+	}
+	if c.Matrix != nil {
+		if c.Matrix.HomeserverURL == "" || c.Matrix.AccessToken == "" || c.Matrix.RoomID == "" {
+			return nil, errors.New("matrix notifier needs homeserver_url, access_token and room_id")
+		}
+		notifiers = append(notifiers, c.Matrix)
+	}
+	for _, wh := range c.Webhooks {
+		if wh.Label == "" || wh.URL == "" {
+			return nil, errors.New("webhook notifiers need a name and url")
+		}
+		if _, ok := webhookFormatters[wh.Format]; !ok {
+			return nil, fmt.Errorf("webhook %s: unknown format %q", wh.Label, wh.Format)
+		}
+		notifiers = append(notifiers, wh)
+	}
+	return notifiers, nil
+}
+
+// notifyAll sends a message through every notifier, returning the first
+// error after trying them all.
+func notifyAll(ctx context.Context, notifiers []Notifier, subject, body string) error {
+	var firstErr error
+	for _, n := range notifiers {
+		if err := n.Notify(ctx, subject, body); err != nil {
+			metrics.Inc("panopticon_notifications_total", "notifier", n.Name(), "result", "error")
+			if firstErr == nil {
+				firstErr = fmt.Errorf("%s: %v", n.Name(), err)
+			}
+			continue
+		}
+		metrics.Inc("panopticon_notifications_total", "notifier", n.Name(), "result", "ok")
+	}
+	return firstErr
+}
+
+// EmailConfig sends notifications by SMTP. STARTTLS is used if the server
+// offers it.
+type EmailConfig struct {
+	Host     string   `json:"host"`
+	Port     int      `json:"port"` // Defaults to 587
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+func (e *EmailConfig) Name() string {
+	return "email"
+}
+
+func (e *EmailConfig) Notify(ctx context.Context, subject, body string) error {
+	port := e.Port
+	if port == 0 {
+		port = 587
+	}
+	var auth smtp.Auth
+	if e.Username != "" {
+		auth = smtp.PlainAuth("", e.Username, e.Password, e.Host)
+	}
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", e.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(e.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	return smtp.SendMail(net.JoinHostPort(e.Host, strconv.Itoa(port)), auth, e.From, e.To, msg.Bytes())
+}
@@ -0,0 +1,75 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// MatrixConfig posts notifications into a Matrix room as m.notice events,
+// using the client-server API as the user the access token belongs to.
+type MatrixConfig struct {
+	HomeserverURL string `json:"homeserver_url"` // e.g. https://matrix-client.matrix.org
+	AccessToken   string `json:"access_token"`
+	RoomID        string `json:"room_id"`
+}
+
+// matrixTxnCounter makes transaction IDs unique within this process.
+var matrixTxnCounter int64
+
+func (m *MatrixConfig) Name() string {
+	return "matrix"
+}
+
+func (m *MatrixConfig) Notify(ctx context.Context, subject, body string) error {
+	content, err := json.Marshal(map[string]string{
+		"msgtype":        "m.notice",
+		"body":           subject + "\n\n" + body,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": "<p><strong>" + html.EscapeString(subject) + "</strong></p><pre>" + html.EscapeString(body) + "</pre>",
+	})
+	if err != nil {
+		return err
+	}
+	txnID := fmt.Sprintf("panopticon-%d-%d", time.Now().UnixNano(), atomic.AddInt64(&matrixTxnCounter, 1))
+	u := fmt.Sprintf("%s/_matrix/client/v3/rooms/%s/send/m.room.message/%s",
+		strings.TrimSuffix(m.HomeserverURL, "/"), url.PathEscape(m.RoomID), url.PathEscape(txnID))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u, bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+m.AccessToken)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("sending to %s: %s: %s", m.RoomID, resp.Status, msg)
+	}
+	return nil
+}
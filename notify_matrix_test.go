@@ -0,0 +1,72 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMatrixNotifier(t *testing.T) {
+	var paths []string
+	var events []map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodPut || req.Header.Get("Authorization") != "Bearer s3cret" {
+			http.Error(w, `{"errcode":"M_FORBIDDEN"}`, http.StatusForbidden)
+			return
+		}
+		var event map[string]string
+		if err := json.NewDecoder(req.Body).Decode(&event); err != nil {
+			t.Error(err)
+		}
+		paths = append(paths, req.URL.EscapedPath())
+		events = append(events, event)
+		w.Write([]byte(`{"event_id":"$1"}`))
+	}))
+	defer srv.Close()
+
+	m := &MatrixConfig{HomeserverURL: srv.URL + "/", AccessToken: "s3cret", RoomID: "!ops:turtles"}
+	for i := 0; i < 2; i++ {
+		if err := m.Notify(context.Background(), "Alert <firing>", "total_users & co"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	prefix := "/_matrix/client/v3/rooms/%21ops:turtles/send/m.room.message/"
+	if len(paths) != 2 || !strings.HasPrefix(paths[0], prefix) || !strings.HasPrefix(paths[1], prefix) {
+		t.Fatalf("sent to %q", paths)
+	}
+	if paths[0] == paths[1] {
+		t.Errorf("transaction ID %q was reused", paths[0])
+	}
+	for k, want := range map[string]string{
+		"msgtype":        "m.notice",
+		"body":           "Alert <firing>\n\ntotal_users & co",
+		"format":         "org.matrix.custom.html",
+		"formatted_body": "<p><strong>Alert &lt;firing&gt;</strong></p><pre>total_users &amp; co</pre>",
+	} {
+		if events[0][k] != want {
+			t.Errorf("%s: got %q, want %q", k, events[0][k], want)
+		}
+	}
+
+	m.AccessToken = "wrong"
+	if err := m.Notify(context.Background(), "subject", "body"); err == nil || !strings.Contains(err.Error(), "M_FORBIDDEN") {
+		t.Errorf("refused notification: got %v", err)
+	}
+}
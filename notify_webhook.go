@@ -0,0 +1,95 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// Discord rejects embeds with longer descriptions.
+const discordDescriptionLimit = 4096
+
+// WebhookConfig posts notifications to an incoming webhook, with the
+// payload shaped for the service behind it.
+type WebhookConfig struct {
+	Label  string `json:"name"`
+	URL    string `json:"url"`
+	Format string `json:"format"` // slack, discord or json
+}
+
+// webhookFormatters render a notification as the JSON payload a service
+// expects.
+var webhookFormatters = map[string]func(subject, body string) interface{}{
+	"slack": func(subject, body string) interface{} {
+		return map[string]interface{}{
+			"text": subject,
+			"blocks": []interface{}{
+				map[string]interface{}{
+					"type": "header",
+					"text": map[string]string{"type": "plain_text", "text": subject},
+				},
+				map[string]interface{}{
+					"type": "section",
+					"text": map[string]string{"type": "mrkdwn", "text": "```" + body + "```"},
+				},
+			},
+		}
+	},
+	"discord": func(subject, body string) interface{} {
+		description := "```\n" + body + "```"
+		if len(description) > discordDescriptionLimit {
+			description = description[:discordDescriptionLimit-len("…```")] + "…```"
+		}
+		return map[string]interface{}{
+			"embeds": []interface{}{
+				map[string]string{"title": subject, "description": description},
+			},
+		}
+	},
+	"json": func(subject, body string) interface{} {
+		return map[string]string{"subject": subject, "body": body}
+	},
+}
+
+func (wh *WebhookConfig) Name() string {
+	return "webhook:" + wh.Label
+}
+
+func (wh *WebhookConfig) Notify(ctx context.Context, subject, body string) error {
+	payload, err := json.Marshal(webhookFormatters[wh.Format](subject, body))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wh.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s: %s", resp.Status, msg)
+	}
+	return nil
+}
@@ -0,0 +1,86 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var payload []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("content type %q", req.Header.Get("Content-Type"))
+		}
+		payload, _ = io.ReadAll(req.Body)
+		if strings.Contains(req.URL.Path, "broken") {
+			http.Error(w, "no such hook", http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		format, want string
+	}{
+		{"slack", `{"blocks":[{"text":{"text":"Alert","type":"plain_text"},"type":"header"},{"text":{"text":"` + "```users: 5```" + `","type":"mrkdwn"},"type":"section"}],"text":"Alert"}`},
+		{"discord", `{"embeds":[{"description":"` + "```\\nusers: 5```" + `","title":"Alert"}]}`},
+		{"json", `{"body":"users: 5","subject":"Alert"}`},
+	} {
+		wh := &WebhookConfig{Label: tc.format, URL: srv.URL + "/hook", Format: tc.format}
+		if err := wh.Notify(context.Background(), "Alert", "users: 5"); err != nil {
+			t.Errorf("%s: %v", tc.format, err)
+			continue
+		}
+		if string(payload) != tc.want {
+			t.Errorf("%s: sent %s, want %s", tc.format, payload, tc.want)
+		}
+	}
+
+	wh := &WebhookConfig{Label: "broken", URL: srv.URL + "/broken", Format: "json"}
+	if err := wh.Notify(context.Background(), "Alert", "users: 5"); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Errorf("failed webhook: got %v", err)
+	}
+}
+
+func TestDiscordDescriptionLimit(t *testing.T) {
+	b, err := json.Marshal(webhookFormatters["discord"]("Digest", strings.Repeat("homeserver\n", 1000)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var payload struct {
+		Embeds []struct{ Description string }
+	}
+	if err := json.Unmarshal(b, &payload); err != nil {
+		t.Fatal(err)
+	}
+	description := payload.Embeds[0].Description
+	if len(description) > discordDescriptionLimit || !utf8.ValidString(description) || !strings.HasSuffix(description, "…```") {
+		t.Errorf("description of %d bytes ends %q", len(description), description[len(description)-10:])
+	}
+}
+
+func TestNewNotifiersRejectsUnknownFormat(t *testing.T) {
+	_, err := newNotifiers(NotifiersConfig{Webhooks: []*WebhookConfig{{Label: "chat", URL: "https://chat.turtles/hook", Format: "teams"}}})
+	if err == nil {
+		t.Error("accepted a webhook with an unknown format")
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+)
+
+var analyzeAfterRows = flag.Int64("analyze-after-rows", 10000, "on MySQL and Postgres, refresh a table's query planner statistics once a job deletes this many of its rows; 0 never does")
+
+// sqliteIncrementalVacuum is the value of PRAGMA auto_vacuum which lets
+// PRAGMA incremental_vacuum free pages.
+const sqliteIncrementalVacuum = 2
+
+// enableIncrementalVacuum makes a new sqlite database return the pages
+// freed by deletes to the filesystem when optimize_db runs. The mode can
+// only be changed by a VACUUM, which is instant while the database is
+// empty, but could take a long time for an existing one, so that is left
+// to the operator.
+func enableIncrementalVacuum(db *sql.DB) error {
+	ctx := context.Background()
+	// PRAGMA auto_vacuum only applies to the connection it's run on.
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	var mode, tables int
+	if err := conn.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+		return err
+	}
+	if mode == sqliteIncrementalVacuum {
+		return nil
+	}
+	if err := conn.QueryRowContext(ctx, "SELECT COUNT(*) FROM sqlite_master").Scan(&tables); err != nil {
+		return err
+	}
+	if tables > 0 {
+		log.Print("The database doesn't free deleted pages incrementally; to let optimize_db do so, run PRAGMA auto_vacuum = INCREMENTAL and VACUUM on it while panopticon is stopped")
+		return nil
+	}
+	if _, err := conn.ExecContext(ctx, "PRAGMA auto_vacuum = INCREMENTAL"); err != nil {
+		return err
+	}
+	_, err = conn.ExecContext(ctx, "VACUUM")
+	return err
+}
+
+// optimizeDB is the optimize_db job, for sqlite. It frees the pages left
+// empty by deletes, if the database allows, and lets sqlite refresh the
+// query planner statistics which need it.
+func optimizeDB(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		var mode int
+		if err := db.QueryRowContext(ctx, "PRAGMA auto_vacuum").Scan(&mode); err != nil {
+			return err
+		}
+		if mode == sqliteIncrementalVacuum {
+			var free int64
+			if err := db.QueryRowContext(ctx, "PRAGMA freelist_count").Scan(&free); err != nil {
+				return err
+			}
+			if free > 0 {
+				// Each step of the pragma frees one page, so it has to be
+				// read to the end rather than executed.
+				rows, err := db.QueryContext(ctx, "PRAGMA incremental_vacuum")
+				if err != nil {
+					return err
+				}
+				for rows.Next() {
+				}
+				err = rows.Err()
+				rows.Close()
+				if err != nil {
+					return err
+				}
+				addRowsAffected(ctx, free)
+				log.Printf("Freed %d database pages", free)
+			}
+		}
+		_, err := db.ExecContext(ctx, "PRAGMA optimize")
+		return err
+	}
+}
+
+// analyzeAfterDelete refreshes the query planner statistics of a table on
+// MySQL and Postgres once a job has deleted at least -analyze-after-rows of
+// its rows, since plans otherwise degrade until the database gets round to
+// it. sqlite is left to optimize_db.
+func analyzeAfterDelete(ctx context.Context, db *sql.DB, table string, deleted int64) error {
+	if *analyzeAfterRows <= 0 || deleted < *analyzeAfterRows {
+		return nil
+	}
+	var qry string
+	switch driverFor(db) {
+	case "postgres":
+		qry = "ANALYZE " + table
+	case "mysql":
+		qry = "ANALYZE TABLE " + table
+	default:
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, qry); err != nil {
+		return err
+	}
+	log.Printf("Analyzed %s after deleting %d rows", table, deleted)
+	return nil
+}
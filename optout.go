@@ -0,0 +1,295 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	optOutKeyURL  = flag.String("opt-out-key-url", "https://%s/_matrix/key/v2/server", "where to fetch the signing keys of a homeserver asking to opt out; %s is replaced by its server name")
+	optOutMaxAge  = flag.Duration("opt-out-max-age", time.Hour, "how old the origin_server_ts of an opt-out request may be")
+	optOutHashKey = flag.String("opt-out-hash-key", "", "key of the HMAC-SHA256 of its name kept for a homeserver which opted out; defaults to -homeserver-hash-salt, and /opt-out is disabled without either")
+)
+
+// optOutRequest is the body of a POST to /opt-out. It is signed like a
+// Matrix federation request, with one of the homeserver's signing keys over
+// the canonical JSON of the other fields.
+type optOutRequest struct {
+	ServerName     string                       `json:"server_name"`
+	OriginServerTS int64                        `json:"origin_server_ts"` // Milliseconds
+	Signatures     map[string]map[string]string `json:"signatures"`
+}
+
+func createTableOptOuts(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS opt_outs(
+		homeserver_hash VARCHAR(64) NOT NULL PRIMARY KEY,
+		opted_out_at BIGINT NOT NULL
+		)`)
+	return err
+}
+
+// optOutKey returns the key opt-out markers are made with, or "" if opting
+// out is disabled.
+func (s *Storage) optOutKey() string {
+	if *optOutHashKey != "" {
+		return *optOutHashKey
+	}
+	return s.HomeserverHashSalt
+}
+
+// optOutHash is the marker kept for a homeserver which opted out, so that
+// its reports can be dropped without keeping its name. It is keyed, so that
+// it can't be reversed by hashing a list of server names.
+func (s *Storage) optOutHash(homeserver string) string {
+	return homeserverHMAC(s.optOutKey(), homeserver)
+}
+
+// legacyOptOutHash is the unkeyed marker opt-outs used to be kept as, which
+// are still honoured.
+func legacyOptOutHash(homeserver string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(homeserver)))
+	return hex.EncodeToString(sum[:])
+}
+
+func isOptedOut(db *sql.DB, s *Storage, homeserver string) (bool, error) {
+	d := dialectFor(db)
+	hashes := []interface{}{legacyOptOutHash(homeserver)}
+	query := "SELECT COUNT(*) FROM opt_outs WHERE homeserver_hash = " + d.placeholder(1)
+	if s.optOutKey() != "" {
+		hashes = append(hashes, s.optOutHash(homeserver))
+		query += " OR homeserver_hash = " + d.placeholder(2)
+	}
+	var n int
+	err := db.QueryRow(query, hashes...).Scan(&n)
+	return n > 0, err
+}
+
+// canonicalJSON encodes v as Matrix canonical JSON: sorted keys, no
+// insignificant whitespace and no escaping beyond what JSON requires.
+func canonicalJSON(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// fetchVerifyKeys fetches the ed25519 keys a homeserver signs with.
+func fetchVerifyKeys(client *http.Client, serverName string) (map[string]ed25519.PublicKey, error) {
+	if err := validateServerName(serverName); err != nil {
+		return nil, err
+	}
+	resp, err := client.Get(fmt.Sprintf(*optOutKeyURL, serverName))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching keys: %s", resp.Status)
+	}
+	var keys struct {
+		ServerName string `json:"server_name"`
+		VerifyKeys map[string]struct {
+			Key string `json:"key"`
+		} `json:"verify_keys"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&keys); err != nil {
+		return nil, fmt.Errorf("fetching keys: %v", err)
+	}
+	if keys.ServerName != serverName {
+		return nil, fmt.Errorf("fetching keys: got keys for %q", keys.ServerName)
+	}
+	result := map[string]ed25519.PublicKey{}
+	for id, k := range keys.VerifyKeys {
+		raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(k.Key, "="))
+		if err == nil && strings.HasPrefix(id, "ed25519:") && len(raw) == ed25519.PublicKeySize {
+			result[id] = raw
+		}
+	}
+	return result, nil
+}
+
+// verifyOptOut checks that an opt-out request, decoded from body, is recent
+// and signed by one of the keys of the homeserver it names.
+func verifyOptOut(client *http.Client, r *optOutRequest, body []byte) error {
+	age := time.Since(time.UnixMilli(r.OriginServerTS))
+	if age > *optOutMaxAge || age < -*optOutMaxAge {
+		return errors.New("origin_server_ts is too far from now")
+	}
+	return verifyServerSignature(client, r.ServerName, r.Signatures, body)
+}
+
+// verifyServerSignature checks that the JSON object body, without its
+// signatures and unsigned fields, is signed by one of the keys of a
+// homeserver, as Matrix federation requests are.
+func verifyServerSignature(client *http.Client, serverName string, signatures map[string]map[string]string, body []byte) error {
+	var signed map[string]interface{}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&signed); err != nil {
+		return err
+	}
+	delete(signed, "signatures")
+	delete(signed, "unsigned")
+	message, err := canonicalJSON(signed)
+	if err != nil {
+		return err
+	}
+	keys, err := fetchVerifyKeys(client, serverName)
+	if err != nil {
+		return err
+	}
+	for id, sig := range signatures[serverName] {
+		key, ok := keys[id]
+		if !ok {
+			continue
+		}
+		raw, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(sig, "="))
+		if err == nil && ed25519.Verify(key, message, raw) {
+			return nil
+		}
+	}
+	return errors.New("no valid signature from a key of " + serverName)
+}
+
+// purgeHomeserver deletes everything stored about a homeserver and records
+// the hashed marker which makes future reports from it be dropped.
+func purgeHomeserver(db *sql.DB, s *Storage, homeserver string) error {
+	d := dialectFor(db)
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	stored := s.storedHomeserver(homeserver)
+	for _, table := range []string{s.tableName("stats"), s.tableName("dendrite_stats"), "homeservers", "homeserver_metadata", "homeserver_tags", "downsampled_reports", "tombstones", "alerts", "silences", "string_metrics", "verification_nonces", "verified_homeservers", "shadow_divergences"} {
+		if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s WHERE homeserver = %s", table, d.placeholder(1)), stored); err != nil {
+			return fmt.Errorf("purging %s: %v", table, err)
+		}
+	}
+	if err := purgeRawReports(tx, d, homeserver); err != nil {
+		return fmt.Errorf("purging raw_reports: %v", err)
+	}
+	hash := s.optOutHash(homeserver)
+	if _, err := tx.Exec("DELETE FROM opt_outs WHERE homeserver_hash = "+d.placeholder(1), hash); err != nil {
+		return err
+	}
+	_, err = tx.Exec(d.insert("opt_outs", "homeserver_hash", "opted_out_at"), hash, time.Now().UTC().Unix())
+	if err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+// purgeRawReports deletes the raw reports which mention a homeserver. Raw
+// bodies are only kept gzipped, so each has to be read.
+func purgeRawReports(tx *sql.Tx, d dialect, homeserver string) error {
+	rows, err := tx.Query("SELECT id, body_gzip FROM raw_reports")
+	if err != nil {
+		return err
+	}
+	needle, _ := json.Marshal(homeserver)
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		var compressed []byte
+		if err := rows.Scan(&id, &compressed); err != nil {
+			rows.Close()
+			return err
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			continue
+		}
+		// Truncated bodies end early, so ignore read errors.
+		body, _ := io.ReadAll(zr)
+		if bytes.Contains(body, needle) {
+			ids = append(ids, id)
+		}
+	}
+	err = rows.Err()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	for _, id := range ids {
+		if _, err := tx.Exec("DELETE FROM raw_reports WHERE id = "+d.placeholder(1), id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OptOutHandler serves /opt-out, to which a homeserver POSTs a signed
+// request to have its data purged and its future reports dropped.
+type OptOutHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+	Client  *http.Client
+}
+
+func (h *OptOutHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if h.Storage.optOutKey() == "" {
+		notFound(w, req)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, 64*1024))
+	if err != nil {
+		logAndReplyError(w, err, 400, "Error reading body")
+		return
+	}
+	var r optOutRequest
+	if err := json.Unmarshal(body, &r); err != nil {
+		replyError(w, err, 400, jsonErrcode(err), "Error decoding opt-out")
+		return
+	}
+	if r.ServerName == "" {
+		logAndReplyError(w, errors.New("missing server_name"), 400, "Error decoding opt-out")
+		return
+	}
+	if err := validateServerName(r.ServerName); err != nil {
+		replyError(w, err, 400, errcodeValidationFailed, "Error decoding opt-out")
+		return
+	}
+	if err := verifyOptOut(h.Client, &r, body); err != nil {
+		logAndReplyError(w, err, http.StatusForbidden, "Refused opt-out")
+		return
+	}
+	if err := purgeHomeserver(h.DB, h.Storage, r.ServerName); err != nil {
+		logAndReplyError(w, err, 500, "Error purging homeserver")
+		return
+	}
+	log.Printf("%s opted out; purged its data", r.ServerName)
+	metrics.Inc("panopticon_opt_outs_total")
+	writeJSON(w, struct{}{})
+}
@@ -0,0 +1,117 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// reportFieldNames lists every JSON key a report may set.
+func reportFieldNames() []string {
+	var names []string
+	for _, t := range []reflect.Type{
+		reflect.TypeOf(CommonStats{}),
+		reflect.TypeOf(ReportStatsSynapse{}),
+		reflect.TypeOf(ReportStatsDendrite{}),
+	} {
+		for _, name := range jsonFieldNames(t) {
+			if name != "-" {
+				names = append(names, name)
+			}
+		}
+	}
+	return names
+}
+
+// validateFieldPaths checks that paths map to report fields or to the
+// names of string metrics.
+func validateFieldPaths(paths map[string]string, stringFields map[string]*StringMetric) error {
+	known := map[string]bool{}
+	for _, name := range reportFieldNames() {
+		// encoding/json matches keys case-insensitively
+		known[strings.ToLower(name)] = true
+	}
+	for field, path := range paths {
+		if _, ok := stringFields[field]; !ok && !known[strings.ToLower(field)] {
+			return fmt.Errorf("%s is not a report field", field)
+		}
+		for _, segment := range strings.Split(path, ".") {
+			if segment == "" {
+				return fmt.Errorf("%s: invalid path %q", field, path)
+			}
+		}
+	}
+	return nil
+}
+
+// mapFieldPaths copies values out of nested objects in a report to the top
+// level keys they are configured for, so that reporters don't have to flatten
+// their payloads. Paths are dotted, and numeric segments index into arrays,
+// e.g. "stats.users.total" or "servers.0.name". Values already present at the
+// top level take precedence. The objects which paths were read from are
+// removed.
+func mapFieldPaths(body []byte, paths map[string]string) ([]byte, error) {
+	if len(paths) == 0 {
+		return body, nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		// Leave it to the report decoder to complain.
+		return body, nil
+	}
+	roots := map[string]bool{}
+	for field, path := range paths {
+		roots[strings.SplitN(path, ".", 2)[0]] = true
+		if _, ok := raw[field]; ok {
+			continue
+		}
+		if v, ok := lookupPath(raw, strings.Split(path, ".")); ok {
+			raw[field] = v
+		}
+	}
+	for root := range roots {
+		if _, isField := paths[root]; !isField {
+			delete(raw, root)
+		}
+	}
+	return json.Marshal(raw)
+}
+
+func lookupPath(obj map[string]json.RawMessage, path []string) (json.RawMessage, bool) {
+	v, ok := obj[path[0]]
+	if !ok {
+		return nil, false
+	}
+	for _, segment := range path[1:] {
+		var next map[string]json.RawMessage
+		if err := json.Unmarshal(v, &next); err == nil {
+			if v, ok = next[segment]; !ok {
+				return nil, false
+			}
+			continue
+		}
+		var arr []json.RawMessage
+		i, err := strconv.Atoi(segment)
+		if err != nil || json.Unmarshal(v, &arr) != nil || i < 0 || i >= len(arr) {
+			return nil, false
+		}
+		v = arr[i]
+	}
+	return v, true
+}
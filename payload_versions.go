@@ -0,0 +1,138 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// schemaVersionHeader declares the payload schema version of a push, as
+// an alternative to the schema_version field.
+const schemaVersionHeader = "Panopticon-Schema-Version"
+
+// maxSchemaVersionLength is the size of the schema_version column.
+const maxSchemaVersionLength = 32
+
+// PayloadSchema describes a version of the payload which reporters push, so
+// that breaking changes to it can be told apart and checked.
+type PayloadSchema struct {
+	Transforms []*Transform `json:"transforms"` // Applied before the global transforms
+	Required   []string     `json:"required"`   // Fields which must be set
+	Strict     bool         `json:"strict"`     // Reject reports with fields panopticon doesn't know
+}
+
+func validatePayloadSchemas(schemas map[string]*PayloadSchema) error {
+	known := map[string]bool{}
+	for _, name := range reportFieldNames() {
+		known[strings.ToLower(name)] = true
+	}
+	for version, s := range schemas {
+		if version == "" {
+			return errors.New("empty version")
+		}
+		if err := validateTransforms(s.Transforms); err != nil {
+			return fmt.Errorf("%s: transforms: %v", version, err)
+		}
+		for _, f := range s.Required {
+			if !known[strings.ToLower(f)] {
+				return fmt.Errorf("%s: %s is not a report field", version, f)
+			}
+		}
+	}
+	return nil
+}
+
+// payloadSchemaVersion returns the schema version a push declares, if any,
+// in its header or in its schema_version field, which may be a string or a
+// number. The field is removed from the returned body, as the version is
+// stored from the header too.
+func payloadSchemaVersion(req *http.Request, body []byte) (string, []byte, error) {
+	version := req.Header.Get(schemaVersionHeader)
+	var raw map[string]json.RawMessage
+	// Leave it to the report decoder to complain about bad JSON.
+	json.Unmarshal(body, &raw)
+	if v, ok := raw["schema_version"]; ok {
+		var field string
+		if err := json.Unmarshal(v, &field); err != nil {
+			var n json.Number
+			if err := json.Unmarshal(v, &n); err != nil {
+				return "", nil, errors.New("schema_version must be a string or a number")
+			}
+			field = n.String()
+		}
+		if version != "" && field != "" && version != field {
+			return "", nil, fmt.Errorf("%s header %q doesn't match schema_version %q", schemaVersionHeader, version, field)
+		}
+		if version == "" {
+			version = field
+		}
+		delete(raw, "schema_version")
+		var err error
+		if body, err = json.Marshal(raw); err != nil {
+			return "", nil, err
+		}
+	}
+	if len(version) > maxSchemaVersionLength {
+		return "", nil, fmt.Errorf("schema version is longer than %d characters", maxSchemaVersionLength)
+	}
+	return version, body, nil
+}
+
+// payloadSchema looks up the schema of a declared version. Undeclared
+// versions have none, as do all versions if none are configured.
+func (c *Config) payloadSchema(version string) (*PayloadSchema, error) {
+	if version == "" || len(c.PayloadSchemas) == 0 {
+		return nil, nil
+	}
+	s, ok := c.PayloadSchemas[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema version %q", version)
+	}
+	return s, nil
+}
+
+// check validates a report, after transforms, against the schema.
+func (s *PayloadSchema) check(body []byte, isDendrite bool, stringMetrics map[string]*StringMetric) error {
+	if s == nil {
+		return nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		// Leave it to the report decoder to complain.
+		return nil
+	}
+	var missing []string
+	for _, f := range s.Required {
+		if v, ok := raw[f]; !ok || string(bytes.TrimSpace(v)) == "null" {
+			missing = append(missing, f)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("missing required fields: %s", strings.Join(missing, ", "))
+	}
+	if s.Strict {
+		if unknown := unknownFields(body, isDendrite, stringMetrics); len(unknown) > 0 {
+			sort.Strings(unknown)
+			return fmt.Errorf("unknown fields: %s", strings.Join(unknown, ", "))
+		}
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Privacy protects individual homeservers in the aggregates served to a
+// role, by suppressing aggregates of too few homeservers and adding
+// Laplace noise.
+type Privacy struct {
+	MinHomeservers int64   `json:"min_homeservers"` // Aggregates of fewer homeservers are null
+	NoiseEpsilon   float64 `json:"noise_epsilon"`   // Privacy budget of each aggregate; 0 for no noise
+	NoiseKey       string  `json:"noise_key"`       // Secret the noise is derived from; random at startup if unset
+
+	role    string
+	key     []byte
+	fields  map[string]FieldRule // The config's field rules, whose bounds calibrate the noise of sums
+	filters string               // The query parameters narrowing the homeservers of a request's aggregates
+}
+
+// compile checks the privacy settings of a role, and gives them the field
+// rules.
+func (p *Privacy) compile(role string, fields map[string]FieldRule) error {
+	if p.MinHomeservers < 0 {
+		return errors.New("negative min_homeservers")
+	}
+	if p.NoiseEpsilon < 0 {
+		return errors.New("negative noise_epsilon")
+	}
+	p.role, p.fields = role, fields
+	if p.NoiseKey != "" {
+		if len(p.NoiseKey) < 16 {
+			return errors.New("noise_key must be at least 16 characters")
+		}
+		p.key = []byte(p.NoiseKey)
+		return nil
+	}
+	p.key = make([]byte, 32)
+	_, err := rand.Read(p.key)
+	return err
+}
+
+// noiseFilters are the query parameters which narrow the homeservers an
+// aggregate is over. Aggregates narrowed differently get independent noise,
+// so that it doesn't cancel out when one is subtracted from another.
+var noiseFilters = []string{"homeserver", "tag", "exclude_tag", "product", "version"}
+
+// privacyOf returns the privacy settings of a request's role, or nil if
+// it sees exact aggregates.
+func privacyOf(req *http.Request) *Privacy {
+	role := roleOf(req)
+	if role == nil || role.Privacy == nil {
+		return nil
+	}
+	p := *role.Privacy
+	filters := url.Values{}
+	for _, f := range noiseFilters {
+		if v, ok := req.URL.Query()[f]; ok {
+			filters[f] = v
+		}
+	}
+	p.filters = filters.Encode()
+	return &p
+}
+
+// aggregatesOnly refuses endpoints which describe individual homeservers to
+// roles with privacy settings, returning whether it did.
+func aggregatesOnly(w http.ResponseWriter, req *http.Request) bool {
+	if privacyOf(req) == nil {
+		return false
+	}
+	logAndReplyError(w, fmt.Errorf("%s describes individual homeservers", req.URL.Path), http.StatusForbidden, "Forbidden query")
+	return true
+}
+
+// suppressed reports whether an aggregate of n homeservers must be hidden.
+func (p *Privacy) suppressed(n int64) bool {
+	return p != nil && n < p.MinHomeservers
+}
+
+// laplace returns noise for the aggregate of a metric over a window, to
+// which one homeserver can contribute at most sensitivity. The window names
+// the period the aggregate covers, and anything else it depends on. The
+// noise is derived from the role, metric, window and filters rather than
+// drawn afresh, so that asking for the same aggregate again gets the same
+// answer instead of another sample to average the noise away with.
+func (p *Privacy) laplace(metric, window string, sensitivity float64) float64 {
+	if p == nil || p.NoiseEpsilon == 0 {
+		return 0
+	}
+	mac := hmac.New(sha256.New, p.key)
+	fmt.Fprintf(mac, "%s\x00%s\x00%s\x00%s", p.role, metric, window, p.filters)
+	// A uniform value in (-0.5, 0.5), which the inverse of the Laplace
+	// distribution's CDF turns into noise.
+	u := (float64(binary.BigEndian.Uint64(mac.Sum(nil))>>11)+0.5)/(1<<53) - 0.5
+	scale := sensitivity / p.NoiseEpsilon
+	if u < 0 {
+		return scale * math.Log(1+2*u)
+	}
+	return -scale * math.Log(1-2*u)
+}
+
+// slidingWindow names a window of the given length ending now for noise,
+// which stays the same until the day is over.
+func slidingWindow(length string, now time.Time) string {
+	return length + " to " + now.UTC().Format("2006-01-02")
+}
+
+// count protects a count of homeservers of a metric over a window,
+// returning nil if it is suppressed. Noisy counts are rounded and never
+// negative.
+func (p *Privacy) count(n int64, metric, window string) *int64 {
+	if p.suppressed(n) {
+		return nil
+	}
+	if p == nil || p.NoiseEpsilon == 0 {
+		return &n
+	}
+	noisy := int64(math.Round(float64(n) + p.laplace(metric, window, 1)))
+	if noisy < 0 {
+		noisy = 0
+	}
+	return &noisy
+}
+
+// sum protects the sum of a metric over n homeservers, returning false if
+// it is suppressed. The noise is calibrated to the metric's configured
+// maximum; without one, a single homeserver's contribution is unbounded, so
+// noise can't hide it and the sum is suppressed.
+func (p *Privacy) sum(metric, window string, v float64, n int64) (float64, bool) {
+	if p.suppressed(n) {
+		return 0, false
+	}
+	if p == nil || p.NoiseEpsilon == 0 {
+		return v, true
+	}
+	rule, ok := p.fields[metric]
+	if !ok || rule.Max == nil {
+		return 0, false
+	}
+	sensitivity := math.Abs(*rule.Max)
+	if rule.Min != nil && math.Abs(*rule.Min) > sensitivity {
+		sensitivity = math.Abs(*rule.Min)
+	}
+	return v + p.laplace(metric, window, sensitivity), true
+}
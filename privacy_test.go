@@ -0,0 +1,67 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestPrivacyNoise(t *testing.T) {
+	p := &Privacy{NoiseEpsilon: 0.5, NoiseKey: "a noise key for testing"}
+	if err := p.compile("public", nil); err != nil {
+		t.Fatal(err)
+	}
+	other := &Privacy{NoiseEpsilon: 0.5, NoiseKey: "a noise key for testing"}
+	if err := other.compile("partners", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// The same aggregate always gets the same noise, and others their own.
+	noise := p.laplace("total_users", "20000", 1)
+	if again := p.laplace("total_users", "20000", 1); again != noise {
+		t.Errorf("noise changed from %v to %v", noise, again)
+	}
+	for _, n := range []float64{
+		p.laplace("total_users", "20001", 1),
+		p.laplace("daily_messages", "20000", 1),
+		other.laplace("total_users", "20000", 1),
+	} {
+		if n == noise {
+			t.Errorf("noise %v repeated for another aggregate", n)
+		}
+	}
+
+	// Over many aggregates, it has the spread of Laplace noise of scale
+	// sensitivity over epsilon, whose mean absolute value is its scale.
+	var sum, abs float64
+	const samples = 20000
+	for i := 0; i < samples; i++ {
+		n := p.laplace("total_users", fmt.Sprint(i), 1)
+		sum += n
+		abs += math.Abs(n)
+	}
+	if mean := sum / samples; math.Abs(mean) > 0.1 {
+		t.Errorf("mean noise %v, want about 0", mean)
+	}
+	if scale := abs / samples; math.Abs(scale-2) > 0.1 {
+		t.Errorf("mean absolute noise %v, want about 2", scale)
+	}
+
+	if err := (&Privacy{NoiseKey: "short"}).compile("public", nil); err == nil {
+		t.Error("short noise_key accepted")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// productPattern is what product and version parameters may contain, which
+// keeps LIKE wildcards out of them.
+var productPattern = regexp.MustCompile(`^[A-Za-z0-9.+-]+$`)
+
+// productFilter restricts queries to reports sent by one implementation,
+// as named by the product of their user agent (e.g. Synapse/1.95.0), and
+// optionally versions of it.
+type productFilter struct {
+	product string
+	version string // Matches whole components, so 1.9 doesn't match 1.95
+}
+
+// parseProductFilter reads the product and version query parameters,
+// returning nil if there is no product.
+func parseProductFilter(q url.Values) (*productFilter, error) {
+	product, version := q.Get("product"), q.Get("version")
+	if product == "" {
+		if version != "" {
+			return nil, errors.New("version needs a product")
+		}
+		return nil, nil
+	}
+	if !productPattern.MatchString(product) {
+		return nil, fmt.Errorf("bad product %q", product)
+	}
+	if version != "" && !productPattern.MatchString(version) {
+		return nil, fmt.Errorf("bad version %q", version)
+	}
+	return &productFilter{strings.ToLower(product), strings.ToLower(version)}, nil
+}
+
+// productFilterOf parses the product filter of a request, replying with an
+// error and returning false if it is bad or the role can't see user agents.
+func productFilterOf(w http.ResponseWriter, req *http.Request) (*productFilter, bool) {
+	f, err := parseProductFilter(req.URL.Query())
+	if err != nil {
+		logAndReplyError(w, err, 400, "Bad query")
+		return nil, false
+	}
+	if f != nil && hiddenColumnError(w, roleOf(req), "user_agent", "product and version") {
+		return nil, false
+	}
+	return f, true
+}
+
+// conditions returns SQL conditions restricting reports to those whose
+// user_agent matches, adding their arguments to args.
+func (f *productFilter) conditions(d dialect, args *[]interface{}) []string {
+	if f == nil {
+		return nil
+	}
+	var patterns []string
+	if f.version == "" {
+		patterns = []string{f.product + "/%"}
+	} else {
+		prefix := f.product + "/" + f.version
+		patterns = []string{prefix, prefix + ".%", prefix + " %"}
+	}
+	var ors []string
+	for _, p := range patterns {
+		*args = append(*args, p)
+		ors = append(ors, "LOWER(user_agent) LIKE "+d.placeholder(len(*args)))
+	}
+	return []string{"(" + strings.Join(ors, " OR ") + ")"}
+}
+
+// homeserverConditions returns SQL conditions restricting the homeserver
+// column to homeservers which sent a matching report, to the tables of s,
+// at or after since.
+func (f *productFilter) homeserverConditions(s *Storage, d dialect, column string, since int64, args *[]interface{}) []string {
+	if f == nil {
+		return nil
+	}
+	var selects []string
+	for _, table := range []string{"stats", "dendrite_stats"} {
+		*args = append(*args, since)
+		where := append([]string{"local_timestamp >= " + d.placeholder(len(*args))}, f.conditions(d, args)...)
+		selects = append(selects, fmt.Sprintf("SELECT homeserver FROM %s WHERE %s", s.tableName(table), strings.Join(where, " AND ")))
+	}
+	return []string{fmt.Sprintf("%s IN (%s)", column, strings.Join(selects, " UNION "))}
+}
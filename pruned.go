@@ -0,0 +1,46 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// prunedBeforeHeader is sent with read API replies covering time before
+// which reports have been deleted under -stats-retention, giving that time,
+// so that clients can tell the reply only has part of what they asked for.
+// The daily histograms of configured metrics still cover the deleted days.
+const prunedBeforeHeader = "Panopticon-Pruned-Before"
+
+// retentionCutoff returns the start of the first day whose reports are
+// kept at now, or 0 if reports are kept forever. Like prune_stats, it
+// rounds down to whole days.
+func retentionCutoff(now time.Time) int64 {
+	if *statsRetention <= 0 {
+		return 0
+	}
+	cutoff := now.UTC().Unix() - int64(statsRetention.Seconds())
+	return cutoff - cutoff%oneDay
+}
+
+// markPruned flags the reply to a query for reports from since (seconds;
+// 0 for all of them) as partial if they start before the retention cutoff.
+func markPruned(w http.ResponseWriter, since int64) {
+	if cutoff := retentionCutoff(time.Now()); cutoff > 0 && since < cutoff {
+		w.Header().Set(prunedBeforeHeader, strconv.FormatInt(cutoff, 10))
+	}
+}
@@ -0,0 +1,84 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"unicode/utf8"
+)
+
+var (
+	maxPushBytes = flag.Int64("max-push-bytes", 1<<20, "pushes with larger bodies are refused")
+	maxJSONDepth = flag.Int("max-json-depth", 32, "pushes nesting objects and arrays deeper than this are refused")
+)
+
+// maxNumberLength is the longest number literal a push may contain. No
+// report field needs more digits, and longer ones are only slow to parse.
+const maxNumberLength = 64
+
+// readPushBody reads the body of a push, replying with an error and
+// returning false if it is too large.
+func readPushBody(w http.ResponseWriter, req *http.Request) ([]byte, bool) {
+	body, err := io.ReadAll(io.LimitReader(req.Body, *maxPushBytes+1))
+	if err != nil {
+		logAndReplyError(w, err, 400, "Error reading body")
+		return body, false
+	}
+	if int64(len(body)) > *maxPushBytes {
+		logAndReplyError(w, fmt.Errorf("body is larger than %d bytes", *maxPushBytes), http.StatusRequestEntityTooLarge, "Rejected report")
+		return body, false
+	}
+	return body, true
+}
+
+// checkPushBody rejects bodies which are pathological to decode: invalid
+// UTF-8, which encoding/json would silently replace, nesting deeper than
+// -max-json-depth and overlong numbers. Other malformed JSON is left to the
+// report decoder to complain about.
+func checkPushBody(body []byte) error {
+	if !utf8.Valid(body) {
+		return errors.New("body is not valid UTF-8")
+	}
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	depth := 0
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return nil
+		}
+		switch t := tok.(type) {
+		case json.Delim:
+			if t == '{' || t == '[' {
+				depth++
+			} else {
+				depth--
+			}
+			if depth > *maxJSONDepth {
+				return fmt.Errorf("JSON is nested deeper than %d", *maxJSONDepth)
+			}
+		case json.Number:
+			if len(t) > maxNumberLength {
+				return fmt.Errorf("number is longer than %d characters", maxNumberLength)
+			}
+		}
+	}
+}
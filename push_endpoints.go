@@ -0,0 +1,58 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"text/template"
+)
+
+// PushEndpoint customises the reply to a successful push, so that reporters
+// expecting a particular legacy response can be kept happy.
+type PushEndpoint struct {
+	Status      int    `json:"status"`       // Defaults to 200
+	ContentType string `json:"content_type"` // Defaults to application/json
+	Body        string `json:"body"`         // text/template executed with the PushResult; defaults to "{}"
+
+	template *template.Template
+}
+
+func (e *PushEndpoint) compile(name string) error {
+	if e.Body == "" {
+		e.Body = "{}"
+	}
+	var err error
+	e.template, err = template.New(name).Parse(e.Body)
+	return err
+}
+
+// Reply writes the customised response for a successful push.
+func (e *PushEndpoint) Reply(w http.ResponseWriter, result *PushResult) {
+	var body bytes.Buffer
+	if err := e.template.Execute(&body, result); err != nil {
+		logAndReplyError(w, err, 500, "Error rendering response")
+		return
+	}
+	contentType := e.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	w.Header().Set("Content-Type", contentType)
+	if e.Status != 0 {
+		w.WriteHeader(e.Status)
+	}
+	w.Write(body.Bytes())
+}
@@ -0,0 +1,158 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// Fuzz targets for the decoding and validation of pushes, which are
+// internet-facing. Run one with, for example:
+//
+//	go test -run '^$' -fuzz FuzzPush -fuzztime 1m
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var fuzzSeeds = []string{
+	`{"homeserver": "fuzz.example", "total_users": 10, "daily_messages": 3, "cache_factor": 0.5}`,
+	`{"homeserver": "fuzz.example", "nested": {"users": {"total": 5}}, "schema_version": "2"}`,
+	`{"homeserver": "fuzz.example", "total_users": 1e400, "daily_active_users": -9223372036854775809}`,
+	`{"homeserver": "fuzz.example", "memory_rss": 12.75, "python_version": "3.11"}`,
+	`{"homeserver": "fuzz.example", "total_users": null, "daily_user_type_native": "7"}`,
+	`{"homeserver": 5}`,
+	`[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[[]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]]`,
+	"{\"homeserver\": \"\xff\xfe\"}",
+	`{"homeserver": "\ud800"}`,
+	`{`,
+	``,
+}
+
+const fuzzConfig = `{
+  "field_paths": {"total_users": "nested.users.total"},
+  "transforms": [{"from": ["users"], "to": "daily_active_users", "scale": 2}],
+  "payload_schemas": {"2": {"required": ["homeserver"], "strict": true}}
+}`
+
+func fuzzRecorder(f *testing.F) *Recorder {
+	log.SetOutput(io.Discard)
+	f.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := f.TempDir()
+	path := filepath.Join(dir, "config.json")
+	if err := os.WriteFile(path, []byte(fuzzConfig), 0o600); err != nil {
+		f.Fatal(err)
+	}
+	config, err := loadConfig(path)
+	if err != nil {
+		f.Fatal(err)
+	}
+	db, err := openDB("sqlite3", filepath.Join(dir, "stats.db"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Cleanup(func() { db.Close() })
+	storage := (&Storage{Driver: "sqlite3", StatsTable: "stats", DendriteStatsTable: "dendrite_stats"}).withConfig(config)
+	if err := createTables(db, storage); err != nil {
+		f.Fatal(err)
+	}
+	return &Recorder{DB: db, Storage: storage, Config: config}
+}
+
+// FuzzPush checks that no body makes a dry run push fail other than with
+// a client error carrying a JSON body.
+func FuzzPush(f *testing.F) {
+	r := fuzzRecorder(f)
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s), false)
+		f.Add([]byte(s), true)
+	}
+	f.Fuzz(func(t *testing.T, body []byte, dendrite bool) {
+		req := httptest.NewRequest(http.MethodPost, dryRunPath, bytes.NewReader(body))
+		if dendrite {
+			req.Header.Set("User-Agent", "Dendrite/0.13.0")
+		}
+		w := httptest.NewRecorder()
+		r.Handle(w, req)
+		if w.Code != http.StatusOK && (w.Code < 400 || w.Code >= 500) {
+			t.Fatalf("got status %d: %s", w.Code, w.Body)
+		}
+		if !json.Valid(w.Body.Bytes()) {
+			t.Fatalf("got invalid JSON response %q", w.Body)
+		}
+	})
+}
+
+// FuzzSanitizeNumbers checks that sanitizing valid JSON leaves valid JSON.
+func FuzzSanitizeNumbers(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, body []byte) {
+		clean, _, _, err := sanitizeNumbers(body, nil)
+		if err == nil && json.Valid(body) && !json.Valid(clean) {
+			t.Fatalf("sanitized %q into invalid JSON %q", body, clean)
+		}
+	})
+}
+
+// FuzzCheckPushBody checks that bodies which pass checkPushBody are valid
+// UTF-8 and no deeper than allowed.
+func FuzzCheckPushBody(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Add([]byte(strings.Repeat("[", 100000)))
+	f.Fuzz(func(t *testing.T, body []byte) {
+		if checkPushBody(body) != nil || !json.Valid(body) {
+			return
+		}
+		var v interface{}
+		dec := json.NewDecoder(bytes.NewReader(body))
+		dec.UseNumber()
+		if err := dec.Decode(&v); err != nil {
+			t.Fatal(err)
+		}
+		if depth := jsonDepth(v); depth > *maxJSONDepth {
+			t.Fatalf("accepted depth %d", depth)
+		}
+	})
+}
+
+func jsonDepth(v interface{}) int {
+	deepest := 0
+	switch v := v.(type) {
+	case map[string]interface{}:
+		for _, e := range v {
+			if d := jsonDepth(e); d > deepest {
+				deepest = d
+			}
+		}
+	case []interface{}:
+		for _, e := range v {
+			if d := jsonDepth(e); d > deepest {
+				deepest = d
+			}
+		}
+	default:
+		return 0
+	}
+	return deepest + 1
+}
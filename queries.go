@@ -0,0 +1,207 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NamedQuery is a read-only query which admins may run through
+// /admin/queries/<name> without database credentials. Parameters are
+// referred to as :name in the SQL.
+type NamedQuery struct {
+	Description string            `json:"description"`
+	SQL         string            `json:"sql"`
+	Params      map[string]string `json:"params"` // Parameter name to type: "int", "float" or "string"
+
+	stmt   string   // SQL without a trailing semicolon
+	params []string // Parameter for each placeholder
+}
+
+var queryParamRegexp = regexp.MustCompile(`(^|[^:]):([A-Za-z_][A-Za-z0-9_]*)`)
+
+func (q *NamedQuery) compile() error {
+	s := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(q.SQL), ";"))
+	upper := strings.ToUpper(s)
+	if !strings.HasPrefix(upper, "SELECT") && !strings.HasPrefix(upper, "WITH") {
+		return fmt.Errorf("only SELECT queries are allowed")
+	}
+	if strings.Contains(s, ";") {
+		return fmt.Errorf("only a single statement is allowed")
+	}
+	for p, t := range q.Params {
+		if t != "int" && t != "float" && t != "string" {
+			return fmt.Errorf("parameter %s has unknown type %q", p, t)
+		}
+	}
+	q.stmt = s
+	q.params = nil
+	for _, sub := range queryParamRegexp.FindAllStringSubmatch(s, -1) {
+		if _, ok := q.Params[sub[2]]; !ok {
+			return fmt.Errorf("undeclared parameter :%s", sub[2])
+		}
+		q.params = append(q.params, sub[2])
+	}
+	return nil
+}
+
+// query returns the SQL with the placeholders of a dialect, whose
+// arguments are given by args.
+func (q *NamedQuery) query(d dialect) string {
+	i := 0
+	return queryParamRegexp.ReplaceAllStringFunc(q.stmt, func(m string) string {
+		i++
+		return queryParamRegexp.FindStringSubmatch(m)[1] + d.placeholder(i)
+	})
+}
+
+// args converts the query parameters of a request into query arguments.
+func (q *NamedQuery) args(values map[string][]string) ([]interface{}, error) {
+	var args []interface{}
+	for _, p := range q.params {
+		v, ok := values[p]
+		if !ok || len(v) == 0 {
+			return nil, fmt.Errorf("missing parameter %s", p)
+		}
+		switch q.Params[p] {
+		case "int":
+			n, err := strconv.ParseInt(v[0], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %v", p, err)
+			}
+			args = append(args, n)
+		case "float":
+			f, err := strconv.ParseFloat(v[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("parameter %s: %v", p, err)
+			}
+			args = append(args, f)
+		default:
+			args = append(args, v[0])
+		}
+	}
+	return args, nil
+}
+
+// QueriesHandler serves /admin/queries, which lists the named queries, and
+// /admin/queries/<name>, which runs one with its parameters taken from the
+// query string. Results are JSON, or CSV with format=csv.
+type QueriesHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+	Queries map[string]*NamedQuery
+}
+
+func (h *QueriesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet {
+		methodNotAllowed(w, req, http.MethodGet)
+		return
+	}
+	name := strings.Trim(strings.TrimPrefix(req.URL.Path, "/admin/queries"), "/")
+	if name == "" {
+		type listing struct {
+			Name        string            `json:"name"`
+			Description string            `json:"description"`
+			Params      map[string]string `json:"params"`
+		}
+		list := []listing{}
+		for n, q := range h.Queries {
+			list = append(list, listing{n, q.Description, q.Params})
+		}
+		sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+		writeJSON(w, list)
+		return
+	}
+	q, ok := h.Queries[name]
+	if !ok {
+		notFound(w, req)
+		return
+	}
+	values := req.URL.Query()
+	args, err := q.args(values)
+	if err != nil {
+		logAndReplyError(w, err, 400, "Bad query")
+		return
+	}
+	// Belt and braces: the SQL was checked to be a SELECT, but a WITH can
+	// still write, so the database is made to enforce that it only reads.
+	// The transaction outlives the request's context, so that a query which
+	// runs out of time doesn't leave the connection in query_only mode.
+	isSqlite := isSQLite(driverFor(h.DB))
+	tx, err := h.DB.BeginTx(context.Background(), &sql.TxOptions{ReadOnly: !isSqlite})
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error running query")
+		return
+	}
+	defer tx.Rollback()
+	if isSqlite {
+		if _, err := tx.Exec("PRAGMA query_only = 1"); err != nil {
+			logAndReplyError(w, err, 500, "Error running query")
+			return
+		}
+		// The setting belongs to the pooled connection, not the transaction.
+		defer tx.Exec("PRAGMA query_only = 0")
+	}
+	rows, err := tx.QueryContext(req.Context(), q.query(dialectFor(h.DB)), args...)
+	if err != nil {
+		replyQueryError(w, req, err, "Error running query")
+		return
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error running query")
+		return
+	}
+	result, err := scanRows(rows, *maxQueryRows, nil)
+	if err = partialRows(w, req, err); err != nil {
+		replyQueryError(w, req, err, "Error running query")
+		return
+	}
+	if len(result) == *maxQueryRows && rows.Next() {
+		w.Header().Set(partialHeader, "row_limit")
+	}
+	for _, row := range result {
+		if err := h.Storage.Encryption.decryptRow(row, nil); err != nil {
+			logAndReplyError(w, err, 500, "Error running query")
+			return
+		}
+	}
+	if values.Get("format") != "csv" {
+		writeJSON(w, result)
+		return
+	}
+	w.Header().Set("Content-Type", "text/csv")
+	cw := csv.NewWriter(w)
+	cw.Write(columns)
+	for _, row := range result {
+		record := make([]string, len(columns))
+		for i, c := range columns {
+			if v := row[c]; v != nil {
+				record[i] = fmt.Sprint(v)
+			}
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+}
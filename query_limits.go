@@ -0,0 +1,90 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+var (
+	readQueryTimeout = flag.Duration("read-query-timeout", 30*time.Second, "how long the queries of a read API request or named query may run before they are cancelled, so that they can't hold locks which stall pushes; 0 for no limit")
+	maxRowLimit      = flag.Int("max-row-limit", 1000, "the largest limit a read API request may ask for")
+	maxQueryRows     = flag.Int("max-query-rows", 10000, "the most rows a named query returns")
+)
+
+// partialHeader is sent with replies which only have some of the rows
+// asked for, saying why: "timeout" if the query ran out of time, or
+// "row_limit" if there were more rows than may be returned.
+const partialHeader = "Panopticon-Partial"
+
+func validateQueryLimitFlags() error {
+	if *readQueryTimeout < 0 {
+		return fmt.Errorf("invalid -read-query-timeout %s", *readQueryTimeout)
+	}
+	if *maxRowLimit < 1 || *maxQueryRows < 1 {
+		return errors.New("-max-row-limit and -max-query-rows must be at least 1")
+	}
+	return nil
+}
+
+// limitQueryTime gives the queries of a request -read-query-timeout to run,
+// through its context.
+func limitQueryTime(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if *readQueryTimeout <= 0 {
+			h(w, req)
+			return
+		}
+		ctx, cancel := context.WithTimeout(req.Context(), *readQueryTimeout)
+		defer cancel()
+		h(w, req.WithContext(ctx))
+	}
+}
+
+// isQueryTimeout reports whether a query failed because the request ran out
+// of time. Drivers report cancelled queries differently, so the request's
+// context is checked too.
+func isQueryTimeout(req *http.Request, err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || req.Context().Err() == context.DeadlineExceeded
+}
+
+// partialRows handles the error from reading the rows of a query which
+// lists them. If the query ran out of time, the rows read so far are
+// returned, with the reply marked partial, and nil is returned.
+func partialRows(w http.ResponseWriter, req *http.Request, err error) error {
+	if err == nil || !isQueryTimeout(req, err) {
+		return err
+	}
+	metrics.Inc("panopticon_read_query_timeouts_total", "result", "partial")
+	w.Header().Set(partialHeader, "timeout")
+	return nil
+}
+
+// replyQueryError replies to a read request whose query failed. A query
+// which ran out of time gets a 503, as it may succeed when the database is
+// less busy, or for a narrower request.
+func replyQueryError(w http.ResponseWriter, req *http.Request, err error, description string) {
+	if !isQueryTimeout(req, err) {
+		logAndReplyError(w, err, 500, description)
+		return
+	}
+	metrics.Inc("panopticon_read_query_timeouts_total", "result", "failed")
+	replyError(w, fmt.Errorf("query ran for longer than %s", *readQueryTimeout), http.StatusServiceUnavailable, errcodeQueryTimeout, description)
+}
@@ -0,0 +1,192 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Quota caps what a tenant may push each UTC day. A push belongs to the
+// tenant whose token it carries as a bearer token or, failing that, whose
+// homeservers include the one it reports for.
+type Quota struct {
+	Tokens        []string `json:"tokens"`
+	Homeservers   []string `json:"homeservers"`     // Server names, or "*.example.com" for any subdomain
+	ReportsPerDay int64    `json:"reports_per_day"` // 0 for no limit
+	BytesPerDay   int64    `json:"bytes_per_day"`   // 0 for no limit
+}
+
+func (q *Quota) validate() error {
+	if len(q.Tokens) == 0 && len(q.Homeservers) == 0 {
+		return errors.New("no tokens or homeservers")
+	}
+	if q.ReportsPerDay < 0 || q.BytesPerDay < 0 {
+		return errors.New("negative limit")
+	}
+	for _, t := range q.Tokens {
+		if t == "" {
+			return errors.New("empty token")
+		}
+	}
+	return nil
+}
+
+func (q *Quota) hasToken(token string) bool {
+	for _, t := range q.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (q *Quota) hasHomeserver(homeserver string) bool {
+	for _, h := range q.Homeservers {
+		if h == homeserver || (strings.HasPrefix(h, "*.") && strings.HasSuffix(homeserver, h[1:])) {
+			return true
+		}
+	}
+	return false
+}
+
+// tenantFor returns the name of the tenant a push belongs to, or "" if it
+// isn't subject to a quota.
+func tenantFor(quotas map[string]*Quota, req *http.Request, homeserver string) string {
+	names := make([]string, 0, len(quotas))
+	for name := range quotas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	if token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer "); token != "" {
+		for _, name := range names {
+			if quotas[name].hasToken(token) {
+				return name
+			}
+		}
+	}
+	for _, name := range names {
+		if quotas[name].hasHomeserver(homeserver) {
+			return name
+		}
+	}
+	return ""
+}
+
+func createTableQuotaUsage(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS quota_usage(
+		tenant VARCHAR(256) NOT NULL,
+		day BIGINT NOT NULL,
+		reports BIGINT NOT NULL,
+		bytes BIGINT NOT NULL,
+		PRIMARY KEY (tenant, day)
+		)`)
+	return err
+}
+
+// exceeded returns the name of the limit which a push of size bytes would
+// take a tenant over, having pushed reports and bytes today, or "".
+func (q *Quota) exceeded(reports, bytes, size int64) string {
+	if q.ReportsPerDay > 0 && reports+1 > q.ReportsPerDay {
+		return "reports_per_day"
+	}
+	if q.BytesPerDay > 0 && bytes+size > q.BytesPerDay {
+		return "bytes_per_day"
+	}
+	return ""
+}
+
+// quotaUsage returns the reports and bytes a tenant has pushed on a day,
+// counted in days since the epoch.
+func quotaUsage(db *sql.DB, tenant string, day int64) (reports, bytes int64, err error) {
+	d := dialectFor(db)
+	err = db.QueryRow(
+		fmt.Sprintf("SELECT reports, bytes FROM quota_usage WHERE tenant = %s AND day = %s", d.placeholder(1), d.placeholder(2)),
+		tenant, day,
+	).Scan(&reports, &bytes)
+	if err == sql.ErrNoRows {
+		return 0, 0, nil
+	}
+	return reports, bytes, err
+}
+
+// countQuota counts a stored push of size bytes against a tenant's quota.
+// Usage is kept in the database rather than by each instance, so that a
+// quota applies to all of them together.
+func countQuota(db *sql.DB, tenant string, size int64, now time.Time) error {
+	d := dialectFor(db)
+	_, err := db.Exec(
+		d.upsert("quota_usage", []string{"tenant", "day"}, []string{"tenant", "day", "reports", "bytes"}, map[string]string{
+			"reports": d.quote("quota_usage.reports") + " + 1",
+			"bytes":   d.quote("quota_usage.bytes") + " + " + d.excluded("bytes"),
+		}),
+		tenant, now.UTC().Unix()/oneDay, 1, size,
+	)
+	if err != nil {
+		return err
+	}
+	metrics.Inc("panopticon_quota_reports_total", "tenant", tenant)
+	metrics.Add("panopticon_quota_bytes_total", float64(size), "tenant", tenant)
+	return nil
+}
+
+// pruneQuotaUsage deletes the usage of days before today.
+func pruneQuotaUsage(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		today := time.Now().UTC().Unix() / oneDay
+		res, err := db.ExecContext(ctx, "DELETE FROM quota_usage WHERE day < "+dialectFor(db).placeholder(1), today)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			addRowsAffected(ctx, n)
+		}
+		return nil
+	}
+}
+
+// checkQuota refuses a push which would take its tenant over quota,
+// returning whether it did. Otherwise it returns the tenant the push is to
+// be counted against with countQuota once it has been stored, if any.
+// Pushes are only counted once stored, so concurrent pushes can take a
+// tenant slightly over quota.
+func (r *Recorder) checkQuota(w http.ResponseWriter, req *http.Request, homeserver string, size int) (tenant string, refused bool) {
+	tenant = tenantFor(r.Config.Quotas, req, homeserver)
+	if tenant == "" {
+		return "", false
+	}
+	now := time.Now()
+	reports, bytes, err := quotaUsage(r.DB, tenant, now.UTC().Unix()/oneDay)
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error checking quota")
+		return "", true
+	}
+	exceeded := r.Config.Quotas[tenant].exceeded(reports, bytes, int64(size))
+	if exceeded == "" {
+		return tenant, false
+	}
+	metrics.Inc("panopticon_quota_exceeded_total", "tenant", tenant, "quota", exceeded)
+	midnight := now.UTC().Truncate(oneDay * time.Second).Add(oneDay * time.Second)
+	replyRetryLater(w, http.StatusTooManyRequests, errcodeQuotaExceeded, midnight.Sub(now), "Refused push",
+		fmt.Errorf("tenant %s exceeded %s", tenant, exceeded))
+	return "", true
+}
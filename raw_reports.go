@@ -0,0 +1,120 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"flag"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	storeRawReports    = flag.Bool("store-raw-reports", false, "keep the raw body of every push in the raw_reports table, for debugging")
+	rawReportMaxBytes  = flag.Int("raw-report-max-bytes", 64*1024, "raw bodies longer than this are truncated before being stored")
+	rawReportRetention = flag.Duration("raw-report-retention", 7*24*time.Hour, "how long to keep raw reports")
+)
+
+func createTableRawReports(db *sql.DB) error {
+	autoincrement := "AUTOINCREMENT"
+	primaryKeyType := "INTEGER"
+	blobType := "BLOB"
+
+	driver := driverFor(db)
+	if driver == "mysql" {
+		autoincrement = "AUTO_INCREMENT"
+		blobType = "MEDIUMBLOB"
+	} else if driver == "postgres" {
+		autoincrement = ""
+		primaryKeyType = "SERIAL"
+		blobType = "BYTEA"
+	} else if driver == "duckdb" {
+		seq, err := createIDSequence(db, "raw_reports")
+		if err != nil {
+			return err
+		}
+		autoincrement = "DEFAULT nextval('" + seq + "')"
+		primaryKeyType = "BIGINT"
+	}
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS raw_reports(
+		id ` + primaryKeyType + ` NOT NULL PRIMARY KEY ` + autoincrement + ` ,
+		received_at BIGINT NOT NULL,
+		remote_addr TEXT,
+		user_agent TEXT,
+		status INT,
+		truncated INT,
+		body_gzip ` + blobType + `
+		)`)
+	if err != nil {
+		return err
+	}
+	return createIndex(db, "raw_reports_received_at", "raw_reports", "received_at")
+}
+
+// saveRawReport stores a gzipped copy of a request body along with the
+// status code it was answered with.
+func saveRawReport(db *sql.DB, s *Storage, req *http.Request, body []byte, status int) error {
+	truncated := len(body) > *rawReportMaxBytes
+	if truncated {
+		body = body[:*rawReportMaxBytes]
+	}
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	zw.Write(body)
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	_, err := db.Exec(
+		dialectFor(db).insert("raw_reports", "received_at", "remote_addr", "user_agent", "status", "truncated", "body_gzip"),
+		time.Now().UTC().Unix(), s.Encryption.encrypt("remote_addr", req.RemoteAddr), s.Encryption.encrypt("user_agent", req.UserAgent()),
+		status, truncated, compressed.Bytes(),
+	)
+	return err
+}
+
+// pruneRawReports is the prune_raw_reports job, deleting raw reports older
+// than -raw-report-retention.
+func pruneRawReports(db *sql.DB) func(ctx context.Context) error {
+	d := dialectFor(db)
+	return func(ctx context.Context) error {
+		cutoff := time.Now().UTC().Add(-*rawReportRetention).Unix()
+		res, err := db.ExecContext(ctx, "DELETE FROM raw_reports WHERE received_at < "+d.placeholder(1), cutoff)
+		if err != nil {
+			return err
+		}
+		n, err := res.RowsAffected()
+		if err != nil || n == 0 {
+			return nil
+		}
+		addRowsAffected(ctx, n)
+		log.Printf("Pruned %d raw reports", n)
+		return analyzeAfterDelete(ctx, db, "raw_reports", n)
+	}
+}
+
+// statusRecorder remembers the status code written through it.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
@@ -0,0 +1,180 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// runReaggregate implements "panopticon reaggregate", which rebuilds the
+// daily histograms of a range of days, such as after backfilling reports,
+// changing a histogram's buckets or fixing a bug. Each day's histogram is
+// replaced, so it can be run again safely.
+func runReaggregate(args []string) int {
+	fs := flag.NewFlagSet("reaggregate", flag.ExitOnError)
+	fs.StringVar(dbDriver, "db-driver", "sqlite3", "the driver of the database")
+	fs.StringVar(dbPath, "db", "stats.db", "the database to rebuild histograms in")
+	fs.StringVar(configPath, "config", "", "the config file whose histograms to build")
+	fs.StringVar(statsTable, "stats-table", "stats", "the table Synapse reports are stored in")
+	fs.StringVar(dendriteStatsTable, "dendrite-stats-table", "dendrite_stats", "the table Dendrite reports are stored in")
+	from := fs.String("from", "", "the first day to rebuild, as YYYY-MM-DD")
+	to := fs.String("to", "", "the last day to rebuild, as YYYY-MM-DD; yesterday if empty")
+	metric := fs.String("metric", "", "only rebuild the histograms of this metric")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "Usage: panopticon reaggregate -from <day> [-to <day>] [flags]")
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+	if *from == "" || fs.NArg() > 0 || *dbDriver == "memory" {
+		fs.Usage()
+		return 2
+	}
+	storage := storageFromFlags()
+	if err := storage.validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	start, end, err := parseDayRange(*from, *to, time.Now())
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	config, err := loadConfig(*configPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading config: %v\n", err)
+		return 2
+	}
+	histograms := config.Histograms
+	if *metric != "" {
+		bounds, ok := histograms[*metric]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "%s has no histogram in the config\n", *metric)
+			return 2
+		}
+		histograms = map[string][]float64{*metric: bounds}
+	}
+	if len(histograms) == 0 {
+		fmt.Fprintln(os.Stderr, "No histograms are configured")
+		return 2
+	}
+
+	db, err := openDB(storage.Driver, *dbPath)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error opening %s: %v\n", *dbPath, err)
+		return 1
+	}
+	defer db.Close()
+	if err := createTableMetricHistograms(db); err != nil {
+		fmt.Fprintf(os.Stderr, "Error creating tables: %v\n", err)
+		return 1
+	}
+	names := make([]string, 0, len(histograms))
+	for m := range histograms {
+		names = append(names, m)
+	}
+	sort.Strings(names)
+	for _, m := range names {
+		rebuilt, skipped, err := reaggregateHistogram(context.Background(), db, storage, m, histograms[m], start, end)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error rebuilding %s: %v\n", m, err)
+			return 1
+		}
+		fmt.Printf("%s: rebuilt %d days, skipped %d days without reports\n", m, rebuilt, skipped)
+	}
+	return 0
+}
+
+// parseDayRange parses the days from and to, returning the start of the
+// first and of the day after the last. An empty to is yesterday. Today is
+// refused, as its histogram would be built from a partial day.
+func parseDayRange(from, to string, now time.Time) (int64, int64, error) {
+	first, err := time.Parse("2006-01-02", from)
+	if err != nil {
+		return 0, 0, fmt.Errorf("-from: %v", err)
+	}
+	today := now.UTC().Unix()
+	today -= today % oneDay
+	end := today
+	if to != "" {
+		last, err := time.Parse("2006-01-02", to)
+		if err != nil {
+			return 0, 0, fmt.Errorf("-to: %v", err)
+		}
+		end = last.Unix() + oneDay
+	}
+	if end > today {
+		return 0, 0, fmt.Errorf("-to must be before today, as today isn't over yet")
+	}
+	if first.Unix() >= end {
+		return 0, 0, fmt.Errorf("-from must not be after -to")
+	}
+	return first.Unix(), end, nil
+}
+
+// reaggregateHistogram replaces the histograms of metric for the days from
+// start to end. Days with no reports at all are left alone, as their
+// reports may have been pruned after their histograms were built.
+func reaggregateHistogram(ctx context.Context, db *sql.DB, s *Storage, metric string, bounds []float64, start, end int64) (rebuilt, skipped int, err error) {
+	tables := histogramTables(s.tables(), metric)
+	days, err := daysWithReports(ctx, db, s, start, end)
+	if err != nil {
+		return 0, 0, err
+	}
+	for day := start; day < end; day += oneDay {
+		if !days[day] {
+			skipped++
+			continue
+		}
+		if err := buildHistogram(ctx, db, metric, bounds, tables, day); err != nil {
+			return rebuilt, skipped, fmt.Errorf("on %s: %v", time.Unix(day, 0).UTC().Format("2006-01-02"), err)
+		}
+		rebuilt++
+	}
+	return rebuilt, skipped, nil
+}
+
+// daysWithReports returns the days from start to end on which any report
+// is stored.
+func daysWithReports(ctx context.Context, db *sql.DB, s *Storage, start, end int64) (map[int64]bool, error) {
+	d := dialectFor(db)
+	var selects []string
+	var args []interface{}
+	for _, t := range s.tables() {
+		selects = append(selects, fmt.Sprintf("SELECT DISTINCT local_timestamp - local_timestamp %% %d AS day FROM %s WHERE local_timestamp >= %s AND local_timestamp < %s",
+			oneDay, t.Name, d.placeholder(len(args)+1), d.placeholder(len(args)+2)))
+		args = append(args, start, end)
+	}
+	rows, err := db.QueryContext(ctx, strings.Join(selects, " UNION "), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	days := map[int64]bool{}
+	for rows.Next() {
+		var day int64
+		if err := rows.Scan(&day); err != nil {
+			return nil, err
+		}
+		days[day] = true
+	}
+	return days, rows.Err()
+}
@@ -0,0 +1,62 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+)
+
+var (
+	staleReports = flag.String("stale-reports", "accept", "what to do with replayed or out of date reports: accept, flag or reject")
+	maxReportAge = flag.Duration("max-report-age", 0, "reports whose timestamp is older than this are considered stale (0 to disable)")
+)
+
+// staleReason explains why a report looks like a replay of stale data, or
+// returns "" if it doesn't, given the report table it is bound for. Reports
+// without a timestamp are never stale.
+func (r *Recorder) staleReason(table string, c *CommonStats) (string, error) {
+	// Backfilled reports are old by design.
+	if r.StaleReports == "" || r.StaleReports == "accept" || c.RemoteTimestamp == nil || c.Backfilled != nil {
+		return "", nil
+	}
+	if r.MaxReportAge > 0 && *c.RemoteTimestamp < c.LocalTimestamp-int64(r.MaxReportAge.Seconds()) {
+		return fmt.Sprintf("timestamp %d is older than %s", *c.RemoteTimestamp, r.MaxReportAge), nil
+	}
+
+	var last sql.NullInt64
+	err := r.DB.QueryRow(
+		// Reports already flagged stale are left out, so that one with a bad
+		// timestamp doesn't hold back the ones after it.
+		fmt.Sprintf("SELECT MAX(remote_timestamp) FROM %s WHERE homeserver = %s AND (stale IS NULL OR stale = 0)", r.Storage.tableName(table), dialectFor(r.DB).placeholder(1)),
+		c.Homeserver,
+	).Scan(&last)
+	if err != nil {
+		return "", err
+	}
+	if last.Valid && *c.RemoteTimestamp <= last.Int64 {
+		return fmt.Sprintf("timestamp %d is not newer than last report at %d", *c.RemoteTimestamp, last.Int64), nil
+	}
+	return "", nil
+}
+
+func validateStaleReportsFlag() error {
+	switch *staleReports {
+	case "accept", "flag", "reject":
+		return nil
+	}
+	return fmt.Errorf("invalid -stale-reports %q", *staleReports)
+}
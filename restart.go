@@ -0,0 +1,364 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+var (
+	listenNetwork   = flag.String("listen-network", "tcp", "tcp4 or tcp6 to listen on one IP version only, dual to listen on separate IPv4 and IPv6 sockets whatever the host's defaults, or tcp to leave it to the host")
+	reusePort       = flag.Bool("reuse-port", false, "listen with SO_REUSEPORT, so a new process can start listening before the old one exits")
+	shutdownTimeout = flag.Duration("shutdown-timeout", 30*time.Second, "how long to wait for in-flight requests when shutting down")
+	handoffTimeout  = flag.Duration("handoff-timeout", time.Minute, "how long the process started by a SIGUSR2 handoff has to start serving, after which it is killed and the old process carries on")
+)
+
+// listenFDEnv is set for a child started by a listener handoff, to the
+// number of the inherited listening socket.
+const listenFDEnv = "PANOPTICON_LISTEN_FD"
+
+// readyFDEnv is set for a child started by a listener handoff, to the
+// number of a pipe it writes to once it is serving.
+const readyFDEnv = "PANOPTICON_READY_FD"
+
+func validateListenFlags() error {
+	switch *listenNetwork {
+	case "tcp", "tcp4", "tcp6", "dual":
+		return nil
+	}
+	return fmt.Errorf("invalid -listen-network %q: must be tcp, tcp4, tcp6 or dual", *listenNetwork)
+}
+
+// listenNetworks are the networks of the sockets -listen-network asks for.
+// Go makes tcp6 sockets IPv6-only, so with dual IPv4 clients can connect
+// even on hosts which default to IPv6-only sockets.
+func listenNetworks() []string {
+	if *listenNetwork == "dual" {
+		return []string{"tcp4", "tcp6"}
+	}
+	return []string{*listenNetwork}
+}
+
+// listen returns the sockets to serve on, as one listener. They are
+// inherited if we were started by systemd socket activation or by a handoff
+// from a previous process, and opened on addr otherwise.
+func listen(addr string) (net.Listener, error) {
+	var lns []net.Listener
+	if fds, ok := inheritedFDs(); ok {
+		for _, fd := range fds {
+			f := os.NewFile(fd, "listener")
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				closeListeners(lns)
+				return nil, fmt.Errorf("using inherited listener: %v", err)
+			}
+			log.Printf("Serving on inherited listener %s", ln.Addr())
+			lns = append(lns, ln)
+		}
+		return newMultiListener(lns), nil
+	}
+	lc := net.ListenConfig{}
+	if *reusePort {
+		lc.Control = reusePortControl
+	}
+	for _, network := range listenNetworks() {
+		ln, err := lc.Listen(context.Background(), network, addr)
+		if err != nil {
+			closeListeners(lns)
+			return nil, err
+		}
+		log.Printf("Listening on %s (%s)", ln.Addr(), network)
+		lns = append(lns, ln)
+	}
+	return newMultiListener(lns), nil
+}
+
+func closeListeners(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+func inheritedFDs() ([]uintptr, bool) {
+	if v := os.Getenv(listenFDEnv); v != "" {
+		os.Unsetenv(listenFDEnv)
+		var fds []uintptr
+		for _, s := range strings.Split(v, ",") {
+			fd, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, false
+			}
+			fds = append(fds, uintptr(fd))
+		}
+		return fds, true
+	}
+	// systemd socket activation passes its sockets from fd 3.
+	if os.Getenv("LISTEN_PID") == strconv.Itoa(os.Getpid()) && os.Getenv("LISTEN_FDS") != "" {
+		n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+		os.Unsetenv("LISTEN_PID")
+		os.Unsetenv("LISTEN_FDS")
+		if err != nil || n < 1 {
+			return nil, false
+		}
+		fds := make([]uintptr, n)
+		for i := range fds {
+			fds[i] = uintptr(3 + i)
+		}
+		return fds, true
+	}
+	return nil, false
+}
+
+// multiListener accepts connections from several listeners, such as the
+// IPv4 and IPv6 sockets of -listen-network=dual.
+type multiListener struct {
+	lns   []net.Listener
+	conns chan acceptResult
+	done  chan struct{}
+	once  sync.Once
+}
+
+type acceptResult struct {
+	conn net.Conn
+	err  error
+}
+
+func newMultiListener(lns []net.Listener) net.Listener {
+	if len(lns) == 1 {
+		return lns[0]
+	}
+	m := &multiListener{lns: lns, conns: make(chan acceptResult), done: make(chan struct{})}
+	for _, ln := range lns {
+		go m.accept(ln)
+	}
+	return m
+}
+
+func (m *multiListener) accept(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		select {
+		case m.conns <- acceptResult{conn, err}:
+		case <-m.done:
+			if conn != nil {
+				conn.Close()
+			}
+			return
+		}
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+	}
+}
+
+func (m *multiListener) Accept() (net.Conn, error) {
+	select {
+	case r := <-m.conns:
+		return r.conn, r.err
+	case <-m.done:
+		return nil, net.ErrClosed
+	}
+}
+
+func (m *multiListener) Close() error {
+	var err error
+	m.once.Do(func() {
+		close(m.done)
+		for _, ln := range m.lns {
+			if e := ln.Close(); e != nil && err == nil {
+				err = e
+			}
+		}
+	})
+	return err
+}
+
+func (m *multiListener) Addr() net.Addr {
+	return m.lns[0].Addr()
+}
+
+// serve serves HTTP on ln until told to stop. SIGINT and SIGTERM, or on
+// Windows a stop request from the service manager or closing the console,
+// drain in-flight requests and return. On platforms which support it, SIGUSR2
+// starts a new copy of the binary which inherits the listening socket, then
+// drains and returns, so a deploy doesn't refuse any connections.
+func serve(srv *http.Server, ln net.Listener) error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- srv.Serve(ln)
+	}()
+	signalReady()
+
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, handoffSignals...)...)
+	for {
+		select {
+		case err := <-errs:
+			return err
+		case <-serviceStop:
+			log.Printf("Stopping the service, draining requests")
+			return drain(srv, errs)
+		case sig := <-sigs:
+			if isHandoffSignal(sig) {
+				if err := handoff(ln); err != nil {
+					log.Printf("Error handing off listener, continuing to serve: %v", err)
+					continue
+				}
+				log.Printf("Handed off listener, draining requests")
+			} else {
+				log.Printf("Received %s, draining requests", sig)
+			}
+			return drain(srv, errs)
+		}
+	}
+}
+
+// drain shuts srv down, waiting up to -shutdown-timeout for in-flight
+// requests, then for Serve to return its error on errs.
+func drain(srv *http.Server, errs <-chan error) error {
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	if err := <-errs; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// handoff starts a copy of this process with the same arguments, passing
+// it the listening sockets, and waits until it is serving.
+func handoff(ln net.Listener) error {
+	lns := []net.Listener{ln}
+	if m, ok := ln.(*multiListener); ok {
+		lns = m.lns
+	}
+	var (
+		files []*os.File
+		fds   []string
+	)
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+	for _, ln := range lns {
+		tcp, ok := ln.(*net.TCPListener)
+		if !ok {
+			return fmt.Errorf("cannot hand off %T", ln)
+		}
+		f, err := tcp.File()
+		if err != nil {
+			return err
+		}
+		// ExtraFiles start at fd 3.
+		fds = append(fds, strconv.Itoa(3+len(files)))
+		files = append(files, f)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer ready.Close()
+	cmd.ExtraFiles = append(files, readyW)
+	cmd.Env = append(os.Environ(),
+		listenFDEnv+"="+strings.Join(fds, ","),
+		readyFDEnv+"="+strconv.Itoa(3+len(files)))
+	err = cmd.Start()
+	// Only the child may hold the write end, so that reading from the pipe
+	// ends if it exits.
+	readyW.Close()
+	if err != nil {
+		return err
+	}
+	return waitReady(cmd, ready, *handoffTimeout)
+}
+
+// waitReady waits for the child started by a handoff to write to ready,
+// signalling that it is serving. If it exits first, or isn't serving within
+// timeout, it is killed and an error returned, so that we carry on serving
+// rather than drain with nobody to take over.
+func waitReady(cmd *exec.Cmd, ready *os.File, timeout time.Duration) error {
+	signalled := make(chan error, 1)
+	go func() {
+		_, err := ready.Read(make([]byte, 1))
+		signalled <- err
+	}()
+	var failure string
+	select {
+	case err := <-signalled:
+		if err == nil {
+			go cmd.Wait()
+			return nil
+		}
+		failure = "new process exited before serving"
+	case <-time.After(timeout):
+		failure = fmt.Sprintf("new process wasn't serving after %s", timeout)
+	}
+	cmd.Process.Kill()
+	return fmt.Errorf("%s: %v", failure, cmd.Wait())
+}
+
+// signalReady tells the process which handed its listener off to us that we
+// are serving, so it can drain.
+func signalReady() {
+	v := os.Getenv(readyFDEnv)
+	if v == "" {
+		return
+	}
+	os.Unsetenv(readyFDEnv)
+	fd, err := strconv.Atoi(v)
+	if err != nil {
+		log.Printf("Invalid %s %q", readyFDEnv, v)
+		return
+	}
+	f := os.NewFile(uintptr(fd), "ready")
+	defer f.Close()
+	if _, err := f.Write([]byte{1}); err != nil {
+		log.Printf("Error signalling that we are serving: %v", err)
+	}
+}
+
+func isHandoffSignal(sig os.Signal) bool {
+	for _, s := range handoffSignals {
+		if s == sig {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,68 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build linux || darwin || freebsd || netbsd || openbsd || dragonfly
+
+package main
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// startChild runs script in a shell with the write end of a readiness pipe
+// as fd 3, as handoff does, returning the read end.
+func startChild(t *testing.T, script string) (*exec.Cmd, *os.File) {
+	t.Helper()
+	ready, readyW, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ready.Close() })
+	cmd := exec.Command("sh", "-c", script)
+	cmd.ExtraFiles = []*os.File{readyW}
+	err = cmd.Start()
+	readyW.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cmd, ready
+}
+
+func TestWaitReady(t *testing.T) {
+	cmd, ready := startChild(t, "printf x >&3; sleep 0.2")
+	if err := waitReady(cmd, ready, 10*time.Second); err != nil {
+		t.Errorf("child which signalled: %v", err)
+	}
+
+	cmd, ready = startChild(t, "exit 3")
+	if err := waitReady(cmd, ready, 10*time.Second); err == nil || !strings.Contains(err.Error(), "exited before serving") {
+		t.Errorf("child which exited: got %v", err)
+	}
+
+	start := time.Now()
+	cmd, ready = startChild(t, "sleep 30")
+	if err := waitReady(cmd, ready, 100*time.Millisecond); err == nil || !strings.Contains(err.Error(), "wasn't serving") {
+		t.Errorf("child which hung: got %v", err)
+	}
+	if time.Since(start) > 10*time.Second {
+		t.Errorf("child which hung wasn't killed")
+	}
+	if cmd.ProcessState == nil || cmd.ProcessState.Success() {
+		t.Errorf("child which hung: state %v", cmd.ProcessState)
+	}
+}
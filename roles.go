@@ -0,0 +1,139 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Role grants access to the read API with its own tokens, seeing only some
+// of the columns of reports.
+type Role struct {
+	Tokens        []string `json:"tokens"`
+	Columns       []string `json:"columns"`        // If set, the only visible columns
+	HiddenColumns []string `json:"hidden_columns"` // Columns which aren't visible
+	Privacy       *Privacy `json:"privacy"`        // If set, the role only sees protected aggregates
+
+	columns map[string]bool
+	hidden  map[string]bool
+}
+
+// compile checks the role named name, whose columns may also name string
+// metrics, and gives its privacy settings the field rules.
+func (r *Role) compile(name string, stringFields map[string]*StringMetric, fields map[string]FieldRule) error {
+	if len(r.Tokens) == 0 {
+		return errors.New("no tokens")
+	}
+	for _, t := range r.Tokens {
+		if t == "" {
+			return errors.New("empty token")
+		}
+	}
+	if r.Privacy != nil {
+		if err := r.Privacy.compile(name, fields); err != nil {
+			return err
+		}
+	}
+	known := map[string]bool{"id": true}
+	for name := range stringFields {
+		known[name] = true
+	}
+	for _, t := range reportColumns() {
+		for _, c := range t.Columns {
+			known[c.Name] = true
+		}
+	}
+	r.hidden = map[string]bool{}
+	for _, c := range r.HiddenColumns {
+		if !known[c] {
+			return fmt.Errorf("unknown column %s", c)
+		}
+		r.hidden[c] = true
+	}
+	if r.Columns == nil {
+		return nil
+	}
+	r.columns = map[string]bool{}
+	for _, c := range r.Columns {
+		if !known[c] {
+			return fmt.Errorf("unknown column %s", c)
+		}
+		r.columns[c] = true
+	}
+	return nil
+}
+
+// visible reports whether a column may be shown. A nil role sees every
+// column.
+func (r *Role) visible(column string) bool {
+	if r == nil {
+		return true
+	}
+	return !r.hidden[column] && (r.columns == nil || r.columns[column])
+}
+
+// visibleColumns returns the columns of t which the role may see, or nil
+// if it may see them all.
+func (r *Role) visibleColumns(t *tableDef) []string {
+	if r == nil {
+		return nil
+	}
+	cols := []string{}
+	for _, c := range append([]columnDef{{"id", ""}}, t.Columns...) {
+		if r.visible(c.Name) {
+			cols = append(cols, c.Name)
+		}
+	}
+	return cols
+}
+
+// hiddenColumnError refuses a query which needs a column the role can't
+// see, returning whether it did.
+func hiddenColumnError(w http.ResponseWriter, role *Role, column, use string) bool {
+	if role.visible(column) {
+		return false
+	}
+	logAndReplyError(w, fmt.Errorf("%s needs column %s, which isn't visible", use, column), http.StatusForbidden, "Forbidden query")
+	return true
+}
+
+type roleKey struct{}
+
+// roleOf returns the role a read API request was authorised as, or nil if
+// it used the read or admin token.
+func roleOf(req *http.Request) *Role {
+	role, _ := req.Context().Value(roleKey{}).(*Role)
+	return role
+}
+
+// matchRole returns the role with the given token, if any.
+func matchRole(roles map[string]*Role, token []byte) *Role {
+	for _, r := range roles {
+		for _, t := range r.Tokens {
+			if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+				return r
+			}
+		}
+	}
+	return nil
+}
+
+func withRole(req *http.Request, role *Role) *http.Request {
+	return req.WithContext(context.WithValue(req.Context(), roleKey{}, role))
+}
@@ -0,0 +1,58 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"net/http"
+)
+
+// sampleRate returns N such that one in N of the reports for a homeserver
+// should be stored, from the config's sampling rates for the homeserver or
+// failing that for its tenant.
+func (r *Recorder) sampleRate(req *http.Request, homeserver string) int64 {
+	if n, ok := r.Config.Sampling[homeserver]; ok {
+		return n
+	}
+	if tenant := tenantFor(r.Config.Quotas, req, homeserver); tenant != "" {
+		if n, ok := r.Config.Sampling[tenant]; ok {
+			return n
+		}
+	}
+	return 1
+}
+
+// sampledOut picks whether to drop a report sampled at one in n.
+func sampledOut(n int64) bool {
+	drop := n > 1 && rand.Int63n(n) != 0
+	if n > 1 {
+		if drop {
+			metrics.Inc("panopticon_sampled_reports_total", "result", "dropped")
+		} else {
+			metrics.Inc("panopticon_sampled_reports_total", "result", "stored")
+		}
+	}
+	return drop
+}
+
+func validateSampling(sampling map[string]int64) error {
+	for name, n := range sampling {
+		if n < 1 {
+			return fmt.Errorf("%s: rate must be at least 1", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"flag"
+	"net/http"
+	"path"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	scannerTraps = flag.Bool("scanner-traps", true, "answer requests which look like vulnerability scanners with a 404 without logging them, counting them in panopticon_scanner_requests_total")
+	scannerBan   = flag.Duration("scanner-ban", 0, "refuse every request from a client which hit a scanner trap for this long; 0 disables banning")
+)
+
+// trapPaths are paths, or prefixes of paths ending in a slash, which only
+// scanners ask for.
+var trapPaths = []string{
+	"/.env", "/.git/", "/.aws/", "/.ssh/", "/.DS_Store",
+	"/wp-admin/", "/wp-login.php", "/wp-content/", "/wp-includes/", "/xmlrpc.php",
+	"/phpmyadmin/", "/pma/", "/vendor/phpunit/",
+	"/cgi-bin/", "/actuator/", "/boaform/", "/HNAP1", "/owa/", "/solr/",
+	"/server-status", "/console/", "/manager/html",
+}
+
+// trapExtensions are file extensions of server side scripts, none of which
+// panopticon serves.
+var trapExtensions = map[string]bool{
+	".php": true, ".asp": true, ".aspx": true, ".jsp": true, ".cgi": true, ".env": true,
+}
+
+// trapUserAgents are substrings of the user agents of well known scanners.
+var trapUserAgents = []string{
+	"zgrab", "masscan", "nmap", "sqlmap", "nikto", "nuclei", "censysinspect", "l9explore",
+}
+
+// scannerSignature returns a short name for the kind of scanner traffic req
+// looks like, or "" if it looks legitimate.
+func scannerSignature(req *http.Request) string {
+	p := req.URL.Path
+	if strings.Contains(p, "..") {
+		return "traversal"
+	}
+	for _, t := range trapPaths {
+		if p == strings.TrimSuffix(t, "/") || (strings.HasSuffix(t, "/") && strings.HasPrefix(p, t)) {
+			return "path"
+		}
+	}
+	if trapExtensions[strings.ToLower(path.Ext(p))] {
+		return "extension"
+	}
+	ua := strings.ToLower(req.UserAgent())
+	for _, s := range trapUserAgents {
+		if strings.Contains(ua, s) {
+			return "user_agent"
+		}
+	}
+	return ""
+}
+
+// banList remembers clients which hit a trap until their ban expires.
+type banList struct {
+	mu     sync.Mutex
+	expiry map[string]time.Time
+}
+
+var bans = &banList{expiry: map[string]time.Time{}}
+
+func (b *banList) ban(ip string, until time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.expiry[ip] = until
+	now := time.Now()
+	for ip, t := range b.expiry {
+		if t.Before(now) {
+			delete(b.expiry, ip)
+		}
+	}
+	metrics.Set("panopticon_banned_clients", float64(len(b.expiry)))
+}
+
+func (b *banList) banned(ip string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	t, ok := b.expiry[ip]
+	return ok && time.Now().Before(t)
+}
+
+// trapScanners wraps the server's handler, turning away requests from
+// scanners before they reach an endpoint, so that they neither fill the logs
+// with decoding errors nor get stored.
+func trapScanners(h http.Handler) http.Handler {
+	if !*scannerTraps {
+		return h
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ip, _ := clientIP(req)
+		if *scannerBan > 0 && bans.banned(ip) {
+			metrics.Inc("panopticon_scanner_requests_total", "signature", "banned")
+			writeError(w, http.StatusForbidden, errcodeUnauthorized, "banned")
+			return
+		}
+		if sig := scannerSignature(req); sig != "" {
+			metrics.Inc("panopticon_scanner_requests_total", "signature", sig)
+			if *scannerBan > 0 {
+				bans.ban(ip, time.Now().Add(*scannerBan))
+			}
+			notFound(w, req)
+			return
+		}
+		h.ServeHTTP(w, req)
+	})
+}
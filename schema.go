@@ -0,0 +1,371 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	autoMigrate        = flag.Bool("auto-migrate", false, "add columns missing from existing tables, rather than only logging them")
+	statsTable         = flag.String("stats-table", "stats", "the table Synapse reports are stored in")
+	dendriteStatsTable = flag.String("dendrite-stats-table", "dendrite_stats", "the table Dendrite reports are stored in")
+	dbSchema           = flag.String("db-schema", "", "the Postgres schema or MySQL database holding the report tables, if not the connection's default")
+)
+
+// identifierRegexp matches the table and schema names which may be
+// configured. They are lower case, as Postgres folds unquoted names to lower
+// case but not quoted ones, and only some statements quote them.
+var identifierRegexp = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+type columnDef struct {
+	Name string
+	Type string
+}
+
+// tableDef describes a table with an auto-incrementing id primary key, so
+// that it can be both created and compared against the live schema.
+type tableDef struct {
+	Name    string
+	Columns []columnDef
+
+	// Kind is stats or dendrite_stats for the report tables, whatever
+	// their name, as in the read API.
+	Kind string
+
+	// GeneratedIDs keys the table by the ids its Storage generates instead
+	// of by an auto-incremented integer.
+	GeneratedIDs bool
+
+	// Indexes are the columns of each index on the table, comma separated.
+	Indexes []string
+}
+
+// reportIndexes are the indexes of the report tables, for the last report
+// of a homeserver which every push looks up to check for staleness and
+// downsampling.
+var reportIndexes = []string{"homeserver, remote_timestamp", "homeserver, local_timestamp"}
+
+func createTable(db *sql.DB, t *tableDef) error {
+	if err := createTableWithIDColumn(db, t); err != nil {
+		return err
+	}
+	return createIndexes(db, t)
+}
+
+// createIndexes creates the indexes of t whose columns all exist. A table
+// created by an older version may lack some until checkSchema adds them,
+// which creates the rest.
+func createIndexes(db *sql.DB, t *tableDef) error {
+	if len(t.Indexes) == 0 {
+		return nil
+	}
+	live, err := liveColumns(db, t.Name)
+	if err != nil {
+		return err
+	}
+	// Indexes are named after the table without its schema, as Postgres puts
+	// them in the schema of their table.
+	base := t.Name[strings.LastIndex(t.Name, ".")+1:]
+indexes:
+	for _, columns := range t.Indexes {
+		for _, c := range strings.Split(columns, ", ") {
+			if !live[c] {
+				continue indexes
+			}
+		}
+		name := base + "_" + strings.ReplaceAll(columns, ", ", "_")
+		if err := createIndex(db, name, t.Name, columns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func createTableWithIDColumn(db *sql.DB, t *tableDef) error {
+	if t.GeneratedIDs {
+		return createTableWithID(db, t, "id VARCHAR(36) NOT NULL PRIMARY KEY")
+	}
+	autoincrement := "AUTOINCREMENT"
+	primaryKeyType := "INTEGER"
+
+	driver := driverFor(db)
+	if driver == "mysql" {
+		autoincrement = "AUTO_INCREMENT"
+	} else if driver == "postgres" {
+		autoincrement = ""
+		primaryKeyType = "SERIAL"
+	} else if driver == "duckdb" {
+		seq, err := createIDSequence(db, t.Name)
+		if err != nil {
+			return err
+		}
+		autoincrement = "DEFAULT nextval('" + seq + "')"
+		primaryKeyType = "BIGINT"
+	}
+	return createTableWithID(db, t, "id "+primaryKeyType+" NOT NULL PRIMARY KEY "+autoincrement)
+}
+
+func createTableWithID(db *sql.DB, t *tableDef, id string) error {
+	cols := []string{id}
+	for _, c := range t.Columns {
+		cols = append(cols, c.Name+" "+c.Type)
+	}
+	_, err := db.Exec(fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s(\n\t\t%s\n\t\t)", t.Name, strings.Join(cols, ",\n\t\t")))
+	return err
+}
+
+// createTables creates every table panopticon uses which doesn't exist yet,
+// with the report tables described by s.
+func createTables(db *sql.DB, s *Storage) error {
+	for _, t := range s.tables() {
+		if err := createTable(db, t); err != nil {
+			return err
+		}
+	}
+	for _, create := range []func(*sql.DB) error{
+		createTableJobLocks,
+		createTableTombstones,
+		createTableDownsampledReports,
+		func(db *sql.DB) error { return createTableHomeservers(db, s) },
+		createTableHomeserverMetadata,
+		createTableRawReports,
+		createTableMetricHistograms,
+		createTableExportWatermarks,
+		createTableJobRuns,
+		createTableOptOuts,
+		createTableAlerts,
+		createTableSilences,
+		createTableAnnotations,
+		createTableStringMetrics,
+		createTableVerifications,
+		createTableShadowDivergences,
+		createTableQuotaUsage,
+	} {
+		if err := create(db); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createIDSequence creates the sequence which numbers the rows of table on
+// DuckDB, which has no auto-increment columns.
+func createIDSequence(db *sql.DB, table string) (string, error) {
+	seq := table + "_id_seq"
+	_, err := db.Exec("CREATE SEQUENCE IF NOT EXISTS " + seq)
+	return seq, err
+}
+
+// liveColumns returns the names of the columns a table currently has.
+func liveColumns(db *sql.DB, table string) (map[string]bool, error) {
+	rows, err := db.Query("SELECT * FROM " + table + " WHERE 1 = 0")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	names, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	cols := map[string]bool{}
+	for _, n := range names {
+		cols[strings.ToLower(n)] = true
+	}
+	return cols, nil
+}
+
+// retypeColumns checks that the columns of t meant to hold floats, those of
+// fields configured as floats, have a floating point type, changing them if
+// fix is set. A table created before a field was configured as a float has
+// an integer column, which would round or refuse fractional values. SQLite
+// keeps them whatever the column's type, so its tables are left be.
+func retypeColumns(db *sql.DB, t *tableDef, fix bool) error {
+	driver := driverFor(db)
+	if isSQLite(driver) {
+		return nil
+	}
+	rows, err := db.Query("SELECT * FROM " + t.Name + " WHERE 1 = 0")
+	if err != nil {
+		return err
+	}
+	types, err := rows.ColumnTypes()
+	rows.Close()
+	if err != nil {
+		return err
+	}
+	live := map[string]string{}
+	for _, ct := range types {
+		live[strings.ToLower(ct.Name())] = strings.ToUpper(ct.DatabaseTypeName())
+	}
+	for _, c := range t.Columns {
+		have, ok := live[c.Name]
+		if !ok || !strings.HasPrefix(c.Type, "DOUBLE") || !strings.Contains(have, "INT") {
+			continue
+		}
+		if !fix {
+			return fmt.Errorf("%s.%s is %s, but is configured as a float (run with -auto-migrate to change it to %s)", t.Name, c.Name, have, c.Type)
+		}
+		alter := "ALTER TABLE %s ALTER COLUMN %s TYPE %s"
+		if driver == "mysql" {
+			alter = "ALTER TABLE %s MODIFY COLUMN %s %s"
+		}
+		if _, err := db.Exec(fmt.Sprintf(alter, t.Name, c.Name, c.Type)); err != nil {
+			return fmt.Errorf("changing %s.%s to %s: %v", t.Name, c.Name, c.Type, err)
+		}
+		log.Printf("Schema drift: changed column %s of %s from %s to %s", c.Name, t.Name, have, c.Type)
+	}
+	return nil
+}
+
+type tableKey struct {
+	db    *sql.DB
+	table string
+}
+
+// liveColumnCache holds the live columns of each stats table, so that
+// inserts needn't look them up each time. checkSchema forgets them when it
+// adds columns.
+var liveColumnCache sync.Map // tableKey -> map[string]bool
+
+// withNulls returns every column of t which the table has, in order, with
+// the given values or a NULL of the column's type for the others, so that
+// inserts into a table always have the same columns. Given columns the
+// table lacks are still included, and fail the insert as before.
+func withNulls(db *sql.DB, t *tableDef, cols []string, vals []interface{}) ([]string, []interface{}, error) {
+	key := tableKey{db, t.Name}
+	cached, ok := liveColumnCache.Load(key)
+	if !ok {
+		live, err := liveColumns(db, t.Name)
+		if err != nil {
+			return nil, nil, err
+		}
+		cached, _ = liveColumnCache.LoadOrStore(key, live)
+	}
+	live := cached.(map[string]bool)
+	given := make(map[string]interface{}, len(cols))
+	for i, c := range cols {
+		given[c] = vals[i]
+	}
+	allCols := make([]string, 0, len(t.Columns))
+	allVals := make([]interface{}, 0, len(t.Columns))
+	for _, c := range t.Columns {
+		v, ok := given[c.Name]
+		if !ok && !live[c.Name] {
+			continue
+		}
+		if !ok {
+			v = nullOf(c.Type)
+		}
+		delete(given, c.Name)
+		allCols = append(allCols, c.Name)
+		allVals = append(allVals, v)
+	}
+	for _, c := range cols {
+		if v, ok := given[c]; ok {
+			allCols = append(allCols, c)
+			allVals = append(allVals, v)
+		}
+	}
+	return allCols, allVals, nil
+}
+
+// nullOf returns a NULL for a column of the given type.
+func nullOf(columnType string) interface{} {
+	switch {
+	case strings.Contains(columnType, "INT"):
+		return sql.NullInt64{}
+	case strings.HasPrefix(columnType, "DOUBLE"):
+		return sql.NullFloat64{}
+	default:
+		return sql.NullString{}
+	}
+}
+
+// checkSchema compares the live schema of each table with the expected
+// one and logs any differences. Missing columns are added if fix is set.
+// It returns the number of columns which are still missing.
+func checkSchema(db *sql.DB, tables []*tableDef, fix bool) (int, error) {
+	missingTotal := 0
+	for _, t := range tables {
+		live, err := liveColumns(db, t.Name)
+		if err != nil {
+			return 0, fmt.Errorf("reading schema of %s: %v", t.Name, err)
+		}
+		expected := map[string]bool{"id": true}
+		missing, added := 0, 0
+		for _, c := range t.Columns {
+			expected[c.Name] = true
+			if live[c.Name] {
+				continue
+			}
+			if !fix {
+				log.Printf("Schema drift: %s is missing column %s %s (run with -auto-migrate to add it)", t.Name, c.Name, c.Type)
+				missing++
+				continue
+			}
+			if _, err := db.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", t.Name, c.Name, c.Type)); err != nil {
+				log.Printf("Schema drift: error adding column %s to %s: %v", c.Name, t.Name, err)
+				missing++
+				continue
+			}
+			log.Printf("Schema drift: added column %s %s to %s", c.Name, c.Type, t.Name)
+			liveColumnCache.Delete(tableKey{db, t.Name})
+			added++
+		}
+		if added > 0 {
+			if err := createIndexes(db, t); err != nil {
+				log.Printf("Schema drift: error indexing %s: %v", t.Name, err)
+			}
+		}
+		if err := retypeColumns(db, t, fix); err != nil {
+			return 0, err
+		}
+		for c := range live {
+			if !expected[c] {
+				log.Printf("Schema drift: %s has unexpected column %s", t.Name, c)
+			}
+		}
+		metrics.Set("panopticon_schema_missing_columns", float64(missing), "table", t.Name)
+		missingTotal += missing
+	}
+	return missingTotal, nil
+}
+
+// reportColumns returns the columns of each kind of report table, which
+// are the same wherever and however the tables are stored, for checking
+// configured column names against.
+func reportColumns() []*tableDef {
+	return (&Storage{StatsTable: "stats", DendriteStatsTable: "dendrite_stats"}).tables()
+}
+
+// schemaCheck is the schema_check job, which repeats the startup schema
+// check in case the database was changed underneath us.
+func schemaCheck(db *sql.DB, s *Storage) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		missing, err := checkSchema(db, s.tables(), *autoMigrate)
+		if err == nil && missing > 0 {
+			err = fmt.Errorf("%d columns missing", missing)
+		}
+		return err
+	}
+}
@@ -9,6 +9,7 @@ from dateutil import tz
 from datetime import datetime
 
 from pymysql import Connection
+from typing import Sequence
 
 ONE_DAY = 24 * 60 * 60
 # if the aggregation table isn't populated, this (2015-01-01) is the date that
@@ -25,6 +26,13 @@ class Config:
         self.db_password = os.environ["PANOPTICON_DB_PASSWORD"]
         self.db_host = os.environ["PANOPTICON_DB_HOST"]
         self.db_port = int(os.environ["PANOPTICON_DB_PORT"])
+        # Homeservers with any of these tags, e.g. internal test servers,
+        # are left out of the aggregates.
+        self.exclude_tags = [
+            t.strip().lower()
+            for t in os.environ.get("PANOPTICON_EXCLUDE_TAGS", "").split(",")
+            if t.strip()
+        ]
 
     def connect_db(self) -> Connection:
         return pymysql.connect(
@@ -74,6 +82,24 @@ def set_up_aggregate_stats_table(db: Connection):
 
     create_table(db, SCHEMA)
 
+    # Panopticon creates this table too; decommissioned homeservers are
+    # excluded from the aggregates.
+    create_table(db, """
+        CREATE TABLE IF NOT EXISTS `tombstones` (
+            `homeserver` varchar(256) NOT NULL,
+            `reason` text,
+            `tombstoned_at` bigint(20) DEFAULT NULL,
+            PRIMARY KEY (`homeserver`)
+        )
+    """)
+    create_table(db, """
+        CREATE TABLE IF NOT EXISTS `homeserver_tags` (
+            `homeserver` varchar(256) NOT NULL,
+            `tag` varchar(64) NOT NULL,
+            PRIMARY KEY (`homeserver`, `tag`)
+        )
+    """)
+
 
 def main():
     configuration = Config()
@@ -85,11 +111,11 @@ def main():
     while True:
         now = datetime.utcnow().date()
         today = int(datetime(now.year, now.month, now.day, tzinfo=tz.tzutc()).strftime('%s'))
-        aggregate_until_today(db, today)
+        aggregate_until_today(db, today, configuration.exclude_tags)
         time.sleep(ONE_DAY)
 
 
-def aggregate_until_today(db: Connection, today: int):
+def aggregate_until_today(db: Connection, today: int, exclude_tags: Sequence[str] = ()):
     with db.cursor() as cursor:
         start_date_query = """
             SELECT day from aggregate_stats
@@ -104,6 +130,11 @@ def aggregate_until_today(db: Connection, today: int):
             # which is when the stats table is populated from.
             last_day_in_db = INITIAL_DAY
 
+    tag_filter = ""
+    if exclude_tags:
+        placeholders = ", ".join(["%s"] * len(exclude_tags))
+        tag_filter = f"AND homeserver NOT IN (SELECT homeserver FROM homeserver_tags WHERE tag IN ({placeholders}))"
+
     processing_day = last_day_in_db + ONE_DAY
     while processing_day < today:
         with db.cursor() as cursor:
@@ -145,18 +176,23 @@ def aggregate_until_today(db: Connection, today: int):
                     FROM stats
                     WHERE local_timestamp >= %s and local_timestamp < %s
                     AND total_users > 0
+                    AND homeserver NOT IN (SELECT homeserver FROM tombstones)
+                    {tag_filter}
                     GROUP BY homeserver
                     UNION
                     SELECT {QUERY_COLUMNS}, MAX(local_timestamp)
                     FROM dendrite_stats
                     WHERE local_timestamp >= %s and local_timestamp < %s
                     AND total_users > 0
+                    AND homeserver NOT IN (SELECT homeserver FROM tombstones)
+                    {tag_filter}
                     GROUP BY homeserver
                 ) as s;
             """
 
-            date_range = (processing_day, processing_day + ONE_DAY, processing_day, processing_day + ONE_DAY)
-            cursor.execute(query, date_range)
+            day_range = (processing_day, processing_day + ONE_DAY)
+            params = (day_range + tuple(exclude_tags)) * 2
+            cursor.execute(query, params)
             result = cursor.fetchone()
 
             insert_query = """
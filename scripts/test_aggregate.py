@@ -72,6 +72,8 @@ class AggregateTestCase(TestCase):
         db = self.config.connect_db()
         with db.cursor() as cursor:
             cursor.execute("DROP TABLE IF EXISTS aggregate_stats;")
+            cursor.execute("DROP TABLE IF EXISTS tombstones;")
+            cursor.execute("DROP TABLE IF EXISTS homeserver_tags;")
 
             for stats_table in ('stats', 'dendrite_stats'):
                 cursor.execute(f"DROP TABLE IF EXISTS {stats_table};")
@@ -157,3 +159,69 @@ class AggregateTestCase(TestCase):
             self.assertIsNot(row, None)
             self.assertEqual(row["total_users"], 1)
             self.assertEqual(row["daily_active_homeservers"], 1)
+
+    def test_tombstoned_homeservers_not_counted(self):
+        """
+        Tests that decommissioned homeservers are excluded from the aggregates.
+        """
+
+        db = self.config.connect_db()
+        with db.cursor() as cursor:
+            insert_recording(
+                cursor,
+                "hs1",
+                INITIAL_DAY + ONE_DAY + 300,
+                {metric: 1 for metric in METRIC_COLUMNS},
+            )
+            insert_recording(
+                cursor,
+                "hs2-decommissioned",
+                INITIAL_DAY + ONE_DAY + 300,
+                {metric: 3 for metric in METRIC_COLUMNS},
+            )
+            cursor.execute(
+                "INSERT INTO tombstones (homeserver, tombstoned_at) VALUES (%s, %s)",
+                ("hs2-decommissioned", INITIAL_DAY),
+            )
+
+        aggregate_until_today(db, today=INITIAL_DAY + 2 * ONE_DAY)
+
+        with db.cursor() as cursor:
+            row = select_aggregate(cursor, INITIAL_DAY + ONE_DAY)
+            self.assertIsNot(row, None)
+            self.assertEqual(row["total_users"], 1)
+            self.assertEqual(row["daily_active_homeservers"], 1)
+
+    def test_excluded_tags_not_counted(self):
+        """
+        Tests that homeservers with an excluded tag are left out of the
+        aggregates.
+        """
+
+        db = self.config.connect_db()
+        with db.cursor() as cursor:
+            insert_recording(
+                cursor,
+                "hs1",
+                INITIAL_DAY + ONE_DAY + 300,
+                {metric: 1 for metric in METRIC_COLUMNS},
+            )
+            insert_recording(
+                cursor,
+                "hs2-staging",
+                INITIAL_DAY + ONE_DAY + 300,
+                {metric: 3 for metric in METRIC_COLUMNS},
+                table="dendrite_stats",
+            )
+            cursor.execute(
+                "INSERT INTO homeserver_tags (homeserver, tag) VALUES (%s, %s)",
+                ("hs2-staging", "internal"),
+            )
+
+        aggregate_until_today(db, today=INITIAL_DAY + 2 * ONE_DAY, exclude_tags=["internal"])
+
+        with db.cursor() as cursor:
+            row = select_aggregate(cursor, INITIAL_DAY + ONE_DAY)
+            self.assertIsNot(row, None)
+            self.assertEqual(row["total_users"], 1)
+            self.assertEqual(row["daily_active_homeservers"], 1)
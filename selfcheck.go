@@ -0,0 +1,147 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+var (
+	selfCheck     = flag.Bool("self-check", true, "check the database, schema, write targets and configured paths on startup, and refuse to start if any check fails")
+	selfCheckOnly = flag.Bool("self-check-only", false, "run the startup self-check, then exit non-zero if it failed rather than serving")
+)
+
+// selfCheckTimeout bounds each self-check which talks to a database.
+const selfCheckTimeout = 10 * time.Second
+
+// startupCheck is one of the checks run before serving. Hint tells the
+// operator how to fix a failure. Failures of optional checks are only
+// warned about.
+type startupCheck struct {
+	Name     string
+	Run      func(ctx context.Context) error
+	Hint     string
+	Optional bool
+}
+
+// startupChecks are the checks of the self-check. missing is the number
+// of columns the schema check found missing.
+func startupChecks(db, readDB *sql.DB, missing int, targets []*writeTarget) []startupCheck {
+	checks := []startupCheck{
+		{Name: "database", Run: func(ctx context.Context) error {
+			return db.PingContext(ctx)
+		}, Hint: "check -db and that the database server is up"},
+		{Name: "schema", Run: func(ctx context.Context) error {
+			if missing > 0 {
+				return fmt.Errorf("%d columns are missing", missing)
+			}
+			return nil
+		}, Hint: "run with -auto-migrate to add them, or add them by hand"},
+	}
+	if !*readOnly {
+		checks = append(checks, startupCheck{Name: "database writes", Run: func(ctx context.Context) error {
+			return checkWritable(ctx, db)
+		}, Hint: "check the database user's permissions, that -db isn't a read-only replica and that a sqlite file's directory is writable; -read-only starts without writes"})
+	}
+	if readDB != db {
+		checks = append(checks, startupCheck{Name: "read database", Run: func(ctx context.Context) error {
+			return readDB.PingContext(ctx)
+		}, Hint: "check -read-db or -read-snapshot"})
+	}
+	for _, t := range targets {
+		t := t
+		if t.db == nil {
+			continue
+		}
+		// Reports are still stored while a target which isn't required is
+		// down, so that only warrants a warning.
+		checks = append(checks, startupCheck{Name: "write target " + t.Name, Run: func(ctx context.Context) error {
+			return t.db.PingContext(ctx)
+		}, Hint: "check its dsn in the config file", Optional: !t.Required})
+	}
+	if *dbDriver == "memory" && *memoryDump != "" {
+		checks = append(checks, startupCheck{Name: "memory dump directory", Run: func(ctx context.Context) error {
+			return checkDirWritable(filepath.Dir(*memoryDump))
+		}, Hint: "create the directory of -memory-dump, writable by this user"})
+	}
+	if *shadowURL != "" {
+		checks = append(checks, startupCheck{Name: "shadow URL", Run: func(ctx context.Context) error {
+			u, err := url.Parse(*shadowURL)
+			if err == nil && (u.Scheme != "http" && u.Scheme != "https" || u.Host == "") {
+				err = fmt.Errorf("%q isn't an absolute http or https URL", *shadowURL)
+			}
+			return err
+		}, Hint: "set -shadow-url to the legacy collector's push URL"})
+	}
+	return checks
+}
+
+// checkWritable makes a change to db which is rolled back, which fails if
+// the database can't be written.
+func checkWritable(ctx context.Context, db *sql.DB) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	// No hash is empty, so nothing is deleted, but the statement still
+	// needs write access.
+	_, err = tx.ExecContext(ctx, "DELETE FROM opt_outs WHERE homeserver_hash = "+dialectFor(db).placeholder(1), "")
+	return err
+}
+
+func checkDirWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".panopticon-self-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// runStartupChecks runs every check, logging each result, and returns
+// whether all those which aren't optional passed.
+func runStartupChecks(checks []startupCheck) bool {
+	failed := 0
+	for _, c := range checks {
+		ctx, cancel := context.WithTimeout(context.Background(), selfCheckTimeout)
+		err := c.Run(ctx)
+		cancel()
+		switch {
+		case err == nil:
+			log.Printf("Self-check %s: ok", c.Name)
+			metrics.Set("panopticon_self_check_ok", 1, "check", c.Name)
+			continue
+		case c.Optional:
+			log.Printf("Self-check %s: WARNING: %v (%s)", c.Name, err, c.Hint)
+		default:
+			failed++
+			log.Printf("Self-check %s: FAILED: %v (%s)", c.Name, err, c.Hint)
+		}
+		metrics.Set("panopticon_self_check_ok", 0, "check", c.Name)
+	}
+	if failed > 0 {
+		log.Printf("Self-check: %d of %d checks failed", failed, len(checks))
+	}
+	return failed == 0
+}
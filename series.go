@@ -0,0 +1,249 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	oneDay            = 24 * 60 * 60
+	defaultSeriesDays = 30
+	maxSeriesDays     = 366
+)
+
+// seriesMetrics returns the report fields which can be rolled up, which are
+// the integer fields common to every homeserver implementation.
+func seriesMetrics() map[string]bool {
+	names := map[string]bool{}
+	t := reflect.TypeOf(CommonStats{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name := strings.Split(f.Tag.Get("json"), ",")[0]
+		if f.Type == reflect.TypeOf((*int64)(nil)) && name != "" && name != "-" && name != "timestamp" {
+			names[name] = true
+		}
+	}
+	return names
+}
+
+func validateDerivedMetrics(derived map[string]*Expr) error {
+	known := seriesMetrics()
+	for name, e := range derived {
+		if _, ok := engagementMetrics[name]; known[name] || ok {
+			return fmt.Errorf("%s is already a metric", name)
+		}
+		for _, v := range e.Vars() {
+			if !known[v] {
+				return fmt.Errorf("%s: unknown metric %s", name, v)
+			}
+		}
+	}
+	return nil
+}
+
+// SeriesHandler serves /api/v1/series, daily rollups of metrics over every
+// homeserver. Each day sums the last report of that day from each
+// homeserver with any users, as scripts/aggregate.py does. Derived metrics
+// from the config file are computed from those sums, and engagement metrics
+// from the homeservers which reported both of their metrics.
+//
+// It accepts the query parameters metric (repeated), since and until
+// (seconds, defaulting to the last 30 days), homeserver, tag, exclude_tag,
+// product and version. With annotations=1, the series is returned with the
+// annotations overlapping it, optionally those with each annotation_tag.
+type SeriesHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+	Derived map[string]*Expr
+}
+
+func (h *SeriesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	q := req.URL.Query()
+	requested := q["metric"]
+	if len(requested) == 0 {
+		logAndReplyError(w, fmt.Errorf("missing metric"), 400, "Bad query")
+		return
+	}
+	known := seriesMetrics()
+	needed := map[string]bool{}
+	for _, m := range requested {
+		if known[m] {
+			needed[m] = true
+		} else if e, ok := h.Derived[m]; ok {
+			for _, v := range e.Vars() {
+				needed[v] = true
+			}
+		} else if e, ok := engagementMetrics[m]; ok {
+			needed[e.numerator] = true
+			needed[e.denominator] = true
+		} else {
+			logAndReplyError(w, fmt.Errorf("unknown metric %q", m), 400, "Bad query")
+			return
+		}
+	}
+	if (q.Get("homeserver") != "" || len(q["tag"]) > 0 || len(q["exclude_tag"]) > 0) &&
+		hiddenColumnError(w, roleOf(req), "homeserver", "homeserver and tag") {
+		return
+	}
+	product, ok := productFilterOf(w, req)
+	if !ok {
+		return
+	}
+	var columns []string
+	index := map[string]int{}
+	for m := range needed {
+		if hiddenColumnError(w, roleOf(req), m, "metric "+m) {
+			return
+		}
+		index[m] = len(columns)
+		columns = append(columns, m)
+	}
+
+	now := time.Now().UTC().Unix()
+	until := now - now%oneDay + oneDay
+	since := until - defaultSeriesDays*oneDay
+	for _, p := range []struct {
+		param string
+		value *int64
+	}{{"since", &since}, {"until", &until}} {
+		if v := q.Get(p.param); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				logAndReplyError(w, err, 400, "Bad query")
+				return
+			}
+			*p.value = ts
+		}
+	}
+	since -= since % oneDay
+	if until <= since || until-since > maxSeriesDays*oneDay {
+		logAndReplyError(w, fmt.Errorf("bad range %d to %d", since, until), 400, "Bad query")
+		return
+	}
+	markPruned(w, since)
+
+	// latest[day][homeserver] is the last report of the day.
+	latest := map[int64]map[string][]sql.NullFloat64{}
+	for _, table := range []string{"stats", "dendrite_stats"} {
+		args := []interface{}{since, until}
+		where := []string{
+			"local_timestamp >= " + d.placeholder(1),
+			"local_timestamp < " + d.placeholder(2),
+			"total_users > 0",
+			"homeserver NOT IN (SELECT homeserver FROM tombstones)",
+		}
+		if hs := q.Get("homeserver"); hs != "" {
+			args = append(args, h.Storage.storedHomeserver(hs))
+			where = append(where, "homeserver = "+d.placeholder(len(args)))
+		}
+		where = append(where, tagConditions(q, d, "homeserver", &args)...)
+		where = append(where, product.conditions(d, &args)...)
+		qry := fmt.Sprintf("SELECT homeserver, local_timestamp, %s FROM %s WHERE %s ORDER BY local_timestamp",
+			strings.Join(columns, ", "), h.Storage.tableName(table), strings.Join(where, " AND "))
+		if err := collectLatest(req.Context(), h.DB, qry, args, len(columns), latest); err != nil {
+			replyQueryError(w, req, err, "Error querying series")
+			return
+		}
+	}
+
+	series := []map[string]interface{}{}
+	for day := since; day < until; day += oneDay {
+		sums := map[string]float64{}
+		counts := map[string]int64{}
+		for _, values := range latest[day] {
+			for i, v := range values {
+				if v.Valid {
+					sums[columns[i]] += v.Float64
+					counts[columns[i]]++
+				}
+			}
+		}
+		if p := privacyOf(req); p != nil {
+			for m, v := range sums {
+				if protected, ok := p.sum(m, fmt.Sprint(day), v, counts[m]); ok {
+					sums[m] = protected
+				} else {
+					delete(sums, m)
+				}
+			}
+		}
+		point := map[string]interface{}{"day": day}
+		for _, m := range requested {
+			var v float64
+			var ok bool
+			if e, derived := h.Derived[m]; derived {
+				v, ok = e.Eval(sums)
+			} else if e, engagement := engagementMetrics[m]; engagement {
+				v, ok = e.eval(latest[day], index, privacyOf(req), day)
+			} else {
+				v, ok = sums[m]
+			}
+			if ok {
+				point[m] = v
+			} else {
+				point[m] = nil
+			}
+		}
+		series = append(series, point)
+	}
+	if q.Get("annotations") != "1" {
+		writeJSON(w, series)
+		return
+	}
+	annotations, err := queryAnnotations(h.DB, since, until, q["annotation_tag"])
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error loading annotations")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"series": series, "annotations": annotations})
+}
+
+// collectLatest runs a query returning homeserver, local_timestamp and n
+// metrics, ordered by local_timestamp, and records the last row of each day
+// for each homeserver in latest.
+func collectLatest(ctx context.Context, db *sql.DB, qry string, args []interface{}, n int, latest map[int64]map[string][]sql.NullFloat64) error {
+	rows, err := db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hs string
+		var ts int64
+		values := make([]sql.NullFloat64, n)
+		dest := []interface{}{&hs, &ts}
+		for i := range values {
+			dest = append(dest, &values[i])
+		}
+		if err := rows.Scan(dest...); err != nil {
+			return err
+		}
+		day := ts - ts%oneDay
+		if latest[day] == nil {
+			latest[day] = map[string][]sql.NullFloat64{}
+		}
+		latest[day][hs] = values
+	}
+	return rows.Err()
+}
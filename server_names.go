@@ -0,0 +1,138 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	serverFetchRateLimit = flag.Float64("server-fetch-rate-limit", 0.1, "requests per second each client IP may make to /opt-out, /verify and /verify/challenge, which fetch from the homeserver they name")
+	serverFetchRateBurst = flag.Int("server-fetch-rate-burst", 10, "requests a client IP may make at once to /opt-out, /verify and /verify/challenge")
+)
+
+var (
+	dnsNameRegexp = regexp.MustCompile(`^[A-Za-z0-9.-]{1,255}$`)
+	portRegexp    = regexp.MustCompile(`^[0-9]{1,5}$`)
+)
+
+// cgnatNet is the shared address space of carrier-grade NATs, which
+// net.IP.IsPrivate doesn't cover.
+var _, cgnatNet, _ = net.ParseCIDR("100.64.0.0/10")
+
+// validateServerName checks that name is a Matrix server name, a DNS name
+// or IP literal with an optional port, which is safe to fetch from: it
+// can't change the path of the URL it is put in, and isn't a loopback,
+// link-local or private address.
+func validateServerName(name string) error {
+	host := name
+	if i := strings.LastIndexByte(name, ':'); i >= 0 && !strings.HasSuffix(name, "]") {
+		host = name[:i]
+		if !portRegexp.MatchString(name[i+1:]) {
+			return fmt.Errorf("invalid port in server name %q", name)
+		}
+	}
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		ip := net.ParseIP(host[1 : len(host)-1])
+		if ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid IPv6 literal in server name %q", name)
+		}
+		return checkPublicIP(ip)
+	}
+	if !dnsNameRegexp.MatchString(host) {
+		return fmt.Errorf("invalid server name %q", name)
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return checkPublicIP(ip)
+	}
+	lower := strings.ToLower(strings.TrimSuffix(host, "."))
+	if lower == "localhost" || strings.HasSuffix(lower, ".localhost") {
+		return fmt.Errorf("server name %q is local", name)
+	}
+	return nil
+}
+
+var errPrivateAddress = errors.New("refusing to fetch from a loopback, link-local or private address")
+
+func checkPublicIP(ip net.IP) error {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsLinkLocalUnicast() ||
+		ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || cgnatNet.Contains(ip) {
+		return errPrivateAddress
+	}
+	return nil
+}
+
+// serverFetchClient returns the client which fetches from the URLs made
+// from templates. If a server name sent by a client picks the host of any
+// of them, it refuses to connect to private addresses, so that a name
+// resolving to one, or redirecting to one, can't reach internal services.
+func serverFetchClient(templates ...string) *http.Client {
+	client := &http.Client{Timeout: 10 * time.Second}
+	guarded := false
+	for _, t := range templates {
+		host := t
+		if i := strings.Index(host, "://"); i >= 0 {
+			host = host[i+3:]
+		}
+		if i := strings.IndexByte(host, '/'); i >= 0 {
+			host = host[:i]
+		}
+		guarded = guarded || strings.Contains(host, "%s")
+	}
+	if !guarded {
+		return client
+	}
+	dialer := &net.Dialer{
+		Timeout: 10 * time.Second,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			ip := net.ParseIP(host)
+			if ip == nil {
+				return fmt.Errorf("unexpected address %q", address)
+			}
+			return checkPublicIP(ip)
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.DialContext = dialer.DialContext
+	// A proxy would make the connection in our stead, so bypass it.
+	transport.Proxy = nil
+	client.Transport = transport
+	return client
+}
+
+// newServerFetchLimiter returns the rate limit of the endpoints which
+// fetch from the homeserver a request names.
+func newServerFetchLimiter() middleware {
+	if *serverFetchRateLimit <= 0 {
+		return chain()
+	}
+	burst := *serverFetchRateBurst
+	if burst < 1 {
+		burst = 1
+	}
+	return rateLimit("server_fetch", newRateLimiter(*serverFetchRateLimit, burst))
+}
@@ -0,0 +1,126 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/eventlog"
+)
+
+var serviceName = flag.String("service-name", "panopticon", "the name panopticon is registered under when run as a Windows service")
+
+var (
+	// serviceStop is closed when the service manager asks us to stop.
+	serviceStop chan struct{}
+	// serviceDone is closed once we have stopped serving, and serviceExited
+	// once the service manager has been told so.
+	serviceDone, serviceExited chan struct{}
+)
+
+// startService hooks panopticon up to the Windows service manager, if it
+// was started by it. Logs go to the Windows event log under the service's
+// name, and relative paths are resolved against the directory of the
+// executable rather than System32.
+func startService() error {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return err
+	}
+	if el, err := eventlog.Open(*serviceName); err == nil {
+		log.SetOutput(eventLogWriter{el})
+		log.SetFlags(0)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	if err := os.Chdir(filepath.Dir(exe)); err != nil {
+		return err
+	}
+	serviceStop = make(chan struct{})
+	serviceDone = make(chan struct{})
+	serviceExited = make(chan struct{})
+	go func() {
+		defer close(serviceExited)
+		if err := svc.Run(*serviceName, windowsService{}); err != nil {
+			log.Printf("Error running as a service: %v", err)
+		}
+	}()
+	return nil
+}
+
+// stopService tells the service manager that we have stopped.
+func stopService() {
+	if serviceDone == nil {
+		return
+	}
+	close(serviceDone)
+	<-serviceExited
+}
+
+type windowsService struct{}
+
+// Execute handles requests from the service manager. Pausing the service
+// enables maintenance mode, so that pushes are refused with 503 and
+// reporters retry later, and continuing it disables maintenance mode again.
+func (windowsService) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case <-serviceDone:
+			// Stopped serving without being asked to, such as on an error.
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Pause:
+				maintenance.set(true, int64(maintenanceRetryAfter.Seconds()))
+				log.Print("Service paused, refusing pushes")
+				status <- svc.Status{State: svc.Paused, Accepts: accepts}
+			case svc.Continue:
+				maintenance.set(false, 0)
+				log.Print("Service continued, accepting pushes")
+				status <- svc.Status{State: svc.Running, Accepts: accepts}
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				close(serviceStop)
+				<-serviceDone
+				return false, 0
+			}
+		}
+	}
+}
+
+type eventLogWriter struct {
+	*eventlog.Log
+}
+
+func (w eventLogWriter) Write(p []byte) (int, error) {
+	msg := strings.TrimSuffix(string(p), "\n")
+	if err := w.Info(1, msg); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,79 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build windows
+
+package main
+
+import (
+	"testing"
+	"time"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// TestServiceRequests drives the service handler as the service manager
+// would: pausing and continuing toggle maintenance mode, and stopping waits
+// for serving to stop.
+func TestServiceRequests(t *testing.T) {
+	setFlag(t, &serviceStop, make(chan struct{}))
+	setFlag(t, &serviceDone, make(chan struct{}))
+	defer maintenance.set(false, 0)
+
+	requests := make(chan svc.ChangeRequest)
+	status := make(chan svc.Status, 1)
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		windowsService{}.Execute(nil, requests, status)
+	}()
+	expect := func(want svc.State) {
+		t.Helper()
+		select {
+		case s := <-status:
+			if s.State != want {
+				t.Fatalf("state %d, want %d", s.State, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no status, want %d", want)
+		}
+	}
+	expect(svc.Running)
+
+	requests <- svc.ChangeRequest{Cmd: svc.Pause}
+	expect(svc.Paused)
+	if enabled, _ := maintenance.get(); !enabled {
+		t.Error("pausing didn't enable maintenance mode")
+	}
+	requests <- svc.ChangeRequest{Cmd: svc.Continue}
+	expect(svc.Running)
+	if enabled, _ := maintenance.get(); enabled {
+		t.Error("continuing didn't disable maintenance mode")
+	}
+
+	requests <- svc.ChangeRequest{Cmd: svc.Stop}
+	expect(svc.StopPending)
+	select {
+	case <-serviceStop:
+	case <-time.After(5 * time.Second):
+		t.Fatal("stopping didn't tell the server to stop")
+	}
+	select {
+	case <-exited:
+		t.Fatal("handler returned before serving stopped")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(serviceDone)
+	<-exited
+}
@@ -0,0 +1,268 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"time"
+)
+
+var (
+	shadowURL           = flag.String("shadow-url", "", "forward every push to this legacy collector too, and record where its response differs from panopticon's; empty doesn't")
+	shadowCompareBodies = flag.Bool("shadow-compare-bodies", false, "with -shadow-url, also count responses whose JSON bodies differ as divergent, not just those whose statuses do")
+	shadowTimeout       = flag.Duration("shadow-timeout", 10*time.Second, "how long to wait for the legacy collector of -shadow-url")
+)
+
+// maxShadowResponse is how much of each response is kept when they diverge.
+const maxShadowResponse = 1024
+
+// maxShadowInFlight bounds the pushes being forwarded at once; beyond it,
+// pushes aren't forwarded, so that a slow legacy collector can't pile up
+// goroutines.
+const maxShadowInFlight = 64
+
+func createTableShadowDivergences(db *sql.DB) error {
+	err := createTable(db, &tableDef{Name: "shadow_divergences", Columns: []columnDef{
+		{"homeserver", "VARCHAR(256)"},
+		{"received_at", "BIGINT NOT NULL"},
+		{"status", "INT NOT NULL"},
+		{"legacy_status", "INT NOT NULL"}, // 0 if the legacy collector couldn't be reached
+		{"response", "TEXT"},
+		{"legacy_response", "TEXT"}, // Or the error reaching it
+	}})
+	if err != nil {
+		return err
+	}
+	return createIndex(db, "shadow_divergences_received_at", "shadow_divergences", "received_at")
+}
+
+// ShadowDivergence is a push to which panopticon and the legacy collector
+// responded differently.
+type ShadowDivergence struct {
+	ID             int64  `json:"id"`
+	Homeserver     string `json:"homeserver"`
+	ReceivedAt     int64  `json:"received_at"`
+	Status         int    `json:"status"`
+	LegacyStatus   int    `json:"legacy_status"`
+	Response       string `json:"response"`
+	LegacyResponse string `json:"legacy_response"`
+}
+
+// shadowRecorder keeps the status and the start of the body of a
+// response.
+type shadowRecorder struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (s *shadowRecorder) WriteHeader(code int) {
+	s.status = code
+	s.ResponseWriter.WriteHeader(code)
+}
+
+func (s *shadowRecorder) Write(b []byte) (int, error) {
+	if room := maxShadowResponse - s.body.Len(); room > 0 {
+		if len(b) < room {
+			room = len(b)
+		}
+		s.body.Write(b[:room])
+	}
+	return s.ResponseWriter.Write(b)
+}
+
+// shadow forwards pushes to the legacy collector of -shadow-url after h
+// has handled them, comparing the responses in the background. Dry runs
+// and heartbeats aren't forwarded. This is meant for migrating from another collector:
+// reporters are pointed at panopticon, which keeps the old one fed until
+// the divergences are understood.
+type shadow struct {
+	DB       *sql.DB
+	Storage  *Storage
+	Client   *http.Client
+	inFlight chan struct{}
+}
+
+func newShadow(db *sql.DB, storage *Storage) *shadow {
+	if *shadowURL == "" || !featureEnabled("shadow") {
+		return nil
+	}
+	return &shadow{
+		DB:       db,
+		Storage:  storage,
+		Client:   &http.Client{Timeout: *shadowTimeout},
+		inFlight: make(chan struct{}, maxShadowInFlight),
+	}
+}
+
+// wrap returns h, shadowed unless s is nil.
+func (s *shadow) wrap(h http.HandlerFunc) http.HandlerFunc {
+	if s == nil {
+		return h
+	}
+	return func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == dryRunPath {
+			h(w, req)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(req.Body, *maxPushBytes+1))
+		req.Body.Close()
+		if err != nil {
+			logAndReplyError(w, err, 400, "Error reading body")
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+		rec := &shadowRecorder{ResponseWriter: w, status: http.StatusOK}
+		h(rec, req)
+		var report struct {
+			Homeserver string `json:"homeserver"`
+		}
+		// Bodies which aren't JSON are forwarded without a homeserver.
+		json.Unmarshal(body, &report)
+		if report.Homeserver == heartbeatHomeserver {
+			return
+		}
+		select {
+		case s.inFlight <- struct{}{}:
+		default:
+			metrics.Inc("panopticon_shadow_pushes_total", "result", "skipped")
+			return
+		}
+		fwd, err := s.request(req, body)
+		if err != nil {
+			<-s.inFlight
+			log.Printf("Error forwarding push to %s: %v", *shadowURL, err)
+			metrics.Inc("panopticon_shadow_pushes_total", "result", "error")
+			return
+		}
+		go func() {
+			defer func() { <-s.inFlight }()
+			s.compare(fwd, report.Homeserver, rec.status, rec.body.Bytes())
+		}()
+	}
+}
+
+// request builds the push forwarded to the legacy collector, with the
+// headers a homeserver would send. Authorization isn't forwarded, as
+// panopticon's tokens mean nothing to the legacy collector.
+func (s *shadow) request(req *http.Request, body []byte) (*http.Request, error) {
+	url := *shadowURL
+	if req.URL.RawQuery != "" {
+		url += "?" + req.URL.RawQuery
+	}
+	fwd, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	for _, name := range []string{"Content-Type", "Content-Encoding", "User-Agent"} {
+		if v := req.Header.Get(name); v != "" {
+			fwd.Header.Set(name, v)
+		}
+	}
+	ip, _ := clientIP(req)
+	fwd.Header.Set("X-Forwarded-For", ip)
+	traceOf(req.Context()).propagate(fwd.Header)
+	return fwd, nil
+}
+
+func (s *shadow) compare(fwd *http.Request, homeserver string, status int, response []byte) {
+	legacyStatus, legacyResponse := 0, ""
+	resp, err := s.Client.Do(fwd)
+	if err != nil {
+		legacyResponse = err.Error()
+	} else {
+		b, _ := io.ReadAll(io.LimitReader(resp.Body, maxShadowResponse))
+		resp.Body.Close()
+		legacyStatus, legacyResponse = resp.StatusCode, string(b)
+	}
+	if legacyStatus == status && (!*shadowCompareBodies || sameJSON(response, []byte(legacyResponse))) {
+		metrics.Inc("panopticon_shadow_pushes_total", "result", "match")
+		return
+	}
+	metrics.Inc("panopticon_shadow_pushes_total", "result", "diverged")
+	log.Printf("Shadow push for %q diverged: panopticon replied %d, %s replied %d", homeserver, status, *shadowURL, legacyStatus)
+	_, err = insertRow(s.DB, "shadow_divergences",
+		[]string{"homeserver", "received_at", "status", "legacy_status", "response", "legacy_response"},
+		[]interface{}{s.Storage.storedHomeserver(homeserver), time.Now().UTC().Unix(), status, legacyStatus, string(response), legacyResponse})
+	if err != nil {
+		log.Printf("Error saving shadow divergence: %v", err)
+	}
+}
+
+// sameJSON reports whether two bodies are the same JSON, or failing that
+// the same bytes.
+func sameJSON(a, b []byte) bool {
+	var va, vb interface{}
+	if json.Unmarshal(a, &va) != nil || json.Unmarshal(b, &vb) != nil {
+		return bytes.Equal(bytes.TrimSpace(a), bytes.TrimSpace(b))
+	}
+	ca, errA := canonicalJSON(va)
+	cb, errB := canonicalJSON(vb)
+	return errA == nil && errB == nil && bytes.Equal(ca, cb)
+}
+
+// ShadowHandler serves /admin/shadow: GET lists the most recent shadow
+// divergences, up to limit, and DELETE clears them.
+type ShadowHandler struct {
+	DB *sql.DB
+}
+
+func (h *ShadowHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method == http.MethodDelete {
+		if _, err := h.DB.Exec("DELETE FROM shadow_divergences"); err != nil {
+			logAndReplyError(w, err, 500, "Error clearing shadow divergences")
+			return
+		}
+		writeJSON(w, struct{}{})
+		return
+	}
+	limit, err := intParam(req.URL.Query().Get("limit"), defaultRowLimit)
+	if err != nil || limit <= 0 || limit > *maxRowLimit {
+		logAndReplyError(w, fmt.Errorf("bad limit %q", req.URL.Query().Get("limit")), 400, "Bad query")
+		return
+	}
+	rows, err := h.DB.Query(fmt.Sprintf(`SELECT id, homeserver, received_at, status, legacy_status, response, legacy_response
+		FROM shadow_divergences ORDER BY received_at DESC, id DESC LIMIT %d`, limit))
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error loading shadow divergences")
+		return
+	}
+	defer rows.Close()
+	divergences := []*ShadowDivergence{}
+	for rows.Next() {
+		var (
+			d                            ShadowDivergence
+			hs, response, legacyResponse sql.NullString
+		)
+		if err := rows.Scan(&d.ID, &hs, &d.ReceivedAt, &d.Status, &d.LegacyStatus, &response, &legacyResponse); err != nil {
+			logAndReplyError(w, err, 500, "Error loading shadow divergences")
+			return
+		}
+		d.Homeserver, d.Response, d.LegacyResponse = hs.String, response.String, legacyResponse.String
+		divergences = append(divergences, &d)
+	}
+	if err := rows.Err(); err != nil {
+		logAndReplyError(w, err, 500, "Error loading shadow divergences")
+		return
+	}
+	writeJSON(w, divergences)
+}
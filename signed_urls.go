@@ -0,0 +1,203 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	signedURLKey    = flag.String("signed-url-key", "", "secret with which /admin/signed-urls signs export URLs; changing it revokes every URL signed with the old one")
+	signedURLMaxAge = flag.Duration("signed-url-max-age", 7*24*time.Hour, "the longest a signed URL may stay valid")
+)
+
+const defaultSignedURLAge = 24 * time.Hour
+
+// signedPaths are the export downloads which URLs may be signed for. A
+// prefix ending in / matches the paths below it.
+var signedPaths = []string{"/api/v1/reports", "/admin/queries/"}
+
+func validateSignedURLFlags() error {
+	if *signedURLKey != "" && len(*signedURLKey) < 16 {
+		return errors.New("-signed-url-key must be at least 16 characters")
+	}
+	if *signedURLMaxAge <= 0 {
+		return errors.New("-signed-url-max-age must be positive")
+	}
+	return nil
+}
+
+func signablePath(path string) bool {
+	for _, p := range signedPaths {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) && len(path) > len(p) {
+			return true
+		}
+	}
+	return false
+}
+
+// urlSignature is the hex HMAC-SHA256 of a path and its query parameters,
+// which include when the signature expires and the role it grants.
+func urlSignature(path string, q url.Values) string {
+	mac := hmac.New(sha256.New, []byte(*signedURLKey))
+	mac.Write([]byte(path + "?" + q.Encode()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// verifySignedURL checks the signature of a request for path with the
+// query parameters q, returning the role it was signed for, if any.
+func verifySignedURL(path string, q url.Values, now time.Time) (string, error) {
+	if !signablePath(path) {
+		return "", fmt.Errorf("URLs can't be signed for %s", path)
+	}
+	signed := url.Values{}
+	for k, v := range q {
+		if k != "signature" {
+			signed[k] = v
+		}
+	}
+	want := urlSignature(path, signed)
+	if !hmac.Equal([]byte(q.Get("signature")), []byte(want)) {
+		return "", errors.New("bad signature")
+	}
+	expires, err := strconv.ParseInt(q.Get("expires"), 10, 64)
+	if err != nil {
+		return "", errors.New("bad expires")
+	}
+	if now.Unix() >= expires {
+		return "", errors.New("expired")
+	}
+	return q.Get("role"), nil
+}
+
+// acceptSignedURLs serves requests carrying a signature with h, if it is
+// valid, as the role it was signed for. Other requests are passed to
+// authed, which checks their token.
+func acceptSignedURLs(roles map[string]*Role, h, authed http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		q := req.URL.Query()
+		if *signedURLKey == "" || !q.Has("signature") {
+			authed(w, req)
+			return
+		}
+		name, err := verifySignedURL(req.URL.Path, q, time.Now())
+		role := roles[name]
+		if err == nil && name != "" && role == nil {
+			err = fmt.Errorf("no role %s", name)
+		}
+		if err != nil {
+			metrics.Inc("panopticon_signed_url_requests_total", "result", "refused")
+			replyError(w, err, http.StatusForbidden, errcodeUnauthorized, "Refused signed URL")
+			return
+		}
+		metrics.Inc("panopticon_signed_url_requests_total", "result", "ok")
+		q.Del("expires")
+		q.Del("role")
+		q.Del("signature")
+		req = req.Clone(req.Context())
+		req.URL.RawQuery = q.Encode()
+		if role != nil {
+			req = withRole(req, role)
+		}
+		h(w, req)
+	}
+}
+
+// signedURLRequest is the body of a POST to /admin/signed-urls.
+type signedURLRequest struct {
+	Path      string `json:"path"`       // With the query string of the export
+	ExpiresIn int64  `json:"expires_in"` // Seconds; a day if 0
+	Role      string `json:"role"`       // If set, the URL only sees what the role sees
+}
+
+// SignedURLsHandler serves /admin/signed-urls, which signs the URL of an
+// export download so that it can be handed to someone, or a tool, without
+// a token until it expires.
+type SignedURLsHandler struct {
+	Roles map[string]*Role
+}
+
+func (h *SignedURLsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if *signedURLKey == "" {
+		notFound(w, req)
+		return
+	}
+	if req.Method != http.MethodPost {
+		methodNotAllowed(w, req, http.MethodPost)
+		return
+	}
+	var r signedURLRequest
+	if err := json.NewDecoder(req.Body).Decode(&r); err != nil {
+		replyError(w, err, 400, jsonErrcode(err), "Error decoding signed URL request")
+		return
+	}
+	u, err := url.Parse(r.Path)
+	if err == nil && !signablePath(u.Path) {
+		err = fmt.Errorf("URLs can't be signed for %s", u.Path)
+	}
+	if err == nil && r.Role != "" {
+		if h.Roles[r.Role] == nil {
+			err = fmt.Errorf("no role %s", r.Role)
+		} else if strings.HasPrefix(u.Path, "/admin/") {
+			err = errors.New("roles only apply to the read API")
+		}
+	}
+	// expires_in is checked in seconds before it becomes a Duration, which
+	// a huge one would overflow into an allowed age.
+	maxSeconds := int64(*signedURLMaxAge / time.Second)
+	age := defaultSignedURLAge
+	if r.ExpiresIn > 0 && r.ExpiresIn <= maxSeconds {
+		age = time.Duration(r.ExpiresIn) * time.Second
+	}
+	if err == nil && (r.ExpiresIn < 0 || r.ExpiresIn > maxSeconds || age > *signedURLMaxAge) {
+		err = fmt.Errorf("expires_in must be between 1 and %d", maxSeconds)
+	}
+	if err != nil {
+		logAndReplyError(w, err, 400, "Bad signed URL request")
+		return
+	}
+	q := u.Query()
+	for _, p := range []string{"expires", "role", "signature"} {
+		if q.Has(p) {
+			logAndReplyError(w, fmt.Errorf("%s is set by signing", p), 400, "Bad signed URL request")
+			return
+		}
+	}
+	expires := time.Now().Add(age).Unix()
+	q.Set("expires", strconv.FormatInt(expires, 10))
+	if r.Role != "" {
+		q.Set("role", r.Role)
+	}
+	q.Set("signature", urlSignature(u.Path, q))
+	scheme := "http"
+	if isHTTPS(req) {
+		scheme = "https"
+	}
+	writeJSON(w, struct {
+		URL       string `json:"url"`
+		ExpiresAt int64  `json:"expires_at"`
+	}{fmt.Sprintf("%s://%s%s?%s", scheme, req.Host, u.Path, q.Encode()), expires})
+}
@@ -0,0 +1,142 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Silence suppresses alert notifications for a homeserver, or for every
+// alert if it has none, between two times.
+type Silence struct {
+	ID         int64  `json:"id"`
+	Homeserver string `json:"homeserver,omitempty"`
+	StartsAt   int64  `json:"starts_at"` // Defaults to now
+	EndsAt     int64  `json:"ends_at"`
+	Reason     string `json:"reason"`
+	CreatedAt  int64  `json:"created_at"`
+}
+
+func createTableSilences(db *sql.DB) error {
+	return createTable(db, &tableDef{Name: "silences", Columns: []columnDef{
+		{"homeserver", "VARCHAR(256)"},
+		{"starts_at", "BIGINT NOT NULL"},
+		{"ends_at", "BIGINT NOT NULL"},
+		{"reason", "TEXT"},
+		{"created_at", "BIGINT NOT NULL"},
+	}})
+}
+
+// isSilenced reports whether alert notifications for a homeserver, or for
+// the network if it is "", are silenced at ts.
+func isSilenced(db *sql.DB, homeserver string, ts int64) (bool, error) {
+	d := dialectFor(db)
+	var n int
+	err := db.QueryRow(
+		fmt.Sprintf("SELECT COUNT(*) FROM silences WHERE starts_at <= %s AND ends_at > %s AND (homeserver IS NULL OR homeserver = %s)",
+			d.placeholder(1), d.placeholder(2), d.placeholder(3)),
+		ts, ts, homeserver,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// SilencesHandler serves /admin/silences: GET lists silences, or only the
+// current ones with active=1, POST adds one and DELETE ?id=... removes one.
+type SilencesHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *SilencesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	now := time.Now().UTC().Unix()
+	switch req.Method {
+	case http.MethodGet:
+		qry := "SELECT id, homeserver, starts_at, ends_at, reason, created_at FROM silences"
+		var args []interface{}
+		if req.URL.Query().Get("active") == "1" {
+			qry += fmt.Sprintf(" WHERE starts_at <= %s AND ends_at > %s", d.placeholder(1), d.placeholder(2))
+			args = append(args, now, now)
+		}
+		rows, err := h.DB.Query(qry+" ORDER BY id", args...)
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error listing silences")
+			return
+		}
+		defer rows.Close()
+		silences := []Silence{}
+		for rows.Next() {
+			var s Silence
+			var homeserver, reason sql.NullString
+			if err := rows.Scan(&s.ID, &homeserver, &s.StartsAt, &s.EndsAt, &reason, &s.CreatedAt); err != nil {
+				logAndReplyError(w, err, 500, "Error listing silences")
+				return
+			}
+			s.Homeserver, s.Reason = homeserver.String, reason.String
+			silences = append(silences, s)
+		}
+		if err := rows.Err(); err != nil {
+			logAndReplyError(w, err, 500, "Error listing silences")
+			return
+		}
+		writeJSON(w, silences)
+	case http.MethodPost:
+		var s Silence
+		if err := json.NewDecoder(req.Body).Decode(&s); err != nil {
+			logAndReplyError(w, err, 400, "Error decoding silence")
+			return
+		}
+		if s.StartsAt == 0 {
+			s.StartsAt = now
+		}
+		if s.EndsAt <= s.StartsAt {
+			logAndReplyError(w, errors.New("ends_at must be after starts_at"), 400, "Error decoding silence")
+			return
+		}
+		var homeserver interface{}
+		if s.Homeserver != "" {
+			s.Homeserver = h.Storage.storedHomeserver(s.Homeserver)
+			homeserver = s.Homeserver
+		}
+		s.CreatedAt = now
+		var err error
+		s.ID, err = insertRow(h.DB, "silences", []string{"homeserver", "starts_at", "ends_at", "reason", "created_at"},
+			[]interface{}{homeserver, s.StartsAt, s.EndsAt, s.Reason, s.CreatedAt})
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error saving silence")
+			return
+		}
+		writeJSON(w, s)
+	case http.MethodDelete:
+		id, err := strconv.ParseInt(req.URL.Query().Get("id"), 10, 64)
+		if err != nil {
+			logAndReplyError(w, err, 400, "Bad silence ID")
+			return
+		}
+		if _, err := h.DB.Exec("DELETE FROM silences WHERE id = "+d.placeholder(1), id); err != nil {
+			logAndReplyError(w, err, 500, "Error removing silence")
+			return
+		}
+		writeJSON(w, struct{}{})
+	default:
+		methodNotAllowed(w, req, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	readSnapshot         = flag.String("read-snapshot", "", "sqlite only: serve the read API from a copy of the database at this path, refreshed periodically, so that heavy queries don't block pushes")
+	readSnapshotInterval = flag.Duration("read-snapshot-interval", 5*time.Minute, "how often to refresh the -read-snapshot copy")
+)
+
+// takeSnapshot copies the live sqlite database to path. The copy is written
+// alongside and renamed into place, so readers never see a partial one.
+func takeSnapshot(ctx context.Context, db *sql.DB, path string) error {
+	d := dialectFor(db)
+	tmp := path + ".tmp"
+	if err := os.Remove(tmp); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, "VACUUM INTO "+d.placeholder(1), tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// openReadSnapshot takes a first snapshot and opens it read-only. Pooled
+// connections are recycled after each refresh, so that they pick up the new
+// copy.
+func openReadSnapshot(db *sql.DB, path string) (*sql.DB, error) {
+	driver := driverFor(db)
+	if !isSQLite(driver) {
+		return nil, fmt.Errorf("-read-snapshot needs an sqlite driver, not %s", driver)
+	}
+	if err := takeSnapshot(context.Background(), db, path); err != nil {
+		return nil, err
+	}
+	// Absolute paths already start with a slash.
+	uri := "file:" + strings.TrimPrefix(path, "file:") + "?mode=ro"
+	readDB, err := openDB(driver, uri)
+	if err != nil {
+		return nil, err
+	}
+	readDB.SetConnMaxLifetime(*readSnapshotInterval)
+	return readDB, readDB.Ping()
+}
+
+// snapshotDB is the snapshot_db job.
+func snapshotDB(db *sql.DB, path string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		return takeSnapshot(ctx, db, path)
+	}
+}
@@ -0,0 +1,28 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+// modernc.org/sqlite is a pure Go translation of sqlite, registered as the
+// "sqlite" driver, so that panopticon can be built with CGO_ENABLED=0, for
+// example to cross-compile it. mattn/go-sqlite3, registered as "sqlite3",
+// needs cgo but is faster, so is used whenever it's built in.
+import _ "modernc.org/sqlite"
+
+// isSQLite reports whether driver is one of the sqlite drivers. They
+// share a dialect and store the same files, so either can open a database
+// created with the other.
+func isSQLite(driver string) bool {
+	return driver == "sqlite3" || driver == "sqlite"
+}
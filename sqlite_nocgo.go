@@ -0,0 +1,30 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !cgo
+
+package main
+
+import (
+	"database/sql"
+
+	"modernc.org/sqlite"
+)
+
+// Without cgo, mattn/go-sqlite3 is left out and the pure Go driver also
+// serves as "sqlite3", so that the default -db-driver and the memory
+// driver work.
+func init() {
+	sql.Register("sqlite3", &sqlite.Driver{})
+}
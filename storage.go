@@ -0,0 +1,111 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Storage describes how reports are stored in a database: the driver it is
+// opened with, which decides column types, the names of the report tables,
+// their ids, and how the values in them are encoded. Whatever creates,
+// writes or reads the report tables is given one rather than reading the
+// flags or the config, so that Recorders storing reports differently can
+// run in one process.
+type Storage struct {
+	Driver             string
+	StatsTable         string
+	DendriteStatsTable string
+	Schema             string // The Postgres schema or MySQL database, if not the connection's default
+	IDType             string // "integer", "uuid" or "ulid"
+	HomeserverHashSalt string // If set, homeserver names are stored as a salted hash
+
+	Fields        map[string]FieldRule     // The "fields" section of the config
+	StringMetrics map[string]*StringMetric // The "string_metrics" section of the config
+	Encryption    *ColumnEncryption        // The "column_encryption" section of the config, or nil
+}
+
+// storageFromFlags returns the Storage given by -db-driver, -stats-table,
+// -dendrite-stats-table, -db-schema, -id-type and -homeserver-hash-salt.
+func storageFromFlags() *Storage {
+	return &Storage{
+		Driver:             *dbDriver,
+		StatsTable:         *statsTable,
+		DendriteStatsTable: *dendriteStatsTable,
+		Schema:             *dbSchema,
+		IDType:             *idType,
+		HomeserverHashSalt: *homeserverHashSalt,
+	}
+}
+
+// withConfig returns a copy of s which stores fields as the config c says.
+func (s *Storage) withConfig(c *Config) *Storage {
+	cp := *s
+	cp.Fields = c.Fields
+	cp.StringMetrics = c.StringMetrics
+	cp.Encryption = c.ColumnEncryption
+	return &cp
+}
+
+// withDriver returns a copy of s for a database opened with another driver,
+// such as a write target.
+func (s *Storage) withDriver(driver string) *Storage {
+	c := *s
+	c.Driver = driver
+	return &c
+}
+
+func (s *Storage) validate() error {
+	if _, ok := idGenerators[s.IDType]; !ok && s.IDType != "integer" {
+		return fmt.Errorf("unknown -id-type %s", s.IDType)
+	}
+	for _, name := range []string{s.StatsTable, s.DendriteStatsTable} {
+		if !identifierRegexp.MatchString(name) {
+			return fmt.Errorf("bad table name %q", name)
+		}
+	}
+	if s.StatsTable == s.DendriteStatsTable {
+		return errors.New("-stats-table and -dendrite-stats-table must differ")
+	}
+	if s.Schema == "" {
+		return nil
+	}
+	if !identifierRegexp.MatchString(s.Schema) {
+		return fmt.Errorf("bad schema name %q", s.Schema)
+	}
+	if isSQLite(s.Driver) || s.Driver == "memory" {
+		return errors.New("-db-schema needs a database with schemas, not " + s.Driver)
+	}
+	return nil
+}
+
+// tableName returns the qualified name of the table storing a kind of
+// report, stats or dendrite_stats.
+func (s *Storage) tableName(kind string) string {
+	name := s.StatsTable
+	if kind == "dendrite_stats" {
+		name = s.DendriteStatsTable
+	}
+	if s.Schema != "" {
+		return s.Schema + "." + name
+	}
+	return name
+}
+
+// tables returns the report tables, whose schema is checked for drift.
+func (s *Storage) tables() []*tableDef {
+	return []*tableDef{synapseTable(s), dendriteTable(s)}
+}
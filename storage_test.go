@@ -0,0 +1,79 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestRecordersStoreIndependently runs two Recorders whose Storage differs
+// in every setting side by side, checking that neither leaks into the other.
+func TestRecordersStoreIndependently(t *testing.T) {
+	log.SetOutput(io.Discard)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+	dir := t.TempDir()
+	newRecorder := func(name string, s *Storage) *Recorder {
+		db, err := openDB("sqlite3", filepath.Join(dir, name+".db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { db.Close() })
+		if err := createTables(db, s); err != nil {
+			t.Fatal(err)
+		}
+		return &Recorder{DB: db, Storage: s, Config: &Config{}}
+	}
+	plain := newRecorder("plain", storageFromFlags().withDriver("sqlite3"))
+	hashed := newRecorder("hashed", &Storage{
+		Driver:             "sqlite3",
+		StatsTable:         "stats",
+		DendriteStatsTable: "dendrite_stats",
+		IDType:             "ulid",
+		HomeserverHashSalt: "pepper",
+		Fields:             map[string]FieldRule{"total_users": {Type: "float"}},
+		StringMetrics:      map[string]*StringMetric{"deployment": {}},
+	})
+
+	body := `{"homeserver": "one.example", "total_users": 10.5, "deployment": "k8s"}`
+	for _, tc := range []struct {
+		r                   *Recorder
+		homeserver, idType  string
+		totalUsers, ignored string
+	}{
+		{plain, "one.example", "int64", "11", `["deployment"]`},
+		{hashed, homeserverHMAC("pepper", "one.example"), "string", "10.5", `[]`},
+	} {
+		var res PushResult
+		request(t, tc.r.Handle, http.MethodPost, "/push?verbose=1", body, "", &res)
+		assertJSON(t, "ignored fields", res.Ignored, tc.ignored)
+		var id interface{}
+		var hs, totalUsers string
+		if err := tc.r.DB.QueryRow("SELECT id, homeserver, total_users FROM stats").Scan(&id, &hs, &totalUsers); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%T", id); got != tc.idType {
+			t.Errorf("id %v is a %s, want a %s", id, got, tc.idType)
+		}
+		if hs != tc.homeserver || totalUsers != tc.totalUsers {
+			t.Errorf("stored %s with %s users, want %s with %s", hs, totalUsers, tc.homeserver, tc.totalUsers)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// usageTable is a table described by /admin/storage, with the columns
+// dating its oldest and newest rows, if it has them.
+type usageTable struct {
+	name           string
+	oldest, newest string
+	perHomeserver  bool // Whether its rows are counted per homeserver
+}
+
+func usageTables(s *Storage) []usageTable {
+	return []usageTable{
+		{s.tableName("stats"), "local_timestamp", "local_timestamp", true},
+		{s.tableName("dendrite_stats"), "local_timestamp", "local_timestamp", true},
+		{"string_metrics", "local_timestamp", "local_timestamp", true},
+		{"downsampled_reports", "bucket_start", "last_timestamp", true},
+		{"raw_reports", "received_at", "received_at", false},
+		{"homeservers", "first_seen", "last_seen", false},
+		{"homeserver_metadata", "updated_at", "updated_at", false},
+		{"homeserver_tags", "", "", false},
+		{"metric_histograms", "day", "day", false},
+		{"alerts", "fired_at", "fired_at", false},
+		{"silences", "created_at", "created_at", false},
+		{"annotations", "created_at", "created_at", false},
+		{"tombstones", "tombstoned_at", "tombstoned_at", false},
+		{"opt_outs", "opted_out_at", "opted_out_at", false},
+		{"verification_nonces", "expires_at", "expires_at", false},
+		{"verified_homeservers", "verified_at", "verified_at", false},
+		{"shadow_divergences", "received_at", "received_at", false},
+		{"job_runs", "started_at", "started_at", false},
+		{"job_locks", "", "", false},
+		{"quota_usage", "", "", false},
+		{"export_watermarks", "updated_at", "updated_at", false},
+	}
+}
+
+// TableUsage is the storage used by a table.
+type TableUsage struct {
+	Table       string `json:"table"`
+	Rows        int64  `json:"rows"`
+	ApproxBytes *int64 `json:"approx_bytes"` // Null if the database can't tell
+	Oldest      *int64 `json:"oldest"`       // Seconds; null if empty or undated
+	Newest      *int64 `json:"newest"`
+}
+
+// HomeserverUsage is the storage used by the reports of a homeserver.
+type HomeserverUsage struct {
+	Homeserver  string `json:"homeserver"`
+	Rows        int64  `json:"rows"`
+	ApproxBytes *int64 `json:"approx_bytes"`
+	Oldest      *int64 `json:"oldest"`
+	Newest      *int64 `json:"newest"`
+
+	bytes float64
+}
+
+// StorageUsage is the reply of /admin/storage.
+type StorageUsage struct {
+	Tables      []TableUsage      `json:"tables"`
+	Homeservers []HomeserverUsage `json:"homeservers"`
+}
+
+// valueBytes returns an expression for the bytes taken by the values of
+// the given columns on sqlite, which can't tell how much space a table
+// takes without the dbstat extension.
+func valueBytes(cols map[string]bool) string {
+	var terms []string
+	for c := range cols {
+		terms = append(terms, fmt.Sprintf("COALESCE(LENGTH(CAST(%s AS BLOB)), 0)", c))
+	}
+	sort.Strings(terms)
+	return "COALESCE(SUM(" + strings.Join(terms, " + ") + "), 0)"
+}
+
+// tableBytes returns the bytes the database says a table takes, with its
+// indexes, or nil if it can't tell.
+func tableBytes(db *sql.DB, table string) (*int64, error) {
+	var qry string
+	switch driverFor(db) {
+	case "postgres":
+		qry = "SELECT pg_total_relation_size($1)"
+	case "mysql":
+		qry = "SELECT data_length + index_length FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = ?"
+	default:
+		return nil, nil
+	}
+	var n sql.NullInt64
+	if err := db.QueryRow(qry, table).Scan(&n); err != nil || !n.Valid {
+		return nil, err
+	}
+	return &n.Int64, nil
+}
+
+// tableUsage describes a table, adding its rows to those of each homeserver
+// in hs if it has them.
+func tableUsage(db *sql.DB, t usageTable, hs map[string]*HomeserverUsage) (TableUsage, error) {
+	u := TableUsage{Table: t.name}
+	sqlite := isSQLite(driverFor(db))
+	bytesExpr := "NULL"
+	if sqlite {
+		cols, err := liveColumns(db, t.name)
+		if err != nil {
+			return u, err
+		}
+		bytesExpr = valueBytes(cols)
+	}
+	oldest, newest := "NULL", "NULL"
+	if t.oldest != "" {
+		oldest, newest = "MIN("+t.oldest+")", "MAX("+t.newest+")"
+	}
+	var first, last, size sql.NullInt64
+	err := db.QueryRow(fmt.Sprintf("SELECT COUNT(*), %s, %s, %s FROM %s", oldest, newest, bytesExpr, t.name)).Scan(&u.Rows, &first, &last, &size)
+	if err != nil {
+		return u, err
+	}
+	u.Oldest, u.Newest = nullableInt(first), nullableInt(last)
+	if sqlite {
+		u.ApproxBytes = nullableInt(size)
+	} else if u.ApproxBytes, err = tableBytes(db, t.name); err != nil {
+		return u, err
+	}
+	if !t.perHomeserver || u.Rows == 0 {
+		return u, nil
+	}
+	rows, err := db.Query(fmt.Sprintf("SELECT homeserver, COUNT(*), %s, %s, %s FROM %s WHERE homeserver IS NOT NULL GROUP BY homeserver",
+		oldest, newest, bytesExpr, t.name))
+	if err != nil {
+		return u, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var name string
+		var n int64
+		if err := rows.Scan(&name, &n, &first, &last, &size); err != nil {
+			return u, err
+		}
+		h, ok := hs[name]
+		if !ok {
+			h = &HomeserverUsage{Homeserver: name}
+			hs[name] = h
+		}
+		h.Rows += n
+		if first.Valid && (h.Oldest == nil || first.Int64 < *h.Oldest) {
+			oldest := first.Int64
+			h.Oldest = &oldest
+		}
+		if last.Valid && (h.Newest == nil || last.Int64 > *h.Newest) {
+			newest := last.Int64
+			h.Newest = &newest
+		}
+		if sqlite {
+			h.bytes += float64(size.Int64)
+		} else if u.ApproxBytes != nil {
+			// Without per row sizes, rows are taken to be the same size.
+			h.bytes += float64(*u.ApproxBytes) * float64(n) / float64(u.Rows)
+		}
+	}
+	return u, rows.Err()
+}
+
+func nullableInt(n sql.NullInt64) *int64 {
+	if !n.Valid {
+		return nil
+	}
+	return &n.Int64
+}
+
+// StorageHandler serves /admin/storage, the rows, approximate size and
+// oldest and newest rows of each table, and of the reports of each
+// homeserver, largest first. It accepts the query parameter limit, which
+// limits the homeservers listed.
+type StorageHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *StorageHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	limit, err := intParam(req.URL.Query().Get("limit"), defaultRowLimit)
+	if err != nil || limit <= 0 || limit > *maxRowLimit {
+		logAndReplyError(w, fmt.Errorf("bad limit %q", req.URL.Query().Get("limit")), 400, "Bad query")
+		return
+	}
+	usage := StorageUsage{Tables: []TableUsage{}, Homeservers: []HomeserverUsage{}}
+	hs := map[string]*HomeserverUsage{}
+	// Homeservers' sizes are only known if those of all their tables are.
+	sizes := true
+	for _, t := range usageTables(h.Storage) {
+		u, err := tableUsage(h.DB, t, hs)
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error measuring "+t.name)
+			return
+		}
+		sizes = sizes && (u.ApproxBytes != nil || !t.perHomeserver)
+		usage.Tables = append(usage.Tables, u)
+	}
+	for _, u := range hs {
+		if sizes {
+			n := int64(u.bytes)
+			u.ApproxBytes = &n
+		}
+		usage.Homeservers = append(usage.Homeservers, *u)
+	}
+	sort.Slice(usage.Homeservers, func(i, j int) bool {
+		a, b := usage.Homeservers[i], usage.Homeservers[j]
+		if a.bytes != b.bytes {
+			return a.bytes > b.bytes
+		}
+		if a.Rows != b.Rows {
+			return a.Rows > b.Rows
+		}
+		return a.Homeserver < b.Homeserver
+	})
+	if len(usage.Homeservers) > limit {
+		usage.Homeservers = usage.Homeservers[:limit]
+	}
+	writeJSON(w, usage)
+}
@@ -0,0 +1,268 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const defaultStringMetricLength = 64
+
+// StringMetric configures a report field with categorical string values,
+// such as the flavour of a deployment, which are stored outside the stats
+// tables and counted by value.
+type StringMetric struct {
+	MaxLength int      `json:"max_length"` // Longer values are truncated; 64 bytes by default
+	Values    []string `json:"values"`     // If set, other values are stored as "other"
+}
+
+// categoricalColumns are the stats columns which can be counted by value
+// like string metrics.
+var categoricalColumns = map[string]bool{
+	"python_version":          true,
+	"database_engine":         true,
+	"database_server_version": true,
+	"server_context":          true,
+	"log_level":               true,
+}
+
+var stringMetricPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,63}$`)
+
+func validateStringMetrics(configured map[string]*StringMetric) error {
+	known := map[string]bool{}
+	for _, name := range reportFieldNames() {
+		known[strings.ToLower(name)] = true
+	}
+	for name, m := range configured {
+		if !stringMetricPattern.MatchString(name) {
+			return fmt.Errorf("%s: names must be lower case letters, digits and underscores", name)
+		}
+		if known[name] {
+			return fmt.Errorf("%s is already a report field", name)
+		}
+		if m.MaxLength < 0 || m.MaxLength > 256 {
+			return fmt.Errorf("%s: max_length must be at most 256", name)
+		}
+	}
+	return nil
+}
+
+// normalise returns the value stored for v.
+func (m *StringMetric) normalise(v string) string {
+	if m.Values != nil {
+		for _, allowed := range m.Values {
+			if v == allowed {
+				return v
+			}
+		}
+		return "other"
+	}
+	max := m.MaxLength
+	if max == 0 {
+		max = defaultStringMetricLength
+	}
+	if len(v) > max {
+		v = v[:max]
+		// Don't leave half a character behind.
+		for !utf8.ValidString(v) {
+			v = v[:len(v)-1]
+		}
+	}
+	return v
+}
+
+// stringMetricValues returns the values of the configured string metrics
+// in a report. Values which aren't strings, or are empty, are ignored.
+func stringMetricValues(body []byte, stringMetrics map[string]*StringMetric) map[string]string {
+	if len(stringMetrics) == 0 {
+		return nil
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(body, &raw); err != nil {
+		return nil
+	}
+	values := map[string]string{}
+	for name, m := range stringMetrics {
+		var v string
+		if json.Unmarshal(raw[name], &v) == nil && v != "" {
+			values[name] = m.normalise(v)
+		}
+	}
+	return values
+}
+
+func createTableStringMetrics(db *sql.DB) error {
+	err := createTable(db, &tableDef{Name: "string_metrics", Columns: []columnDef{
+		{"homeserver", "VARCHAR(256) NOT NULL"},
+		{"local_timestamp", "BIGINT NOT NULL"},
+		{"metric", "VARCHAR(64) NOT NULL"},
+		{"value", "VARCHAR(256) NOT NULL"},
+	}})
+	if err != nil {
+		return err
+	}
+	return createIndex(db, "string_metrics_metric_local_timestamp", "string_metrics", "metric, local_timestamp")
+}
+
+// saveStringMetrics stores the string metrics of a report.
+func saveStringMetrics(ex execer, d dialect, homeserver string, ts int64, values map[string]string) error {
+	for metric, v := range values {
+		_, err := ex.Exec(d.insert("string_metrics", "homeserver", "local_timestamp", "metric", "value"), homeserver, ts, metric, v)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// StringMetricsHandler serves /api/v1/string-metrics, the number of
+// homeservers reporting each value of a string metric or categorical
+// column each day, going by each homeserver's last report of the day. It
+// accepts the query parameters metric, since and until (seconds,
+// defaulting to the last 30 days) and homeserver.
+type StringMetricsHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *StringMetricsHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	q := req.URL.Query()
+	metric := q.Get("metric")
+	if _, ok := h.Storage.StringMetrics[metric]; !ok && !categoricalColumns[metric] {
+		logAndReplyError(w, fmt.Errorf("unknown string metric %q", metric), 400, "Bad query")
+		return
+	}
+	if hiddenColumnError(w, roleOf(req), metric, "metric "+metric) {
+		return
+	}
+	if q.Get("homeserver") != "" && hiddenColumnError(w, roleOf(req), "homeserver", "homeserver") {
+		return
+	}
+	now := time.Now().UTC().Unix()
+	until := now - now%oneDay + oneDay
+	since := until - defaultSeriesDays*oneDay
+	for _, p := range []struct {
+		param string
+		value *int64
+	}{{"since", &since}, {"until", &until}} {
+		if v := q.Get(p.param); v != "" {
+			ts, err := strconv.ParseInt(v, 10, 64)
+			if err != nil {
+				logAndReplyError(w, err, 400, "Bad query")
+				return
+			}
+			*p.value = ts
+		}
+	}
+	since -= since % oneDay
+	if until <= since || until-since > maxSeriesDays*oneDay {
+		logAndReplyError(w, fmt.Errorf("bad range %d to %d", since, until), 400, "Bad query")
+		return
+	}
+	markPruned(w, since)
+
+	// Each source is a table and the column holding the metric.
+	type source struct{ table, column string }
+	var sources []source
+	if categoricalColumns[metric] {
+		for _, t := range h.Storage.tables() {
+			for _, c := range t.Columns {
+				if c.Name == metric {
+					sources = append(sources, source{t.Name, metric})
+				}
+			}
+		}
+	} else {
+		sources = append(sources, source{"string_metrics", "value"})
+	}
+	// latest[day][homeserver] is the last value of the day.
+	latest := map[int64]map[string]string{}
+	for _, src := range sources {
+		args := []interface{}{since, until}
+		where := []string{
+			"local_timestamp >= " + d.placeholder(1),
+			"local_timestamp < " + d.placeholder(2),
+			src.column + " IS NOT NULL",
+			src.column + " != ''",
+			"homeserver NOT IN (SELECT homeserver FROM tombstones)",
+		}
+		if src.table == "string_metrics" {
+			args = append(args, metric)
+			where = append(where, "metric = "+d.placeholder(len(args)))
+		}
+		if hs := q.Get("homeserver"); hs != "" {
+			args = append(args, h.Storage.storedHomeserver(hs))
+			where = append(where, "homeserver = "+d.placeholder(len(args)))
+		}
+		qry := fmt.Sprintf("SELECT homeserver, local_timestamp, %s FROM %s WHERE %s ORDER BY local_timestamp",
+			src.column, src.table, strings.Join(where, " AND "))
+		if err := collectLatestStrings(req.Context(), h.DB, qry, args, latest); err != nil {
+			replyQueryError(w, req, err, "Error querying string metric")
+			return
+		}
+	}
+
+	type dayCounts struct {
+		Day    int64             `json:"day"`
+		Values map[string]*int64 `json:"values"` // Null if suppressed for privacy
+	}
+	result := []dayCounts{}
+	for day := since; day < until; day += oneDay {
+		counts := map[string]int64{}
+		for _, v := range latest[day] {
+			counts[v]++
+		}
+		d := dayCounts{Day: day, Values: map[string]*int64{}}
+		for v, n := range counts {
+			d.Values[v] = privacyOf(req).count(n, metric+" = "+v, fmt.Sprint(day))
+		}
+		result = append(result, d)
+	}
+	writeJSON(w, result)
+}
+
+// collectLatestStrings runs a query returning homeserver, local_timestamp
+// and a value, ordered by local_timestamp, and records the last value of
+// each day for each homeserver in latest.
+func collectLatestStrings(ctx context.Context, db *sql.DB, qry string, args []interface{}, latest map[int64]map[string]string) error {
+	rows, err := db.QueryContext(ctx, qry, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var hs, v string
+		var ts int64
+		if err := rows.Scan(&hs, &ts, &v); err != nil {
+			return err
+		}
+		day := ts - ts%oneDay
+		if latest[day] == nil {
+			latest[day] = map[string]string{}
+		}
+		latest[day][hs] = v
+	}
+	return rows.Err()
+}
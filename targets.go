@@ -0,0 +1,141 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"sync"
+)
+
+// dbDrivers remembers which driver each open database uses, since with
+// write targets they needn't all match -db-driver.
+var dbDrivers sync.Map
+
+func openDB(driver, dsn string) (*sql.DB, error) {
+	if driver == "memory" {
+		return openMemoryDB(dsn)
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	dbDrivers.Store(db, driver)
+	return db, nil
+}
+
+// driverFor returns the driver name db was opened with by openDB.
+func driverFor(db *sql.DB) string {
+	if d, ok := dbDrivers.Load(db); ok {
+		return d.(string)
+	}
+	panic("database not opened with openDB")
+}
+
+// WriteTargetConfig configures an additional database which every stored
+// report is also written to.
+type WriteTargetConfig struct {
+	Name     string `json:"name"`
+	Driver   string `json:"driver"`
+	DSN      string `json:"dsn"`
+	Required bool   `json:"required"` // Fail the push if this target can't be written
+
+	// For the "influx" driver, where DSN is the line protocol write URL.
+	Token       string `json:"token"`
+	Measurement string `json:"measurement"`
+}
+
+type writeTarget struct {
+	WriteTargetConfig
+	db      *sql.DB
+	storage *Storage
+}
+
+// openWriteTargets connects to each configured write target and creates
+// the report tables of s there. Only required targets have to be
+// reachable.
+func openWriteTargets(configs []WriteTargetConfig, s *Storage) ([]*writeTarget, error) {
+	var targets []*writeTarget
+	for _, c := range configs {
+		if c.Name == "" || c.Name == "primary" {
+			return nil, fmt.Errorf("write target needs a name other than \"primary\"")
+		}
+		if c.Driver == "influx" {
+			targets = append(targets, &writeTarget{WriteTargetConfig: c})
+			continue
+		}
+		db, err := openDB(c.Driver, c.DSN)
+		if err != nil {
+			return nil, fmt.Errorf("write target %s: %v", c.Name, err)
+		}
+		storage := s.withDriver(c.Driver)
+		for _, t := range storage.tables() {
+			if err = createTable(db, t); err != nil {
+				break
+			}
+		}
+		if err != nil {
+			if c.Required {
+				return nil, fmt.Errorf("write target %s: %v", c.Name, err)
+			}
+			// Writes to it will fail and be counted until it comes back.
+			log.Printf("Error creating tables in write target %s: %v", c.Name, err)
+		}
+		targets = append(targets, &writeTarget{c, db, storage})
+	}
+	return targets, nil
+}
+
+// save writes a report to a write target.
+func (t *writeTarget) save(sr StatsReport, isDendrite bool, id string) error {
+	if isDendrite {
+		s := sr.ReportStatsDendrite
+		s.Common = sr.ReportStatsSynapse.CommonStats
+		if t.Driver == "influx" {
+			cols, vals := s.Columns()
+			return t.writeInflux("dendrite_stats", sr.Homeserver, sr.LocalTimestamp, cols, vals)
+		}
+		_, _, err := s.Save(t.db, t.storage, id)
+		return err
+	}
+	if t.Driver == "influx" {
+		cols, vals := sr.ReportStatsSynapse.Columns()
+		return t.writeInflux("stats", sr.Homeserver, sr.LocalTimestamp, cols, vals)
+	}
+	_, _, err := sr.ReportStatsSynapse.Save(t.db, t.storage, id)
+	return err
+}
+
+// saveToTargets writes a report to every write target. Failures are logged
+// and counted, and only returned for required targets.
+func (r *Recorder) saveToTargets(sr StatsReport, isDendrite bool, id string) error {
+	for _, t := range r.Targets {
+		err := injectFault(t.Name)
+		if err == nil {
+			err = t.save(sr, isDendrite, id)
+		}
+		if err != nil {
+			metrics.Inc("panopticon_target_writes_total", "target", t.Name, "result", "error")
+			log.Printf("Error writing to target %s: %v", t.Name, err)
+			if t.Required {
+				return fmt.Errorf("target %s: %v", t.Name, err)
+			}
+			continue
+		}
+		metrics.Inc("panopticon_target_writes_total", "target", t.Name, "result", "ok")
+	}
+	return nil
+}
@@ -23,7 +23,7 @@ trap "rm -rf ${dir}" EXIT
 port=9002
 
 cd $(dirname $(dirname $(realpath $0)))
-./panopticon --port=${port} --db=${dir}/stats.db 2>$1 &
+./panopticon --port=${port} --db=${dir}/stats.db ${EXTRA_ARGS:-} 2>$1 &
 PID=$! 
 function kill_server {
   kill $PID
@@ -0,0 +1,15 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret"
+. $(dirname $0)/setup.sh
+log "Testing /admin/homeservers"
+
+auth="Authorization: Bearer s3cret"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "known.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+curl -k -H "${auth}" -d '{"homeserver": "known.turtles", "display_name": "Known", "owner_contact": "ops@turtles", "tags": ["Internal", "staging", "internal"]}' http://localhost:${port}/admin/homeservers >/dev/null 2>&1
+assert_eq "known.turtles|Known|internal,staging" "$(sqlite3 ${dir}/stats.db 'SELECT m.homeserver, display_name, GROUP_CONCAT(tag) FROM homeserver_metadata m JOIN homeserver_tags t ON m.homeserver = t.homeserver GROUP BY m.homeserver')"
+assert_eq '"tags":["internal","staging"]' "$(curl -k -H "${auth}" http://localhost:${port}/admin/homeservers 2>/dev/null | grep -o '"tags":\[[^]]*\]')"
+assert_eq '"owner_contact":"ops@turtles"' "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/reports?metadata=1" 2>/dev/null | grep -o '"owner_contact":"[^"]*"')"
+
+curl -k -X DELETE -H "${auth}" "http://localhost:${port}/admin/homeservers?homeserver=known.turtles" >/dev/null 2>&1
+assert_eq "0|0" "$(sqlite3 ${dir}/stats.db 'SELECT (SELECT COUNT(*) FROM homeserver_metadata), (SELECT COUNT(*) FROM homeserver_tags)')"
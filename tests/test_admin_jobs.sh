@@ -0,0 +1,30 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret --store-raw-reports"
+. $(dirname $0)/setup.sh
+log "Testing the jobs admin API"
+
+auth="Authorization: Bearer s3cret"
+sqlite3 ${dir}/stats.db "INSERT INTO raw_reports(received_at, remote_addr, user_agent, status, truncated, body_gzip) VALUES (1, '127.0.0.1', 'test', 200, 0, x'')"
+assert_eq '"name":"schema_check"
+"name":"optimize_db"
+"name":"prune_raw_reports"' "$(curl -k -H "${auth}" http://localhost:${port}/admin/jobs 2>/dev/null | grep -o '"name":"[^"]*"')"
+assert_eq '"id":1,"job":"prune_raw_reports","trigger":"manual"' "$(curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/prune_raw_reports 2>/dev/null | grep -o '"id.*"manual"')"
+sleep 0.5
+assert_eq '"state":"succeeded"
+"rows_affected":1' "$(curl -k -H "${auth}" http://localhost:${port}/admin/jobs/runs/1 2>/dev/null | grep -o '"state":"[^"]*"\|"rows_affected":[0-9]*')"
+assert_eq '"last_run":{"id":1' "$(curl -k -H "${auth}" http://localhost:${port}/admin/jobs 2>/dev/null | grep -o '"last_run":{"id":1')"
+assert_eq "prune_raw_reports|manual|succeeded|1" "$(sqlite3 ${dir}/stats.db 'SELECT job, triggered_by, state, rows_affected FROM job_runs WHERE finished_at IS NOT NULL')"
+
+# Past runs are listed newest first.
+curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/prune_raw_reports >/dev/null 2>&1
+sleep 0.5
+assert_eq '"id":2
+"id":1' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/jobs/runs?job=prune_raw_reports" 2>/dev/null | grep -o '"id":[0-9]*')"
+assert_eq '"id":2' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/jobs/runs?limit=1" 2>/dev/null | grep -o '"id":[0-9]*')"
+assert_eq "[]" "$(curl -k -H "${auth}" "http://localhost:${port}/admin/jobs/runs?job=schema_check" 2>/dev/null)"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "${auth}" "http://localhost:${port}/admin/jobs/runs?limit=x" 2>/dev/null)"
+
+assert_eq "404" "$(curl -k -X POST -o /dev/null -w '%{http_code}' -H "${auth}" http://localhost:${port}/admin/jobs/prune_stats 2>/dev/null)"
+assert_eq "404" "$(curl -k -o /dev/null -w '%{http_code}' -H "${auth}" http://localhost:${port}/admin/jobs/runs/99 2>/dev/null)"
+assert_eq "405" "$(curl -k -o /dev/null -w '%{http_code}' -H "${auth}" http://localhost:${port}/admin/jobs/schema_check 2>/dev/null)"
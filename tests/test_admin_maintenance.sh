@@ -0,0 +1,12 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret --read-only --maintenance-retry-after=2m"
+. $(dirname $0)/setup.sh
+log "Testing maintenance mode"
+
+assert_eq "503 120" "$(curl -k -o /dev/null -w '%{http_code} %header{retry-after}' -d '{"homeserver": "patient.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq '{"error_message":"try again later","errcode":"STORAGE_UNAVAILABLE","retry_after":120}' "$(curl -k -d '{"homeserver": "patient.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "ok" "$(curl -k http://localhost:${port}/test 2>/dev/null)"
+
+assert_eq '{"enabled":false,"retry_after":120}' "$(curl -k -H "Authorization: Bearer s3cret" -d '{"enabled": false}' http://localhost:${port}/admin/maintenance 2>/dev/null)"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "patient.turtles"}' http://localhost:${port}/push 2>/dev/null)"
@@ -0,0 +1,36 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "queries": {
+    "biggest": {
+      "description": "Homeservers with the most users",
+      "sql": "SELECT homeserver, total_users FROM stats WHERE total_users >= :min_users ORDER BY total_users DESC",
+      "params": {"min_users": "int"}
+    },
+    "sneaky": {
+      "sql": "WITH x AS (SELECT 1) DELETE FROM stats"
+    }
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --admin-token=s3cret"
+. $(dirname $0)/setup.sh
+log "Testing /admin/queries"
+
+auth="Authorization: Bearer s3cret"
+for n in 3 30 300; do
+  curl -k -d '{"homeserver": "hs'${n}'.turtles", "total_users": '${n}'}' http://localhost:${port}/push >/dev/null 2>&1
+done
+
+assert_eq '[{"homeserver":"hs300.turtles","total_users":300},{"homeserver":"hs30.turtles","total_users":30}]' \
+  "$(curl -k -H "${auth}" "http://localhost:${port}/admin/queries/biggest?min_users=10" 2>/dev/null)"
+assert_eq 'homeserver,total_users
+hs300.turtles,300' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/queries/biggest?min_users=100&format=csv" 2>/dev/null | tr -d '\r')"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${auth}" "http://localhost:${port}/admin/queries/biggest")"
+assert_eq "404" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${auth}" "http://localhost:${port}/admin/queries/nonsense")"
+assert_eq "500" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${auth}" "http://localhost:${port}/admin/queries/sneaky")"
+assert_eq "3" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "after.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+rm ${conf}
@@ -0,0 +1,32 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret"
+. $(dirname $0)/setup.sh
+log "Testing /admin/storage"
+
+auth="Authorization: Bearer s3cret"
+push() {
+  curl -k -s -H "User-Agent: ${2:-Synapse/1.100.0}" -d "$1" http://localhost:${port}/push >/dev/null
+}
+push '{"homeserver": "big.turtles", "total_users": 100, "python_version": "3.11.4", "database_engine": "Postgres"}'
+push '{"homeserver": "big.turtles", "total_users": 101, "python_version": "3.11.4", "database_engine": "Postgres"}'
+push '{"homeserver": "small.turtles", "total_users": 1}'
+push '{"homeserver": "dendrite.turtles", "total_users": 5}' Dendrite/0.13
+sqlite3 ${dir}/stats.db "UPDATE stats SET local_timestamp = 1000 WHERE id = 1"
+
+usage=$(curl -k -s -H "${auth}" http://localhost:${port}/admin/storage)
+assert_eq "3|1000" "$(echo "${usage}" | jq -r '.tables[] | select(.table == "stats") | "\(.rows)|\(.oldest)"')"
+assert_eq "1" "$(echo "${usage}" | jq -r '.tables[] | select(.table == "dendrite_stats") | .rows')"
+assert_eq "3" "$(echo "${usage}" | jq -r '.tables[] | select(.table == "homeservers") | .rows')"
+assert_eq "true" "$(echo "${usage}" | jq -r '[.tables[] | select(.table == "stats" or .table == "dendrite_stats") | .approx_bytes > 0] | all')"
+assert_eq "null|null" "$(echo "${usage}" | jq -r '.tables[] | select(.table == "job_locks") | "\(.oldest)|\(.newest)"')"
+
+# Homeservers come largest first, with the ages of their reports.
+assert_eq "big.turtles,dendrite.turtles,small.turtles" "$(echo "${usage}" | jq -r '[.homeservers[].homeserver] | join(",")')"
+assert_eq "2|1000" "$(echo "${usage}" | jq -r '.homeservers[0] | "\(.rows)|\(.oldest)"')"
+assert_eq "true" "$(echo "${usage}" | jq -r '.homeservers[0].approx_bytes > .homeservers[2].approx_bytes and .homeservers[0].newest > 1000')"
+assert_eq "1" "$(curl -k -s -H "${auth}" "http://localhost:${port}/admin/storage?limit=1" | jq '.homeservers | length')"
+
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${auth}" "http://localhost:${port}/admin/storage?limit=0")"
+assert_eq "401" "$(curl -k -s -o /dev/null -w '%{http_code}' http://localhost:${port}/admin/storage)"
+assert_eq "405" "$(curl -k -s -o /dev/null -w '%{http_code}' -X POST -H "${auth}" http://localhost:${port}/admin/storage)"
@@ -0,0 +1,16 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret"
+. $(dirname $0)/setup.sh
+log "Testing /admin/tombstones"
+
+assert_eq '{"error_message":"unauthorized","errcode":"UNAUTHORIZED"}' "$(curl -k -d '{"homeserver": "old.turtles"}' http://localhost:${port}/admin/tombstones 2>/dev/null)"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "old.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+curl -k -H "Authorization: Bearer s3cret" -d '{"homeserver": "old.turtles", "reason": "test server"}' http://localhost:${port}/admin/tombstones >/dev/null 2>&1
+assert_eq '{"error_message":"Rejected report: old.turtles is decommissioned","errcode":"VALIDATION_FAILED"}' "$(curl -k -d '{"homeserver": "old.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats WHERE homeserver == "old.turtles"')"
+assert_eq "old.turtles|test server" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, reason FROM tombstones')"
+
+curl -k -X DELETE -H "Authorization: Bearer s3cret" "http://localhost:${port}/admin/tombstones?homeserver=old.turtles" >/dev/null 2>&1
+assert_eq "{}" "$(curl -k -d '{"homeserver": "old.turtles"}' http://localhost:${port}/push 2>/dev/null)"
@@ -0,0 +1,62 @@
+#!/bin/bash -eu
+
+hookdir=$(mktemp -d)
+python3 - ${hookdir}/hooks <<'PY' &
+import http.server, sys
+class H(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers["Content-Length"]))
+        with open(sys.argv[1], "ab") as f:
+            f.write(body + b"\n")
+        self.send_response(204)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(("127.0.0.1", 9015), H).serve_forever()
+PY
+hooks=$!
+until curl http://127.0.0.1:9015/ >/dev/null 2>&1; do
+  sleep 0.1
+done
+
+conf=${hookdir}/config.json
+cat >${conf} <<CONF
+{
+  "notifiers": {"webhooks": [{"name": "test", "url": "http://127.0.0.1:9015/", "format": "json"}]},
+  "alerts": {"big_homeserver": {"metric": "total_users", "comparison": ">", "threshold": 100, "scope": "homeserver"}}
+}
+CONF
+EXTRA_ARGS="--config=${conf} --admin-token=s3cret"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${hooks}; rm -rf ${hookdir}" EXIT
+log "Testing alert silences"
+
+auth="Authorization: Bearer s3cret"
+function evaluate {
+  curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/evaluate_alerts >/dev/null 2>&1
+  sleep 0.5
+}
+now=$(date +%s)
+
+assert_eq '"id":1,"homeserver":"busy.turtles","starts_at":'${now} "$(curl -k -H "${auth}" -d '{"homeserver": "busy.turtles", "ends_at": '$((now + 3600))', "reason": "upgrade"}' http://localhost:${port}/admin/silences 2>/dev/null | grep -o '"id.*"starts_at":[0-9]*')"
+curl -k -d '{"homeserver": "busy.turtles", "total_users": 150}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "loud.turtles", "total_users": 150}' http://localhost:${port}/push >/dev/null 2>&1
+evaluate
+# Both alerts fire, but only the unsilenced one is notified.
+assert_eq "2" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM alerts WHERE resolved_at IS NULL')"
+assert_eq '[Firing] big_homeserver on loud.turtles' "$(grep -o '\[[^"]*' ${hookdir}/hooks)"
+assert_eq 'panopticon_alert_notifications_silenced_total{rule="big_homeserver"} 1' "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep '^panopticon_alert_notifications_silenced_total')"
+
+# A silence without a homeserver silences everything.
+curl -k -H "${auth}" -d '{"ends_at": '$((now + 3600))'}' http://localhost:${port}/admin/silences >/dev/null 2>&1
+curl -k -H "${auth}" -d '{"starts_at": '$((now - 7200))', "ends_at": '$((now - 3600))'}' http://localhost:${port}/admin/silences >/dev/null 2>&1
+assert_eq '"id":1
+"id":2' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/silences?active=1" 2>/dev/null | grep -o '"id":[0-9]*')"
+curl -k -d '{"homeserver": "loud.turtles", "total_users": 50}' http://localhost:${port}/push >/dev/null 2>&1
+evaluate
+assert_eq "1" "$(wc -l <${hookdir}/hooks)"
+
+curl -k -H "${auth}" -X DELETE "http://localhost:${port}/admin/silences?id=2" >/dev/null 2>&1
+assert_eq '"id":1
+"id":3' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/silences" 2>/dev/null | grep -o '"id":[0-9]*')"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "${auth}" -d '{"ends_at": 1}' http://localhost:${port}/admin/silences 2>/dev/null)"
@@ -0,0 +1,60 @@
+#!/bin/bash -eu
+
+hookdir=$(mktemp -d)
+python3 - ${hookdir}/hooks <<'PY' &
+import http.server, sys
+class H(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers["Content-Length"]))
+        with open(sys.argv[1], "ab") as f:
+            f.write(body + b"\n")
+        self.send_response(204)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(("127.0.0.1", 9014), H).serve_forever()
+PY
+hooks=$!
+until curl http://127.0.0.1:9014/ >/dev/null 2>&1; do
+  sleep 0.1
+done
+
+conf=${hookdir}/config.json
+cat >${conf} <<CONF
+{
+  "notifiers": {"webhooks": [{"name": "test", "url": "http://127.0.0.1:9014/", "format": "json"}]},
+  "alerts": {
+    "few_homeservers": {"metric": "active_homeservers", "comparison": "<", "threshold": 2},
+    "big_homeserver": {"metric": "total_users", "comparison": ">", "threshold": 100, "window": "7d", "scope": "homeserver"}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --admin-token=s3cret"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${hooks}; rm -rf ${hookdir}" EXIT
+log "Testing alerts"
+
+auth="Authorization: Bearer s3cret"
+function evaluate {
+  curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/evaluate_alerts >/dev/null 2>&1
+  sleep 0.5
+}
+
+curl -k -d '{"homeserver": "small.turtles", "total_users": 5}' http://localhost:${port}/push >/dev/null 2>&1
+evaluate
+assert_eq '{"body":"active_homeservers is 1 (alerting while active_homeservers \u003c 2, over 24h).","subject":"[Firing] few_homeservers"}' "$(cat ${hookdir}/hooks)"
+assert_eq '"rule":"few_homeservers","homeserver":null,"observed":1' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/alerts?state=firing" 2>/dev/null | grep -o '"rule[^}]*"observed":[0-9]*')"
+
+# Evaluating again doesn't notify again.
+evaluate
+assert_eq "1" "$(wc -l <${hookdir}/hooks)"
+
+curl -k -d '{"homeserver": "big.turtles", "total_users": 150}' http://localhost:${port}/push >/dev/null 2>&1
+evaluate
+assert_eq '[Firing] big_homeserver on big.turtles
+[Resolved] few_homeservers' "$(tail -n +2 ${hookdir}/hooks | grep -o '\[[^"]*')"
+assert_eq "big_homeserver|big.turtles|150.0|0
+few_homeservers||1.0|1" "$(sqlite3 ${dir}/stats.db 'SELECT rule, homeserver, observed, resolved_at IS NOT NULL FROM alerts ORDER BY rule')"
+assert_eq '"rule":"big_homeserver"' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/alerts?state=firing" 2>/dev/null | grep -o '"rule":"[^"]*"')"
+assert_eq '"rule":"few_homeservers"' "$(curl -k -H "${auth}" "http://localhost:${port}/admin/alerts?state=resolved" 2>/dev/null | grep -o '"rule":"[^"]*"')"
+assert_eq 'panopticon_alerts_firing{rule="big_homeserver"} 1' "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep '^panopticon_alerts_firing{rule="big')"
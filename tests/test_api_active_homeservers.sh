@@ -0,0 +1,15 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/active-homeservers"
+
+assert_eq '{"24h":0,"30d":0,"7d":0}' "$(curl -k -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/active-homeservers 2>/dev/null)"
+
+curl -k -d '{"homeserver": "one.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "one.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "two.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+sqlite3 ${dir}/stats.db "INSERT INTO homeservers VALUES ('old.turtles', 1000, $(( $(date +%s) - 10 * 86400 )), 1)"
+
+assert_eq '{"24h":2,"30d":3,"7d":2}' "$(curl -k -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/active-homeservers 2>/dev/null)"
+assert_eq "2" "$(sqlite3 ${dir}/stats.db 'SELECT report_count FROM homeservers WHERE homeserver == "one.turtles"')"
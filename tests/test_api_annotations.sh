@@ -0,0 +1,44 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad --admin-token=s3cret"
+. $(dirname $0)/setup.sh
+log "Testing annotations"
+
+admin="Authorization: Bearer s3cret"
+read="Authorization: Bearer r3ad"
+day=$(( $(date +%s) / 86400 * 86400 - 2 * 86400 ))
+
+assert_eq '{"id":1,"title":"matrix.org outage","text":"Pushes from matrix.org failed","tags":["outage","matrix.org"],"starts_at":'$((day + 3600))',"ends_at":'$((day + 7200))',"created_at":' \
+  "$(curl -k -H "${admin}" -d '{"title": "matrix.org outage", "text": "Pushes from matrix.org failed", "tags": ["outage", "matrix.org"], "starts_at": '$((day + 3600))', "ends_at": '$((day + 7200))'}' \
+    http://localhost:${port}/admin/annotations 2>/dev/null | sed 's/"created_at":.*/"created_at":/')"
+curl -k -H "${admin}" -d '{"title": "Collector migrated", "starts_at": '$((day + 86400 + 60))'}' http://localhost:${port}/admin/annotations >/dev/null 2>&1
+curl -k -H "${admin}" -d '{"title": "Long ago", "starts_at": 1000, "ends_at": 2000}' http://localhost:${port}/admin/annotations >/dev/null 2>&1
+
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "${admin}" -d '{"title": "Backwards", "starts_at": 20, "ends_at": 10}' http://localhost:${port}/admin/annotations 2>/dev/null)"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "${admin}" -d '{"title": "Bad tag", "starts_at": 20, "tags": ["a,b"]}' http://localhost:${port}/admin/annotations 2>/dev/null)"
+assert_eq "401" "$(curl -k -o /dev/null -w '%{http_code}' -H "${read}" -d '{"title": "Not admin", "starts_at": 20}' http://localhost:${port}/admin/annotations 2>/dev/null)"
+
+# Annotations overlapping the range are returned, oldest first.
+assert_eq '["matrix.org outage","Collector migrated"]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/annotations?since=$((day + 5000))" 2>/dev/null | jq -c '[.[].title]')"
+assert_eq '["matrix.org outage"]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/annotations?tag=outage&tag=matrix.org" 2>/dev/null | jq -c '[.[].title]')"
+assert_eq '["Long ago"]' "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/annotations?until=$day" 2>/dev/null | jq -c '[.[].title]')"
+
+# Series can be returned alongside the annotations over them.
+curl -k -d '{"homeserver": "one.turtles", "total_users": 5}' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq '{"days":3,"annotations":["matrix.org outage","Collector migrated"]}' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/series?metric=total_users&since=${day}&annotations=1" 2>/dev/null | jq -c '{days: .series | length, annotations: [.annotations[].title]}')"
+assert_eq '["Collector migrated"]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/series?metric=total_users&since=$((day + 86400))&annotations=1" 2>/dev/null | jq -c '[.annotations[].title]')"
+
+# Grafana's JSON data sources query annotations by time range and tags.
+assert_eq "{}" "$(curl -k -H "${read}" http://localhost:${port}/api/v1/grafana/ 2>/dev/null)"
+grafana='{"range": {"from": "'$(date -u -d @${day} +%FT%T.000Z)'", "to": "'$(date -u -d @$((day + 2 * 86400)) +%FT%T.000Z)'"}, "annotation": {"name": "events", "query": "outage"}}'
+assert_eq '[{"annotation":{"name":"events","query":"outage"},"time":'$(( (day + 3600) * 1000 ))',"timeEnd":'$(( (day + 7200) * 1000 ))',"isRegion":true,"title":"matrix.org outage","text":"Pushes from matrix.org failed","tags":["outage","matrix.org"]}]' \
+  "$(curl -k -H "${read}" -d "${grafana}" http://localhost:${port}/api/v1/grafana/annotations 2>/dev/null)"
+assert_eq '[false]' \
+  "$(curl -k -H "${read}" -d "${grafana/outage/}" http://localhost:${port}/api/v1/grafana/annotations 2>/dev/null | jq -c '[.[1].isRegion]')"
+
+assert_eq "{}" "$(curl -k -X DELETE -H "${admin}" "http://localhost:${port}/admin/annotations?id=1" 2>/dev/null)"
+assert_eq '["Collector migrated"]' "$(curl -k -H "${admin}" "http://localhost:${port}/admin/annotations?since=${day}" 2>/dev/null | jq -c '[.[].title]')"
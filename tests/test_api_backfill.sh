@@ -0,0 +1,15 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--backfill-token=backfiller --stale-reports=reject"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/backfill"
+
+now=$(date +%s)
+then=$((now - 86400))
+assert_eq "{}" "$(curl -k -d '{"homeserver": "offline.turtles", "timestamp": '${now}'}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "401" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"homeserver": "offline.turtles", "local_timestamp": '${then}'}' http://localhost:${port}/api/v1/backfill)"
+assert_eq "{}" "$(curl -k -H 'Authorization: Bearer backfiller' -d '{"homeserver": "offline.turtles", "timestamp": '${then}', "local_timestamp": '${then}'}' http://localhost:${port}/api/v1/backfill 2>/dev/null)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H 'Authorization: Bearer backfiller' -d '{"homeserver": "offline.turtles", "local_timestamp": 1000}' http://localhost:${port}/api/v1/backfill)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H 'Authorization: Bearer backfiller' -d '{"homeserver": "offline.turtles", "local_timestamp": '$((now + 3600))'}' http://localhost:${port}/api/v1/backfill)"
+assert_eq "${then}|1|${now}" "$(sqlite3 ${dir}/stats.db 'SELECT local_timestamp, backfilled, (SELECT local_timestamp FROM stats WHERE backfilled IS NULL) FROM stats WHERE backfilled = 1')"
+assert_eq "${then}|2" "$(sqlite3 ${dir}/stats.db "SELECT first_seen, report_count FROM homeservers WHERE homeserver = 'offline.turtles'")"
@@ -0,0 +1,19 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/cadence"
+
+now=$(date +%s)
+hour=3600
+# hourly.turtles reports every hour, but skipped one two hours ago.
+for ago in 6 5 4 3 1; do
+  sqlite3 ${dir}/stats.db "INSERT INTO stats (homeserver, local_timestamp) VALUES ('hourly.turtles', $((now - ago * hour)))"
+done
+# gone.turtles reported every hour, then stopped three hours ago.
+for ago in 6 5 4 3; do
+  sqlite3 ${dir}/stats.db "INSERT INTO dendrite_stats (homeserver, local_timestamp) VALUES ('gone.turtles', $((now - ago * hour)))"
+done
+
+assert_eq '[{"homeserver":"gone.turtles","reports":4,"last_seen":'$((now - 3 * hour))',"median_interval_seconds":3600,"jitter_seconds":0,"missed_reports":3,"overdue":true},{"homeserver":"hourly.turtles","reports":5,"last_seen":'$((now - hour))',"median_interval_seconds":3600,"jitter_seconds":0,"missed_reports":1,"overdue":false}]' \
+  "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/cadence?window=1d" 2>/dev/null)"
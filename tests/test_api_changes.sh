@@ -0,0 +1,23 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing the changes API"
+
+auth="Authorization: Bearer r3ad"
+sqlite3 ${dir}/stats.db "INSERT INTO stats(homeserver, local_timestamp, database_engine, user_agent, total_users) VALUES
+  ('moving.turtles', 100, 'Sqlite3', 'Synapse/1.90.0', 1),
+  ('moving.turtles', 200, 'Sqlite3', 'Synapse/1.90.0', 2),
+  ('moving.turtles', 300, NULL, 'Synapse/1.91.0', 3),
+  ('moving.turtles', 400, 'Psycopg2', 'Synapse/1.91.0', 4),
+  ('other.turtles', 400, 'Psycopg2', 'Synapse/1.92.0', 4)"
+
+assert_eq '[{"table":"stats","field":"database_engine","from":"Sqlite3","to":"Psycopg2","since":400,"last_seen":200}]' \
+  "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/changes?homeserver=moving.turtles&field=database_engine" 2>/dev/null)"
+assert_eq '"field":"user_agent","from":"Synapse/1.90.0","to":"Synapse/1.91.0","since":300
+"field":"database_engine","from":"Sqlite3","to":"Psycopg2","since":400' \
+  "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/changes?homeserver=moving.turtles" 2>/dev/null | grep -o '"field[^}]*"since":[0-9]*')"
+assert_eq '"to":"Synapse/1.91.0"' "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/changes?homeserver=moving.turtles&until=350" 2>/dev/null | grep -o '"to":"[^"]*"')"
+assert_eq '[]' "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/changes?homeserver=other.turtles" 2>/dev/null)"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "${auth}" "http://localhost:${port}/api/v1/changes?homeserver=moving.turtles&field=nope" 2>/dev/null)"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "${auth}" "http://localhost:${port}/api/v1/changes" 2>/dev/null)"
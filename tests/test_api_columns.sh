@@ -0,0 +1,38 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "roles": {
+    "aggregates": {"tokens": ["aggr"], "columns": ["total_users", "daily_messages"], "privacy": {"min_homeservers": 3}}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/columns"
+
+curl -k -d '{"homeserver": "one.turtles", "total_users": 5, "daily_messages": 10}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "two.turtles", "total_users": 7}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "two.turtles", "total_users": 9}' http://localhost:${port}/push >/dev/null 2>&1
+today=$(date -u +%F)
+
+column='import json,sys
+for c in json.load(sys.stdin):
+    if c["column"] == sys.argv[1]:
+        print(json.dumps(c, sort_keys=True))'
+assert_eq '{"column": "total_users", "first_seen": "'${today}'", "homeservers": 2, "last_seen": "'${today}'", "max": 9, "min": 5, "null_rate": 0, "reports": 3, "table": "stats", "type": "BIGINT"}' \
+  "$(curl -k -s -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/columns?table=stats" | python3 -c "${column}" total_users)"
+assert_eq '{"column": "daily_messages", "first_seen": "'${today}'", "homeservers": 1, "last_seen": "'${today}'", "max": 10, "min": 10, "null_rate": 0.6666666666666666, "reports": 3, "table": "stats", "type": "BIGINT"}' \
+  "$(curl -k -s -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/columns?table=stats" | python3 -c "${column}" daily_messages)"
+assert_eq '{"column": "daily_active_rooms", "first_seen": null, "homeservers": 0, "last_seen": null, "max": null, "min": null, "null_rate": 1, "reports": 3, "table": "stats", "type": "BIGINT"}' \
+  "$(curl -k -s -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/columns?table=stats" | python3 -c "${column}" daily_active_rooms)"
+assert_eq '0' "$(curl -k -s -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/columns?table=dendrite_stats" | python3 -c 'import json,sys; print(json.load(sys.stdin)[0]["reports"])')"
+
+# Roles only see their columns, with protected aggregates.
+assert_eq 'daily_messages total_users' \
+  "$(curl -k -s -H "Authorization: Bearer aggr" "http://localhost:${port}/api/v1/columns?table=stats" | python3 -c 'import json,sys; print(" ".join(sorted(c["column"] for c in json.load(sys.stdin))))')"
+assert_eq '{"column": "total_users", "first_seen": "'${today}'", "homeservers": null, "last_seen": "'${today}'", "max": null, "min": null, "null_rate": 0, "reports": 3, "table": "stats", "type": "BIGINT"}' \
+  "$(curl -k -s -H "Authorization: Bearer aggr" "http://localhost:${port}/api/v1/columns?table=stats" | python3 -c "${column}" total_users)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/columns?table=nonsense")"
+rm ${conf}
@@ -0,0 +1,69 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "roles": {
+    "public": {"tokens": ["publ1c"], "privacy": {"min_homeservers": 3}}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing snapshot diffs"
+
+first=$(( $(date +%s) / 86400 * 86400 - 20 * 86400 ))
+second=$(( first + 10 * 86400 ))
+from=$(date -u -d @${first} +%F)
+to=$(date -u -d @${second} +%F)
+sqlite3 ${dir}/stats.db "INSERT INTO stats(homeserver, local_timestamp, total_users, daily_active_users, daily_messages) VALUES
+  ('kept.turtles', ${first} - 3 * 86400, 10, 1, 100),
+  ('kept.turtles', ${first} + 100, 20, 2, 100),
+  ('gone.turtles', ${first} + 100, 5, 1, 10),
+  ('stale.turtles', ${first} - 8 * 86400, 1000, 1000, 1000),
+  ('kept.turtles', ${second} + 100, 30, 3, 300),
+  ('new.turtles', ${second} - 86400, 15, 5, 50),
+  ('later.turtles', ${second} + 86400, 99, 99, 99)"
+
+diff="http://localhost:${port}/api/v1/diff?from=${from}&to=${to}&metric=total_users&metric=daily_messages"
+assert_eq '{"from":"'${from}'","to":"'${to}'","window_days":7,"homeservers":{"from":2,"to":2,"change":0,"percent":0},"gained":1,"lost":1,"gained_homeservers":["new.turtles"],"lost_homeservers":["gone.turtles"],"metrics":{"daily_messages":{"from":110,"to":350,"change":240,"percent":218.2},"total_users":{"from":25,"to":45,"change":20,"percent":80}},"retained_metrics":{"daily_messages":{"from":100,"to":300,"change":200,"percent":200},"total_users":{"from":20,"to":30,"change":10,"percent":50}}}' \
+  "$(curl -k -H "Authorization: Bearer r3ad" "${diff}" 2>/dev/null)"
+
+# A longer window reaches back to older reports.
+assert_eq '{"from":3,"to":2,"change":-1,"percent":-33.3}' \
+  "$(curl -k -H "Authorization: Bearer r3ad" "${diff}&window=240h" 2>/dev/null | jq -c .homeservers)"
+
+assert_eq "## Network from ${from} to ${to}
+
+Each homeserver's last report within 7 days of the end of each day.
+
+| | ${from} | ${to} | Change | % |
+|---|---:|---:|---:|---:|
+| Homeservers | 2 | 2 | +0 | +0.0% |
+| daily_active_users | 3 | 8 | +5 | +166.7% |
+| daily_messages | 110 | 350 | +240 | +218.2% |
+| total_users | 25 | 45 | +20 | +80.0% |
+| daily_active_users (retained homeservers) | 2 | 3 | +1 | +50.0% |
+| daily_messages (retained homeservers) | 100 | 300 | +200 | +200.0% |
+| total_users (retained homeservers) | 20 | 30 | +10 | +50.0% |
+
+### Gained: 1
+
+- new.turtles
+
+### Lost: 1
+
+- gone.turtles" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/diff?from=${from}&to=${to}&format=markdown" 2>/dev/null)"
+
+# Roles with privacy settings don't see which homeservers came and went.
+assert_eq '{"homeservers":{"from":null,"to":null,"change":null,"percent":null},"gained":null,"gained_homeservers":null,"total_users":null}' \
+  "$(curl -k -H "Authorization: Bearer publ1c" "${diff}" 2>/dev/null | jq -c '{homeservers, gained, gained_homeservers, total_users: .metrics.total_users.to}')"
+
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/diff?from=${to}&to=${from}" 2>/dev/null)"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "${diff}&window=36h" 2>/dev/null)"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "${diff}&metric=python_version" 2>/dev/null)"
+
+# The same diff can be built straight from the database.
+assert_eq "| total_users | 25 | 45 | +20 | +80.0% |" "$(./panopticon diff --db=${dir}/stats.db --from=${from} --to=${to} | grep '^| total_users |')"
+assert_eq '["new.turtles"]' "$(./panopticon diff --db=${dir}/stats.db --from=${from} --to=${to} --format=json | jq -c .gained_homeservers)"
+rm -f ${conf}
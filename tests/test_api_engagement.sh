@@ -0,0 +1,20 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing engagement metrics in /api/v1/series"
+
+curl -k -d '{"homeserver": "one.turtles", "total_users": 10, "daily_active_users": 2, "monthly_active_users": 4, "daily_messages": 10}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "two.turtles", "total_users": 30, "daily_active_users": 6, "daily_messages": 30}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "idle.turtles", "total_users": 5, "daily_active_users": 0, "daily_messages": 3}' http://localhost:${port}/push >/dev/null 2>&1
+
+today=$(( $(date +%s) / 86400 * 86400 ))
+series() {
+  curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/series?since=${today}&metric=active_user_ratio&metric=monthly_active_user_ratio&metric=dau_mau_ratio&metric=messages_per_dau${1:-}" 2>/dev/null
+}
+
+# Only one.turtles reported monthly_active_users, so the ratios using it
+# ignore the others.
+assert_eq '[{"active_user_ratio":0.17777777777777778,"dau_mau_ratio":0.5,"day":'${today}',"messages_per_dau":5.375,"monthly_active_user_ratio":0.4}]' "$(series)"
+assert_eq '[{"active_user_ratio":0.2,"dau_mau_ratio":null,"day":'${today}',"messages_per_dau":5,"monthly_active_user_ratio":null}]' "$(series '&homeserver=two.turtles')"
+assert_eq '[{"active_user_ratio":0,"dau_mau_ratio":null,"day":'${today}',"messages_per_dau":null,"monthly_active_user_ratio":null}]' "$(series '&homeserver=idle.turtles')"
@@ -0,0 +1,42 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "roles": {
+    "public": {"tokens": ["publ1c"], "privacy": {"min_homeservers": 3}}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad --admin-token=s3cret --feed-token=f33d"
+. $(dirname $0)/setup.sh
+log "Testing the Atom feed"
+
+curl -k -d '{"homeserver": "first.turtles", "total_users": 1}' http://localhost:${port}/push >/dev/null 2>&1
+now=$(date +%s)
+sqlite3 ${dir}/stats.db "UPDATE homeservers SET first_seen = ${now} - 300"
+sqlite3 ${dir}/stats.db "INSERT INTO alerts(rule, homeserver, observed, fired_at, resolved_at) VALUES
+  ('too_quiet', NULL, 1, ${now} - 200, ${now} - 100)"
+curl -k -H "Authorization: Bearer s3cret" -d '{"homeserver": "first.turtles", "ends_at": '$((now + 3600))', "reason": "Upgrading"}' \
+  http://localhost:${port}/admin/silences >/dev/null 2>&1
+
+feed=$(curl -k "http://localhost:${port}/api/v1/feed.atom?token=f33d" 2>/dev/null)
+assert_eq '<?xml version="1.0" encoding="UTF-8"?>' "$(head -n1 <<<"${feed}")"
+assert_eq "Silenced first.turtles
+[Resolved] too_quiet
+[Firing] too_quiet
+New homeserver: first.turtles" "$(grep -o '<title>[^<]*</title>' <<<"${feed}" | sed 1d | sed 's/<[^>]*>//g')"
+assert_eq "  <id>http://localhost:${port}/api/v1/feed.atom</id>" "$(grep -m1 '<id>' <<<"${feed}")"
+assert_eq "    <summary>Alert too_quiet resolved after 1m40s.</summary>" "$(grep 'resolved after' <<<"${feed}")"
+assert_eq "    <id>http://localhost:${port}/api/v1/feed.atom#alert/1/fired</id>" "$(grep 'alert/1/fired' <<<"${feed}")"
+assert_eq "application/atom+xml; charset=utf-8" "$(curl -k -o /dev/null -w '%{content_type}' "http://localhost:${port}/api/v1/feed.atom?token=f33d" 2>/dev/null)"
+
+assert_eq "1" "$(curl -k "http://localhost:${port}/api/v1/feed.atom?token=f33d&limit=1" 2>/dev/null | grep -c '<entry>')"
+assert_eq "4" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/feed.atom" 2>/dev/null | grep -c '<entry>')"
+
+# The feed token only opens the feed.
+assert_eq "401" "$(curl -k -o /dev/null -w '%{http_code}' "http://localhost:${port}/api/v1/feed.atom?token=wrong" 2>/dev/null)"
+assert_eq "401" "$(curl -k -o /dev/null -w '%{http_code}' -H "Authorization: Bearer f33d" "http://localhost:${port}/api/v1/reports" 2>/dev/null)"
+# The feed names homeservers, so roles with privacy settings can't read it.
+assert_eq "403" "$(curl -k -o /dev/null -w '%{http_code}' -H "Authorization: Bearer publ1c" "http://localhost:${port}/api/v1/feed.atom" 2>/dev/null)"
+rm -f ${conf}
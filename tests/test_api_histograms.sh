@@ -0,0 +1,31 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "histograms": {"cache_factor": [0.5, 1, 2]},
+  "jobs": {"prune_stats": {"schedule": "@every 1s"}}
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad --stats-retention=48h"
+. $(dirname $0)/setup.sh
+log "Testing histograms of pruned reports"
+
+today=$(( $(date +%s) / 86400 * 86400 ))
+old=$((today - 5 * 86400))
+# gone.turtles is tombstoned, so is left out of the histograms.
+sqlite3 ${dir}/stats.db "INSERT INTO tombstones (homeserver, tombstoned_at) VALUES ('gone.turtles', 0);
+  INSERT INTO stats (homeserver, local_timestamp, cache_factor) VALUES
+  ('gone.turtles', $((old + 5)), 0.1),
+  ('one.turtles', $((old + 10)), 5.0),
+  ('one.turtles', $((old + 20)), 0.5),
+  ('two.turtles', $((old + 30)), 1.5),
+  ('three.turtles', $((old + 40)), NULL),
+  ('new.turtles', $((today - 86400)), 1.0)"
+sleep 2.5
+
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+assert_eq '[{"day":'${old}',"buckets":[{"le":0.5,"homeservers":1},{"le":1,"homeservers":0},{"le":2,"homeservers":1},{"le":null,"homeservers":0}]}]' \
+  "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/histograms?metric=cache_factor&until=$((old + 86400))" 2>/dev/null)"
+assert_eq "5" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(DISTINCT day) FROM metric_histograms")"
+rm ${conf}
@@ -0,0 +1,12 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/ingest-stats"
+
+curl -k -d '{"homeserver": "busy.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "busy.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "quiet.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d 'garbage' http://localhost:${port}/push >/dev/null 2>&1
+
+assert_eq '{"window":"168h0m0s","accepted":3,"rejected":1,"bytes":98,"top_homeservers":[{"name":"busy.turtles","count":2},{"name":"quiet.turtles","count":1}],"rejection_reasons":[{"name":"bad_request","count":1}]}' "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/ingest-stats?window=7d" 2>/dev/null)"
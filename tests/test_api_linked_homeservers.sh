@@ -0,0 +1,51 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "roles": {
+    "public": {"tokens": ["publ1c"], "privacy": {"min_homeservers": 2}},
+    "noip": {"tokens": ["n0ip"], "hidden_columns": ["remote_ip"]},
+    "nonames": {"tokens": ["n0names"], "hidden_columns": ["homeserver"]}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad --trusted-proxies=127.0.0.1,::1 --link-ipv4-prefix=24 --link-exclude=100.64.0.0/10"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/linked-homeservers"
+
+push() {
+  curl -k -s -H "X-Real-IP: $2" -d '{"homeserver": "'$1'"}' http://localhost:${port}/push >/dev/null
+}
+linked() {
+  curl -k -s -H "Authorization: Bearer ${2:-r3ad}" "http://localhost:${port}/api/v1/linked-homeservers${1:-}"
+}
+
+push a.turtles 192.0.2.1
+push b.turtles 192.0.2.200
+# c reports from b's subnet and from another one, where d reports.
+push c.turtles 198.51.100.5
+push c.turtles 192.0.2.7
+push d.turtles 198.51.100.6
+push e.turtles 2001:db8::1
+push f.turtles 2001:db8::2
+push g.turtles 203.0.113.1
+# Shared carrier-grade NAT doesn't link.
+push h.turtles 100.64.0.1
+push i.turtles 100.64.0.1
+
+assert_eq "9|5" "$(linked | jq -r '"\(.homeservers)|\(.operators)"')"
+assert_eq "a.turtles,b.turtles,c.turtles,d.turtles|192.0.2.0/24,198.51.100.0/24" "$(linked | jq -r '.groups[0] | "\(.homeservers | join(","))|\(.networks | join(","))"')"
+assert_eq "e.turtles,f.turtles|2001:db8::/64" "$(linked | jq -r '.groups[1] | "\(.homeservers | join(","))|\(.networks | join(","))"')"
+assert_eq "1" "$(linked '?limit=1' | jq '.groups | length')"
+
+# Exact addresses only link e and f, with IPv6 /64s.
+assert_eq "8" "$(linked '?ipv4_prefix=32' | jq -r .operators)"
+assert_eq "9" "$(linked '?ipv4_prefix=32&ipv6_prefix=128' | jq -r .operators)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/linked-homeservers?ipv4_prefix=33")"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/linked-homeservers?window=0s")"
+
+assert_eq "null" "$(linked '' publ1c | jq -c .groups)"
+assert_eq "true" "$(linked '' publ1c | jq '.operators != null')"
+assert_eq "null" "$(linked '' n0names | jq -c .groups)"
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer n0ip" http://localhost:${port}/api/v1/linked-homeservers)"
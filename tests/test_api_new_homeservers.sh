@@ -0,0 +1,42 @@
+#!/bin/bash -eu
+
+confdir=$(mktemp -d)
+cat >${confdir}/config.json <<'CONF'
+{"roles": {"public": {"tokens": ["pub"], "privacy": {"min_homeservers": 2}}}}
+CONF
+EXTRA_ARGS="--read-token=r3ad --admin-token=s3cret --config=${confdir}/config.json"
+. $(dirname $0)/setup.sh
+trap "kill_server; rm -rf ${confdir}" EXIT
+log "Testing /api/v1/new-homeservers"
+
+function new {
+  curl -k -H "Authorization: Bearer ${2:-r3ad}" "http://localhost:${port}/api/v1/new-homeservers?${1:-}" 2>/dev/null
+}
+
+curl -k -d '{"homeserver": "b.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "a.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+now=$(date +%s)
+today=$(date -u +%F)
+two_days_ago=$(date -u -d @$(( now - 2 * 86400 )) +%F)
+yesterday=$(date -u -d @$(( now - 86400 )) +%F)
+sqlite3 ${dir}/stats.db "INSERT INTO homeservers VALUES ('old.turtles', $(( now - 2 * 86400 )), ${now}, 5)"
+sqlite3 ${dir}/stats.db "INSERT INTO homeservers VALUES ('ancient.turtles', $(( now - 40 * 86400 )), ${now}, 9)"
+
+assert_eq "{\"days\":[{\"date\":\"${two_days_ago}\",\"count\":1,\"homeservers\":[\"old.turtles\"]},{\"date\":\"${yesterday}\",\"count\":0},{\"date\":\"${today}\",\"count\":2,\"homeservers\":[\"a.turtles\",\"b.turtles\"]}],\"total\":3}" "$(new window=2d)"
+assert_eq '"total":3' "$(new | grep -o '"total":[0-9]*')"
+assert_eq '"total":4' "$(new window=60d | grep -o '"total":[0-9]*')"
+
+# Decommissioned and filtered out homeservers aren't listed.
+curl -k -X POST -H "Authorization: Bearer s3cret" -d '{"homeserver": "b.turtles"}' http://localhost:${port}/admin/tombstones >/dev/null 2>&1
+sqlite3 ${dir}/stats.db "INSERT INTO homeserver_tags VALUES ('old.turtles', 'internal')"
+assert_eq "{\"days\":[{\"date\":\"${yesterday}\",\"count\":0},{\"date\":\"${today}\",\"count\":1,\"homeservers\":[\"a.turtles\"]}],\"total\":1}" "$(new 'window=1d&exclude_tag=internal')"
+
+# Roles which only see protected aggregates get suppressed counts.
+assert_eq "{\"days\":[{\"date\":\"${yesterday}\",\"count\":null},{\"date\":\"${today}\",\"count\":null}],\"total\":null}" "$(new 'window=1d&exclude_tag=internal' pub)"
+assert_eq '"total":2' "$(new 'window=2d' pub | grep -o '"total":[0-9]*')"
+
+# Bad windows are refused, including 2^48 + 1 days, which overflows to one
+# day in nanoseconds.
+for window in 400d 0d 0 -3d xd 1.5d 281474976710657d; do
+  assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H 'Authorization: Bearer r3ad' "http://localhost:${port}/api/v1/new-homeservers?window=${window}" 2>/dev/null)"
+done
@@ -0,0 +1,45 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "fields": {
+    "daily_messages": {"min": 0, "max": 1000}
+  },
+  "roles": {
+    "public": {"tokens": ["publ1c"], "privacy": {"min_homeservers": 3}},
+    "noisy": {"tokens": ["n0isy"], "privacy": {"min_homeservers": 2, "noise_epsilon": 0.5, "noise_key": "n0ise key of the n0isy role"}}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing privacy protections for public aggregates"
+
+curl -k -d '{"homeserver": "one.turtles", "total_users": 5, "daily_messages": 10}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "two.turtles", "total_users": 7, "daily_messages": 20}' http://localhost:${port}/push >/dev/null 2>&1
+
+today=$(( $(date +%s) / 86400 * 86400 ))
+series="http://localhost:${port}/api/v1/series?metric=total_users&metric=daily_messages&since=${today}"
+assert_eq '[{"daily_messages":30,"day":'${today}',"total_users":12}]' "$(curl -k -H "Authorization: Bearer r3ad" "${series}" 2>/dev/null)"
+assert_eq '[{"daily_messages":null,"day":'${today}',"total_users":null}]' "$(curl -k -H "Authorization: Bearer publ1c" "${series}" 2>/dev/null)"
+assert_eq '{"24h":null,"30d":null,"7d":null}' "$(curl -k -H "Authorization: Bearer publ1c" http://localhost:${port}/api/v1/active-homeservers 2>/dev/null)"
+
+curl -k -d '{"homeserver": "three.turtles", "total_users": 9, "daily_messages": 30}' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq '[{"daily_messages":60,"day":'${today}',"total_users":21}]' "$(curl -k -H "Authorization: Bearer publ1c" "${series}" 2>/dev/null)"
+assert_eq '{"24h":3,"30d":3,"7d":3}' "$(curl -k -H "Authorization: Bearer publ1c" http://localhost:${port}/api/v1/active-homeservers 2>/dev/null)"
+
+# Noise needs a bound on what one homeserver contributes, so metrics without
+# a configured maximum are suppressed.
+noisy=$(curl -k -H "Authorization: Bearer n0isy" "${series}" 2>/dev/null)
+assert_eq '"total_users":null' "$(echo "${noisy}" | grep -o '"total_users":[^,}]*')"
+assert_eq '"daily_messages":number' "$(echo "${noisy}" | grep -o '"daily_messages":[^,}]*' | sed 's/:-\?[0-9.e+-]*$/:number/')"
+# Asking again gets the same noise, rather than another sample to average.
+assert_eq "${noisy}" "$(curl -k -H "Authorization: Bearer n0isy" "${series}" 2>/dev/null)"
+assert_eq '"24h":integer' "$(curl -k -H "Authorization: Bearer n0isy" http://localhost:${port}/api/v1/active-homeservers 2>/dev/null | grep -o '"24h":[0-9]*' | sed 's/:[0-9]*$/:integer/')"
+
+# Endpoints about individual homeservers are refused.
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer publ1c" http://localhost:${port}/api/v1/reports)"
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer publ1c" http://localhost:${port}/api/v1/cadence)"
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer publ1c" http://localhost:${port}/api/v1/ingest-stats)"
+rm ${conf}
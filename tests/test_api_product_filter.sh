@@ -0,0 +1,32 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing filtering the read API by product"
+
+curl -k -A "Synapse/1.95.0" -d '{"homeserver": "new.turtles", "total_users": 10}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -A "Synapse/1.9.1" -d '{"homeserver": "old.turtles", "total_users": 20}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -A "Dendrite/0.13.0" -d '{"homeserver": "dendrite.turtles", "total_users": 40}' http://localhost:${port}/push >/dev/null 2>&1
+
+function get {
+  curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/$1" 2>/dev/null
+}
+function homeservers {
+  get "$1" | python3 -c 'import json,sys; print(" ".join(sorted(r["homeserver"] for r in json.load(sys.stdin))))'
+}
+
+assert_eq "new.turtles old.turtles" "$(homeservers 'reports?product=synapse')"
+assert_eq "new.turtles" "$(homeservers 'reports?product=Synapse&version=1.95')"
+assert_eq "old.turtles" "$(homeservers 'reports?product=synapse&version=1.9')"
+assert_eq "dendrite.turtles" "$(homeservers 'reports?table=dendrite_stats&product=dendrite')"
+
+today=$(( $(date +%s) / 86400 * 86400 ))
+assert_eq '[{"day":'${today}',"total_users":30}]' "$(get "series?metric=total_users&since=${today}&product=synapse")"
+assert_eq '[{"day":'${today}',"total_users":40}]' "$(get "series?metric=total_users&since=${today}&product=dendrite")"
+
+assert_eq '{"24h":2,"30d":2,"7d":2}' "$(get 'active-homeservers?product=synapse')"
+assert_eq '{"24h":1,"30d":1,"7d":1}' "$(get 'active-homeservers?product=synapse&version=1.9.1')"
+assert_eq '"total":1' "$(get 'new-homeservers?product=dendrite' | grep -o '"total":[0-9]*')"
+
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?product=syn%25")"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/series?metric=total_users&version=1.95")"
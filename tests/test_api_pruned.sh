@@ -0,0 +1,24 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad --stats-retention=48h"
+. $(dirname $0)/setup.sh
+log "Testing that replies reaching back before pruned reports say so"
+
+read="Authorization: Bearer r3ad"
+now=$(date +%s)
+cutoff=$(( (now - 48 * 3600) / 86400 * 86400 ))
+pruned_before() {
+  curl -k -s -o /dev/null -D - -H "${read}" "http://localhost:${port}$1" | tr -d '\r' | sed -n 's/^Panopticon-Pruned-Before: //p'
+}
+
+assert_eq "${cutoff}" "$(pruned_before /api/v1/reports)"
+assert_eq "${cutoff}" "$(pruned_before "/api/v1/reports?since=$(( cutoff - 1 ))")"
+assert_eq "" "$(pruned_before "/api/v1/reports?since=${cutoff}")"
+assert_eq "${cutoff}" "$(pruned_before "/api/v1/series?metric=total_users")"
+assert_eq "" "$(pruned_before "/api/v1/series?metric=total_users&since=$(( now - 3600 ))")"
+assert_eq "${cutoff}" "$(pruned_before "/api/v1/changes?homeserver=one.turtles")"
+assert_eq "${cutoff}" "$(pruned_before "/api/v1/string-metrics?metric=python_version")"
+today=$(date -u +%Y-%m-%d)
+yesterday=$(date -u -d @$(( now - 86400 )) +%Y-%m-%d)
+assert_eq "${cutoff}" "$(pruned_before "/api/v1/diff?from=${yesterday}&to=${today}")"
+assert_eq "" "$(pruned_before "/api/v1/diff?from=${yesterday}&to=${today}&window=24h")"
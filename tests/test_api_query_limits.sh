@@ -0,0 +1,44 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "queries": {
+    "all": {
+      "sql": "SELECT homeserver FROM stats ORDER BY homeserver"
+    },
+    "endless": {
+      "sql": "WITH RECURSIVE c(x) AS (SELECT 1 UNION ALL SELECT x + 1 FROM c) SELECT x FROM c WHERE x % 1000000000 = 0"
+    }
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --admin-token=s3cret --read-token=r3ad --read-query-timeout=500ms --max-row-limit=5 --max-query-rows=2"
+. $(dirname $0)/setup.sh
+log "Testing read query limits"
+
+admin="Authorization: Bearer s3cret"
+read="Authorization: Bearer r3ad"
+for n in 1 2 3; do
+  curl -k -d '{"homeserver": "hs'${n}'.turtles", "total_users": '${n}'}' http://localhost:${port}/push >/dev/null 2>&1
+done
+
+# Limits above -max-row-limit are refused.
+assert_eq "200" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${read}" "http://localhost:${port}/api/v1/reports?limit=5")"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${read}" "http://localhost:${port}/api/v1/reports?limit=6")"
+
+# Named queries return at most -max-query-rows rows, saying so.
+headers=$(mktemp)
+assert_eq '[{"homeserver":"hs1.turtles"},{"homeserver":"hs2.turtles"}]' \
+  "$(curl -k -s -D ${headers} -H "${admin}" "http://localhost:${port}/admin/queries/all")"
+assert_eq "Panopticon-Partial: row_limit" "$(grep -i '^Panopticon-Partial' ${headers} | tr -d '\r')"
+
+# A query which runs out of time is cancelled, returning what it has.
+assert_eq "200" "$(curl -k -s -D ${headers} -o /dev/null -w '%{http_code}' -H "${admin}" "http://localhost:${port}/admin/queries/endless")"
+assert_eq "Panopticon-Partial: timeout" "$(grep -i '^Panopticon-Partial' ${headers} | tr -d '\r')"
+assert_eq "1" "$(curl -k -s http://localhost:${port}/metrics | grep -c '^panopticon_read_query_timeouts_total{result="partial"} 1')"
+
+# Pushes aren't held up by it.
+assert_eq "{}" "$(curl -k -s -d '{"homeserver": "after.turtles"}' http://localhost:${port}/push)"
+assert_eq "4" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+rm ${conf} ${headers}
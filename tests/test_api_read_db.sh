@@ -0,0 +1,13 @@
+#!/bin/bash -eu
+
+# A separate database standing in for a replica which hasn't caught up.
+replica=$(mktemp -u)
+sqlite3 ${replica} "CREATE TABLE stats (id INTEGER PRIMARY KEY, homeserver TEXT, local_timestamp BIGINT, remote_addr TEXT); INSERT INTO stats VALUES (1, 'replica.turtles', 0, '');"
+EXTRA_ARGS="--read-token=r3ad --read-db=${replica}"
+. $(dirname $0)/setup.sh
+log "Testing a separate read database"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "primary.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq '"homeserver":"replica.turtles"' "$(curl -k -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports 2>/dev/null | grep -o '"homeserver":"[^"]*"')"
+assert_eq "primary.turtles" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver FROM stats')"
+rm -f ${replica}
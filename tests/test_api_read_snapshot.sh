@@ -0,0 +1,13 @@
+#!/bin/bash -eu
+
+snapshot=$(mktemp -u)
+EXTRA_ARGS="--read-token=r3ad --read-snapshot=${snapshot} --read-snapshot-interval=1s"
+. $(dirname $0)/setup.sh
+log "Testing the read API snapshot"
+
+assert_eq "[]" "$(curl -k -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports 2>/dev/null)"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "snapshot.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+sleep 2.5
+assert_eq '"homeserver":"snapshot.turtles"' "$(curl -k -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports 2>/dev/null | grep -o '"homeserver":"[^"]*"')"
+assert_eq "1" "$(sqlite3 ${snapshot} 'SELECT COUNT(*) FROM stats')"
+rm -f ${snapshot}
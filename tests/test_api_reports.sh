@@ -0,0 +1,29 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/reports"
+
+assert_eq '{"error_message":"unauthorized","errcode":"UNAUTHORIZED"}' "$(curl -k http://localhost:${port}/api/v1/reports 2>/dev/null)"
+
+assert_eq "{}" "$(curl -k -4 -d '{"homeserver": "v4.turtles", "total_users": 4}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "127.0.0.1|4" "$(sqlite3 ${dir}/stats.db 'SELECT remote_ip, remote_ip_family FROM stats WHERE homeserver == "v4.turtles"')"
+
+assert_eq "v4.turtles 4" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?cidr=127.0.0.0/8" 2>/dev/null | python3 -c 'import json,sys; r=json.load(sys.stdin); print(r[0]["homeserver"], r[0]["total_users"])')"
+assert_eq "[]" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?cidr=10.0.0.0/8" 2>/dev/null)"
+assert_eq "[]" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?homeserver=other.turtles" 2>/dev/null)"
+
+# CIDR matching reads past pages of reports which don't match, but stops at
+# the limit.
+for i in 1 2 3; do
+	curl -k -4 -d '{"homeserver": "v4.turtles", "total_users": 4}' http://localhost:${port}/push >/dev/null 2>&1
+done
+sqlite3 ${dir}/stats.db "WITH RECURSIVE n(i) AS (SELECT 1 UNION ALL SELECT i + 1 FROM n WHERE i < 2500) INSERT INTO stats (homeserver, local_timestamp, remote_ip, remote_ip_family) SELECT 'v10.turtles', 0, '10.0.0.1', 4 FROM n"
+assert_eq "2" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?cidr=127.0.0.0/8&limit=2" 2>/dev/null | python3 -c 'import json,sys; print(len(json.load(sys.stdin)))')"
+assert_eq "4" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?cidr=127.0.0.0/8" 2>/dev/null | python3 -c 'import json,sys; print(len(json.load(sys.stdin)))')"
+assert_eq "3" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?cidr=10.0.0.0/8&limit=3" 2>/dev/null | python3 -c 'import json,sys; print(len(json.load(sys.stdin)))')"
+
+# Without column encryption, values which look encrypted are just text, so
+# a push can't break reading reports.
+assert_eq "{}" "$(curl -k -A 'enc1:x' -d '{"homeserver": "prefixed.turtles", "log_level": "enc1:AAAA"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "enc1:x enc1:AAAA" "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/reports?homeserver=prefixed.turtles" 2>/dev/null | python3 -c 'import json,sys; r=json.load(sys.stdin)[0]; print(r["user_agent"], r["log_level"])')"
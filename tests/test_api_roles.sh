@@ -0,0 +1,32 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "roles": {
+    "community": {"tokens": ["c0mmunity"], "hidden_columns": ["remote_addr", "remote_ip", "remote_ip_family", "forwarded_for", "user_agent"]},
+    "aggregates": {"tokens": ["aggr"], "columns": ["total_users", "daily_messages"]}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing column masking for read API roles"
+
+curl -k -H 'User-Agent: Synapse/1.0' -d '{"homeserver": "one.turtles", "total_users": 5, "daily_messages": 10}' http://localhost:${port}/push >/dev/null 2>&1
+
+keys='import json,sys; print(",".join(sorted(json.load(sys.stdin)[0])))'
+all=$(curl -k -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports 2>/dev/null | python3 -c "${keys}")
+assert_eq "remote_addr remote_ip user_agent" "$(echo ${all} | tr , '\n' | grep -x 'remote_addr\|remote_ip\|user_agent' | tr '\n' ' ' | sed 's/ $//')"
+masked=$(curl -k -H "Authorization: Bearer c0mmunity" http://localhost:${port}/api/v1/reports 2>/dev/null | python3 -c "${keys}")
+assert_eq "" "$(echo ${masked} | tr , '\n' | grep -x 'remote_addr\|remote_ip\|remote_ip_family\|forwarded_for\|user_agent' || true)"
+assert_eq "homeserver" "$(echo ${masked} | tr , '\n' | grep -x homeserver)"
+assert_eq '[{"daily_messages":10,"total_users":5}]' "$(curl -k -H "Authorization: Bearer aggr" http://localhost:${port}/api/v1/reports 2>/dev/null)"
+
+# Filtering on a hidden column is refused rather than leaking it.
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer c0mmunity" "http://localhost:${port}/api/v1/reports?cidr=127.0.0.0/8")"
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer aggr" "http://localhost:${port}/api/v1/reports?homeserver=one.turtles")"
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer aggr" "http://localhost:${port}/api/v1/series?metric=daily_active_users")"
+assert_eq "200" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer aggr" "http://localhost:${port}/api/v1/series?metric=total_users")"
+assert_eq "401" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer wrong" http://localhost:${port}/api/v1/reports)"
+rm ${conf}
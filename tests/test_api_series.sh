@@ -0,0 +1,29 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "derived_metrics": {
+    "messages_per_active_user": "daily_messages / daily_active_users"
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing /api/v1/series"
+
+curl -k -d '{"homeserver": "one.turtles", "total_users": 5, "daily_messages": 10, "daily_active_users": 2}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "one.turtles", "total_users": 5, "daily_messages": 20, "daily_active_users": 4}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "two.turtles", "total_users": 7, "daily_messages": 40, "daily_active_users": 4}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "standby.turtles", "total_users": 0, "daily_messages": 99, "daily_active_users": 9}' http://localhost:${port}/push >/dev/null 2>&1
+
+today=$(( $(date +%s) / 86400 * 86400 ))
+assert_eq '[{"daily_messages":60,"day":'${today}',"messages_per_active_user":7.5,"total_users":12}]' \
+  "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/series?metric=total_users&metric=daily_messages&metric=messages_per_active_user&since=${today}" 2>/dev/null)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/series?metric=nonsense")"
+
+# Tombstoned homeservers are left out.
+sqlite3 ${dir}/stats.db "INSERT INTO tombstones (homeserver, tombstoned_at) VALUES ('two.turtles', 0)"
+assert_eq '[{"daily_messages":20,"day":'${today}',"messages_per_active_user":5,"total_users":5}]' \
+  "$(curl -k -H "Authorization: Bearer r3ad" "http://localhost:${port}/api/v1/series?metric=total_users&metric=daily_messages&metric=messages_per_active_user&since=${today}" 2>/dev/null)"
+rm ${conf}
@@ -0,0 +1,58 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "queries": {
+    "biggest": {
+      "sql": "SELECT homeserver, total_users FROM stats WHERE total_users >= :min_users ORDER BY total_users DESC",
+      "params": {"min_users": "int"}
+    }
+  },
+  "roles": {
+    "narrow": {"tokens": ["narr0w"], "columns": ["total_users"]}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --admin-token=s3cret --read-token=r3ad --signed-url-key=0123456789abcdef"
+. $(dirname $0)/setup.sh
+log "Testing signed URLs"
+
+admin="Authorization: Bearer s3cret"
+for n in 3 30; do
+  curl -k -d '{"homeserver": "hs'${n}'.turtles", "total_users": '${n}'}' http://localhost:${port}/push >/dev/null 2>&1
+done
+
+sign() {
+  curl -k -s -H "${admin}" -d "$1" http://localhost:${port}/admin/signed-urls
+}
+status() {
+  curl -k -s -o /dev/null -w '%{http_code}' "$1"
+}
+
+url=$(sign '{"path": "/api/v1/reports?homeserver=hs3.turtles"}' | jq -r .url)
+assert_eq "hs3.turtles|3" "$(curl -k -s "${url}" | jq -r '.[] | "\(.homeserver)|\(.total_users)"')"
+# Without the signature, or with a changed query, a token is needed.
+assert_eq "401" "$(status "http://localhost:${port}/api/v1/reports?homeserver=hs3.turtles")"
+assert_eq "403" "$(status "${url/hs3/hs30}")"
+assert_eq "401" "$(status "${url/\/reports/\/series}")"
+
+url=$(sign '{"path": "/api/v1/reports", "role": "narrow"}' | jq -r .url)
+assert_eq "total_users" "$(curl -k -s "${url}" | jq -r '.[0] | keys | map(select(. != "id")) | join(",")')"
+assert_eq "403" "$(status "${url/narrow/nobody}")"
+
+url=$(sign '{"path": "/admin/queries/biggest?min_users=10&format=csv", "expires_in": 60}' | jq -r .url)
+assert_eq "homeserver,total_users
+hs30.turtles,30" "$(curl -k -s "${url}")"
+assert_eq "401" "$(status "http://localhost:${port}/admin/queries/biggest?min_users=10&format=csv")"
+
+url=$(sign '{"path": "/api/v1/reports", "expires_in": 1}' | jq -r .url)
+sleep 2
+assert_eq "403" "$(status "${url}")"
+
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${admin}" -d '{"path": "/admin/silences"}' http://localhost:${port}/admin/signed-urls)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${admin}" -d '{"path": "/api/v1/reports", "expires_in": 999999999}' http://localhost:${port}/admin/signed-urls)"
+# 2^55 + 3600 seconds in nanoseconds overflows to an hour.
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${admin}" -d '{"path": "/api/v1/reports", "expires_in": 36028797018967568}' http://localhost:${port}/admin/signed-urls)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "${admin}" -d '{"path": "/admin/queries/biggest", "role": "narrow"}' http://localhost:${port}/admin/signed-urls)"
+assert_eq "401" "$(curl -k -s -o /dev/null -w '%{http_code}' -H "Authorization: Bearer r3ad" -d '{"path": "/api/v1/reports"}' http://localhost:${port}/admin/signed-urls)"
@@ -0,0 +1,61 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "string_metrics": {
+    "deployment": {"values": ["docker", "debian", "helm"]},
+    "region": {"max_length": 4}
+  },
+  "field_paths": {"region": "host.region"},
+  "payload_schemas": {"strict": {"strict": true}},
+  "roles": {
+    "public": {"tokens": ["publ1c"], "privacy": {"min_homeservers": 2}},
+    "narrow": {"tokens": ["narr0w"], "columns": ["total_users", "region"]}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing string metrics"
+
+read="Authorization: Bearer r3ad"
+today=$(( $(date +%s) / 86400 * 86400 ))
+push() {
+  curl -k -H "User-Agent: ${2:-Synapse/1.100.0}" -d "$1" http://localhost:${port}/push 2>/dev/null
+}
+
+assert_eq "{}" "$(push '{"homeserver": "one.turtles", "deployment": "debian", "log_level": "DEBUG", "host": {"region": "europe"}}')"
+push '{"homeserver": "one.turtles", "deployment": "docker", "log_level": "INFO"}' >/dev/null
+push '{"homeserver": "two.turtles", "deployment": "nix", "log_level": "INFO", "host": {"region": "asia"}}' >/dev/null
+push '{"homeserver": "three.turtles", "deployment": 3, "log_level": "WARNING"}' Dendrite/0.13.0 >/dev/null
+
+# Each homeserver is counted once a day, by its last value.
+assert_eq '[{"day":'${today}',"values":{"docker":1,"other":1}}]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/string-metrics?metric=deployment&since=${today}" 2>/dev/null)"
+assert_eq '[{"day":'${today}',"values":{"asia":1,"euro":1}}]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/string-metrics?metric=region&since=${today}" 2>/dev/null)"
+# Categorical columns of the stats tables can be counted too.
+assert_eq '[{"day":'${today}',"values":{"INFO":2,"WARNING":1}}]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/string-metrics?metric=log_level&since=${today}" 2>/dev/null)"
+assert_eq '[{"day":'${today}',"values":{"INFO":1}}]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/string-metrics?metric=log_level&since=${today}&homeserver=one.turtles" 2>/dev/null)"
+
+assert_eq '[{"day":'${today}',"values":{"INFO":2,"WARNING":null}}]' \
+  "$(curl -k -H "Authorization: Bearer publ1c" "http://localhost:${port}/api/v1/string-metrics?metric=log_level&since=${today}" 2>/dev/null)"
+assert_eq "403" "$(curl -k -o /dev/null -w '%{http_code}' -H "Authorization: Bearer narr0w" "http://localhost:${port}/api/v1/string-metrics?metric=deployment" 2>/dev/null)"
+assert_eq "200" "$(curl -k -o /dev/null -w '%{http_code}' -H "Authorization: Bearer narr0w" "http://localhost:${port}/api/v1/string-metrics?metric=region" 2>/dev/null)"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -H "${read}" "http://localhost:${port}/api/v1/string-metrics?metric=total_users" 2>/dev/null)"
+
+# String metrics aren't unknown fields.
+assert_eq '[]' "$(curl -k -d '{"homeserver": "one.turtles", "deployment": "helm"}' "http://localhost:${port}/push?verbose=1" 2>/dev/null | jq -c .ignored)"
+assert_eq "{}" "$(curl -k -H "X-Payload-Schema-Version: strict" -d '{"homeserver": "one.turtles", "deployment": "helm"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "4" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM string_metrics WHERE homeserver = 'one.turtles' AND metric = 'deployment'")"
+
+# Tombstoned homeservers aren't counted.
+sqlite3 ${dir}/stats.db "INSERT INTO tombstones (homeserver, tombstoned_at) VALUES ('two.turtles', 0)"
+assert_eq '[{"day":'${today}',"values":{"helm":1}}]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/string-metrics?metric=deployment&since=${today}" 2>/dev/null)"
+assert_eq '[{"day":'${today}',"values":{"INFO":1,"WARNING":1}}]' \
+  "$(curl -k -H "${read}" "http://localhost:${port}/api/v1/string-metrics?metric=log_level&since=${today}" 2>/dev/null)"
+rm -f ${conf}
@@ -0,0 +1,19 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret"
+. $(dirname $0)/setup.sh
+log "Testing tag filters"
+
+auth="Authorization: Bearer s3cret"
+for hs in public.turtles staging.turtles plain.turtles; do
+  curl -k -d '{"homeserver": "'${hs}'"}' http://localhost:${port}/push >/dev/null 2>&1
+done
+curl -k -H "${auth}" -d '{"homeserver": "public.turtles", "tags": ["public"]}' http://localhost:${port}/admin/homeservers >/dev/null 2>&1
+curl -k -H "${auth}" -d '{"homeserver": "staging.turtles", "tags": ["internal", "staging"]}' http://localhost:${port}/admin/homeservers >/dev/null 2>&1
+
+assert_eq '{"24h":2,"30d":2,"7d":2}' "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/active-homeservers?exclude_tag=internal" 2>/dev/null)"
+assert_eq '{"24h":1,"30d":1,"7d":1}' "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/active-homeservers?tag=public" 2>/dev/null)"
+assert_eq '{"internal":{"24h":1,"30d":1,"7d":1},"public":{"24h":1,"30d":1,"7d":1},"staging":{"24h":1,"30d":1,"7d":1}}' \
+  "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/active-homeservers?group_by=tag" 2>/dev/null)"
+assert_eq '"homeserver":"staging.turtles"' "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/reports?tag=staging" 2>/dev/null | grep -o '"homeserver":"[^"]*"')"
+assert_eq "2" "$(curl -k -H "${auth}" "http://localhost:${port}/api/v1/reports?exclude_tag=staging" 2>/dev/null | grep -o '"homeserver"' | wc -l)"
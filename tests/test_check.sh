@@ -0,0 +1,22 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--read-token=r3ad --downsample-interval=1h"
+. $(dirname $0)/setup.sh
+log "Testing panopticon check"
+
+url=http://localhost:${port}
+assert_eq "OK: report 1 stored and read back" "$(./panopticon check -url=${url} -read-token=r3ad | grep -o '^OK: report 1 stored and read back')"
+assert_eq "panopticon-check.internal|panopticon-check" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, user_agent FROM stats')"
+
+status=0
+./panopticon check -url=${url} -homeserver=other.check -wait=1s -read-token=wrong 2>${dir}/err || status=$?
+assert_eq "1|CHECK FAILED: reading back report 2: 401 Unauthorized" "${status}|$(cat ${dir}/err)"
+
+# A report which isn't stored fails the check straight away.
+status=0
+./panopticon check -url=${url} -read-token=r3ad 2>${dir}/err || status=$?
+assert_eq "1|CHECK FAILED: pushing: report was downsampled, so not stored" "${status}|$(cat ${dir}/err)"
+
+status=0
+./panopticon check -read-token=r3ad 2>/dev/null || status=$?
+assert_eq "2" "${status}"
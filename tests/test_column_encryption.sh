@@ -0,0 +1,79 @@
+#!/bin/bash -eu
+
+confdir=$(mktemp -d)
+key=$(head -c 32 /dev/urandom | base64)
+cat >${confdir}/config.json <<CONF
+{
+  "roles": {
+    "ops": {"tokens": ["0ps"]},
+    "community": {"tokens": ["c0mmunity"]}
+  },
+  "queries": {
+    "agents": {"sql": "SELECT user_agent FROM stats ORDER BY id"}
+  },
+  "column_encryption": {
+    "key_command": ["sh", "-c", "echo ${key}"],
+    "columns": ["remote_addr", "forwarded_for", "user_agent", "database_engine"],
+    "roles": ["ops"]
+  }
+}
+CONF
+cat >${confdir}/bad.json <<'CONF'
+{"column_encryption": {"key": "c2hvcnQ=", "columns": ["user_agent"]}}
+CONF
+cat >${confdir}/bad_column.json <<CONF
+{"column_encryption": {"key": "${key}", "columns": ["remote_ip"]}}
+CONF
+EXTRA_ARGS="--config=${confdir}/config.json --read-token=r3ad --admin-token=s3cret --store-raw-reports --compact-after=24h"
+. $(dirname $0)/setup.sh
+log "Testing column encryption"
+
+assert_eq "Could not load config: column_encryption: key is 5 bytes, not 32" \
+  "$(./panopticon --config=${confdir}/bad.json 2>&1 | grep -o 'Could not load config.*')"
+assert_eq "Could not load config: column_encryption: column remote_ip: not a TEXT column of reports" \
+  "$(./panopticon --config=${confdir}/bad_column.json 2>&1 | grep -o 'Could not load config.*')"
+
+curl -k -H 'User-Agent: Synapse/1.99' -H 'X-Forwarded-For: 192.0.2.7' -d '{"homeserver": "one.turtles", "total_users": 5}' http://localhost:${port}/push >/dev/null 2>&1
+
+# The database only holds ciphertext.
+assert_eq "enc1: enc1: enc1: " "$(sqlite3 ${dir}/stats.db "SELECT substr(remote_addr, 1, 5), substr(forwarded_for, 1, 5), substr(user_agent, 1, 5), remote_ip FROM stats" | tr '|' ' ')"
+assert_eq "0" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM stats WHERE user_agent LIKE '%Synapse%' OR remote_addr LIKE '%127.0.0.1%'")"
+assert_eq "enc1: enc1:" "$(sqlite3 ${dir}/stats.db "SELECT substr(remote_addr, 1, 5), substr(user_agent, 1, 5) FROM raw_reports" | tr '|' ' ')"
+
+# The read and admin tokens and authorised roles see plaintext, others nothing.
+fields='import json,sys; r = json.load(sys.stdin)[0]; print(r["user_agent"], r["forwarded_for"], r["remote_addr"].rsplit(":", 1)[0])'
+assert_eq "Synapse/1.99 192.0.2.7 127.0.0.1" "$(curl -k -s -H 'Authorization: Bearer r3ad' http://localhost:${port}/api/v1/reports | python3 -c "${fields}")"
+assert_eq "Synapse/1.99 192.0.2.7 127.0.0.1" "$(curl -k -s -H 'Authorization: Bearer 0ps' http://localhost:${port}/api/v1/reports | python3 -c "${fields}")"
+assert_eq "None None" "$(curl -k -s -H 'Authorization: Bearer c0mmunity' http://localhost:${port}/api/v1/reports | python3 -c 'import json,sys; r = json.load(sys.stdin)[0]; print(r["user_agent"], r["remote_addr"])')"
+assert_eq "1" "$(curl -k -s -H 'Authorization: Bearer 0ps' "http://localhost:${port}/api/v1/reports?cidr=127.0.0.0/8" | python3 -c 'import json,sys; print(len(json.load(sys.stdin)))')"
+assert_eq '[{"user_agent":"Synapse/1.99"}]' "$(curl -k -s -H 'Authorization: Bearer s3cret' http://localhost:${port}/admin/queries/agents)"
+assert_eq "403" "$(curl -k -s -o /dev/null -w '%{http_code}' -H 'Authorization: Bearer c0mmunity' http://localhost:${port}/api/v1/linked-homeservers)"
+assert_eq "200" "$(curl -k -s -o /dev/null -w '%{http_code}' -H 'Authorization: Bearer 0ps' http://localhost:${port}/api/v1/linked-homeservers)"
+
+# Columns which aren't encrypted are plaintext, whatever they hold.
+assert_eq "{}" "$(curl -k -s -d '{"homeserver": "prefixed.turtles", "log_level": "enc1:AAAA"}' http://localhost:${port}/push)"
+assert_eq "enc1:AAAA" "$(curl -k -s -H 'Authorization: Bearer c0mmunity' "http://localhost:${port}/api/v1/reports?homeserver=prefixed.turtles" | python3 -c 'import json,sys; print(json.load(sys.stdin)[0]["log_level"])')"
+
+# Deltas carry over the plaintext of their base's encrypted columns, and
+# other columns as they are, even when they look encrypted.
+base=$(curl -k -s -d '{"homeserver": "delta.turtles", "total_users": 5, "database_engine": "PostgreSQL", "log_level": "enc1:AAAA"}' "http://localhost:${port}/push?delta=1" | jq -r .id)
+curl -k -s -d '{"homeserver": "delta.turtles", "delta_of": '${base}', "total_users": 6}' "http://localhost:${port}/push?delta=1" >/dev/null
+assert_eq "5 PostgreSQL enc1:AAAA
+6 PostgreSQL enc1:AAAA" "$(curl -k -s -H 'Authorization: Bearer r3ad' "http://localhost:${port}/api/v1/reports?homeserver=delta.turtles" | python3 -c '
+import json, sys
+for r in sorted(json.load(sys.stdin), key=lambda r: r["total_users"]):
+    print(r["total_users"], r["database_engine"], r["log_level"])')"
+assert_eq "enc1: enc1:" "$(sqlite3 ${dir}/stats.db "SELECT substr(database_engine, 1, 5) FROM stats WHERE homeserver = 'delta.turtles'" | paste -sd' ')"
+
+# Reports whose encrypted columns hold the same plaintext are compacted
+# together, though their ciphertexts differ.
+for users in 7 8; do
+  curl -k -H 'User-Agent: Synapse/1.99' -d "{\"homeserver\": \"compact.turtles\", \"total_users\": ${users}}" http://localhost:${port}/push >/dev/null 2>&1
+done
+curl -k -H 'User-Agent: Synapse/1.100' -d '{"homeserver": "compact.turtles", "total_users": 9}' http://localhost:${port}/push >/dev/null 2>&1
+sqlite3 ${dir}/stats.db "UPDATE stats SET local_timestamp = local_timestamp - 3 * 86400 WHERE homeserver = 'compact.turtles'"
+curl -k -X POST -H 'Authorization: Bearer s3cret' http://localhost:${port}/admin/jobs/compact_stats >/dev/null 2>&1
+sleep 0.5
+assert_eq "8 2
+9 " "$(sqlite3 ${dir}/stats.db "SELECT total_users, compacted_reports FROM stats WHERE homeserver = 'compact.turtles' ORDER BY id" | tr '|' ' ')"
+rm -r ${confdir}
@@ -0,0 +1,34 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret --compact-after=24h"
+. $(dirname $0)/setup.sh
+log "Testing compaction"
+
+auth="Authorization: Bearer s3cret"
+day=$(( $(date +%s) / 86400 * 86400 - 3 * 86400 ))
+sqlite3 ${dir}/stats.db "INSERT INTO stats(homeserver, local_timestamp, total_users, daily_messages, python_version) VALUES
+  ('compact.turtles', ${day} + 100, 10, 5, '3.11'),
+  ('compact.turtles', ${day} + 200, 12, 5, '3.11'),
+  ('compact.turtles', ${day} + 300, 11, 5, '3.11'),
+  ('compact.turtles', ${day} + 400, 13, 6, '3.12'),
+  ('compact.turtles', ${day} + 86400, 14, 6, '3.12'),
+  ('other.turtles', ${day} + 100, 1, 1, '3.11')"
+curl -k -d '{"homeserver": "compact.turtles", "total_users": 15}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "compact.turtles", "total_users": 16}' http://localhost:${port}/push >/dev/null 2>&1
+
+curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/compact_stats >/dev/null 2>&1
+sleep 0.5
+assert_eq "compact_stats|succeeded|2" "$(sqlite3 ${dir}/stats.db 'SELECT job, state, rows_affected FROM job_runs')"
+# The reports of a day which only differ in counters are kept as the last
+# of them, with the range of each counter that varied.
+assert_eq "$((day + 300))|11|3|{\"total_users\":[10,12]}
+$((day + 400))|13||
+$((day + 86400))|14||" "$(sqlite3 ${dir}/stats.db "SELECT local_timestamp, total_users, compacted_reports, compacted_ranges FROM stats WHERE homeserver = 'compact.turtles' AND total_users < 15 ORDER BY local_timestamp")"
+# Recent days are left alone.
+assert_eq "2" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM stats WHERE total_users >= 15")"
+
+# Compacting again widens the ranges of already compacted rows.
+sqlite3 ${dir}/stats.db "INSERT INTO stats(homeserver, local_timestamp, total_users, daily_messages, python_version) VALUES ('compact.turtles', ${day} + 500, 9, 5, '3.11')"
+curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/compact_stats >/dev/null 2>&1
+sleep 0.5
+assert_eq "$((day + 500))|9|4|{\"total_users\":[9,12]}" "$(sqlite3 ${dir}/stats.db "SELECT local_timestamp, total_users, compacted_reports, compacted_ranges FROM stats WHERE homeserver = 'compact.turtles' AND python_version = '3.11'")"
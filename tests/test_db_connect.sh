@@ -0,0 +1,19 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--db-health-interval=1s"
+. $(dirname $0)/setup.sh
+log "Testing waiting for the database"
+
+sleep 1.5
+assert_eq "panopticon_db_up 1" "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep db_up)"
+
+out=$(mktemp)
+start=$(date +%s)
+if timeout 20 ./panopticon --port=9003 --db-driver=mysql --db='nobody@tcp(127.0.0.1:1)/nothing' --db-connect-timeout=2s 2>${out}; then
+  log "panopticon started without a database"
+  exit 1
+fi
+assert_eq "1" "$(( $(date +%s) - start >= 1 ))"
+assert_eq "Waiting for database" "$(grep -o 'Waiting for database' ${out} | head -n1)"
+assert_eq "Could not connect to database" "$(grep -o 'Could not connect to database' ${out})"
+rm -f ${out}
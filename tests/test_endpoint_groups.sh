@@ -0,0 +1,42 @@
+#!/bin/bash -eu
+
+confdir=$(mktemp -d)
+conf=${confdir}/config.json
+cat >${conf} <<CONF
+{
+  "endpoint_groups": {
+    "push": {"tokens": ["pu5h"]},
+    "read": {"rate_limit": 0.1, "rate_burst": 2, "cors_origins": ["https://dash.example.com"], "compress": true},
+    "admin": {"log_requests": true}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad --admin-token=s3cret"
+. $(dirname $0)/setup.sh
+trap "kill_server; rm -rf ${confdir}" EXIT
+log "Testing endpoint groups"
+
+function status {
+  curl -k -s -o /dev/null -w '%{http_code}' "$@"
+}
+
+# Pushes need the push group's token, unlike the public endpoints.
+assert_eq "401" "$(status -d '{"homeserver": "a.turtles", "total_users": 1}' http://localhost:${port}/push)"
+assert_eq "200" "$(status -H "Authorization: Bearer pu5h" -d '{"homeserver": "a.turtles", "total_users": 1}' http://localhost:${port}/push)"
+assert_eq "200" "$(status http://localhost:${port}/healthz)"
+
+# Preflight requests from allowed origins are answered without a token.
+preflight=$(curl -k -s -D - -o /dev/null -X OPTIONS -H "Origin: https://dash.example.com" -H "Access-Control-Request-Method: GET" http://localhost:${port}/api/v1/reports)
+assert_eq "204" "$(echo "${preflight}" | head -1 | cut -d' ' -f2)"
+assert_eq "https://dash.example.com" "$(echo "${preflight}" | grep -i '^Access-Control-Allow-Origin:' | cut -d' ' -f2 | tr -d '\r')"
+assert_eq "" "$(curl -k -s -D - -o /dev/null -H "Origin: https://evil.example.com" -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports | grep -i '^Access-Control' || true)"
+
+# Read responses are gzipped for clients which accept it, and the read
+# group's rate limit is spent.
+assert_eq "gzip" "$(curl -k -s -D - -o /dev/null -H "Accept-Encoding: gzip" -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports | grep -i '^Content-Encoding:' | cut -d' ' -f2 | tr -d '\r')"
+assert_eq "429" "$(status -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports)"
+
+# The admin group logs requests; other groups are limited separately.
+assert_eq "200" "$(status -H "Authorization: Bearer s3cret" http://localhost:${port}/admin/tombstones)"
+grep -q "admin: .* GET /admin/tombstones 200" $1
+assert_eq "1" "$(curl -k -s http://localhost:${port}/metrics | grep -c 'panopticon_rate_limited_requests_total{group="read"} 1')"
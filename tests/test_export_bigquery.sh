@@ -0,0 +1,64 @@
+#!/bin/bash -eu
+
+bqdir=$(mktemp -d)
+openssl genrsa -out ${bqdir}/key.pem 2048 2>/dev/null
+python3 - ${bqdir} <<'PY' &
+import http.server, json, sys
+out = sys.argv[1]
+class H(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers["Content-Length"]))
+        if self.path == "/token":
+            reply = {"access_token": "t0ken", "expires_in": 3600}
+        else:
+            with open(out + "/requests", "ab") as f:
+                f.write(self.path.encode() + b" " + self.headers["Authorization"].encode() + b" " + body + b"\n")
+            reply = {}
+        self.send_response(200)
+        self.send_header("Content-Type", "application/json")
+        self.end_headers()
+        self.wfile.write(json.dumps(reply).encode())
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(("127.0.0.1", 9013), H).serve_forever()
+PY
+sink=$!
+python3 -c 'import json, sys; print(json.dumps({"client_email": "exporter@example.iam.gserviceaccount.com", "token_uri": "http://127.0.0.1:9013/token", "private_key": open(sys.argv[1]).read()}))' ${bqdir}/key.pem >${bqdir}/credentials.json
+cat >${bqdir}/config.json <<CONF
+{
+  "jobs": {"bigquery_export": {"schedule": "@every 1s"}},
+  "bigquery": {
+    "credentials_file": "${bqdir}/credentials.json",
+    "project": "matrix",
+    "dataset": "panopticon",
+    "tables": {"stats": "synapse"},
+    "columns": {"remote_addr": "-", "total_users": "users"},
+    "endpoint": "http://127.0.0.1:9013"
+  }
+}
+CONF
+EXTRA_ARGS="--config=${bqdir}/config.json"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${sink}; rm -rf ${bqdir}" EXIT
+log "Testing the BigQuery export"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "bigquery.turtles", "total_users": 7}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "bigquery.turtles", "total_users": 8}' http://localhost:${port}/push 2>/dev/null)"
+until [[ -s ${bqdir}/requests ]]; do
+  sleep 0.1
+done
+sleep 0.5
+assert_eq "1" "$(wc -l <${bqdir}/requests)"
+assert_eq "/bigquery/v2/projects/matrix/datasets/panopticon/tables/synapse/insertAll Bearer t0ken" "$(cut -d' ' -f1-3 ${bqdir}/requests)"
+assert_eq '"insertId":"stats-1"
+"insertId":"stats-2"' "$(grep -o '"insertId":"[^"]*"' ${bqdir}/requests)"
+assert_eq '"users":7
+"users":8' "$(grep -o '"users":[0-9]*' ${bqdir}/requests)"
+assert_eq "" "$(grep -o remote_addr ${bqdir}/requests || true)"
+assert_eq "2" "$(sqlite3 ${dir}/stats.db "SELECT last_id FROM export_watermarks WHERE exporter = 'bigquery' AND source_table = 'stats'")"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "bigquery.turtles", "total_users": 9}' http://localhost:${port}/push 2>/dev/null)"
+until [[ $(wc -l <${bqdir}/requests) == 2 ]]; do
+  sleep 0.1
+done
+assert_eq '"insertId":"stats-3"' "$(tail -n1 ${bqdir}/requests | grep -o '"insertId":"[^"]*"')"
@@ -0,0 +1,25 @@
+#!/bin/bash -eu
+
+confdir=$(mktemp -d)
+conf=${confdir}/config.json
+echo '{"features": {"delta_reports": false, "verification": false}}' >${conf}
+EXTRA_ARGS="--config=${conf} --verification-period=1h"
+. $(dirname $0)/setup.sh
+trap "kill_server; rm -rf ${confdir}" EXIT
+log "Testing feature flags"
+
+# /version shows which features are switched on.
+assert_eq "delta_reports=False group_tokens=True histograms=True shadow=True verification=False" \
+  "$(curl -k -s http://localhost:${port}/version | python3 -c 'import json,sys; print(" ".join("%s=%s" % kv for kv in sorted(json.load(sys.stdin)["features"].items())))')"
+assert_eq "1" "$(curl -k -s http://localhost:${port}/metrics | grep -c 'panopticon_feature_enabled{feature="verification"} 0')"
+
+# Switched off subsystems behave as though they didn't exist.
+assert_eq "404" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"server_name": "a.turtles"}' http://localhost:${port}/verify/challenge)"
+curl -k -d '{"homeserver": "a.turtles", "total_users": 1, "total_room_count": 2}' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq "delta_of" "$(curl -k -s -d '{"homeserver": "a.turtles", "total_users": 3, "delta_of": 1}' "http://localhost:${port}/push?verbose=1" | python3 -c 'import json,sys; print(" ".join(json.load(sys.stdin)["ignored"]))')"
+assert_eq "3||" "$(sqlite3 ${dir}/stats.db 'SELECT total_users, total_room_count, verified FROM stats ORDER BY id DESC LIMIT 1')"
+
+# Unknown features are refused on startup.
+echo '{"features": {"no_such_feature": true}}' >${confdir}/bad.json
+assert_eq "Could not load config: features: unknown feature no_such_feature; features are delta_reports, group_tokens, histograms, shadow, verification" \
+  "$(./panopticon --config=${confdir}/bad.json 2>&1 | grep -o 'Could not load config.*')"
@@ -0,0 +1,23 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--heartbeat-interval=1h --admin-token=s3cret"
+. $(dirname $0)/setup.sh
+log "Testing the heartbeat job"
+
+auth="Authorization: Bearer s3cret"
+for i in 1 2; do
+  curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/heartbeat >/dev/null 2>&1
+  sleep 0.5
+done
+assert_eq "succeeded|1
+succeeded|1" "$(sqlite3 ${dir}/stats.db "SELECT state, rows_affected FROM job_runs WHERE job = 'heartbeat'")"
+assert_eq "panopticon.internal|1|1|panopticon-heartbeat
+panopticon.internal|1|1|panopticon-heartbeat" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, uptime_seconds >= 0, memory_rss > 0, user_agent FROM stats')"
+assert_eq "internal" "$(sqlite3 ${dir}/stats.db "SELECT tag FROM homeserver_tags WHERE homeserver = 'panopticon.internal'")"
+assert_eq 'panopticon_heartbeats_total{result="ok"} 2' "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep panopticon_heartbeats_total)"
+
+# Admins' metadata is kept.
+sqlite3 ${dir}/stats.db "DELETE FROM homeserver_tags; UPDATE homeserver_metadata SET display_name = 'canary'"
+curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/heartbeat >/dev/null 2>&1
+sleep 0.5
+assert_eq "canary|0" "$(sqlite3 ${dir}/stats.db "SELECT display_name, (SELECT COUNT(*) FROM homeserver_tags) FROM homeserver_metadata")"
@@ -0,0 +1,68 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing AWS Lambda invocations"
+
+# A stand-in for the Lambda runtime API, handing out each event in turn and
+# recording the replies.
+runtime=${dir}/runtime
+mkdir ${runtime}
+cat >${runtime}/1 <<'EVENT'
+{"version": "2.0", "rawPath": "/push", "rawQueryString": "verbose=1", "headers": {"content-type": "application/json"},
+ "requestContext": {"http": {"method": "POST", "sourceIp": "198.51.100.7"}},
+ "body": "{\"homeserver\": \"lambda.turtles\", \"total_users\": 4}", "isBase64Encoded": false}
+EVENT
+cat >${runtime}/2 <<'EVENT'
+{"httpMethod": "POST", "path": "/push", "multiValueHeaders": {"User-Agent": ["Dendrite/0.13.0"]},
+ "requestContext": {"identity": {"sourceIp": "198.51.100.8"}},
+ "body": "eyJob21lc2VydmVyIjogInJlc3QudHVydGxlcyIsICJ0b3RhbF91c2VycyI6IDV9", "isBase64Encoded": true}
+EVENT
+echo '{"hello": "world"}' >${runtime}/3
+python3 - ${runtime} <<'PY' &
+import http.server, os, sys, time
+n = 0
+class H(http.server.BaseHTTPRequestHandler):
+    def do_GET(self):
+        global n
+        if self.path == "/":
+            self.send_response(200)
+            self.end_headers()
+            return
+        n += 1
+        path = os.path.join(sys.argv[1], str(n))
+        while not os.path.exists(path):
+            time.sleep(1)
+        body = open(path, "rb").read()
+        self.send_response(200)
+        self.send_header("Lambda-Runtime-Aws-Request-Id", "req%d" % n)
+        self.send_header("Lambda-Runtime-Deadline-Ms", str(int(time.time() * 1000) + 10000))
+        self.end_headers()
+        self.wfile.write(body)
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers["Content-Length"]))
+        name = self.path.split("/")[-2] + "." + self.path.split("/")[-1]
+        with open(os.path.join(sys.argv[1], name), "wb") as f:
+            f.write(body)
+        self.send_response(202)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.ThreadingHTTPServer(("127.0.0.1", 9016), H).serve_forever()
+PY
+runtime_pid=$!
+until curl http://127.0.0.1:9016/ >/dev/null 2>&1; do
+  sleep 0.1
+done
+
+AWS_LAMBDA_RUNTIME_API=127.0.0.1:9016 ./panopticon --db=${dir}/lambda.db 2>>$1 &
+lambda_pid=$!
+trap "kill_server; kill ${runtime_pid} ${lambda_pid}; rm -rf ${dir}" EXIT
+until [[ -e ${runtime}/req3.error ]]; do
+  sleep 0.1
+done
+
+assert_eq '{"statusCode":200,"headers":{"Content-Security-Policy":"default-src '"'self'; frame-ancestors 'none'"'","Referrer-Policy":"no-referrer","X-Content-Type-Options":"nosniff","X-Frame-Options":"DENY"},"body":"{\"id\":1,\"table\":\"stats\",\"stored\":[\"homeserver\",\"local_timestamp\",\"remote_addr\",\"total_users\",\"remote_ip\",\"remote_ip_family\"],\"ignored\":[]}\n","isBase64Encoded":false}' "$(cat ${runtime}/req1.response)"
+assert_eq '"statusCode":200' "$(grep -o '"statusCode":[0-9]*' ${runtime}/req2.response)"
+assert_eq '{"errorMessage":"not an API Gateway request","errorType":"InvalidEvent"}' "$(cat ${runtime}/req3.error)"
+assert_eq "lambda.turtles|4|198.51.100.7" "$(sqlite3 ${dir}/lambda.db 'SELECT homeserver, total_users, remote_ip FROM stats')"
+assert_eq "rest.turtles|5|198.51.100.8" "$(sqlite3 ${dir}/lambda.db 'SELECT homeserver, total_users, remote_ip FROM dendrite_stats')"
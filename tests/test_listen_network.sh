@@ -0,0 +1,17 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--listen-network=dual --trusted-proxies=127.0.0.1,::1"
+. $(dirname $0)/setup.sh
+log "Testing listen networks"
+
+# Both IP versions are served on separate sockets.
+assert_eq "ok" "$(curl -4 -s http://127.0.0.1:${port}/healthz)"
+assert_eq "ok" "$(curl -6 -s "http://[::1]:${port}/healthz")"
+grep -q "Listening on 0.0.0.0:${port} (tcp4)" $1
+grep -q "Listening on \[::\]:${port} (tcp6)" $1
+
+# Clients behind NAT64 are recorded by their IPv4 address.
+curl -6 -s -H "X-Real-IP: 64:ff9b::c000:201" -d '{"homeserver": "nat64.turtles", "total_users": 1}' "http://[::1]:${port}/push" >/dev/null
+curl -6 -s -d '{"homeserver": "v6.turtles", "total_users": 1}' "http://[::1]:${port}/push" >/dev/null
+assert_eq "nat64.turtles|192.0.2.1
+v6.turtles|::1" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, remote_ip FROM stats ORDER BY id')"
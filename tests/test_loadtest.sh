@@ -0,0 +1,10 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing the loadtest subcommand"
+
+out=$(./panopticon loadtest --target=http://localhost:${port}/push --rate=20 --duration=1s --homeservers=5 --dendrite=0.5)
+sent=$(echo "${out}" | grep -o '^Sent [0-9]*' | cut -d' ' -f2)
+assert_eq "  200: ${sent}" "$(echo "${out}" | grep '^  200:')"
+assert_eq "${sent}" "$(sqlite3 ${dir}/stats.db 'SELECT (SELECT COUNT(*) FROM stats) + (SELECT COUNT(*) FROM dendrite_stats)')"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(DISTINCT homeserver) BETWEEN 1 AND 5 FROM stats')"
@@ -0,0 +1,25 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing merge"
+
+curl -k -d '{"homeserver": "east.turtles", "total_users": 1}' http://localhost:${port}/push >/dev/null 2>&1
+sqlite3 ${dir}/stats.db ".backup ${dir}/east.db"
+curl -k -d '{"homeserver": "west.turtles", "total_users": 2}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -H "User-Agent: Dendrite/0.13.0" -d '{"homeserver": "west.turtles", "total_users": 3}' http://localhost:${port}/push >/dev/null 2>&1
+sqlite3 ${dir}/stats.db ".backup ${dir}/west.db"
+
+# west.db also has east.turtles' report, which is only copied once.
+assert_eq "${dir}/east.db stats: copied 1, skipped 0 duplicates
+${dir}/east.db dendrite_stats: copied 0, skipped 0 duplicates
+${dir}/west.db stats: copied 1, skipped 1 duplicates
+${dir}/west.db dendrite_stats: copied 1, skipped 0 duplicates" "$(./panopticon merge --db=${dir}/merged.db ${dir}/east.db ${dir}/west.db 2>/dev/null)"
+assert_eq "east.turtles|1
+west.turtles|2" "$(sqlite3 ${dir}/merged.db 'SELECT homeserver, total_users FROM stats ORDER BY id')"
+assert_eq "west.turtles|3" "$(sqlite3 ${dir}/merged.db 'SELECT homeserver, total_users FROM dendrite_stats')"
+assert_eq "east.turtles|1
+west.turtles|2" "$(sqlite3 ${dir}/merged.db 'SELECT homeserver, report_count FROM homeservers ORDER BY homeserver')"
+
+# Merging again changes nothing.
+assert_eq "${dir}/west.db stats: copied 0, skipped 2 duplicates" "$(./panopticon merge --db=${dir}/merged.db ${dir}/west.db 2>/dev/null | head -1)"
+assert_eq "2" "$(sqlite3 ${dir}/merged.db 'SELECT COUNT(*) FROM stats')"
@@ -0,0 +1,15 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing method enforcement and unknown paths"
+
+assert_eq "405 POST" "$(curl -k -s -D - -o /dev/null http://localhost:${port}/push | tr -d '\r' | awk '/^HTTP/ {code=$2} /^Allow:/ {allow=$2} END {print code, allow}')"
+assert_eq '{"error_message":"PUT is not allowed","errcode":"METHOD_NOT_ALLOWED"}' "$(curl -k -X PUT -d '{"homeserver": "put.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "0" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+
+assert_eq "ok" "$(curl -k http://localhost:${port}/healthz 2>/dev/null)"
+assert_eq "200" "$(curl -k -I -o /dev/null -w '%{http_code}' http://localhost:${port}/healthz 2>/dev/null)"
+assert_eq "405" "$(curl -k -X POST -o /dev/null -w '%{http_code}' http://localhost:${port}/test 2>/dev/null)"
+
+assert_eq '{"error_message":"no such endpoint","errcode":"NOT_FOUND"}' "$(curl -k http://localhost:${port}/wp-login.php 2>/dev/null)"
+assert_eq "404" "$(curl -k -o /dev/null -w '%{http_code}' http://localhost:${port}/ 2>/dev/null)"
@@ -0,0 +1,72 @@
+#!/bin/bash -eu
+
+keydir=$(mktemp -d)
+openssl genpkey -algorithm ed25519 -out ${keydir}/key.pem 2>/dev/null
+pubkey=$(openssl pkey -in ${keydir}/key.pem -pubout -outform DER | tail -c 32 | base64 | tr -d '=')
+cat >${keydir}/keys.json <<KEYS
+{"server_name": "leaving.turtles", "verify_keys": {"ed25519:a": {"key": "${pubkey}"}}}
+KEYS
+python3 - ${keydir}/keys.json <<'PY' &
+import http.server, sys
+keys = open(sys.argv[1], "rb").read()
+class H(http.server.BaseHTTPRequestHandler):
+    def do_GET(self):
+        if self.path != "/leaving.turtles/_matrix/key/v2/server":
+            self.send_response(404)
+            self.end_headers()
+            return
+        self.send_response(200)
+        self.end_headers()
+        self.wfile.write(keys)
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(("127.0.0.1", 9013), H).serve_forever()
+PY
+keyserver=$!
+
+EXTRA_ARGS="--opt-out-key-url=http://127.0.0.1:9013/%s/_matrix/key/v2/server --opt-out-hash-key=k3y --store-raw-reports --server-fetch-rate-limit=0.01 --server-fetch-rate-burst=12"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${keyserver}; rm -rf ${keydir}" EXIT
+log "Testing opt-outs"
+
+# sign prints a signed opt-out request for server, signed as signer.
+function sign {
+  python3 -c '
+import json, sys, time
+print(json.dumps({"server_name": sys.argv[1], "origin_server_ts": int(time.time() * 1000)}, sort_keys=True, separators=(",", ":"), ensure_ascii=False), end="")
+' $1 >${keydir}/message
+  sig=$(openssl pkeyutl -sign -inkey ${keydir}/key.pem -rawin -in ${keydir}/message | base64 | tr -d '=\n')
+  python3 -c '
+import json, sys
+r = json.load(open(sys.argv[1]))
+r["signatures"] = {sys.argv[2]: {"ed25519:a": sys.argv[3]}}
+print(json.dumps(r))
+' ${keydir}/message $2 ${sig}
+}
+
+curl -k -d '{"homeserver": "leaving.turtles", "total_users": 3}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "staying.turtles", "total_users": 4}' http://localhost:${port}/push >/dev/null 2>&1
+
+# Requests which were tampered with, or which can't be verified, are refused.
+assert_eq "403" "$(sign leaving.turtles leaving.turtles | sed 's/^{/{"extra": 1, /' | curl -k -s -o /dev/null -w '%{http_code}' -d @- http://localhost:${port}/opt-out)"
+assert_eq "403" "$(sign other.turtles other.turtles | curl -k -s -o /dev/null -w '%{http_code}' -d @- http://localhost:${port}/opt-out)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{' http://localhost:${port}/opt-out)"
+assert_eq "2|2" "$(sqlite3 ${dir}/stats.db 'SELECT (SELECT COUNT(*) FROM stats), (SELECT COUNT(*) FROM raw_reports)')"
+
+assert_eq "{}" "$(sign leaving.turtles leaving.turtles | curl -k -d @- http://localhost:${port}/opt-out 2>/dev/null)"
+assert_eq "staying.turtles|staying.turtles|1" "$(sqlite3 ${dir}/stats.db 'SELECT (SELECT GROUP_CONCAT(homeserver) FROM stats), (SELECT GROUP_CONCAT(homeserver) FROM homeservers), (SELECT COUNT(*) FROM raw_reports)')"
+assert_eq "$(printf leaving.turtles | openssl dgst -sha256 -hmac k3y | sed 's/.* //')" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver_hash FROM opt_outs')"
+
+# Later reports are dropped without an error.
+assert_eq "{}" "$(curl -k -d '{"homeserver": "leaving.turtles", "total_users": 3}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "1|1" "$(sqlite3 ${dir}/stats.db 'SELECT (SELECT COUNT(*) FROM stats), (SELECT COUNT(*) FROM raw_reports)')"
+
+# Server names which could make panopticon fetch from elsewhere are refused
+# before anything is fetched.
+for name in 127.0.0.1 10.1.2.3:8448 '[::1]' localhost evil.turtles/x a@leaving.turtles 'leaving.turtles?' leaving.turtles:http; do
+  assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -d "{\"server_name\": \"${name}\"}" http://localhost:${port}/opt-out)"
+done
+
+# Clients making too many requests are refused; the twelve above used up the
+# burst.
+assert_eq "429" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"server_name": "leaving.turtles"}' http://localhost:${port}/opt-out)"
@@ -0,0 +1,21 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--admin-token=s3cret --store-raw-reports"
+. $(dirname $0)/setup.sh
+log "Testing the optimize_db job"
+
+auth="Authorization: Bearer s3cret"
+# New databases free pages incrementally.
+assert_eq "2" "$(sqlite3 ${dir}/stats.db 'PRAGMA auto_vacuum')"
+
+padding=$(head -c 30000 /dev/urandom | base64 -w0)
+for i in $(seq 20); do
+  curl -k -d '{"homeserver": "vacuum.turtles", "padding": "'${padding}'", "total_users": '${i}'}' http://localhost:${port}/push >/dev/null 2>&1
+done
+sqlite3 ${dir}/stats.db 'DELETE FROM raw_reports'
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT freelist_count > 0 FROM pragma_freelist_count')"
+
+curl -k -X POST -H "${auth}" http://localhost:${port}/admin/jobs/optimize_db >/dev/null 2>&1
+sleep 0.5
+assert_eq "succeeded" "$(sqlite3 ${dir}/stats.db "SELECT state FROM job_runs WHERE job = 'optimize_db'")"
+assert_eq "0" "$(sqlite3 ${dir}/stats.db 'PRAGMA freelist_count')"
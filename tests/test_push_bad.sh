@@ -3,5 +3,6 @@
 . $(dirname $0)/setup.sh
 
 log "Testing /push with bad input"
-assert_eq '{"error_message": "unable to process request"}' "$(curl -k -d "not an object" http://localhost:${port}/push 2>/dev/null)"
-assert_eq '{"error_message": "unable to process request"}' "$(curl -k -d "123" http://localhost:${port}/push 2>/dev/null)"
+assert_eq '{"error_message":"Error decoding JSON: invalid character '"'o'"' in literal null (expecting '"'u'"')","errcode":"INVALID_JSON"}' "$(curl -k -d "not an object" http://localhost:${port}/push 2>/dev/null)"
+assert_eq '"errcode":"VALIDATION_FAILED"' "$(curl -k -d "123" http://localhost:${port}/push 2>/dev/null | grep -o '"errcode":"[^"]*"')"
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -d "123" http://localhost:${port}/push 2>/dev/null)"
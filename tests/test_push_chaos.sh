@@ -0,0 +1,57 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing fault injection"
+
+chaosdir=$(mktemp -d)
+chaos_port=9003
+go build -tags chaos -o ${chaosdir}/panopticon .
+trap 'kill_server; kill ${CHAOS_PID:-} 2>/dev/null || true; rm -rf ${chaosdir}' EXIT
+
+function start_chaos {
+  ${chaosdir}/panopticon --port=${chaos_port} --db=${chaosdir}/stats.db "$@" 2>>${chaosdir}/log &
+  CHAOS_PID=$!
+  until curl -k http://localhost:${chaos_port}/healthz >/dev/null 2>/dev/null; do
+    sleep 0.1
+  done
+}
+
+function stop_chaos {
+  kill ${CHAOS_PID}
+  wait ${CHAOS_PID} 2>/dev/null || true
+}
+
+# Faults can't be injected without -tags chaos.
+if ./panopticon --chaos-failure-rate=1 --help 2>/dev/null; then
+  log "panopticon accepted --chaos-failure-rate without -tags chaos"
+  exit 1
+fi
+
+# Failed writes are reported as the storage being unavailable.
+start_chaos --chaos-failure-rate=1
+assert_eq "500" "$(curl -k -o ${chaosdir}/body -w '%{http_code}' -d '{"homeserver": "chaos.turtles", "total_users": 1}' http://localhost:${chaos_port}/push 2>/dev/null)"
+assert_eq '"STORAGE_UNAVAILABLE"' "$(jq .errcode ${chaosdir}/body)"
+assert_eq "0" "$(sqlite3 ${chaosdir}/stats.db 'SELECT COUNT(*) FROM stats')"
+assert_eq 'panopticon_injected_faults_total{target="primary",fault="failure"} 1' "$(curl -k http://localhost:${chaos_port}/metrics 2>/dev/null | grep injected_faults)"
+assert_eq "Injecting faults into writes" "$(grep -o 'Injecting faults into writes' ${chaosdir}/log)"
+stop_chaos
+
+# Slow writes fill the write queue, and further pushes are refused.
+start_chaos --chaos-latency=2s --max-concurrent-writes=1 --max-queued-writes=0
+curl -k -d '{"homeserver": "slow.turtles", "total_users": 2}' http://localhost:${chaos_port}/push >/dev/null 2>&1 &
+slow=$!
+sleep 0.5
+assert_eq "429" "$(curl -k -o /dev/null -w '%{http_code}' -d '{"homeserver": "fast.turtles", "total_users": 3}' http://localhost:${chaos_port}/push 2>/dev/null)"
+wait ${slow}
+assert_eq "slow.turtles" "$(sqlite3 ${chaosdir}/stats.db 'SELECT homeserver FROM stats')"
+stop_chaos
+
+# Faults can be limited to write targets.
+cat >${chaosdir}/config.json <<CONF
+{"write_targets": [{"name": "mirror", "driver": "sqlite3", "dsn": "${chaosdir}/mirror.db"}]}
+CONF
+start_chaos --config=${chaosdir}/config.json --chaos-failure-rate=1 --chaos-targets=mirror
+assert_eq "{}" "$(curl -k -d '{"homeserver": "mirrored.turtles", "total_users": 4}' http://localhost:${chaos_port}/push 2>/dev/null)"
+assert_eq 'panopticon_target_writes_total{target="mirror",result="error"} 1
+panopticon_target_writes_total{target="primary",result="ok"} 1' "$(curl -k http://localhost:${chaos_port}/metrics 2>/dev/null | grep target_writes)"
+stop_chaos
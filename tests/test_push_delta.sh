@@ -0,0 +1,38 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "string_metrics": {"deployment": {}}
+}
+CONF
+EXTRA_ARGS="--config=${conf}"
+. $(dirname $0)/setup.sh
+log "Testing delta reports"
+
+push() {
+  curl -k -s -H "User-Agent: ${2:-Synapse/1.100.0}" -d "$1" "http://localhost:${port}/push?delta=1"
+}
+row() {
+  sqlite3 ${dir}/stats.db "SELECT homeserver, remote_timestamp, total_users, daily_messages, python_version, user_agent FROM stats WHERE id = $1"
+}
+
+first=$(push '{"homeserver": "one.turtles", "timestamp": 1000, "total_users": 10, "daily_messages": 5, "python_version": "3.11", "deployment": "docker"}' | jq -r .id)
+second=$(push '{"homeserver": "one.turtles", "delta_of": '${first}', "daily_messages": 7}' "Synapse/1.101.0" | jq -r .id)
+assert_eq "one.turtles||10|7|3.11|Synapse/1.101.0" "$(row ${second})"
+assert_eq "docker|docker" "$(sqlite3 ${dir}/stats.db "SELECT value FROM string_metrics ORDER BY local_timestamp" | paste -sd'|')"
+
+# Deltas chain, null clears a field, and ids may be strings.
+third=$(push '{"homeserver": "one.turtles", "delta_of": "'${second}'", "timestamp": 1200, "total_users": 11, "python_version": null}' | jq -r .id)
+assert_eq "one.turtles|1200|11|7||Synapse/1.100.0" "$(row ${third})"
+
+# The base must be a report from the same homeserver.
+assert_eq "409" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"homeserver": "two.turtles", "delta_of": '${first}', "daily_messages": 1}' http://localhost:${port}/push)"
+assert_eq "UNKNOWN_DELTA_BASE" "$(curl -k -s -d '{"homeserver": "one.turtles", "delta_of": 999, "daily_messages": 1}' http://localhost:${port}/push | jq -r .errcode)"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"homeserver": "one.turtles", "delta_of": {}}' http://localhost:${port}/push)"
+assert_eq "3" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+
+# Deltas always get their id back, while full reports outside delta mode
+# get the usual reply.
+assert_eq "number" "$(curl -k -s -d '{"homeserver": "one.turtles", "delta_of": '${third}'}' http://localhost:${port}/push | jq -r '.id | type')"
+assert_eq "{}" "$(curl -k -s -d '{"homeserver": "one.turtles"}' http://localhost:${port}/push)"
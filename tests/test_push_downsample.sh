@@ -0,0 +1,11 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--downsample-interval=1h"
+. $(dirname $0)/setup.sh
+log "Testing /push downsampling"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "chatty.turtles", "daily_active_users": 1}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "chatty.turtles", "daily_active_users": 2}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq '{"id":0,"table":"stats","stored":[],"ignored":[],"downsampled":true}' "$(curl -k -d '{"homeserver": "chatty.turtles", "daily_active_users": 3}' "http://localhost:${port}/push?verbose=1" 2>/dev/null)"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT daily_active_users FROM stats WHERE homeserver == "chatty.turtles"')"
+assert_eq "2" "$(sqlite3 ${dir}/stats.db 'SELECT SUM(report_count) FROM downsampled_reports WHERE homeserver == "chatty.turtles"')"
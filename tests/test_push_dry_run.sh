@@ -0,0 +1,24 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--store-raw-reports --downsample-interval=1h"
+. $(dirname $0)/setup.sh
+log "Testing /push/dry-run"
+
+reply=$(curl -k -H "User-Agent: Dendrite/0.13.0" -d '{"homeserver": "dry.turtles", "total_users": 3, "made_up": 1}' http://localhost:${port}/push/dry-run 2>/dev/null)
+assert_eq '{"id":0,"table":"dendrite_stats","stored":["homeserver","local_timestamp","remote_addr","total_users","remote_ip","remote_ip_family","user_agent"],"ignored":["made_up"],"dry_run":true,"report":{"homeserver":"dry.turtles"' "$(echo "${reply}" | grep -o '^.*"report":{"homeserver":"dry.turtles"')"
+assert_eq '"total_users":3' "$(echo "${reply}" | grep -o '"total_users":3')"
+assert_eq '"user_agent":"Dendrite/0.13.0"' "$(echo "${reply}" | grep -o '"user_agent":"[^"]*"')"
+
+# Nothing is stored.
+for table in stats dendrite_stats homeservers raw_reports; do
+  assert_eq "${table}: 0" "${table}: $(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM ${table}")"
+done
+
+# Reports are checked as they would be by /push.
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -d '{"homeserver": "dry.turtles", "total_users": "many"}' http://localhost:${port}/push/dry-run 2>/dev/null)"
+assert_eq "405" "$(curl -k -o /dev/null -w '%{http_code}' http://localhost:${port}/push/dry-run 2>/dev/null)"
+
+# And the reply says what would happen to them.
+curl -k -d '{"homeserver": "dry.turtles", "total_users": 3}' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq '"stored":[],"ignored":[],"downsampled":true,"dry_run":true' "$(curl -k -d '{"homeserver": "dry.turtles", "total_users": 4}' http://localhost:${port}/push/dry-run 2>/dev/null | grep -o '"stored".*"dry_run":true')"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
@@ -0,0 +1,18 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "push_endpoints": {
+    "/report-usage-stats/push": {"status": 201, "content_type": "text/plain", "body": "stored {{.ID}}"}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf}"
+. $(dirname $0)/setup.sh
+log "Testing custom push endpoint responses"
+
+assert_eq "stored 1 201" "$(curl -k -s -w ' %{http_code}' -d '{"homeserver": "legacy.turtles"}' http://localhost:${port}/report-usage-stats/push)"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "modern.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "2" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+rm ${conf}
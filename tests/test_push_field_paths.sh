@@ -0,0 +1,22 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "field_paths": {
+    "homeserver": "meta.server_name",
+    "total_users": "stats.users.total",
+    "daily_messages": "stats.daily.0"
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf}"
+. $(dirname $0)/setup.sh
+log "Testing nested payload mapping"
+
+assert_eq '{"id":1,"table":"stats","stored":["homeserver","local_timestamp","remote_addr","total_users","daily_messages","remote_ip","remote_ip_family","user_agent"],"ignored":[]}' \
+  "$(curl -k -4 -d '{"meta": {"server_name": "nested.turtles"}, "stats": {"users": {"total": 12}, "daily": [34, 56]}}' "http://localhost:${port}/push?verbose=1" 2>/dev/null)"
+assert_eq "nested.turtles|12|34" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, total_users, daily_messages FROM stats')"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "flat.turtles", "total_users": 7}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "7" "$(sqlite3 ${dir}/stats.db "SELECT total_users FROM stats WHERE homeserver = 'flat.turtles'")"
+rm ${conf}
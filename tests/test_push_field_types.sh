@@ -0,0 +1,23 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "fields": {
+    "cpu_average": {"type": "float"},
+    "total_room_count": {"min": 0, "max": 1000},
+    "daily_messages": {"min": 0, "on_invalid": "drop"},
+    "memory_rss": {"max": 10, "on_invalid": "reject"}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf}"
+. $(dirname $0)/setup.sh
+log "Testing per-field types and range checks"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "float.turtles", "cpu_average": 2.35, "total_users": 99999999999999999999, "total_room_count": 5000, "daily_messages": -3, "daily_active_users": 4.6}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "2.35|9223372036854775807|1000||5|daily_active_users,daily_messages,total_room_count,total_users" \
+  "$(sqlite3 ${dir}/stats.db 'SELECT cpu_average, total_users, total_room_count, daily_messages, daily_active_users, adjusted_fields FROM stats')"
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"homeserver": "big.turtles", "memory_rss": 11}' http://localhost:${port}/push)"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+rm ${conf}
@@ -0,0 +1,14 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--trusted-proxies=127.0.0.1,::1"
+. $(dirname $0)/setup.sh
+log "Testing client IPs from trusted proxy headers"
+
+assert_eq "{}" "$(curl -k -H 'Forwarded: for="[2001:db8:cafe::17]:4711";proto=https, for=127.0.0.1' -d '{"homeserver": "forwarded.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "2001:db8:cafe::17|6|[2001:db8:cafe::17]:4711, 127.0.0.1" "$(sqlite3 ${dir}/stats.db 'SELECT remote_ip, remote_ip_family, forwarded_for FROM stats WHERE homeserver == "forwarded.turtles"')"
+
+assert_eq "{}" "$(curl -k -H 'X-Real-IP: 192.0.2.1' -d '{"homeserver": "real.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "192.0.2.1|4" "$(sqlite3 ${dir}/stats.db 'SELECT remote_ip, remote_ip_family FROM stats WHERE homeserver == "real.turtles"')"
+
+assert_eq "{}" "$(curl -k -H 'X-Forwarded-For: 198.51.100.7, 192.0.2.1' -H 'X-Real-IP: 203.0.113.9' -d '{"homeserver": "xff.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "192.0.2.1" "$(sqlite3 ${dir}/stats.db 'SELECT remote_ip FROM stats WHERE homeserver == "xff.turtles"')"
@@ -0,0 +1,21 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--homeserver-hash-salt=s4lt --admin-token=s3cret --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing hash-only homeserver names"
+
+hash=$(printf hashed.turtles | openssl dgst -sha256 -hmac s4lt | sed 's/.* //')
+curl -k -d '{"homeserver": "hashed.turtles", "total_users": 1}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "Hashed.Turtles", "total_users": 2}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "other.turtles", "total_users": 3}' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq "${hash}|2" "$(sqlite3 ${dir}/stats.db "SELECT homeserver, COUNT(*) FROM stats WHERE homeserver = '${hash}'")"
+assert_eq "0" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM stats WHERE homeserver LIKE '%turtles%'")"
+assert_eq "${hash}" "$(sqlite3 ${dir}/stats.db "SELECT homeserver FROM homeservers WHERE report_count = 2")"
+
+# The APIs take either the name or its hash.
+assert_eq '"total_users":2' "$(curl -k -H 'Authorization: Bearer r3ad' "http://localhost:${port}/api/v1/reports?homeserver=hashed.turtles&limit=1" 2>/dev/null | grep -o '"total_users":[0-9]*')"
+curl -k -H 'Authorization: Bearer s3cret' -d '{"homeserver": "hashed.turtles", "reason": "gone"}' http://localhost:${port}/admin/tombstones >/dev/null 2>&1
+assert_eq "${hash}" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver FROM tombstones')"
+assert_eq "410" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"homeserver": "hashed.turtles"}' http://localhost:${port}/push)"
+curl -k -H 'Authorization: Bearer s3cret' -X DELETE "http://localhost:${port}/admin/tombstones?homeserver=${hash}" >/dev/null 2>&1
+assert_eq "0" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM tombstones')"
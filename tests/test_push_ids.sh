@@ -0,0 +1,16 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--id-type=uuid --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing generated ids"
+
+uuid='[0-9a-f]\{8\}-[0-9a-f]\{4\}-7[0-9a-f]\{3\}-[89ab][0-9a-f]\{3\}-[0-9a-f]\{12\}'
+first=$(curl -k -d '{"homeserver": "uuid.turtles", "total_users": 1}' "http://localhost:${port}/push?verbose=1" 2>/dev/null | grep -o "\"id\":\"${uuid}\"" | cut -d'"' -f4)
+sleep 0.01
+second=$(curl -k -d '{"homeserver": "uuid.turtles", "total_users": 2}' "http://localhost:${port}/push?verbose=1" 2>/dev/null | grep -o "\"id\":\"${uuid}\"" | cut -d'"' -f4)
+assert_eq "36|36" "${#first}|${#second}"
+assert_eq "${first}
+${second}" "$(sqlite3 ${dir}/stats.db 'SELECT id FROM stats ORDER BY id')"
+
+# Generated ids sort by time, so reports still list newest first.
+assert_eq "\"id\":\"${second}\"" "$(curl -k -H 'Authorization: Bearer r3ad' "http://localhost:${port}/api/v1/reports?limit=1" 2>/dev/null | grep -o '"id":"[^"]*"')"
@@ -0,0 +1,38 @@
+#!/bin/bash -eu
+
+targetdir=$(mktemp -d)
+python3 - ${targetdir} <<'PY' &
+import http.server, sys
+out = sys.argv[1]
+class H(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers["Content-Length"]))
+        with open(out + "/points", "ab") as f:
+            f.write(self.headers["Authorization"].encode() + b"\n" + body)
+        self.send_response(204)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(("127.0.0.1", 9012), H).serve_forever()
+PY
+sink=$!
+cat >${targetdir}/config.json <<CONF
+{
+  "write_targets": [
+    {"name": "tsdb", "driver": "influx", "dsn": "http://127.0.0.1:9012/api/v2/write?org=o&bucket=b&precision=ns", "token": "s3cret", "measurement": "homeservers"}
+  ]
+}
+CONF
+EXTRA_ARGS="--config=${targetdir}/config.json"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${sink}; rm -rf ${targetdir}" EXIT
+log "Testing the Influx line protocol write target"
+
+until curl http://127.0.0.1:9012/ >/dev/null 2>/dev/null; do
+  sleep 0.1
+done
+assert_eq "{}" "$(curl -k -d '{"homeserver": "tsdb turtles", "timestamp": 5, "total_users": 7, "cache_factor": 0.5, "python_version": "3.9"}' http://localhost:${port}/push 2>/dev/null)"
+ts=$(sqlite3 ${dir}/stats.db 'SELECT local_timestamp FROM stats')
+assert_eq "Token s3cret
+homeservers,homeserver=tsdb\ turtles,table=stats total_users=7i,cache_factor=0.5 ${ts}000000000" "$(cat ${targetdir}/points)"
+assert_eq 'panopticon_target_writes_total{target="tsdb",result="ok"} 1' "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep 'target="tsdb"')"
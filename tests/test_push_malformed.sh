@@ -0,0 +1,16 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--max-push-bytes=1000 --max-json-depth=4"
+. $(dirname $0)/setup.sh
+log "Testing malformed pushes"
+
+function status {
+  curl -k -s -o /dev/null -w '%{http_code}' --data-binary @- http://localhost:${port}/push
+}
+
+assert_eq "200" "$(echo '{"homeserver": "fine.turtles", "nested": [[1]]}' | status)"
+assert_eq "400" "$(echo '{"homeserver": "deep.turtles", "nested": [[[[1]]]]}' | status)"
+assert_eq "400" "$(printf '{"homeserver": "bad\xff.turtles"}' | status)"
+assert_eq "400" "$(echo '{"homeserver": "long.turtles", "total_users": 1'$(printf '0%.0s' {1..80})'}' | status)"
+assert_eq "413" "$(echo '{"homeserver": "big.turtles", "padding": "'$(printf 'x%.0s' {1..1000})'"}' | status)"
+assert_eq "fine.turtles" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver FROM stats')"
@@ -0,0 +1,16 @@
+#!/bin/bash -eu
+
+dump=$(mktemp -u)
+EXTRA_ARGS="--db-driver=memory --memory-max-reports=2 --memory-dump=${dump} --memory-dump-interval=1s --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing the memory driver"
+
+for n in 1 2 3; do
+  assert_eq "{}" "$(curl -k -d "{\"homeserver\": \"memory${n}.turtles\", \"total_users\": ${n}}" http://localhost:${port}/push 2>/dev/null)"
+done
+assert_eq '"homeserver":"memory2.turtles"
+"homeserver":"memory3.turtles"' "$(curl -k -H "Authorization: Bearer r3ad" http://localhost:${port}/api/v1/reports 2>/dev/null | grep -o '"homeserver":"[^"]*"' | sort)"
+assert_eq "" "$(ls ${dir})"
+sleep 2.5
+assert_eq "memory2.turtles|memory3.turtles" "$(sqlite3 ${dump} 'SELECT group_concat(homeserver, "|") FROM (SELECT homeserver FROM stats ORDER BY id)')"
+rm -f ${dump}
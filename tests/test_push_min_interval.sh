@@ -0,0 +1,39 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "min_report_intervals": {
+    "trusted.turtles": "0s",
+    "brief.turtles": "2s"
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf} --min-report-interval=1h"
+. $(dirname $0)/setup.sh
+log "Testing the minimum interval between reports"
+
+push() {
+  curl -k -D ${dir}/headers -o ${dir}/body -w '%{http_code}' -d '{"homeserver": "'$1'", "total_users": 1}' http://localhost:${port}/push${2:-} 2>/dev/null
+}
+
+assert_eq "200" "$(push hourly.turtles)"
+assert_eq "429" "$(push hourly.turtles)"
+assert_eq '"RATE_LIMITED"' "$(jq .errcode ${dir}/body)"
+retry=$(jq .retry_after ${dir}/body)
+assert_eq "1" "$(( retry > 3590 && retry <= 3600 ))"
+assert_eq "Retry-After: ${retry}" "$(grep -i '^Retry-After' ${dir}/headers | tr -d '\r')"
+# Dry runs don't count, and aren't refused.
+assert_eq "200" "$(push hourly.turtles /dry-run)"
+assert_eq "200" "$(push other.turtles)"
+
+assert_eq "200" "$(push trusted.turtles)"
+assert_eq "200" "$(push trusted.turtles)"
+assert_eq "200" "$(push brief.turtles)"
+assert_eq "429" "$(push brief.turtles)"
+sleep 2.1
+assert_eq "200" "$(push brief.turtles)"
+
+assert_eq "hourly.turtles|1" "$(sqlite3 ${dir}/stats.db "SELECT homeserver, COUNT(*) FROM stats WHERE homeserver = 'hourly.turtles'")"
+assert_eq "panopticon_early_reports_total 2" "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep early_reports)"
+rm -f ${conf}
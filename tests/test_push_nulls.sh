@@ -0,0 +1,15 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing NULLs for fields a report leaves out"
+
+# Every column is inserted, so a field left out is NULL while a zero is kept.
+assert_eq "{}" "$(curl -k -d '{"homeserver": "zero.turtles", "total_users": 0}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "0|integer|null" "$(sqlite3 ${dir}/stats.db 'SELECT total_users, typeof(total_users), typeof(daily_messages) FROM stats WHERE homeserver = "zero.turtles"')"
+
+# Reports with different fields share the statement.
+assert_eq "{}" "$(curl -k -d '{"homeserver": "other.turtles", "daily_messages": 5}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "null|5" "$(sqlite3 ${dir}/stats.db 'SELECT typeof(total_users), daily_messages FROM stats WHERE homeserver = "other.turtles"')"
+
+assert_eq "{}" "$(curl -k -A 'Dendrite/0.13.0' -d '{"homeserver": "dendrite.turtles", "total_users": 0}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "0|null" "$(sqlite3 ${dir}/stats.db 'SELECT total_users, typeof(daily_messages) FROM dendrite_stats WHERE homeserver = "dendrite.turtles"')"
@@ -0,0 +1,11 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--db-driver=sqlite --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing the pure Go sqlite driver"
+
+curl -k -d '{"homeserver": "pure.turtles", "total_users": 3, "daily_messages": 7}' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq "pure.turtles|3|7" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, total_users, daily_messages FROM stats')"
+assert_eq '"total_users":3' "$(curl -k -H 'Authorization: Bearer r3ad' "http://localhost:${port}/api/v1/reports" 2>/dev/null | grep -o '"total_users":3')"
+# The file is an ordinary sqlite database.
+assert_eq "2" "$(sqlite3 ${dir}/stats.db 'PRAGMA auto_vacuum')"
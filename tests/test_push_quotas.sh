@@ -0,0 +1,39 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "quotas": {
+    "partner": {"tokens": ["partner-token"], "homeservers": ["*.partner.turtles"], "reports_per_day": 2},
+    "bulky": {"homeservers": ["bulky.turtles"], "bytes_per_day": 100}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf}"
+. $(dirname $0)/setup.sh
+log "Testing per-tenant quotas"
+
+# A push which isn't stored doesn't count.
+sqlite3 ${dir}/stats.db "INSERT INTO tombstones (homeserver, tombstoned_at) VALUES ('gone.partner.turtles', 0)"
+assert_eq "410" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"homeserver": "gone.partner.turtles"}' http://localhost:${port}/push)"
+assert_eq "" "$(sqlite3 ${dir}/stats.db 'SELECT reports FROM quota_usage WHERE tenant = "partner"')"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "a.partner.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "{}" "$(curl -k -H 'Authorization: Bearer partner-token' -d '{"homeserver": "elsewhere.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+reply=$(curl -k -i -d '{"homeserver": "b.partner.turtles"}' http://localhost:${port}/push 2>/dev/null)
+assert_eq "429" "$(echo "${reply}" | head -1 | grep -o '429')"
+assert_eq '"errcode":"QUOTA_EXCEEDED"' "$(echo "${reply}" | grep -o '"errcode":"[^"]*"')"
+assert_eq "Retry-After" "$(echo "${reply}" | grep -o '^Retry-After')"
+
+# Other tenants, and pushes belonging to none, aren't affected.
+assert_eq "{}" "$(curl -k -d '{"homeserver": "bulky.turtles", "total_users": 1}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "429" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"homeserver": "bulky.turtles", "total_users": 1, "total_room_count": 2, "daily_messages": 3}' http://localhost:${port}/push)"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "free.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "4" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+# Usage is kept in the database, to be shared by every instance.
+assert_eq "partner|2" "$(sqlite3 ${dir}/stats.db 'SELECT tenant, reports FROM quota_usage WHERE tenant = "partner"')"
+
+metrics=$(curl -k http://localhost:${port}/metrics 2>/dev/null)
+assert_eq 'panopticon_quota_reports_total{tenant="partner"} 2' "$(echo "${metrics}" | grep '^panopticon_quota_reports_total{tenant="partner"}')"
+assert_eq 'panopticon_quota_exceeded_total{tenant="partner",quota="reports_per_day"} 1' "$(echo "${metrics}" | grep '^panopticon_quota_exceeded_total{tenant="partner"')"
+rm ${conf}
@@ -0,0 +1,16 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--store-raw-reports"
+. $(dirname $0)/setup.sh
+log "Testing raw report storage"
+
+curl -k -d 'not an object' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq "{}" "$(curl -k -d '{"homeserver": "raw.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+
+assert_eq "400|not an object
+200|{\"homeserver\": \"raw.turtles\"}" "$(python3 - ${dir}/stats.db <<'PY'
+import gzip, sqlite3, sys
+for status, body in sqlite3.connect(sys.argv[1]).execute("SELECT status, body_gzip FROM raw_reports ORDER BY id"):
+    print("%d|%s" % (status, gzip.decompress(body).decode()))
+PY
+)"
@@ -0,0 +1,35 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "quotas": {
+    "fleet": {"homeservers": ["*.fleet.turtles"]}
+  },
+  "sampling": {
+    "fleet": 2,
+    "chatty.turtles": 1000000000
+  }
+}
+CONF
+EXTRA_ARGS="--config=${conf}"
+. $(dirname $0)/setup.sh
+log "Testing sampling"
+
+assert_eq '"stored":[],"ignored":[],"sampled_out":true' "$(curl -k -d '{"homeserver": "chatty.turtles"}' "http://localhost:${port}/push?verbose=1" 2>/dev/null | grep -o '"stored.*true')"
+assert_eq "0" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+
+for i in $(seq 200); do
+  curl -k -d "{\"homeserver\": \"hs${i}.fleet.turtles\"}" http://localhost:${port}/push >/dev/null 2>&1
+done
+curl -k -d '{"homeserver": "unsampled.turtles"}' http://localhost:${port}/push >/dev/null 2>&1
+stored=$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM stats WHERE homeserver LIKE '%.fleet.turtles' AND sample_rate = 2")
+if (( stored < 60 || stored > 140 )); then
+  log "Expected about half of 200 reports to be stored, got ${stored}"
+  exit 1
+fi
+assert_eq "${stored}" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM stats WHERE homeserver LIKE '%.fleet.turtles'")"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM stats WHERE homeserver = 'unsampled.turtles' AND sample_rate IS NULL")"
+metrics=$(curl -k http://localhost:${port}/metrics 2>/dev/null)
+assert_eq "panopticon_sampled_reports_total{result=\"stored\"} ${stored}" "$(echo "${metrics}" | grep '^panopticon_sampled_reports_total{result="stored"}')"
+rm ${conf}
@@ -0,0 +1,38 @@
+#!/bin/bash -eu
+
+confdir=$(mktemp -d)
+cat >${confdir}/config.json <<'CONF'
+{
+  "payload_schemas": {
+    "1": {"transforms": [{"from": ["users"], "to": "total_users"}]},
+    "2": {"required": ["homeserver", "total_users"], "strict": true}
+  }
+}
+CONF
+EXTRA_ARGS="--config=${confdir}/config.json"
+. $(dirname $0)/setup.sh
+trap "kill_server; rm -rf ${confdir}" EXIT
+log "Testing payload schema versions"
+
+function push {
+  curl -k -o /dev/null -w '%{http_code}' "$@" http://localhost:${port}/push 2>/dev/null
+}
+
+assert_eq "200" "$(push -d '{"homeserver": "one.turtles", "schema_version": 1, "users": 3}')"
+assert_eq "200" "$(push -H 'Panopticon-Schema-Version: 2' -d '{"homeserver": "two.turtles", "total_users": 4}')"
+assert_eq "200" "$(push -d '{"homeserver": "two.turtles", "schema_version": "2", "total_users": 5}')"
+assert_eq "200" "$(push -d '{"homeserver": "none.turtles", "total_users": 6}')"
+assert_eq "one.turtles|3|1
+two.turtles|4|2
+two.turtles|5|2
+none.turtles|6|" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, total_users, schema_version FROM stats ORDER BY id')"
+
+# Reports are checked against the schema they declare.
+assert_eq "400" "$(push -H 'Panopticon-Schema-Version: 2' -d '{"homeserver": "two.turtles"}')"
+assert_eq "400" "$(push -H 'Panopticon-Schema-Version: 2' -d '{"homeserver": "two.turtles", "total_users": 1, "users": 1}')"
+assert_eq "400" "$(push -H 'Panopticon-Schema-Version: 3' -d '{"homeserver": "three.turtles"}')"
+assert_eq "400" "$(push -H 'Panopticon-Schema-Version: 1' -d '{"homeserver": "one.turtles", "schema_version": 2}')"
+assert_eq "400" "$(push -d '{"homeserver": "one.turtles", "schema_version": {"major": 1}}')"
+assert_eq "4" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+assert_eq "missing required fields: total_users" "$(grep -o 'missing required fields: [a-z_]*' $1)"
+assert_eq "unknown fields: users" "$(grep -o 'unknown fields: [a-z_]*' $1)"
@@ -0,0 +1,48 @@
+#!/bin/bash -eu
+
+legacydir=$(mktemp -d)
+python3 - ${legacydir}/pushes <<'PY' &
+import http.server, sys
+class H(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        body = self.rfile.read(int(self.headers["Content-Length"]))
+        with open(sys.argv[1], "ab") as f:
+            f.write(body + b"\n")
+        self.send_response(500 if b"broken" in body else 200)
+        self.end_headers()
+        self.wfile.write(b"{}")
+    def do_GET(self):
+        self.send_response(200)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(("127.0.0.1", 9018), H).serve_forever()
+PY
+legacy=$!
+until curl http://127.0.0.1:9018/ >/dev/null 2>&1; do
+  sleep 0.1
+done
+
+EXTRA_ARGS="--shadow-url=http://127.0.0.1:9018/push --shadow-compare-bodies --admin-token=s3cret"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${legacy}; rm -rf ${legacydir}" EXIT
+log "Testing shadow pushes"
+
+auth="Authorization: Bearer s3cret"
+function divergences {
+  curl -k -H "${auth}" http://localhost:${port}/admin/shadow 2>/dev/null | python3 -c 'import json,sys; print(" ".join(sorted("%s:%d:%d" % (d["homeserver"], d["status"], d["legacy_status"]) for d in json.load(sys.stdin))))'
+}
+
+# Pushes are stored and forwarded; only differing responses are recorded.
+curl -k -d '{"homeserver": "same.turtles", "total_users": 1}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "broken.turtles", "total_users": 2}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "verbose.turtles", "total_users": 3}' "http://localhost:${port}/push?verbose=1" >/dev/null 2>&1
+curl -k -d '{"homeserver": "dry.turtles", "total_users": 4}' http://localhost:${port}/push/dry-run >/dev/null 2>&1
+sleep 0.5
+assert_eq "3" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
+assert_eq "3" "$(wc -l <${legacydir}/pushes)"
+assert_eq "broken.turtles:200:500 verbose.turtles:200:200" "$(divergences)"
+assert_eq "1" "$(curl -k -s http://localhost:${port}/metrics | grep -c 'panopticon_shadow_pushes_total{result="match"} 1')"
+
+assert_eq "{}" "$(curl -k -X DELETE -H "${auth}" http://localhost:${port}/admin/shadow 2>/dev/null)"
+assert_eq "" "$(divergences)"
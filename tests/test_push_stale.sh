@@ -0,0 +1,12 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--stale-reports=reject --max-report-age=24h"
+. $(dirname $0)/setup.sh
+log "Testing /push rejects stale reports"
+
+now=$(date +%s)
+assert_eq "{}" "$(curl -k -d "{\"homeserver\": \"fresh.turtles\", \"timestamp\": ${now}}" http://localhost:${port}/push 2>/dev/null)"
+assert_eq '"errcode":"VALIDATION_FAILED"' "$(curl -k -d "{\"homeserver\": \"fresh.turtles\", \"timestamp\": ${now}}" http://localhost:${port}/push 2>/dev/null | grep -o '"errcode":"[^"]*"')"
+assert_eq '"errcode":"VALIDATION_FAILED"' "$(curl -k -d "{\"homeserver\": \"old.turtles\", \"timestamp\": $((now - 100000))}" http://localhost:${port}/push 2>/dev/null | grep -o '"errcode":"[^"]*"')"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "untimed.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats WHERE homeserver == "fresh.turtles"')"
@@ -0,0 +1,21 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--stale-reports=flag"
+. $(dirname $0)/setup.sh
+log "Testing /push flags stale reports"
+
+now=$(date +%s)
+assert_eq "{}" "$(curl -k -d "{\"homeserver\": \"fresh.turtles\", \"timestamp\": ${now}}" http://localhost:${port}/push 2>/dev/null)"
+assert_eq "{}" "$(curl -k -d "{\"homeserver\": \"fresh.turtles\", \"timestamp\": ${now}}" http://localhost:${port}/push 2>/dev/null)"
+assert_eq "0
+1" "$(sqlite3 ${dir}/stats.db 'SELECT COALESCE(stale, 0) FROM stats ORDER BY id')"
+
+# Reports already flagged stale don't hold back later ones.
+sqlite3 ${dir}/stats.db "UPDATE stats SET remote_timestamp = $((now + 1000)) WHERE stale = 1"
+assert_eq "{}" "$(curl -k -d "{\"homeserver\": \"fresh.turtles\", \"timestamp\": $((now + 10))}" http://localhost:${port}/push 2>/dev/null)"
+assert_eq "0" "$(sqlite3 ${dir}/stats.db 'SELECT COALESCE(stale, 0) FROM stats ORDER BY id DESC LIMIT 1')"
+
+# The last report of a homeserver is looked up through an index.
+assert_eq "stats_homeserver_local_timestamp
+stats_homeserver_remote_timestamp" "$(sqlite3 ${dir}/stats.db "SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'stats' ORDER BY name")"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db "EXPLAIN QUERY PLAN SELECT MAX(remote_timestamp) FROM stats WHERE homeserver = 'fresh.turtles' AND (stale IS NULL OR stale = 0)" | grep -c 'USING INDEX stats_homeserver_remote_timestamp')"
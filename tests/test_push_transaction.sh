@@ -0,0 +1,31 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "string_metrics": {"deployment": {}}
+}
+CONF
+EXTRA_ARGS="--config=${conf}"
+. $(dirname $0)/setup.sh
+log "Testing that a push's writes are one transaction"
+
+push() {
+  curl -k -s -o /dev/null -w '%{http_code}' -d "$1" http://localhost:${port}/push
+}
+
+assert_eq "200" "$(push '{"homeserver": "one.turtles", "total_users": 1, "deployment": "docker"}')"
+
+# Make writing string metrics fail, after the stats row has been inserted.
+sqlite3 ${dir}/stats.db "CREATE TRIGGER fail_string_metrics BEFORE INSERT ON string_metrics BEGIN SELECT RAISE(ABORT, 'disk on fire'); END"
+
+assert_eq "500" "$(push '{"homeserver": "one.turtles", "total_users": 2, "deployment": "docker"}')"
+assert_eq "500" "$(push '{"homeserver": "two.turtles", "total_users": 3, "deployment": "helm"}')"
+assert_eq "one.turtles|1" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, total_users FROM stats')"
+assert_eq "one.turtles|1" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, report_count FROM homeservers')"
+assert_eq "1" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM string_metrics')"
+
+# Pushes without string metrics are unaffected.
+assert_eq "200" "$(push '{"homeserver": "two.turtles", "total_users": 4}')"
+assert_eq "two.turtles|1" "$(sqlite3 ${dir}/stats.db "SELECT homeserver, report_count FROM homeservers WHERE homeserver = 'two.turtles'")"
+assert_eq "2" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats')"
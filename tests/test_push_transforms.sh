@@ -0,0 +1,34 @@
+#!/bin/bash -eu
+
+confdir=$(mktemp -d)
+cat >${confdir}/config.json <<'CONF'
+{
+  "transforms": [
+    {"from": ["daily_messages_sent", "sent_messages"], "to": "daily_sent_messages"},
+    {"from": ["memory_rss_bytes"], "to": "memory_rss", "scale": 0.0009765625},
+    {"from": ["users", "total_users"], "to": "total_users"}
+  ]
+}
+CONF
+EXTRA_ARGS="--config=${confdir}/config.json"
+. $(dirname $0)/setup.sh
+trap "kill_server; rm -rf ${confdir}" EXIT
+log "Testing transforms"
+
+function push {
+  curl -k -d "$1" "http://localhost:${port}/push?verbose=1" 2>/dev/null | grep -o '"ignored":\[[^]]*\]'
+}
+
+# Renamed, coalesced and scaled fields are stored, and the old names aren't
+# reported as ignored.
+assert_eq '"ignored":[]' "$(push '{"homeserver": "old.turtles", "daily_messages_sent": 5, "sent_messages": 6, "memory_rss_bytes": 2048, "users": 7, "total_users": 8}')"
+# A value under the new name wins, unless the rule lists it.
+assert_eq '"ignored":[]' "$(push '{"homeserver": "new.turtles", "daily_sent_messages": 9, "daily_messages_sent": 10, "memory_rss": 3, "total_users": 11}')"
+assert_eq "old.turtles|5|2|7
+new.turtles|9|3|11" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, daily_sent_messages, memory_rss, total_users FROM stats ORDER BY id')"
+
+assert_eq "400" "$(curl -k -o /dev/null -w '%{http_code}' -d '{"homeserver": "bad.turtles", "memory_rss_bytes": "lots"}' http://localhost:${port}/push 2>/dev/null)"
+
+# Rules are checked on startup.
+echo '{"transforms": [{"from": ["x"], "to": "no_such_field"}]}' >${confdir}/bad.json
+assert_eq "Could not load config: transforms: 0: no_such_field is not a report field" "$(./panopticon --config=${confdir}/bad.json 2>&1 | grep -o 'Could not load config.*')"
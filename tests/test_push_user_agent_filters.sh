@@ -0,0 +1,42 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "user_agent_filters": [
+    {"pattern": "^python-requests/"},
+    {"name": "go", "pattern": "go-http-client", "action": "flag"}
+  ]
+}
+CONF
+EXTRA_ARGS="--config=${conf} --known-bots=drop"
+. $(dirname $0)/setup.sh
+log "Testing user agent filters"
+
+push() {
+  curl -k -H "User-Agent: $1" -d '{"homeserver": "'$2'", "total_users": 1}' "http://localhost:${port}/push${3:-}" 2>/dev/null
+}
+
+# Dropped pushes succeed, so that scripts don't retry, but aren't stored.
+assert_eq "{}" "$(push python-requests/2.31.0 script.turtles)"
+assert_eq "{}" "$(push 'Mozilla/5.0 (compatible; Googlebot/2.1; +http://www.google.com/bot.html)' crawled.turtles)"
+assert_eq '"filtered"' "$(push Go-http-client/1.1 flagged.turtles '?verbose=1' | jq -c '.stored[] | select(. == "user_agent_filter") | "filtered"')"
+push Synapse/1.100.0 real.turtles >/dev/null
+
+assert_eq "flagged.turtles|go
+real.turtles|" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, user_agent_filter FROM stats ORDER BY homeserver')"
+assert_eq 'panopticon_filtered_pushes_total{filter="^python-requests/",action="drop"} 1
+panopticon_filtered_pushes_total{filter="go",action="flag"} 1
+panopticon_filtered_pushes_total{filter="known_bots",action="drop"} 1' "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep filtered_pushes | sort)"
+
+# Bad filters are refused at startup.
+echo '{"user_agent_filters": [{"pattern": "(", "action": "drop"}]}' >${conf}
+if ./panopticon --port=9003 --db=${dir}/other.db --config=${conf} 2>/dev/null; then
+  log "panopticon started with a bad user agent filter"
+  exit 1
+fi
+if ./panopticon --port=9003 --db=${dir}/other.db --known-bots=ban 2>/dev/null; then
+  log "panopticon started with a bad --known-bots"
+  exit 1
+fi
+rm -f ${conf}
@@ -0,0 +1,7 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing /push?verbose=1"
+
+assert_eq '{"id":1,"table":"stats","stored":["homeserver","local_timestamp","remote_addr","total_users","remote_ip","remote_ip_family","user_agent"],"ignored":["not_a_stat"]}' "$(curl -k -4 -A "curl" -d '{"homeserver": "verbose.turtles", "total_users": 3, "not_a_stat": 1}' "http://localhost:${port}/push?verbose=1" 2>/dev/null)"
+assert_eq "{}" "$(curl -k -d '{"homeserver": "quiet.turtles"}' http://localhost:${port}/push 2>/dev/null)"
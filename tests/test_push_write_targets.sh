@@ -0,0 +1,22 @@
+#!/bin/bash -eu
+
+targetdir=$(mktemp -d)
+cat >${targetdir}/config.json <<CONF
+{
+  "write_targets": [
+    {"name": "mirror", "driver": "sqlite3", "dsn": "${targetdir}/mirror.db"},
+    {"name": "broken", "driver": "mysql", "dsn": "nobody@tcp(127.0.0.1:1)/nothing"}
+  ]
+}
+CONF
+EXTRA_ARGS="--config=${targetdir}/config.json"
+. $(dirname $0)/setup.sh
+log "Testing fan-out to write targets"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "mirrored.turtles", "total_users": 7}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "7" "$(sqlite3 ${dir}/stats.db 'SELECT total_users FROM stats WHERE homeserver == "mirrored.turtles"')"
+assert_eq "7" "$(sqlite3 ${targetdir}/mirror.db 'SELECT total_users FROM stats WHERE homeserver == "mirrored.turtles"')"
+assert_eq 'panopticon_target_writes_total{target="broken",result="error"} 1
+panopticon_target_writes_total{target="mirror",result="ok"} 1
+panopticon_target_writes_total{target="primary",result="ok"} 1' "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep target_writes)"
+rm -rf ${targetdir}
@@ -0,0 +1,39 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing query-archive"
+
+curl -k -d '{"homeserver": "one.turtles", "total_users": 10, "cache_factor": 0.5}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "two.turtles", "total_users": 4}' http://localhost:${port}/push >/dev/null 2>&1
+curl -k -d '{"homeserver": "one.turtles", "total_users": 12}' http://localhost:${port}/push >/dev/null 2>&1
+
+# Archive the reports as NDJSON, the last one gzipped and with an extra field.
+archives=$(mktemp -d)
+sqlite3 -json ${dir}/stats.db 'SELECT homeserver, local_timestamp, total_users, cache_factor FROM stats WHERE id < 3' | python3 -c '
+import json, sys
+for r in json.load(sys.stdin):
+    print(json.dumps({k: v for k, v in r.items() if v is not None}))' >${archives}/2023.ndjson
+sqlite3 -json ${dir}/stats.db 'SELECT homeserver, local_timestamp, total_users, "3.11" AS python_version FROM stats WHERE id = 3' | python3 -c '
+import json, sys
+for r in json.load(sys.stdin):
+    print(json.dumps(r))' | gzip >${archives}/2024.jsonl.gz
+
+query="SELECT homeserver, COUNT(*) AS reports, MAX(total_users) AS users, MAX(cache_factor) AS cache_factor, MAX(python_version) AS python_version FROM reports GROUP BY homeserver ORDER BY homeserver"
+assert_eq "homeserver,reports,users,cache_factor,python_version
+one.turtles,2,12,0.5,3.11
+two.turtles,1,4,," "$(./panopticon query-archive -query "${query}" "${archives}/*" | tr -d '\r')"
+assert_eq '[{"n":3}]' "$(./panopticon query-archive -format=json -table=stats -query 'SELECT COUNT(*) AS n FROM stats' ${archives}/2023.ndjson ${archives}/2024.jsonl.gz | tr -d ' \n')"
+
+# Field names are columns as they are, dots and quotes included.
+echo '{"homeserver": "three.turtles", "memory.rss": 5, "say \"hi\"": 1}' >${archives}/fields.ndjson
+assert_eq '[{"homeserver":"three.turtles","memory.rss":5,"say\"hi\"":1}]' "$(./panopticon query-archive -format=json -query 'SELECT homeserver, "memory.rss", "say ""hi""" FROM reports' ${archives}/fields.ndjson | tr -d ' \n')"
+rm ${archives}/fields.ndjson
+
+# Errors are reported, with a non-zero status.
+status=0
+./panopticon query-archive -query 'SELECT nonsense FROM reports' ${archives}/2023.ndjson 2>/dev/null || status=$?
+assert_eq "1" "${status}"
+assert_eq "no archives match ${archives}/*.parquet" "$(./panopticon query-archive -query 'SELECT 1' "${archives}/*.parquet" 2>&1)"
+touch ${archives}/old.parquet
+assert_eq "Error reading archives: Parquet archives can only be read by builds with -tags duckdb" "$(./panopticon query-archive -query 'SELECT 1' ${archives}/old.parquet 2>&1)"
+rm -r ${archives}
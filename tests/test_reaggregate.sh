@@ -0,0 +1,47 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing re-aggregating histograms"
+
+day=$(( $(date +%s) / 86400 * 86400 - 3 * 86400 ))
+date_of() {
+  date -u -d @$1 +%F
+}
+sqlite3 ${dir}/stats.db "INSERT INTO stats(homeserver, local_timestamp, total_users) VALUES
+  ('a.turtles', ${day} + 100, 5),
+  ('b.turtles', ${day} + 100, 50),
+  ('a.turtles', ${day} + 86400 + 100, 500)"
+sqlite3 ${dir}/stats.db "INSERT INTO metric_histograms(metric, day, bucket, le, homeservers) VALUES
+  ('total_users', ${day} - 86400, 0, 10, 7),
+  ('total_users', ${day}, 0, 100, 1)"
+
+cat >${dir}/config.json <<CONF
+{"histograms": {"total_users": [10, 100]}}
+CONF
+histograms="SELECT day - ${day}, bucket, le, homeservers FROM metric_histograms ORDER BY day, bucket"
+expected="-86400|0|10.0|7
+0|0|10.0|1
+0|1|100.0|1
+0|2||0
+86400|0|10.0|0
+86400|1|100.0|0
+86400|2||1"
+
+out=$(./panopticon reaggregate --db=${dir}/stats.db --config=${dir}/config.json --from=$(date_of $((day - 86400))) --to=$(date_of $((day + 86400))))
+assert_eq "total_users: rebuilt 2 days, skipped 1 days without reports" "${out}"
+# The stale histogram is replaced, and the day whose reports are gone is kept.
+assert_eq "${expected}" "$(sqlite3 ${dir}/stats.db "${histograms}")"
+
+# Running it again changes nothing.
+./panopticon reaggregate --db=${dir}/stats.db --config=${dir}/config.json --from=$(date_of ${day}) >/dev/null
+assert_eq "${expected}" "$(sqlite3 ${dir}/stats.db "${histograms}")"
+
+# Today isn't over, so can't be rebuilt.
+if ./panopticon reaggregate --db=${dir}/stats.db --config=${dir}/config.json --from=$(date -u +%F) --to=$(date -u +%F) 2>/dev/null; then
+  log "reaggregate rebuilt today"
+  exit 1
+fi
+if ./panopticon reaggregate --db=${dir}/stats.db --config=${dir}/config.json --from=$(date_of ${day}) --metric=daily_messages 2>/dev/null; then
+  log "reaggregate rebuilt an unconfigured histogram"
+  exit 1
+fi
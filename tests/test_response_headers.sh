@@ -0,0 +1,37 @@
+#!/bin/bash -eu
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{
+  "response_headers": [
+    {"paths": ["/api/v1/active-homeservers"], "headers": {"Cache-Control": "public, max-age=60"}},
+    {"headers": {"X-Powered-By": "turtles", "Referrer-Policy": ""}}
+  ]
+}
+CONF
+EXTRA_ARGS="--config=${conf} --read-token=r3ad --trusted-proxies=127.0.0.1,::1"
+. $(dirname $0)/setup.sh
+trap "kill_server; rm ${conf}" EXIT
+log "Testing response headers"
+
+function header {
+  curl -k -s -o /dev/null -D - -H "Authorization: Bearer r3ad" "${@:3}" "http://localhost:${port}$1" | tr -d '\r' | grep -i "^$2:" | cut -d' ' -f2- || true
+}
+
+assert_eq "nosniff" "$(header /push x-content-type-options -d '{"homeserver": "one.turtles"}')"
+assert_eq "DENY" "$(header / x-frame-options)"
+assert_eq "default-src 'self'; frame-ancestors 'none'" "$(header / content-security-policy)"
+assert_eq "no-store" "$(header /api/v1/reports cache-control)"
+assert_eq "" "$(header /metrics cache-control)"
+
+# Configured headers override the security headers.
+assert_eq "public, max-age=60" "$(header /api/v1/active-homeservers cache-control)"
+assert_eq "turtles" "$(header /metrics x-powered-by)"
+assert_eq "" "$(header /metrics referrer-policy)"
+
+# Strict-Transport-Security is only sent over HTTPS, which a trusted proxy
+# reports.
+assert_eq "" "$(header /metrics strict-transport-security)"
+assert_eq "max-age=31536000" "$(header /metrics strict-transport-security -H 'X-Forwarded-Proto: https')"
+assert_eq "max-age=31536000" "$(header /metrics strict-transport-security -H 'Forwarded: for=192.0.2.1;proto=https')"
+assert_eq "" "$(header /metrics strict-transport-security -H 'X-Forwarded-Proto: http')"
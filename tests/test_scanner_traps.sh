@@ -0,0 +1,18 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--scanner-ban=1h --trusted-proxies=127.0.0.1,::1"
+. $(dirname $0)/setup.sh
+log "Testing scanner traps"
+
+scanner="X-Real-IP: 192.0.2.66"
+assert_eq "404" "$(curl -k -o /dev/null -w '%{http_code}' -H "${scanner}" http://localhost:${port}/wp-login.php 2>/dev/null)"
+assert_eq '{"error_message":"banned","errcode":"UNAUTHORIZED"}' "$(curl -k -H "${scanner}" -d '{"homeserver": "sneaky.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "0" "$(sqlite3 ${dir}/stats.db 'SELECT COUNT(*) FROM stats WHERE homeserver = "sneaky.turtles"')"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "honest.turtles"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "404" "$(curl -k -o /dev/null -w '%{http_code}' -H 'X-Real-IP: 192.0.2.67' -A 'Mozilla/5.0 zgrab/0.x' http://localhost:${port}/ 2>/dev/null)"
+assert_eq 'panopticon_banned_clients 2
+panopticon_scanner_requests_total{signature="banned"} 1
+panopticon_scanner_requests_total{signature="path"} 1
+panopticon_scanner_requests_total{signature="user_agent"} 1' "$(curl -k http://localhost:${port}/metrics 2>/dev/null | grep -E 'scanner|banned')"
+assert_eq "" "$(grep -i 'error' $1 || true)"
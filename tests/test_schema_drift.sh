@@ -0,0 +1,15 @@
+#!/bin/bash -eu
+
+olddir=$(mktemp -d)
+sqlite3 ${olddir}/old.db 'CREATE TABLE stats(id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, homeserver VARCHAR(256), local_timestamp BIGINT, remote_addr TEXT, total_users BIGINT, legacy_column TEXT)'
+EXTRA_ARGS="--db=${olddir}/old.db --auto-migrate"
+. $(dirname $0)/setup.sh
+log "Testing schema drift self-healing"
+
+assert_eq "{}" "$(curl -k -d '{"homeserver": "migrated.turtles", "total_users": 5, "log_level": "INFO"}' http://localhost:${port}/push 2>/dev/null)"
+assert_eq "5|INFO" "$(sqlite3 ${olddir}/old.db 'SELECT total_users, log_level FROM stats WHERE homeserver == "migrated.turtles"')"
+grep -q "added column stale INT to stats" $1
+grep -q "stats has unexpected column legacy_column" $1
+# The indexes on columns the old table lacked are created once they're added.
+assert_eq "stats_homeserver_local_timestamp stats_homeserver_remote_timestamp" "$(sqlite3 ${olddir}/old.db "SELECT name FROM sqlite_master WHERE type = 'index' AND tbl_name = 'stats' AND name LIKE 'stats_homeserver_%' ORDER BY name" | xargs)"
+rm -rf ${olddir}
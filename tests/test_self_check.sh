@@ -0,0 +1,22 @@
+#!/bin/bash -eu
+
+. $(dirname $0)/setup.sh
+log "Testing the startup self-check"
+
+grep -q "Self-check database writes: ok" $1
+assert_eq "1" "$(curl -k -s http://localhost:${port}/metrics | grep -c 'panopticon_self_check_ok{check="schema"} 1')"
+
+# -self-check-only exits with the result instead of serving.
+checkdir=$(mktemp -d)
+trap "kill_server; rm -rf ${checkdir}" EXIT
+./panopticon --db=${checkdir}/new.db --self-check-only 2>${checkdir}/log
+grep -q "Self-check schema: ok" ${checkdir}/log
+
+# Missing columns and unwritable paths keep it from starting, with hints.
+sqlite3 ${checkdir}/old.db 'CREATE TABLE stats(id INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT, homeserver VARCHAR(256), local_timestamp BIGINT)'
+assert_eq "1" "$(./panopticon --db=${checkdir}/old.db --self-check-only 2>${checkdir}/log || echo $?)"
+grep -q "Self-check schema: FAILED: [0-9]* columns are missing (run with -auto-migrate" ${checkdir}/log
+assert_eq "1" "$(./panopticon --db-driver=memory --memory-dump=${checkdir}/missing/dump.db --self-check-only 2>${checkdir}/log || echo $?)"
+grep -q "Self-check memory dump directory: FAILED" ${checkdir}/log
+./panopticon --port=9019 --db=${checkdir}/old.db 2>${checkdir}/log || true
+grep -q "Refusing to start; fix the failed checks, or run with -self-check=false" ${checkdir}/log
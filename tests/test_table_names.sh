@@ -0,0 +1,20 @@
+#!/bin/bash -eu
+
+EXTRA_ARGS="--stats-table=synapse_v2 --dendrite-stats-table=dendrite_v2 --read-token=r3ad"
+. $(dirname $0)/setup.sh
+log "Testing configurable table names"
+
+assert_eq '"table":"stats"' "$(curl -k -d '{"homeserver": "renamed.turtles", "total_users": 3}' "http://localhost:${port}/push?verbose=1" 2>/dev/null | grep -o '"table":"[^"]*"')"
+curl -k -H "User-Agent: Dendrite/0.13.0" -d '{"homeserver": "renamed.turtles", "total_users": 4}' http://localhost:${port}/push >/dev/null 2>&1
+assert_eq "renamed.turtles|3" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, total_users FROM synapse_v2')"
+assert_eq "renamed.turtles|4" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, total_users FROM dendrite_v2')"
+assert_eq "" "$(sqlite3 ${dir}/stats.db "SELECT name FROM sqlite_master WHERE name IN ('stats', 'dendrite_stats')")"
+
+# The read API still calls them stats and dendrite_stats.
+assert_eq '"total_users":4' "$(curl -k -H 'Authorization: Bearer r3ad' "http://localhost:${port}/api/v1/reports?table=dendrite_stats" 2>/dev/null | grep -o '"total_users":[0-9]*')"
+assert_eq "2" "$(sqlite3 ${dir}/stats.db "SELECT report_count FROM homeservers WHERE homeserver = 'renamed.turtles'")"
+
+assert_eq "bad table name \"stats;\"" "$(./panopticon --stats-table='stats;' 2>&1 | sed 's/^.* bad/bad/')"
+assert_eq "bad table name \"Stats\"" "$(./panopticon --stats-table=Stats 2>&1 | sed 's/^.* bad/bad/')"
+assert_eq "bad schema name \"Collector\"" "$(./panopticon --db-driver=postgres --db-schema=Collector 2>&1 | sed 's/^.* bad/bad/')"
+assert_eq "-db-schema needs a database with schemas, not sqlite3" "$(./panopticon --db-schema=collector 2>&1 | sed 's/^[0-9/]* [0-9:]* //')"
@@ -0,0 +1,53 @@
+#!/bin/bash -eu
+
+legacydir=$(mktemp -d)
+python3 - ${legacydir}/headers <<'PY' &
+import http.server, sys
+class H(http.server.BaseHTTPRequestHandler):
+    def do_POST(self):
+        self.rfile.read(int(self.headers["Content-Length"]))
+        with open(sys.argv[1], "a") as f:
+            f.write("%s %s\n" % (self.headers.get("traceparent"), self.headers.get("tracestate")))
+        self.send_response(200)
+        self.end_headers()
+        self.wfile.write(b"{}")
+    def do_GET(self):
+        self.send_response(200)
+        self.end_headers()
+    def log_message(self, *args):
+        pass
+http.server.HTTPServer(("127.0.0.1", 9019), H).serve_forever()
+PY
+legacy=$!
+until curl http://127.0.0.1:9019/ >/dev/null 2>&1; do
+  sleep 0.1
+done
+
+conf=$(mktemp)
+cat >${conf} <<'CONF'
+{"endpoint_groups": {"push": {"log_requests": true}}}
+CONF
+EXTRA_ARGS="--config=${conf} --shadow-url=http://127.0.0.1:9019/push"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${legacy}; rm -rf ${legacydir} ${conf}" EXIT
+log "Testing trace context propagation"
+
+trace=4bf92f3577b34da6a3ce929d0e0e4736
+headers=$(mktemp)
+curl -k -s -o /dev/null -D ${headers} -H "traceparent: 00-${trace}-00f067aa0ba902b7-01" -H "tracestate: vendor=abc" \
+  -d '{"homeserver": "traced.turtles", "total_users": 1}' http://localhost:${port}/push
+span=$(grep -i '^traceresponse:' ${headers} | tr -d '\r' | sed -n "s/^[Tt]raceresponse: 00-${trace}-\([0-9a-f]\{16\}\)-01$/\1/p")
+assert_eq "16" "${#span}"
+
+# Forwarded pushes carry on the trace, with panopticon's span as parent.
+sleep 0.5
+assert_eq "00-${trace}-${span}-01 vendor=abc" "$(cat ${legacydir}/headers)"
+assert_eq "1" "$(grep -c "push: .* POST /push 200 .* trace=${trace} span=${span}" $1)"
+
+# Invalid trace contexts are ignored.
+for tp in "00-${trace}-0000000000000000-01" "00-00000000000000000000000000000000-00f067aa0ba902b7-01" "ff-${trace}-00f067aa0ba902b7-01" "00-${trace}-00f067aa0ba902b7-01-extra" "garbage"; do
+  assert_eq "" "$(curl -k -s -o /dev/null -D - -H "traceparent: ${tp}" -d '{"homeserver": "untraced.turtles"}' http://localhost:${port}/push | grep -i '^traceresponse' || true)"
+done
+# Later versions may append fields.
+assert_eq "1" "$(curl -k -s -o /dev/null -D - -H "traceparent: 01-${trace}-00f067aa0ba902b7-01-extra" -d '{"homeserver": "future.turtles"}' http://localhost:${port}/push | grep -ic "^traceresponse: 00-${trace}-")"
+rm ${headers}
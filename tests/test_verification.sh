@@ -0,0 +1,80 @@
+#!/bin/bash -eu
+
+webdir=$(mktemp -d)
+keydir=$(mktemp -d)
+openssl genpkey -algorithm ed25519 -out ${keydir}/key.pem 2>/dev/null
+pubkey=$(openssl pkey -in ${keydir}/key.pem -pubout -outform DER | tail -c 32 | base64 | tr -d '=')
+mkdir -p ${webdir}/signing.turtles/_matrix/key/v2
+cat >${webdir}/signing.turtles/_matrix/key/v2/server <<KEYS
+{"server_name": "signing.turtles", "verify_keys": {"ed25519:a": {"key": "${pubkey}"}}}
+KEYS
+python3 -m http.server --bind 127.0.0.1 --directory ${webdir} 9017 >/dev/null 2>&1 &
+web=$!
+until curl http://127.0.0.1:9017/ >/dev/null 2>&1; do
+  sleep 0.1
+done
+
+EXTRA_ARGS="--verification-period=1h --verification-url=http://127.0.0.1:9017/%s/nonce --opt-out-key-url=http://127.0.0.1:9017/%s/_matrix/key/v2/server --admin-token=s3cret --max-verification-nonces=2 --server-fetch-rate-limit=0.01 --server-fetch-rate-burst=16"
+. $(dirname $0)/setup.sh
+trap "kill_server; kill ${web}; rm -rf ${webdir} ${keydir}" EXIT
+log "Testing verification"
+
+function challenge {
+  curl -k -d "{\"server_name\": \"$1\"}" http://localhost:${port}/verify/challenge 2>/dev/null | python3 -c 'import json,sys; print(json.load(sys.stdin)["nonce"])'
+}
+function verify {
+  curl -k -s -o /dev/null -w '%{http_code}' -d "$1" http://localhost:${port}/verify
+}
+function push {
+  curl -k -d "{\"homeserver\": \"$1\", \"total_users\": 3}" http://localhost:${port}/push >/dev/null 2>&1
+}
+
+# Reports are unverified until the homeserver proves control of its domain.
+push served.turtles
+nonce=$(challenge served.turtles)
+assert_eq "403" "$(verify "{\"server_name\": \"served.turtles\", \"nonce\": \"${nonce}\"}")"
+mkdir ${webdir}/served.turtles
+echo ${nonce} >${webdir}/served.turtles/nonce
+assert_eq "403" "$(verify "{\"server_name\": \"other.turtles\", \"nonce\": \"${nonce}\"}")"
+assert_eq "403" "$(verify '{"server_name": "served.turtles", "nonce": "0000"}')"
+assert_eq "400" "$(verify '{')"
+assert_eq "200" "$(verify "{\"server_name\": \"served.turtles\", \"nonce\": \"${nonce}\"}")"
+push served.turtles
+push other.turtles
+assert_eq "served.turtles|0
+served.turtles|1
+other.turtles|0" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, verified FROM stats ORDER BY id')"
+assert_eq "served.turtles|well_known" "$(sqlite3 ${dir}/stats.db 'SELECT homeserver, method FROM verified_homeservers')"
+
+# Nonces can only be used once.
+assert_eq "403" "$(verify "{\"server_name\": \"served.turtles\", \"nonce\": \"${nonce}\"}")"
+
+# Homeservers can also sign the nonce with one of their keys.
+nonce=$(challenge signing.turtles)
+message="{\"nonce\":\"${nonce}\",\"server_name\":\"signing.turtles\"}"
+printf '%s' "${message}" >${keydir}/message
+sig=$(openssl pkeyutl -sign -inkey ${keydir}/key.pem -rawin -in ${keydir}/message | base64 | tr -d '=\n')
+assert_eq "403" "$(verify "{\"nonce\": \"${nonce}\", \"server_name\": \"signing.turtles\", \"signatures\": {\"signing.turtles\": {\"ed25519:a\": \"AAAA${sig:4}\"}}}")"
+assert_eq "200" "$(verify "{\"nonce\": \"${nonce}\", \"server_name\": \"signing.turtles\", \"signatures\": {\"signing.turtles\": {\"ed25519:a\": \"${sig}\"}}}")"
+push signing.turtles
+assert_eq "1" "$(sqlite3 ${dir}/stats.db "SELECT verified FROM stats WHERE homeserver = 'signing.turtles'")"
+assert_eq "signing.turtles|signature" "$(sqlite3 ${dir}/stats.db "SELECT homeserver, method FROM verified_homeservers WHERE homeserver = 'signing.turtles'")"
+
+# Server names which could make panopticon fetch from elsewhere are refused.
+assert_eq "400" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"server_name": "127.0.0.1"}' http://localhost:${port}/verify/challenge)"
+assert_eq "400" "$(verify '{"server_name": "signing.turtles/x", "nonce": "0000"}')"
+
+# A homeserver can only have a few nonces outstanding, and expired ones are
+# pruned.
+challenge capped.turtles >/dev/null
+challenge capped.turtles >/dev/null
+assert_eq "429" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"server_name": "capped.turtles"}' http://localhost:${port}/verify/challenge)"
+sqlite3 ${dir}/stats.db "UPDATE verification_nonces SET expires_at = 1 WHERE homeserver = 'capped.turtles'"
+curl -k -X POST -H "Authorization: Bearer s3cret" http://localhost:${port}/admin/jobs/prune_verification_nonces >/dev/null 2>&1
+sleep 0.5
+assert_eq "0" "$(sqlite3 ${dir}/stats.db "SELECT COUNT(*) FROM verification_nonces WHERE homeserver = 'capped.turtles'")"
+challenge capped.turtles >/dev/null
+
+# Clients making too many requests are refused; the sixteen above used up
+# the burst.
+assert_eq "429" "$(curl -k -s -o /dev/null -w '%{http_code}' -d '{"server_name": "fresh.turtles"}' http://localhost:${port}/verify/challenge)"
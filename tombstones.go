@@ -0,0 +1,120 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Tombstone marks a decommissioned homeserver. Its history is kept, but new
+// reports from it are rejected and it is excluded from aggregates.
+type Tombstone struct {
+	Homeserver   string `json:"homeserver"`
+	Reason       string `json:"reason"`
+	TombstonedAt int64  `json:"tombstoned_at"`
+}
+
+func createTableTombstones(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS tombstones(
+		homeserver VARCHAR(256) NOT NULL PRIMARY KEY,
+		reason TEXT,
+		tombstoned_at BIGINT
+		)`)
+	return err
+}
+
+func isTombstoned(db *sql.DB, homeserver string) (bool, error) {
+	var n int
+	err := db.QueryRow(
+		fmt.Sprintf("SELECT COUNT(*) FROM tombstones WHERE homeserver = %s", dialectFor(db).placeholder(1)),
+		homeserver,
+	).Scan(&n)
+	return n > 0, err
+}
+
+// TombstonesHandler serves /admin/tombstones: GET lists tombstones, POST
+// adds one and DELETE ?homeserver=... removes one.
+type TombstonesHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+}
+
+func (h *TombstonesHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	d := dialectFor(h.DB)
+	switch req.Method {
+	case http.MethodGet:
+		rows, err := h.DB.Query("SELECT homeserver, reason, tombstoned_at FROM tombstones ORDER BY homeserver")
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error listing tombstones")
+			return
+		}
+		defer rows.Close()
+		tombstones := []Tombstone{}
+		for rows.Next() {
+			var t Tombstone
+			var reason sql.NullString
+			if err := rows.Scan(&t.Homeserver, &reason, &t.TombstonedAt); err != nil {
+				logAndReplyError(w, err, 500, "Error listing tombstones")
+				return
+			}
+			t.Reason = reason.String
+			tombstones = append(tombstones, t)
+		}
+		if err := rows.Err(); err != nil {
+			logAndReplyError(w, err, 500, "Error listing tombstones")
+			return
+		}
+		writeJSON(w, tombstones)
+	case http.MethodPost:
+		var t Tombstone
+		if err := json.NewDecoder(req.Body).Decode(&t); err != nil {
+			logAndReplyError(w, err, 400, "Error decoding tombstone")
+			return
+		}
+		if t.Homeserver == "" {
+			logAndReplyError(w, errors.New("missing homeserver"), 400, "Error decoding tombstone")
+			return
+		}
+		t.Homeserver = h.Storage.storedHomeserver(t.Homeserver)
+		t.TombstonedAt = time.Now().UTC().Unix()
+		if _, err := h.DB.Exec("DELETE FROM tombstones WHERE homeserver = "+d.placeholder(1), t.Homeserver); err != nil {
+			logAndReplyError(w, err, 500, "Error saving tombstone")
+			return
+		}
+		_, err := h.DB.Exec(
+			dialectFor(h.DB).insert("tombstones", "homeserver", "reason", "tombstoned_at"),
+			t.Homeserver, t.Reason, t.TombstonedAt,
+		)
+		if err != nil {
+			logAndReplyError(w, err, 500, "Error saving tombstone")
+			return
+		}
+		writeJSON(w, t)
+	case http.MethodDelete:
+		homeserver := h.Storage.storedHomeserver(req.URL.Query().Get("homeserver"))
+		if _, err := h.DB.Exec("DELETE FROM tombstones WHERE homeserver = "+d.placeholder(1), homeserver); err != nil {
+			logAndReplyError(w, err, 500, "Error removing tombstone")
+			return
+		}
+		writeJSON(w, struct{}{})
+	default:
+		methodNotAllowed(w, req, http.MethodGet, http.MethodPost, http.MethodDelete)
+	}
+}
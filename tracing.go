@@ -0,0 +1,129 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// traceContext is the W3C trace context of a request, so that traces
+// started by reporters stay connected through panopticon. panopticon
+// doesn't export spans, but takes part in traces as one hop with its own
+// span id, which its logs and the requests it makes name as their parent.
+type traceContext struct {
+	TraceID  string
+	ParentID string // The caller's span
+	SpanID   string // panopticon's span
+	Flags    string
+	State    string // tracestate, passed on unchanged
+}
+
+type traceKey struct{}
+
+// parseTraceparent parses a traceparent header, returning false if it is
+// invalid, in which case it is ignored. Later versions may append fields,
+// which are ignored too.
+func parseTraceparent(v string) (*traceContext, bool) {
+	v = strings.TrimSpace(v)
+	if len(v) < 55 || (len(v) > 55 && (v[:2] == "00" || v[55] != '-')) {
+		return nil, false
+	}
+	parts := strings.Split(v[:55], "-")
+	if len(parts) != 4 || parts[0] == "ff" {
+		return nil, false
+	}
+	for i, n := range []int{2, 32, 16, 2} {
+		if len(parts[i]) != n || strings.ToLower(parts[i]) != parts[i] {
+			return nil, false
+		}
+		if _, err := hex.DecodeString(parts[i]); err != nil {
+			return nil, false
+		}
+	}
+	if parts[1] == strings.Repeat("0", 32) || parts[2] == strings.Repeat("0", 16) {
+		return nil, false
+	}
+	return &traceContext{TraceID: parts[1], ParentID: parts[2], Flags: parts[3]}, true
+}
+
+func newSpanID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// traceRequests takes the trace context of each request from its
+// traceparent and tracestate headers, and answers with a traceresponse
+// header naming panopticon's span.
+func traceRequests(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		t, ok := parseTraceparent(req.Header.Get("traceparent"))
+		if !ok {
+			h.ServeHTTP(w, req)
+			return
+		}
+		t.SpanID = newSpanID()
+		t.State = strings.Join(req.Header.Values("tracestate"), ",")
+		w.Header().Set("traceresponse", t.header(t.SpanID))
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), traceKey{}, t)))
+	})
+}
+
+// traceOf returns the trace context of a request, or nil.
+func traceOf(ctx context.Context) *traceContext {
+	t, _ := ctx.Value(traceKey{}).(*traceContext)
+	return t
+}
+
+func (t *traceContext) header(spanID string) string {
+	return fmt.Sprintf("00-%s-%s-%s", t.TraceID, spanID, t.Flags)
+}
+
+// propagate sets the trace context headers of a request panopticon makes
+// on behalf of the request with trace context t, with panopticon's span as
+// the parent. It does nothing if t is nil.
+func (t *traceContext) propagate(h http.Header) {
+	if t == nil {
+		return
+	}
+	h.Set("traceparent", t.header(t.SpanID))
+	if t.State != "" {
+		h.Set("tracestate", t.State)
+	}
+}
+
+// traceField returns the field naming the trace of a request in log
+// lines, or "" if it has none.
+func traceField(ctx context.Context) string {
+	if t := traceOf(ctx); t != nil {
+		return fmt.Sprintf(" trace=%s span=%s", t.TraceID, t.SpanID)
+	}
+	return ""
+}
+
+// traceResponseField is traceField for code which only has the response:
+// its traceresponse header names the trace and panopticon's span.
+func traceResponseField(h http.Header) string {
+	parts := strings.Split(h.Get("traceresponse"), "-")
+	if len(parts) != 4 {
+		return ""
+	}
+	return fmt.Sprintf(" trace=%s span=%s", parts[1], parts[2])
+}
@@ -0,0 +1,56 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"database/sql"
+)
+
+// execer is a database or a transaction on one.
+type execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// writeTxOptions returns the options of the transactions which write a push
+// to several tables on a database with the given driver.
+func writeTxOptions(driver string) *sql.TxOptions {
+	switch driver {
+	case "postgres", "mysql":
+		// Every statement writes rows picked by key, so read committed is
+		// enough. Stricter levels would fail or deadlock concurrent first
+		// pushes of a homeserver: MySQL's default repeatable read takes gap
+		// locks for the missing homeservers row.
+		return &sql.TxOptions{Isolation: sql.LevelReadCommitted}
+	}
+	// sqlite's write transactions lock the whole database, so are already
+	// serializable; it and DuckDB have no other levels.
+	return nil
+}
+
+// inWriteTx runs f in a transaction on db, committing it if f succeeds and
+// rolling it back otherwise. f mustn't use db itself: the memory driver has
+// a single connection, which the transaction holds.
+func inWriteTx(db *sql.DB, f func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(context.Background(), writeTxOptions(driverFor(db)))
+	if err != nil {
+		return err
+	}
+	if err := f(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,124 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Transform rewrites a field of incoming reports before they are stored, so
+// that reporters which name or scale a field differently can still be
+// recorded. The first of From which a report has is moved to To,
+// multiplied by Scale if set. A value already at To wins, unless To is
+// itself listed in From, which sets its priority. The other keys in From
+// are dropped.
+type Transform struct {
+	From  []string `json:"from"`
+	To    string   `json:"to"`
+	Scale *float64 `json:"scale"`
+}
+
+func validateTransforms(transforms []*Transform) error {
+	known := map[string]bool{}
+	for _, name := range reportFieldNames() {
+		known[strings.ToLower(name)] = true
+	}
+	for i, t := range transforms {
+		if len(t.From) == 0 {
+			return fmt.Errorf("%d: no from fields", i)
+		}
+		for _, f := range t.From {
+			if f == "" {
+				return fmt.Errorf("%d: empty from field", i)
+			}
+		}
+		if !known[strings.ToLower(t.To)] {
+			return fmt.Errorf("%d: %s is not a report field", i, t.To)
+		}
+		if t.Scale != nil && *t.Scale == 0 {
+			return fmt.Errorf("%d: scale can't be 0", i)
+		}
+	}
+	return nil
+}
+
+// applyTransforms applies the transforms, in order, to the top level keys
+// of a report.
+func applyTransforms(body []byte, transforms []*Transform) ([]byte, error) {
+	if len(transforms) == 0 {
+		return body, nil
+	}
+	var raw map[string]json.RawMessage
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	if err := dec.Decode(&raw); err != nil {
+		// Leave it to the report decoder to complain.
+		return body, nil
+	}
+	for _, t := range transforms {
+		var (
+			value json.RawMessage
+			found bool
+		)
+		if v, ok := raw[t.To]; ok && !t.fromTo() {
+			value, found = v, true
+		}
+		for _, f := range t.From {
+			v, ok := raw[f]
+			if !ok {
+				continue
+			}
+			if !found {
+				scaled, err := t.scale(v)
+				if err != nil {
+					return nil, fmt.Errorf("%s: %v", f, err)
+				}
+				value, found = scaled, true
+			}
+			delete(raw, f)
+		}
+		if found {
+			raw[t.To] = value
+		}
+	}
+	return json.Marshal(raw)
+}
+
+func (t *Transform) fromTo() bool {
+	for _, f := range t.From {
+		if f == t.To {
+			return true
+		}
+	}
+	return false
+}
+
+// scale multiplies a number by the transform's scale. Nulls are left alone.
+func (t *Transform) scale(v json.RawMessage) (json.RawMessage, error) {
+	s := string(bytes.TrimSpace(v))
+	if t.Scale == nil || s == "null" {
+		return v, nil
+	}
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return nil, errors.New("can only scale numbers")
+	}
+	return json.RawMessage(strconv.FormatFloat(f**t.Scale, 'f', -1, 64)), nil
+}
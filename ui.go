@@ -0,0 +1,41 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !headless
+
+package main
+
+import (
+	"embed"
+	"flag"
+	"io/fs"
+	"net/http"
+)
+
+//go:embed ui
+var uiAssets embed.FS
+
+var uiDir = flag.String("ui-dir", "", "serve the dashboard from this directory instead of the assets built into the binary")
+
+// uiHandler serves the dashboard. Build with -tags headless to leave it out.
+func uiHandler() http.Handler {
+	if *uiDir != "" {
+		return http.FileServer(http.Dir(*uiDir))
+	}
+	assets, err := fs.Sub(uiAssets, "ui")
+	if err != nil {
+		panic(err)
+	}
+	return http.FileServer(http.FS(assets))
+}
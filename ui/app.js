@@ -0,0 +1,12 @@
+async function refresh() {
+  const out = document.getElementById("metrics");
+  try {
+    const resp = await fetch("../metrics");
+    out.textContent = (await resp.text()) || "No metrics recorded yet.";
+  } catch (e) {
+    out.textContent = "Error fetching metrics: " + e;
+  }
+}
+
+refresh();
+setInterval(refresh, 30000);
@@ -0,0 +1,60 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !headless
+
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func getUI(t *testing.T, path string) (int, string) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	uiHandler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+	return w.Code, w.Body.String()
+}
+
+func TestUIHandler(t *testing.T) {
+	for _, name := range []string{"index.html", "app.js", "style.css"} {
+		want, err := os.ReadFile(filepath.Join("ui", name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		path := "/" + name
+		if name == "index.html" {
+			path = "/"
+		}
+		if code, body := getUI(t, path); code != http.StatusOK || body != string(want) {
+			t.Errorf("embedded %s: %d, %d bytes, want the %d in ui/", name, code, len(body), len(want))
+		}
+	}
+	if code, _ := getUI(t, "/missing.js"); code != http.StatusNotFound {
+		t.Errorf("missing asset: %d", code)
+	}
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "app.js"), []byte("// work in progress\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	setFlag(t, uiDir, dir)
+	if code, body := getUI(t, "/app.js"); code != http.StatusOK || body != "// work in progress\n" {
+		t.Errorf("-ui-dir app.js: %d %q", code, body)
+	}
+}
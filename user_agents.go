@@ -0,0 +1,104 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var knownBots = flag.String("known-bots", "", "what to do with pushes from the user agents of well known crawlers: drop or flag them; empty to treat them like any other")
+
+// knownBotAgents are substrings of the user agents of well known crawlers,
+// which never run homeservers.
+var knownBotAgents = []string{
+	"googlebot", "bingbot", "yandexbot", "baiduspider", "duckduckbot", "applebot", "slurp",
+	"ahrefsbot", "semrushbot", "mj12bot", "dotbot", "petalbot", "bytespider",
+	"gptbot", "ccbot", "amazonbot", "facebookexternalhit", "crawler", "spider",
+}
+
+// UserAgentFilter drops or flags pushes whose user agent matches a
+// pattern, such as scripts which post to /push but aren't homeservers.
+type UserAgentFilter struct {
+	Name    string `json:"name"`    // Stored in user_agent_filter and used in metrics; the pattern by default
+	Pattern string `json:"pattern"` // Regular expression, matched case-insensitively
+	Action  string `json:"action"`  // "drop" (the default), or "flag" to store the report marked as filtered
+
+	re *regexp.Regexp
+}
+
+func (f *UserAgentFilter) compile() error {
+	if f.Pattern == "" {
+		return errors.New("missing pattern")
+	}
+	re, err := regexp.Compile("(?i)" + f.Pattern)
+	if err != nil {
+		return err
+	}
+	f.re = re
+	switch f.Action {
+	case "":
+		f.Action = "drop"
+	case "drop", "flag":
+	default:
+		return fmt.Errorf("unknown action %q", f.Action)
+	}
+	if f.Name == "" {
+		f.Name = f.Pattern
+	}
+	if len(f.Name) > 64 {
+		return errors.New("name is longer than 64 characters")
+	}
+	return nil
+}
+
+func validateUserAgentFilters(filters []*UserAgentFilter) error {
+	for i, f := range filters {
+		if err := f.compile(); err != nil {
+			return fmt.Errorf("%d: %v", i, err)
+		}
+	}
+	return nil
+}
+
+func validateKnownBotsFlag() error {
+	switch *knownBots {
+	case "", "drop", "flag":
+		return nil
+	}
+	return fmt.Errorf("-known-bots must be drop or flag, not %q", *knownBots)
+}
+
+// userAgentFilterFor returns the first filter matching a user agent, from
+// the config and then -known-bots, or nil if none does.
+func userAgentFilterFor(filters []*UserAgentFilter, ua string) *UserAgentFilter {
+	for _, f := range filters {
+		if f.re.MatchString(ua) {
+			return f
+		}
+	}
+	if *knownBots != "" {
+		lower := strings.ToLower(ua)
+		for _, bot := range knownBotAgents {
+			if strings.Contains(lower, bot) {
+				return &UserAgentFilter{Name: "known_bots", Action: *knownBots}
+			}
+		}
+	}
+	return nil
+}
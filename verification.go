@@ -0,0 +1,244 @@
+// Copyright 2026 The Matrix.org Foundation C.I.C.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+var (
+	verificationPeriod    = flag.Duration("verification-period", 0, "how long reports from a homeserver are marked verified after it proves control of its domain through /verify; 0 disables verification")
+	verificationURL       = flag.String("verification-url", "https://%s/.well-known/panopticon-verification", "where a homeserver verifying through /verify exposes its nonce; %s is replaced by its server name")
+	maxVerificationNonces = flag.Int("max-verification-nonces", 5, "how many unexpired nonces /verify/challenge issues to a homeserver before refusing more")
+)
+
+// verificationNonceTTL is how long a homeserver has to expose or sign a
+// nonce.
+const verificationNonceTTL = 24 * time.Hour
+
+func createTableVerifications(db *sql.DB) error {
+	_, err := db.Exec(`CREATE TABLE IF NOT EXISTS verification_nonces(
+		nonce VARCHAR(64) NOT NULL PRIMARY KEY,
+		homeserver VARCHAR(256) NOT NULL,
+		expires_at BIGINT NOT NULL
+		)`)
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS verified_homeservers(
+		homeserver VARCHAR(256) NOT NULL PRIMARY KEY,
+		verified_at BIGINT NOT NULL,
+		method VARCHAR(16) NOT NULL
+		)`)
+	return err
+}
+
+// isVerified reports whether a homeserver had proven control of its domain
+// within -verification-period before ts.
+func isVerified(db *sql.DB, homeserver string, ts int64) (bool, error) {
+	var verifiedAt int64
+	err := db.QueryRow("SELECT verified_at FROM verified_homeservers WHERE homeserver = "+dialectFor(db).placeholder(1), homeserver).Scan(&verifiedAt)
+	if err == sql.ErrNoRows {
+		return false, nil
+	}
+	return err == nil && verifiedAt <= ts && ts < verifiedAt+int64(verificationPeriod.Seconds()), err
+}
+
+// verificationRequest is the body of a POST to /verify/challenge, which
+// only needs server_name, or to /verify. Signatures are made like those of
+// Matrix federation requests, over the canonical JSON of the other fields.
+type verificationRequest struct {
+	ServerName string                       `json:"server_name"`
+	Nonce      string                       `json:"nonce"`
+	Signatures map[string]map[string]string `json:"signatures"`
+}
+
+// VerifyHandler serves /verify/challenge, which issues a homeserver a
+// nonce, and /verify, which marks its reports verified for
+// -verification-period once it has either served the nonce at
+// -verification-url or signed it with one of its keys, proving it controls
+// the domain it reports as.
+type VerifyHandler struct {
+	DB      *sql.DB
+	Storage *Storage
+	Client  *http.Client
+}
+
+func (h *VerifyHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if *verificationPeriod <= 0 || !featureEnabled("verification") {
+		notFound(w, req)
+		return
+	}
+	body, err := io.ReadAll(io.LimitReader(req.Body, 64*1024))
+	if err != nil {
+		logAndReplyError(w, err, 400, "Error reading body")
+		return
+	}
+	var r verificationRequest
+	if err := json.Unmarshal(body, &r); err != nil {
+		replyError(w, err, 400, jsonErrcode(err), "Error decoding verification")
+		return
+	}
+	if r.ServerName == "" {
+		logAndReplyError(w, errors.New("missing server_name"), 400, "Error decoding verification")
+		return
+	}
+	if err := validateServerName(r.ServerName); err != nil {
+		replyError(w, err, 400, errcodeValidationFailed, "Error decoding verification")
+		return
+	}
+	now := time.Now().UTC().Unix()
+	if req.URL.Path == "/verify/challenge" {
+		h.challenge(w, r.ServerName, now)
+		return
+	}
+	method, err := h.verify(&r, body, now)
+	if err != nil {
+		metrics.Inc("panopticon_verifications_total", "method", method, "result", "failed")
+		logAndReplyError(w, err, http.StatusForbidden, "Refused verification")
+		return
+	}
+	hs := h.Storage.storedHomeserver(r.ServerName)
+	err = inWriteTx(h.DB, func(tx *sql.Tx) error {
+		d := dialectFor(h.DB)
+		if _, err := tx.Exec("DELETE FROM verification_nonces WHERE nonce = "+d.placeholder(1), r.Nonce); err != nil {
+			return err
+		}
+		if _, err := tx.Exec("DELETE FROM verified_homeservers WHERE homeserver = "+d.placeholder(1), hs); err != nil {
+			return err
+		}
+		_, err := tx.Exec(d.insert("verified_homeservers", "homeserver", "verified_at", "method"), hs, now, method)
+		return err
+	})
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error saving verification")
+		return
+	}
+	log.Printf("%s verified its domain by %s", r.ServerName, method)
+	metrics.Inc("panopticon_verifications_total", "method", method, "result", "ok")
+	writeJSON(w, struct {
+		VerifiedUntil int64 `json:"verified_until"`
+	}{now + int64(verificationPeriod.Seconds())})
+}
+
+// errTooManyNonces is returned when a homeserver already has
+// -max-verification-nonces unexpired nonces.
+var errTooManyNonces = errors.New("too many unexpired nonces")
+
+// challenge issues a nonce to a homeserver, unless it already has
+// -max-verification-nonces unexpired ones, so that the table can't be grown
+// without limit.
+func (h *VerifyHandler) challenge(w http.ResponseWriter, serverName string, now int64) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		logAndReplyError(w, err, 500, "Error issuing nonce")
+		return
+	}
+	nonce := hex.EncodeToString(b)
+	expires := now + int64(verificationNonceTTL.Seconds())
+	hs := h.Storage.storedHomeserver(serverName)
+	err := inWriteTx(h.DB, func(tx *sql.Tx) error {
+		d := dialectFor(h.DB)
+		var outstanding int
+		err := tx.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM verification_nonces WHERE homeserver = %s AND expires_at > %s",
+			d.placeholder(1), d.placeholder(2)), hs, now).Scan(&outstanding)
+		if err != nil {
+			return err
+		}
+		if outstanding >= *maxVerificationNonces {
+			return errTooManyNonces
+		}
+		_, err = tx.Exec(d.insert("verification_nonces", "nonce", "homeserver", "expires_at"), nonce, hs, expires)
+		return err
+	})
+	if err == errTooManyNonces {
+		replyError(w, err, http.StatusTooManyRequests, errcodeRateLimited, "Refused nonce")
+		return
+	}
+	if err != nil {
+		logAndReplyError(w, err, 500, "Error issuing nonce")
+		return
+	}
+	writeJSON(w, struct {
+		Nonce     string `json:"nonce"`
+		URL       string `json:"url"`
+		ExpiresAt int64  `json:"expires_at"`
+	}{nonce, fmt.Sprintf(*verificationURL, serverName), expires})
+}
+
+// verify checks that a homeserver controls its domain, returning how.
+func (h *VerifyHandler) verify(r *verificationRequest, body []byte, now int64) (string, error) {
+	method := "well_known"
+	if len(r.Signatures) > 0 {
+		method = "signature"
+	}
+	d := dialectFor(h.DB)
+	var n int
+	err := h.DB.QueryRow(fmt.Sprintf("SELECT COUNT(*) FROM verification_nonces WHERE nonce = %s AND homeserver = %s AND expires_at > %s",
+		d.placeholder(1), d.placeholder(2), d.placeholder(3)), r.Nonce, h.Storage.storedHomeserver(r.ServerName), now).Scan(&n)
+	if err != nil {
+		return method, err
+	}
+	if n == 0 {
+		return method, errors.New("unknown or expired nonce")
+	}
+	if method == "signature" {
+		return method, verifyServerSignature(h.Client, r.ServerName, r.Signatures, body)
+	}
+	resp, err := h.Client.Get(fmt.Sprintf(*verificationURL, r.ServerName))
+	if err != nil {
+		return method, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return method, fmt.Errorf("fetching nonce: %s", resp.Status)
+	}
+	served, err := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	if err != nil {
+		return method, fmt.Errorf("fetching nonce: %v", err)
+	}
+	if strings.TrimSpace(string(served)) != r.Nonce {
+		return method, errors.New("the nonce served doesn't match")
+	}
+	return method, nil
+}
+
+// pruneVerificationNonces deletes expired nonces.
+func pruneVerificationNonces(db *sql.DB) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		now := time.Now().UTC().Unix()
+		res, err := db.ExecContext(ctx, "DELETE FROM verification_nonces WHERE expires_at <= "+dialectFor(db).placeholder(1), now)
+		if err != nil {
+			return err
+		}
+		if n, err := res.RowsAffected(); err == nil && n > 0 {
+			addRowsAffected(ctx, n)
+			log.Printf("Pruned %d expired verification nonces", n)
+		}
+		return nil
+	}
+}